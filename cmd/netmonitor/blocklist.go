@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+
+	"grip/internal/capture"
+)
+
+// runBlocklist implements the blocklist subcommands
+func runBlocklist(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("blocklist requires a subcommand: test <ip>")
+	}
+
+	switch args[0] {
+	case "test":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: blocklist test <ip>")
+		}
+		if blocklistPath == "" {
+			return fmt.Errorf("no blocklist configured, set -blocklist")
+		}
+
+		list, err := capture.LoadBlocklist(blocklistPath)
+		if err != nil {
+			return err
+		}
+		if prefix, ok := list.Match(args[1]); ok {
+			fmt.Printf("%s matches %s in %s\n", args[1], prefix, blocklistPath)
+		} else {
+			fmt.Printf("%s does not match any of the %d entries in %s\n", args[1], list.Len(), blocklistPath)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown blocklist subcommand %q, expected test", args[0])
+	}
+}
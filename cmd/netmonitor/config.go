@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"grip/internal/logger"
+)
+
+// defaultConfigPath returns the config file read when -config is not given,
+// %PROGRAMDATA%\GripNetMonitor\config.json
+func defaultConfigPath() string {
+	programData := os.Getenv("PROGRAMDATA")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+	return filepath.Join(programData, "GripNetMonitor", "config.json")
+}
+
+// resolveConfigPath returns the config file to load: the -config value, or
+// the default location if a file exists there, or "" for none
+func resolveConfigPath() string {
+	if configPath != "" {
+		return configPath
+	}
+	if _, err := os.Stat(defaultConfigPath()); err == nil {
+		return defaultConfigPath()
+	}
+	return ""
+}
+
+// loadConfigFile sets flags from a configuration file. Keys are flag names
+// without the leading dash, e.g. "log-level" or "snaplen". Flags given on the
+// command line take precedence over values from the file.
+//
+// Files ending in .yaml or .yml hold one "key: value" pair per line; anything
+// else is read as a JSON object.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var values map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		values, err = parseYAMLConfig(data)
+	default:
+		values, err = parseJSONConfig(data)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	for name, value := range values {
+		if name == "config" {
+			return fmt.Errorf("invalid config file %s: config files cannot include other config files", path)
+		}
+		if flag.Lookup(name) == nil {
+			return fmt.Errorf("invalid config file %s: unknown setting %q", path, name)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return fmt.Errorf("invalid config file %s: %s: %v", path, name, err)
+		}
+	}
+
+	return nil
+}
+
+// parseJSONConfig reads a flat JSON object of strings, numbers and booleans
+func parseJSONConfig(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(raw))
+	for name, value := range raw {
+		switch v := value.(type) {
+		case string:
+			values[name] = v
+		case json.Number:
+			values[name] = v.String()
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("%s: expected a string, number or boolean", name)
+		}
+	}
+	return values, nil
+}
+
+// parseYAMLConfig reads the flat subset of YAML used by config files:
+// "key: value" lines, optionally quoted values and # comments
+func parseYAMLConfig(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineNumber)
+		}
+		name = strings.TrimSpace(name)
+		value = strings.TrimSpace(value)
+
+		if unquoted, err := strconv.Unquote(value); err == nil && strings.HasPrefix(value, `"`) {
+			value = unquoted
+		} else if len(value) >= 2 && strings.HasPrefix(value, "'") && strings.HasSuffix(value, "'") {
+			value = strings.ReplaceAll(value[1:len(value)-1], "''", "'")
+		} else if i := strings.Index(value, " #"); i >= 0 {
+			value = strings.TrimSpace(value[:i])
+		}
+
+		values[name] = value
+	}
+	return values, scanner.Err()
+}
+
+// runConfig implements the config subcommands
+func runConfig(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("config requires a subcommand: validate")
+	}
+
+	switch args[0] {
+	case "validate":
+		path := resolveConfigPath()
+		if len(args) > 1 {
+			path = args[1]
+		}
+		if path == "" {
+			return fmt.Errorf("no config file given and none found at %s", defaultConfigPath())
+		}
+		if err := validateConfig(path); err != nil {
+			return err
+		}
+		fmt.Printf("%s is valid\n", path)
+		return nil
+	default:
+		return fmt.Errorf("unknown config subcommand %q, expected validate", args[0])
+	}
+}
+
+// validateConfig loads a config file and checks the resulting settings
+// without opening the database or starting capture
+func validateConfig(path string) error {
+	if err := loadConfigFile(path); err != nil {
+		return err
+	}
+	if err := resolveLogLevels(); err != nil {
+		return err
+	}
+	if enableFile && logFilePath == "" {
+		return fmt.Errorf("log file path must be specified when file logging is enabled")
+	}
+	if _, err := parseSNIPorts(); err != nil {
+		return err
+	}
+	if bandwidthThresholdMB < 0 || bandwidthInterval <= 0 {
+		return fmt.Errorf("bandwidth threshold must not be negative and its interval must be positive")
+	}
+	if _, err := parseWebhookEvents(); err != nil {
+		return err
+	}
+	if webhookSummaryInterval <= 0 {
+		return fmt.Errorf("webhook summary interval must be positive, got %v", webhookSummaryInterval)
+	}
+	config, err := captureConfig()
+	if err != nil {
+		return err
+	}
+	return config.Validate()
+}
+
+// writeDefaultConfig writes every flag except -config with its current value
+// to a new JSON config file. An existing file is never overwritten.
+func writeDefaultConfig(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	values := make(map[string]interface{})
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		getter, ok := f.Value.(flag.Getter)
+		if !ok {
+			values[f.Name] = f.Value.String()
+			return
+		}
+		switch v := getter.Get().(type) {
+		case time.Duration:
+			values[f.Name] = v.String()
+		default:
+			values[f.Name] = v
+		}
+	})
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+
+	logger.Info("Wrote default configuration to %s", path)
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseYAMLConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr string
+	}{
+		{"plain", "log-level: debug\nsnaplen: 1500\n", map[string]string{"log-level": "debug", "snaplen": "1500"}, ""},
+		{"comments and document start", "---\n# Settings\n\n  log-level: warn  \n", map[string]string{"log-level": "warn"}, ""},
+		{"trailing comment", "interface: Ethernet # wired\n", map[string]string{"interface": "Ethernet"}, ""},
+		{"double quoted", `log-file: "C:\\Logs\\grip.log # kept"`, map[string]string{"log-file": `C:\Logs\grip.log # kept`}, ""},
+		{"single quoted", "interface: 'Bob''s Wi-Fi'", map[string]string{"interface": "Bob's Wi-Fi"}, ""},
+		{"colon in value", "webhook-url: https://example.com:8443/hook", map[string]string{"webhook-url": "https://example.com:8443/hook"}, ""},
+		{"empty value", "interface:", map[string]string{"interface": ""}, ""},
+		{"later value wins", "snaplen: 100\nsnaplen: 200", map[string]string{"snaplen": "200"}, ""},
+		{"missing colon", "log-level: info\nverbose\n", nil, "line 2"},
+	}
+	for _, tt := range tests {
+		got, err := parseYAMLConfig([]byte(tt.data))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseJSONConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"strings", `{"log-level": "debug", "interface": "Wi-Fi \"5G\""}`, map[string]string{"log-level": "debug", "interface": `Wi-Fi "5G"`}, false},
+		{"numbers keep their text", `{"snaplen": 1500, "bandwidth-threshold-mb": 0.5}`, map[string]string{"snaplen": "1500", "bandwidth-threshold-mb": "0.5"}, false},
+		{"booleans", `{"promiscuous": true, "store-external": false}`, map[string]string{"promiscuous": "true", "store-external": "false"}, false},
+		{"empty", `{}`, map[string]string{}, false},
+		{"nested object", `{"webhook": {"url": "x"}}`, nil, true},
+		{"array", `{"interface": ["a", "b"]}`, nil, true},
+		{"null", `{"interface": null}`, nil, true},
+		{"not an object", `["log-level"]`, nil, true},
+		{"malformed", `{"log-level": }`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseJSONConfig([]byte(tt.data))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestLoadConfigFile loads config files into a fresh flag set and checks the
+// resulting values, that flags given on the command line keep theirs, and
+// that unknown or invalid settings are rejected
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		data    string
+		args    []string // Command line
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "json",
+			file: "config.json",
+			data: `{"log-level": "debug", "snaplen": 1500, "promiscuous": true, "interval": "30s"}`,
+			want: map[string]string{"log-level": "debug", "snaplen": "1500", "promiscuous": "true", "interval": "30s"},
+		},
+		{
+			name: "yaml",
+			file: "config.yaml",
+			data: "log-level: 'warn'\nsnaplen: 96 # headers only\ninterval: \"1m0s\"\n",
+			want: map[string]string{"log-level": "warn", "snaplen": "96", "promiscuous": "false", "interval": "1m0s"},
+		},
+		{
+			name: "yml extension, any case",
+			file: "config.YML",
+			data: "promiscuous: true\n",
+			want: map[string]string{"log-level": "info", "snaplen": "262144", "promiscuous": "true", "interval": "10s"},
+		},
+		{
+			name: "command line wins",
+			file: "config.json",
+			data: `{"log-level": "debug", "snaplen": 1500}`,
+			args: []string{"-log-level=error"},
+			want: map[string]string{"log-level": "error", "snaplen": "1500", "promiscuous": "false", "interval": "10s"},
+		},
+		{
+			name: "command line default value still wins",
+			file: "config.yaml",
+			data: "snaplen: 1500\n",
+			args: []string{"-snaplen=262144"},
+			want: map[string]string{"log-level": "info", "snaplen": "262144", "promiscuous": "false", "interval": "10s"},
+		},
+		{name: "unknown key", file: "config.json", data: `{"log-levle": "debug"}`, wantErr: `unknown setting "log-levle"`},
+		{name: "include", file: "config.yaml", data: "config: other.yaml\n", wantErr: "cannot include"},
+		{name: "bad value", file: "config.json", data: `{"snaplen": "large"}`, wantErr: "snaplen"},
+		{name: "bad json", file: "config.json", data: `log-level: debug`, wantErr: "invalid config file"},
+		{name: "json in a yaml file", file: "config.yaml", data: `{"log-level" "debug"}`, wantErr: "line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := flag.CommandLine
+			t.Cleanup(func() { flag.CommandLine = previous })
+			flag.CommandLine = flag.NewFlagSet("netmonitor", flag.ContinueOnError)
+			flag.String("config", "", "")
+			flag.String("log-level", "info", "")
+			flag.Int("snaplen", 262144, "")
+			flag.Bool("promiscuous", false, "")
+			flag.Duration("interval", 10*time.Second, "")
+			if err := flag.CommandLine.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			err := loadConfigFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got := flag.Lookup(name).Value.String(); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+
+	if err := loadConfigFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loading a missing file succeeded")
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"grip/internal/capture"
+
+	"golang.org/x/sys/windows"
+)
+
+// dashboardRefresh is how often the dashboard is redrawn
+const dashboardRefresh = time.Second
+
+// ANSI sequences used to draw the dashboard
+const (
+	ansiAltScreen   = "\033[?1049h"
+	ansiMainScreen  = "\033[?1049l"
+	ansiHideCursor  = "\033[?25l"
+	ansiShowCursor  = "\033[?25h"
+	ansiHome        = "\033[H"
+	ansiClearLine   = "\033[K"
+	ansiClearScreen = "\033[J"
+	ansiBold        = "\033[1m"
+	ansiReset       = "\033[0m"
+)
+
+// runDashboard draws live statistics until q is pressed or a signal arrives.
+// It only reads statistics, so capture keeps running underneath it.
+func runDashboard(signals <-chan os.Signal) error {
+	restore, err := prepareConsole()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer fmt.Print(ansiShowCursor + ansiMainScreen)
+
+	keys := readKeys()
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+
+	for {
+		width, height := consoleSize()
+		fmt.Print(ansiHome + renderDashboard(width, height) + ansiClearScreen)
+
+		select {
+		case <-signals:
+			return nil
+		case key := <-keys:
+			if key == 'q' || key == 'Q' || key == 3 { // 3 is Ctrl-C without processed input
+				return nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// readKeys delivers key presses from stdin. The goroutine is left blocked on
+// stdin when the caller exits, which is fine as the process ends.
+func readKeys() <-chan byte {
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil {
+				return
+			} else if n == 1 {
+				keys <- buf[0]
+			}
+		}
+	}()
+	return keys
+}
+
+// prepareConsole enables ANSI sequences on stdout and unbuffered key input on
+// stdin, returning a function that restores the previous modes
+func prepareConsole() (func(), error) {
+	stdout := windows.Handle(os.Stdout.Fd())
+	stdin := windows.Handle(os.Stdin.Fd())
+
+	var outMode, inMode uint32
+	if err := windows.GetConsoleMode(stdout, &outMode); err != nil {
+		return nil, fmt.Errorf("the dashboard needs a console: %v", err)
+	}
+	if err := windows.GetConsoleMode(stdin, &inMode); err != nil {
+		return nil, fmt.Errorf("the dashboard needs a console: %v", err)
+	}
+
+	if err := windows.SetConsoleMode(stdout, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING); err != nil {
+		return nil, fmt.Errorf("console does not support ANSI sequences: %v", err)
+	}
+	// Deliver single key presses without echo; Ctrl-C still raises a signal
+	windows.SetConsoleMode(stdin, inMode&^(windows.ENABLE_LINE_INPUT|windows.ENABLE_ECHO_INPUT))
+
+	return func() {
+		windows.SetConsoleMode(stdin, inMode)
+		windows.SetConsoleMode(stdout, outMode)
+	}, nil
+}
+
+// consoleSize returns the visible console window size, or 80x25 if unknown
+func consoleSize() (int, int) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info); err != nil {
+		return 80, 25
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1
+}
+
+// renderDashboard builds one frame that fits in width x height characters
+func renderDashboard(width, height int) string {
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fitWidth(fmt.Sprintf(format, args...), width))
+	}
+
+	stats := capture.GetStatistics()
+	rates := capture.GetRates()
+
+	add("%sGrip Network Monitor%s   up %s   (q to quit)", ansiBold, ansiReset, time.Since(stats.StartTime).Round(time.Second))
+	add("Total: %d packets, %s   now %s/s", stats.TotalPackets.Load(), formatBytes(stats.TotalBytes.Load()),
+		formatBytes(uint64(capture.CurrentRate(""))))
+	for _, rate := range rates {
+		add("Rate %-4s %10s/s %10.1f pkt/s", shortDuration(rate.Window), formatBytes(uint64(rate.BytesPerSec)), rate.PacketsPerSec)
+	}
+
+	// Protocol distribution by bytes
+	add("")
+	add("%sProtocols%s", ansiBold, ansiReset)
+	protocols := capture.GetProtocolCounts()
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return protocols[names[i]].Bytes > protocols[names[j]].Bytes
+	})
+	barWidth := width - 40
+	if barWidth < 10 {
+		barWidth = 10
+	}
+	totalBytes := stats.TotalBytes.Load()
+	for _, name := range names {
+		share := percentOf(protocols[name].Bytes, totalBytes)
+		add("%-8s %10s %5.1f%% %s", name, formatBytes(protocols[name].Bytes), share,
+			strings.Repeat("#", int(share/100*float64(barWidth))))
+	}
+
+	// Top talkers fill the remaining rows
+	add("")
+	add("%s%-32s %12s %12s %12s%s", ansiBold, "Application", "Bytes", "Packets", "Now", ansiReset)
+	rows := height - len(lines) - 1
+	if rows > 0 {
+		for _, talker := range capture.TopTalkers(rows) {
+			current := formatBytes(uint64(capture.CurrentRate(talker.Key))) + "/s"
+			add("%-32s %12s %12d %12s", truncate(talker.ProcessName, 32), formatBytes(talker.TotalBytes), talker.TotalPackets, current)
+		}
+	}
+
+	return strings.Join(lines, ansiClearLine+"\r\n") + ansiClearLine
+}
+
+// shortDuration renders 10s, 1m or 5m rather than 10s, 1m0s and 5m0s
+func shortDuration(d time.Duration) string {
+	if d >= time.Minute && d%time.Minute == 0 {
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+	return d.String()
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n-1]) + "~"
+}
+
+// fitWidth cuts line to at most width visible characters. Escape sequences
+// take no room and are all kept, so a cut line still ends its bold text.
+func fitWidth(line string, width int) string {
+	var b strings.Builder
+	visible := 0
+	for i := 0; i < len(line); {
+		if line[i] == '\033' {
+			end := escapeEnd(line, i)
+			b.WriteString(line[i:end])
+			i = end
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(line[i:])
+		if visible < width {
+			b.WriteString(line[i : i+size])
+			visible++
+		}
+		i += size
+	}
+	return b.String()
+}
+
+// escapeEnd returns the index just past the escape sequence starting at
+// line[i]: a CSI sequence like "\033[1m", or ESC and the byte after it
+func escapeEnd(line string, i int) int {
+	j := i + 1
+	if j < len(line) && line[j] == '[' {
+		// Parameter and intermediate bytes, then one final byte
+		j++
+		for j < len(line) && (line[j] < 0x40 || line[j] > 0x7e) {
+			j++
+		}
+	}
+	return min(j+1, len(line))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFitWidth(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		width int
+		want  string
+	}{
+		{"fits", "Total: 12 packets", 40, "Total: 12 packets"},
+		{"cut", "Total: 12 packets", 5, "Total"},
+		{"escapes take no room", ansiBold + "Protocols" + ansiReset, 9, ansiBold + "Protocols" + ansiReset},
+		{"reset kept when cut", ansiBold + "Grip Network Monitor" + ansiReset + "   up 5s", 4, ansiBold + "Grip" + ansiReset},
+		{"cut before an escape", "ab" + ansiBold + "cd" + ansiReset, 1, "a" + ansiBold + ansiReset},
+		{"multi-byte characters", "Ünïcödé.exe 5 MB", 7, "Ünïcödé"},
+		{"zero width", ansiBold + "Application" + ansiReset, 0, ansiBold + ansiReset},
+		{"unfinished escape", "abc\033[1", 2, "ab\033[1"},
+	}
+	for _, tt := range tests {
+		if got := fitWidth(tt.line, tt.width); got != tt.want {
+			t.Errorf("%s: fitWidth(%q, %d) = %q, want %q", tt.name, tt.line, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"chrome.exe", 32, "chrome.exe"},
+		{"chrome.exe", 10, "chrome.exe"},
+		{"chrome.exe", 7, "chrome~"},
+		{"Übersetzungsprogramm.exe", 8, "Überset~"},
+		{"微信.exe", 3, "微信~"},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.n)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q is not valid UTF-8", tt.s, tt.n, got)
+		}
+	}
+}
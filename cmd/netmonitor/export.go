@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"grip/internal/capture"
+	"grip/internal/database"
+)
+
+// exportTables lists the tables runExport can write
+var exportTables = []string{"packets", "flows", "apps"}
+
+// runExport writes stored packets, flows or application totals to a CSV or
+// JSON Lines file
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "Output format: csv or jsonl (newline-delimited JSON)")
+	table := fs.String("table", "packets", "Data to export: "+strings.Join(exportTables, ", "))
+	out := fs.String("out", "", "Output file (default <table>.csv or <table>.jsonl)")
+	since := fs.String("since", "", "Only export rows from this long ago or this time onwards, e.g. 24h")
+	from := fs.String("from", "", "Only export rows at or after this time (RFC3339 or YYYY-MM-DD)")
+	to := fs.String("to", "", "Only export rows at or before this time (RFC3339 or YYYY-MM-DD)")
+	processName := fs.String("process", "", "Only export rows attributed to this process name, e.g. chrome.exe")
+	protocol := fs.String("protocol", "", "Only export packets or flows with this protocol, e.g. TCP")
+	direction := fs.String("direction", "", "Only export packets or flows with this direction, e.g. outgoing")
+	includePayload := fs.Bool("include-payload", false, "Include stored packet payloads (base64); they are redacted by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// "json" predates JSON Lines support and produces the same output
+	if *format == "json" {
+		*format = "jsonl"
+	}
+	if *format != "csv" && *format != "jsonl" {
+		return fmt.Errorf("unsupported format %q, expected csv or jsonl", *format)
+	}
+
+	filter := database.PacketFilter{
+		ProcessName: *processName,
+		Protocol:    *protocol,
+		Direction:   *direction,
+	}
+	var err error
+	if *from != "" && *since != "" {
+		return fmt.Errorf("-from and -since are mutually exclusive")
+	}
+	if *since != "" {
+		if filter.From, err = parseSince(*since); err != nil {
+			return fmt.Errorf("invalid -since: %v", err)
+		}
+	}
+	if filter.From.IsZero() {
+		if filter.From, err = parseExportTime(*from); err != nil {
+			return fmt.Errorf("invalid -from: %v", err)
+		}
+	}
+	if filter.To, err = parseExportTime(*to); err != nil {
+		return fmt.Errorf("invalid -to: %v", err)
+	}
+
+	var export func(filter database.PacketFilter, w exportWriter) (int, error)
+	switch *table {
+	case "packets":
+		export = func(filter database.PacketFilter, w exportWriter) (int, error) {
+			return exportPackets(filter, w, *includePayload)
+		}
+	case "flows":
+		export = exportFlows
+	case "apps":
+		if filter.Protocol != "" || filter.Direction != "" {
+			return fmt.Errorf("-protocol and -direction do not apply to -table apps")
+		}
+		export = exportApps
+	default:
+		return fmt.Errorf("unknown table %q, expected one of %s", *table, strings.Join(exportTables, ", "))
+	}
+
+	path := *out
+	if path == "" {
+		path = *table + "." + *format
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	defer file.Close()
+
+	buf := bufio.NewWriter(file)
+	var w exportWriter
+	if *format == "csv" {
+		w = &csvExportWriter{w: csv.NewWriter(buf)}
+	} else {
+		w = &jsonExportWriter{enc: json.NewEncoder(buf)}
+	}
+
+	count, err := export(filter, w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("export failed after %d rows: %v", count, err)
+	}
+
+	if err := buf.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d %s to %s\n", count, *table, path)
+	return nil
+}
+
+// exportWriter writes one header and any number of rows in a single format
+type exportWriter interface {
+	// Write emits a row. header names its fields for CSV; values holds the
+	// CSV cells and record is the value encoded as one JSON line.
+	Write(header, values []string, record interface{}) error
+	Flush() error
+}
+
+type csvExportWriter struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+func (c *csvExportWriter) Write(header, values []string, record interface{}) error {
+	if !c.wroteHeader {
+		c.wroteHeader = true
+		if err := c.w.Write(header); err != nil {
+			return err
+		}
+	}
+	return c.w.Write(values)
+}
+
+func (c *csvExportWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonExportWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonExportWriter) Write(header, values []string, record interface{}) error {
+	return j.enc.Encode(record)
+}
+
+func (j *jsonExportWriter) Flush() error {
+	return nil
+}
+
+var packetHeader = []string{
+	"timestamp", "device", "src_ip", "src_port", "dst_ip", "dst_port", "dst_host", "reverse_host",
+	"geoip", "protocol", "length", "packet_count", "total_bytes", "direction", "scope", "process_id", "process_name",
+	"process_path", "service_name", "process_owner", "flagged", "payload", "src_mac", "dst_mac", "vlan",
+}
+
+// exportPackets writes packet rows. Payloads are only written with
+// includePayload, so exports shared for troubleshooting don't leak them.
+func exportPackets(filter database.PacketFilter, w exportWriter, includePayload bool) (int, error) {
+	// Resolve device IDs to names once rather than joining per row
+	deviceNames, err := deviceNames()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = database.StreamPackets(filter, func(record database.PacketRecord) error {
+		count++
+		vlan := "" // Untagged, like the NULL column
+		if record.VLAN > 0 {
+			vlan = strconv.Itoa(int(record.VLAN))
+		}
+		payload := ""
+		if !includePayload {
+			record.Payload = nil
+		} else if len(record.Payload) > 0 {
+			payload = base64.StdEncoding.EncodeToString(record.Payload)
+		}
+		return w.Write(packetHeader, []string{
+			record.Timestamp.Format(time.RFC3339Nano),
+			deviceNames[record.DeviceID],
+			record.SrcIP,
+			record.SrcPort,
+			record.DstIP,
+			record.DstPort,
+			record.DstHost,
+			record.ReverseHost,
+			record.GeoIP,
+			record.Protocol,
+			strconv.Itoa(record.Length),
+			strconv.FormatUint(record.PacketCount, 10),
+			strconv.FormatUint(record.TotalBytes, 10),
+			record.Direction,
+			record.Scope,
+			strconv.FormatUint(uint64(record.ProcessID), 10),
+			record.ProcessName,
+			record.ProcessPath,
+			record.ServiceName,
+			record.ProcessOwner,
+			strconv.FormatBool(record.Flagged),
+			payload,
+			record.SrcMAC,
+			record.DstMAC,
+			vlan,
+		}, capture.PacketLog{
+			Timestamp:    record.Timestamp,
+			Device:       deviceNames[record.DeviceID],
+			SrcIP:        record.SrcIP,
+			SrcPort:      record.SrcPort,
+			DstIP:        record.DstIP,
+			DstPort:      record.DstPort,
+			SrcMAC:       record.SrcMAC,
+			DstMAC:       record.DstMAC,
+			VLAN:         record.VLAN,
+			DstHost:      record.DstHost,
+			ReverseHost:  record.ReverseHost,
+			GeoIP:        record.GeoIP,
+			Protocol:     record.Protocol,
+			Length:       record.Length,
+			PacketCount:  record.PacketCount,
+			TotalBytes:   record.TotalBytes,
+			Direction:    record.Direction,
+			Scope:        record.Scope,
+			ProcessID:    record.ProcessID,
+			ProcessName:  record.ProcessName,
+			ProcessPath:  record.ProcessPath,
+			ServiceName:  record.ServiceName,
+			ProcessOwner: record.ProcessOwner,
+			Payload:      record.Payload,
+			Flagged:      record.Flagged,
+		})
+	})
+	return count, err
+}
+
+var flowHeader = []string{
+	"first_seen", "last_seen", "device", "src_ip", "src_port", "dst_ip", "dst_port", "dst_host",
+	"reverse_host", "protocol", "direction", "scope", "packet_count", "byte_count", "tcp_flags", "process_id",
+	"process_name", "process_path", "attribution",
+}
+
+func exportFlows(filter database.PacketFilter, w exportWriter) (int, error) {
+	deviceNames, err := deviceNames()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = database.StreamFlows(filter, func(flow database.FlowRecord) error {
+		count++
+		return w.Write(flowHeader, []string{
+			flow.FirstSeen.Format(time.RFC3339Nano),
+			flow.LastSeen.Format(time.RFC3339Nano),
+			deviceNames[flow.DeviceID],
+			flow.SrcIP,
+			flow.SrcPort,
+			flow.DstIP,
+			flow.DstPort,
+			flow.DstHost,
+			flow.ReverseHost,
+			flow.Protocol,
+			flow.Direction,
+			flow.Scope,
+			strconv.FormatUint(flow.PacketCount, 10),
+			strconv.FormatUint(flow.ByteCount, 10),
+			flow.TCPFlags,
+			strconv.FormatUint(uint64(flow.ProcessID), 10),
+			flow.ProcessName,
+			flow.ProcessPath,
+			flow.Attribution,
+		}, flow)
+	})
+	return count, err
+}
+
+var appHeader = []string{
+	"process_name", "process_id", "process_path", "service_name", "process_owner", "exe_sha256", "exe_publisher",
+	"exe_error", "command_line", "total_packets", "total_bytes", "packets_in", "bytes_in", "packets_out", "bytes_out",
+	"first_seen", "last_seen", "destinations",
+}
+
+// appExport is the JSON form of an application row, with its destinations
+// decoded rather than left as an embedded JSON string
+type appExport struct {
+	database.ApplicationStats
+	Destinations []string `json:"destinations,omitempty"`
+}
+
+func exportApps(filter database.PacketFilter, w exportWriter) (int, error) {
+	count := 0
+	err := database.StreamAppStats(filter, func(app database.ApplicationStats) error {
+		count++
+		var destinations []string
+		if app.Destinations != "" {
+			if err := json.Unmarshal([]byte(app.Destinations), &destinations); err != nil {
+				return fmt.Errorf("invalid destinations for %s: %v", app.ProcessName, err)
+			}
+		}
+		return w.Write(appHeader, []string{
+			app.ProcessName,
+			strconv.FormatUint(uint64(app.ProcessID), 10),
+			app.ProcessPath,
+			app.ServiceName,
+			app.ProcessOwner,
+			app.ExeSHA256,
+			app.ExePublisher,
+			app.ExeError,
+			app.CommandLine,
+			strconv.FormatUint(app.TotalPackets, 10),
+			strconv.FormatUint(app.TotalBytes, 10),
+			strconv.FormatUint(app.PacketsIn, 10),
+			strconv.FormatUint(app.BytesIn, 10),
+			strconv.FormatUint(app.PacketsOut, 10),
+			strconv.FormatUint(app.BytesOut, 10),
+			app.FirstSeen.Format(time.RFC3339Nano),
+			app.LastSeen.Format(time.RFC3339Nano),
+			strings.Join(destinations, ";"),
+		}, appExport{ApplicationStats: app, Destinations: destinations})
+	})
+	return count, err
+}
+
+// deviceNames maps interface IDs to their names
+func deviceNames() (map[int64]string, error) {
+	interfaces, err := database.GetInterfaces()
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int64]string, len(interfaces))
+	for _, iface := range interfaces {
+		names[iface.ID] = iface.Name
+	}
+	return names, nil
+}
+
+// parseExportTime accepts RFC3339 timestamps or plain dates in local time and
+// returns the time in UTC, as it is stored
+func parseExportTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	return t.UTC(), err
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"grip/internal/database"
+)
+
+// TestExportCSVRoundTrip stores packets captured with different UTC offsets,
+// exports them as CSV from a time given in yet another offset, and checks
+// that the exported timestamps read back as the stored instants and select
+// the same rows when passed back as -from and -to
+func TestExportCSVRoundTrip(t *testing.T) {
+	config := database.DefaultConfig()
+	config.Path = filepath.Join(t.TempDir(), "grip.db")
+	config.CheckpointInterval = 0
+	if err := database.InitDatabase(config); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(database.CloseDatabase)
+	deviceID, err := database.StoreInterface(database.NetworkInterface{Name: "eth0", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	india := time.FixedZone("IST", 5*3600+1800)
+	pacific := time.FixedZone("PST", -8*3600)
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	packets := []struct {
+		port string
+		at   time.Time
+	}{
+		{"1", noon.Add(250 * time.Millisecond).In(india)},
+		{"2", noon.Add(10*time.Minute + 500*time.Millisecond).In(pacific)},
+		{"3", noon.Add(20*time.Minute + 123456789)},
+	}
+	for _, p := range packets {
+		err := database.StorePacket(database.PacketRecord{Timestamp: p.at, DeviceID: deviceID, SrcIP: "10.0.0.2", SrcPort: p.port,
+			DstIP: "192.0.2.1", DstPort: "443", Protocol: "TCP", Length: 100, Direction: "outgoing"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	export := func(args ...string) [][]string {
+		t.Helper()
+		out := filepath.Join(t.TempDir(), "packets.csv")
+		if err := runExport(append([]string{"-table", "packets", "-format", "csv", "-out", out}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		rows, err := csv.NewReader(file).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) == 0 || !reflect.DeepEqual(rows[0], packetHeader) {
+			t.Fatalf("CSV header %v, want %v", rows, packetHeader)
+		}
+		return rows[1:]
+	}
+
+	rows := export("-from", noon.Add(5*time.Minute).In(india).Format(time.RFC3339))
+	if len(rows) != 2 {
+		t.Fatalf("exported %d packets from 12:05 UTC, want 2: %v", len(rows), rows)
+	}
+	for i, row := range rows {
+		want := packets[i+1]
+		exported, err := time.Parse(time.RFC3339Nano, row[0])
+		if err != nil {
+			t.Fatalf("row %d: %v", i, err)
+		}
+		if !exported.Equal(want.at) || row[1] != "eth0" || row[3] != want.port || row[10] != "100" {
+			t.Errorf("row %d = %v, want port %s at %v on eth0", i, row, want.port, want.at)
+		}
+	}
+
+	// The exported timestamps select the same rows again
+	again := export("-from", rows[0][0], "-to", rows[1][0])
+	if !reflect.DeepEqual(again, rows) {
+		t.Errorf("exporting from %s to %s: %v, want %v", rows[0][0], rows[1][0], again, rows)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+
+	"grip/internal/firewall"
+)
+
+// runFirewall implements the firewall subcommands
+func runFirewall(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: firewall <block|unblock|check> <executable path>")
+	}
+	path := args[1]
+
+	switch args[0] {
+	case "block":
+		if err := firewall.BlockApplication(path); err != nil {
+			return err
+		}
+		fmt.Printf("Outbound traffic from %s is blocked by rule %q\n", path, firewall.RuleName(path))
+		return nil
+	case "unblock":
+		if err := firewall.UnblockApplication(path); err != nil {
+			return err
+		}
+		fmt.Printf("%s is not blocked\n", path)
+		return nil
+	case "check":
+		blocked, err := firewall.IsBlocked(path)
+		if err != nil {
+			return err
+		}
+		if blocked {
+			fmt.Printf("%s is blocked by rule %q\n", path, firewall.RuleName(path))
+		} else {
+			fmt.Printf("%s is not blocked\n", path)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown firewall subcommand %q, expected block, unblock or check", args[0])
+	}
+}
@@ -1,13 +1,61 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"grip/internal/capture"
 	"grip/internal/logger"
 )
 
+// resolveLogLevels applies -log-level to the individual level switches.
+// Any -log-<level> flag given explicitly on the command line wins.
+func resolveLogLevels() error {
+	level, err := logger.ParseLevel(logLevel)
+	if err != nil {
+		return err
+	}
+
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	levels := []struct {
+		flag    string
+		level   logger.LogLevel
+		enabled *bool
+	}{
+		{"log-error", logger.LevelError, &enableError},
+		{"log-warning", logger.LevelWarning, &enableWarning},
+		{"log-info", logger.LevelInfo, &enableInfo},
+		{"log-debug", logger.LevelDebug, &enableDebug},
+		{"log-trace", logger.LevelTrace, &enableTrace},
+	}
+	for _, l := range levels {
+		if !explicit[l.flag] {
+			*l.enabled = l.level <= level
+		}
+	}
+	return nil
+}
+
+// logTimeLayout returns the time layout for -log-time-format, which takes a
+// Go layout or one of the names rfc3339 and rfc3339nano
+func logTimeLayout(format string) string {
+	switch strings.ToLower(format) {
+	case "rfc3339":
+		return time.RFC3339
+	case "rfc3339nano":
+		return time.RFC3339Nano
+	default:
+		return format
+	}
+}
+
 // initMainLogger initializes the logger for the main package before capture is initialized
 func initMainLogger() error {
 	// Validate logging configuration
@@ -26,6 +74,22 @@ func initMainLogger() error {
 		EnableFile:    enableFile,
 		LogFilePath:   logFilePath,
 		UseColors:     useColors,
+		TimeFormat:    logTimeLayout(logTimeFormat),
+		UseUTC:        logUTC,
+		IncludeCaller: logCaller,
+		MaxSizeMB:     logMaxSizeMB,
+		MaxBackups:    logMaxBackups,
+		RotateDaily:   logRotateDaily,
+
+		SeparateLevels: logSeparateLevels,
+		ErrorLogPath:   logErrorPath,
+
+		EnableEventLog: enableEventLog,
+		EventLogSource: svcName,
+		EventLogInfo:   eventLogInfo,
+
+		SyslogAddr:    syslogAddr,
+		SyslogAppName: svcName,
 	}
 
 	// Initialize the logger package directly
@@ -49,17 +113,67 @@ func configureLogging() error {
 		EnableFile:    enableFile,
 		LogFilePath:   logFilePath,
 		UseColors:     useColors,
+		TimeFormat:    logTimeLayout(logTimeFormat),
+		UseUTC:        logUTC,
+		IncludeCaller: logCaller,
+		MaxSizeMB:     logMaxSizeMB,
+		MaxBackups:    logMaxBackups,
+		RotateDaily:   logRotateDaily,
+
+		SeparateLevels: logSeparateLevels,
+		ErrorLogPath:   logErrorPath,
+
+		EnableEventLog: enableEventLog,
+		EventLogSource: svcName,
+		EventLogInfo:   eventLogInfo,
+
+		SyslogAddr:    syslogAddr,
+		SyslogAppName: svcName,
 	}
 
+	capture.SetErrorLogInterval(errorLogInterval)
+
 	// Initialize the capture package logger
 	return capture.InitializeLogger(config)
 }
 
 func usage(errmsg string) {
 	fmt.Fprintf(os.Stderr,
-		"%s\n\nusage: %s <command>\n"+
+		"%s\n\nusage: %s [-config file] [flags] <command>\n"+
 			"       where <command> is one of\n"+
-			"       install, remove, debug, start, stop, pause or continue.\n",
-		errmsg, os.Args[0])
+			"       install, remove, debug, dashboard, status, start, stop, pause or continue.\n"+
+			"       dashboard captures like debug but shows live statistics instead of log lines.\n"+
+			"       install [-write-config] records -config so the service rereads the file on every start;\n"+
+			"       without -config, %s is read if it exists.\n"+
+			"       %s config validate [file]\n"+
+			"       checks a config file without starting capture.\n"+
+			"       %s loglevel <error|warn|info|debug|trace>\n"+
+			"       changes the log level of the running service without restarting it.\n"+
+			"       status, tail, top and loglevel reach the service over the pipe named by -pipe-name.\n"+
+			"       %s analyze <file.pcap>\n"+
+			"       reads packets from a capture file instead of live interfaces.\n"+
+			"       %s replay <file.pcap> [-speed 1]\n"+
+			"       feeds a capture file through the live pipeline, HTTP endpoint and packet stream included, at its original pace times -speed (0 = as fast as possible).\n"+
+			"       %s tail [-app name] [-protocol p] [-direction d] [-dst cidr] [-json]\n"+
+			"       streams the packets captured by the running service to the console.\n"+
+			"       %s top\n"+
+			"       shows live statistics of the running service, or stored traffic when it is unreachable.\n"+
+			"       %s export [-table packets|flows|apps] [-format csv|jsonl] [-out file] [-since 24h] [-process name] [-protocol p] [-direction d] [-include-payload]\n"+
+			"       writes stored packets, flows or application totals to a CSV or JSON Lines file.\n"+
+			"       %s query <top-apps|top-destinations|top-ports> [-since 24h] [-limit 10] [-json]\n"+
+			"       ranks stored flows by bytes.\n"+
+			"       %s query app-history [-interval daily|hourly] [-process name] [-since 24h] [-json]\n"+
+			"       shows the hourly or daily traffic of applications.\n"+
+			"       %s query listeners [-json]\n"+
+			"       lists the listening sockets found by the last scan and the processes that own them.\n"+
+			"       %s -blocklist file blocklist test <ip>\n"+
+			"       reports whether an address matches the blocklist.\n"+
+			"       %s maintenance [-force]\n"+
+			"       compacts and optimizes the database while the service is stopped.\n"+
+			"       %s -webhook-url url webhook test\n"+
+			"       sends a sample event to the webhook.\n"+
+			"       %s firewall <block|unblock|check> <executable path>\n"+
+			"       adds or removes a Windows Firewall rule blocking the program's outbound traffic.\n",
+		errmsg, os.Args[0], defaultConfigPath(), os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 	os.Exit(2)
 }
@@ -1,18 +1,23 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	util "grip/internal"
+	"grip/internal/api"
 	"grip/internal/capture"
 	"grip/internal/database"
+	"grip/internal/ipc"
 	"grip/internal/logger"
+	"grip/internal/stream"
 
 	"golang.org/x/sys/windows/svc"
 )
@@ -20,7 +25,11 @@ import (
 var (
 	svcName = "NetMonitor"
 
+	// Configuration file read before the other flags are applied
+	configPath string
+
 	// Log levels
+	logLevel      string
 	enableError   bool
 	enableWarning bool
 	enableInfo    bool
@@ -32,21 +41,205 @@ var (
 	enableFile    bool
 	logFilePath   string
 	useColors     bool
+	logTimeFormat string
+	logUTC        bool
+	logCaller     bool
+
+	// Log file rotation
+	logMaxSizeMB   int
+	logMaxBackups  int
+	logRotateDaily bool
+
+	// Per-level log files
+	logSeparateLevels bool
+	logErrorPath      string
+
+	// Windows Event Log
+	enableEventLog bool
+	eventLogInfo   bool
+
+	// Remote syslog server
+	syslogAddr string
+
+	// Repeated error suppression
+	errorLogInterval time.Duration
+
+	// HTTP endpoint
+	httpAddr       string
+	metricsMaxApps int
+
+	// gRPC packet stream
+	grpcAddr string
+
+	// Named pipe serving status, tail and top
+	pipeName string
+
+	// Loopback address serving net/http/pprof
+	debugAddr string
+
+	// Set when running under the service manager, which then owns pausing
+	runningAsService bool
+
+	// Raw packet dump
+	dumpDir       string
+	dumpMaxSizeMB int
+	dumpMaxAge    time.Duration
+	dumpMaxFiles  int
+	dumpFilter    string
+
+	// TLS server name inspection
+	sniPorts string
+
+	// Flow aggregation
+	flowIdleTimeout  time.Duration
+	flowCheckpoint   time.Duration
+	storePackets     bool
+	aggregatePackets bool
+	sampleRate       uint64
+
+	// Capture options
+	snapLen           int
+	promiscuous       bool
+	storeExternal     bool
+	readTimeout       time.Duration
+	statsSaveInterval time.Duration
+	statsSavePackets  uint64
+	maxDestinations   int
+	loadApps          int
+	interfaces        string
+	interfaceRescan   time.Duration
+	listenerScan      time.Duration
+	captureFilter     string
+	retention         time.Duration
+
+	// Database
+	dbPath               string
+	dbBusyTimeout        time.Duration
+	dbSynchronous        string
+	dbJournalMode        string
+	dbCacheSizeKB        int
+	dbCheckpointInterval time.Duration
+
+	// GeoIP and reverse DNS enrichment
+	geoipDB           string
+	reverseDNS        bool
+	reverseDNSWorkers int
+	reverseDNSTimeout time.Duration
+
+	// Payload capture
+	capturePayloadBytes int
+	capturePayloadPorts string
+	logPayload          bool
+
+	// Alerts
+	blocklistPath        string
+	alertNewDestinations bool
+	bandwidthThresholdMB int
+	bandwidthInterval    time.Duration
+	webhookURL           string
+
+	// Webhook events besides alerts
+	webhookAuthHeader      string
+	webhookEvents          string
+	webhookSummaryInterval time.Duration
 )
 
 func init() {
+	flag.StringVar(&configPath, "config", "", "JSON or YAML file of flag values (flag names as keys); command-line flags take precedence")
+
 	// Log level flags
-	flag.BoolVar(&enableError, "log-error", true, "Enable error logging")
-	flag.BoolVar(&enableWarning, "log-warning", true, "Enable warning logging")
-	flag.BoolVar(&enableInfo, "log-info", true, "Enable info logging")
-	flag.BoolVar(&enableDebug, "log-debug", false, "Enable debug logging")
-	flag.BoolVar(&enableTrace, "log-trace", false, "Enable trace logging")
+	flag.StringVar(&logLevel, "log-level", "info", "Most verbose level to log: error, warn, info, debug or trace")
+	flag.BoolVar(&enableError, "log-error", true, "Enable error logging (overrides -log-level)")
+	flag.BoolVar(&enableWarning, "log-warning", true, "Enable warning logging (overrides -log-level)")
+	flag.BoolVar(&enableInfo, "log-info", true, "Enable info logging (overrides -log-level)")
+	flag.BoolVar(&enableDebug, "log-debug", false, "Enable debug logging (overrides -log-level)")
+	flag.BoolVar(&enableTrace, "log-trace", false, "Enable trace logging (overrides -log-level)")
 
 	// Log destination flags
 	flag.BoolVar(&enableConsole, "log-console", true, "Enable console logging")
 	flag.BoolVar(&enableFile, "log-file", false, "Enable file logging")
 	flag.StringVar(&logFilePath, "log-path", "logs/netmonitor.log", "Path to log file (if file logging enabled)")
 	flag.BoolVar(&useColors, "log-colors", true, "Use colors in console output")
+	flag.StringVar(&logTimeFormat, "log-time-format", logger.DefaultTimeFormat, "Log timestamp format: a Go time layout, rfc3339 or rfc3339nano")
+	flag.BoolVar(&logUTC, "log-utc", false, "Log timestamps in UTC instead of local time")
+	flag.BoolVar(&logCaller, "log-caller", false, "Prefix debug and trace messages with the source file and line that logged them")
+	flag.IntVar(&logMaxSizeMB, "log-max-size-mb", 100, "Rotate the log file once it exceeds this size in MB (0 for no limit)")
+	flag.IntVar(&logMaxBackups, "log-max-backups", 10, "Number of rotated log files kept (0 to keep all)")
+	flag.BoolVar(&logRotateDaily, "log-rotate-daily", false, "Also rotate the log file when the date changes")
+	flag.BoolVar(&logSeparateLevels, "log-separate-levels", false, "Also write each level to its own file next to -log-path, e.g. netmonitor.error.log")
+	flag.StringVar(&logErrorPath, "log-error-path", "", "Also write errors to this file, e.g. to monitor them separately from the main log")
+	flag.BoolVar(&enableEventLog, "log-eventlog", true, "Write errors, warnings and service start/stop to the Windows Event Log (only once the service is installed)")
+	flag.BoolVar(&eventLogInfo, "log-eventlog-info", false, "Also write info messages to the Windows Event Log")
+	flag.StringVar(&syslogAddr, "log-syslog", "", "Also send log messages to a syslog server, as udp://host:port, tcp://host:port or host:port (UDP)")
+	flag.DurationVar(&errorLogInterval, "error-log-interval", 30*time.Second, "Log a repeated hot-path error (process lookups, database writes) at most once per interval")
+
+	// HTTP endpoint flags
+	flag.StringVar(&httpAddr, "http-addr", "", "Listen address for the Prometheus /metrics endpoint, e.g. 127.0.0.1:9183 (empty to disable); /packets and /history are only served on a loopback address")
+	flag.IntVar(&metricsMaxApps, "metrics-max-apps", 50, "Maximum number of applications exported as metric series (0 to disable per-app metrics)")
+	flag.StringVar(&grpcAddr, "grpc-addr", "", "Loopback listen address for the gRPC live packet stream, e.g. 127.0.0.1:9184 (empty to disable)")
+	flag.StringVar(&pipeName, "pipe-name", ipc.DefaultPipeName, `Name of the pipe \\.\pipe\<name> that status, tail, top and loglevel reach the service on (empty to disable)`)
+	flag.StringVar(&debugAddr, "debug-addr", "", "Loopback address to serve net/http/pprof profiles on, e.g. 127.0.0.1:6060 (empty to disable)")
+
+	// Raw packet dump flags
+	flag.StringVar(&dumpDir, "dump-dir", "", "Directory to mirror raw packets into rotating pcap files (empty to disable)")
+	flag.IntVar(&dumpMaxSizeMB, "dump-max-size-mb", 100, "Rotate a pcap dump file once it exceeds this size in MB (0 for no limit)")
+	flag.DurationVar(&dumpMaxAge, "dump-max-age", 0, "Rotate a pcap dump file after this duration, e.g. 1h (0 for no limit)")
+	flag.IntVar(&dumpMaxFiles, "dump-max-files", 10, "Number of pcap dump files kept per interface (0 for no limit)")
+	flag.StringVar(&dumpFilter, "dump-filter", "", "Optional BPF filter for dumped packets, e.g. \"udp port 53\"")
+
+	// TLS server name flags
+	flag.StringVar(&sniPorts, "sni-ports", "443", "Comma-separated TCP ports whose TLS ClientHello is inspected for the server name")
+
+	// Flow aggregation flags
+	flag.DurationVar(&flowIdleTimeout, "flow-idle-timeout", 60*time.Second, "Write a flow to the database after it has been idle this long")
+	flag.DurationVar(&flowCheckpoint, "flow-checkpoint-interval", 5*time.Minute, "Update the database row of a still-open flow this often (0 to write flows only when they end)")
+	flag.BoolVar(&storePackets, "store-packets", true, "Store one packet_logs row per packet in addition to aggregated flows")
+	flag.BoolVar(&aggregatePackets, "aggregate-packets", false, "Store one packet_logs row per connection, process and second with packet and byte counts instead of one row per packet")
+	flag.Uint64Var(&sampleRate, "sample-rate", 1, "Store only one packet_logs row in this many packets, scaled to stand for all of them; statistics and flows still count every packet (1 stores all)")
+
+	// Capture flags
+	defaults := capture.DefaultCaptureConfig()
+	flag.IntVar(&snapLen, "snaplen", defaults.SnapshotLen, "Bytes captured per packet (64-262144); byte counts always use the full wire length")
+	flag.BoolVar(&promiscuous, "promiscuous", defaults.Promiscuous, "Capture in promiscuous mode (also sees traffic not addressed to this machine)")
+	flag.DurationVar(&readTimeout, "read-timeout", defaults.ReadTimeout, "How long the capture driver may buffer packets before handing them over; lower values reduce latency at the cost of CPU")
+	flag.BoolVar(&storeExternal, "store-external", defaults.StoreExternal, "Store flows and packets of external traffic between two other hosts; when false it is only counted in the statistics")
+	flag.DurationVar(&statsSaveInterval, "stats-save-interval", defaults.SaveInterval, "How often application statistics are written to the database (at least 1s)")
+	flag.Uint64Var(&statsSavePackets, "stats-save-packets", defaults.SavePackets, "Also save statistics once this many packets arrived since the last save, at most once per second (0 to disable)")
+	flag.StringVar(&interfaces, "interfaces", "", "Comma-separated interface names or description substrings to capture on, e.g. \"Ethernet,Wi-Fi\" (empty for all)")
+	flag.DurationVar(&interfaceRescan, "interface-rescan", defaults.RescanInterval, "How often to look for added and removed interfaces while capturing (0 to only look at start)")
+	flag.DurationVar(&listenerScan, "listener-scan-interval", defaults.ListenerScanInterval, "How often listening sockets are recorded; a listener never seen before raises a new_listener alert (0 to disable)")
+	flag.StringVar(&captureFilter, "capture-filter", "", "BPF filter applied to all captured traffic, e.g. \"not port 3389\"")
+	flag.DurationVar(&retention, "retention", 0, "Delete stored packets and flows older than this, e.g. 720h (0 keeps everything)")
+	flag.IntVar(&capturePayloadBytes, "capture-payload", 0, "Store the first N bytes of each packet's application payload, at most 4096 (0 to disable)")
+	flag.StringVar(&capturePayloadPorts, "capture-payload-ports", "", "Comma-separated ports whose payload is stored, e.g. \"80,8080\" (empty for all ports)")
+	flag.BoolVar(&logPayload, "log-payload", false, "Also log stored payloads in hex at debug level; they are left out of the log by default")
+	flag.IntVar(&loadApps, "load-apps", defaults.LoadApps, "Applications whose stored statistics are loaded at start, most recently active first; the rest are loaded when next seen (0 to load all)")
+	flag.IntVar(&maxDestinations, "max-destinations-per-app", defaults.MaxDestinations, "Destinations kept in memory per application; the least recently used ones beyond this are evicted (0 for no limit)")
+
+	// Database flags
+	dbDefaults := database.DefaultConfig()
+	flag.StringVar(&dbPath, "db-path", "", "Database file (default %LOCALAPPDATA%\\GripNetMonitor\\netmonitor.db); its directory is created if needed")
+	flag.DurationVar(&dbBusyTimeout, "db-busy-timeout", dbDefaults.BusyTimeout, "How long a database write waits for a lock held by another reader or writer")
+	flag.StringVar(&dbSynchronous, "db-synchronous", dbDefaults.Synchronous, "SQLite synchronous mode: OFF, NORMAL, FULL or EXTRA (FULL survives power loss; NORMAL is faster but can lose the last commits; OFF is fastest but a system crash can corrupt the database)")
+	flag.StringVar(&dbJournalMode, "db-journal-mode", dbDefaults.JournalMode, "SQLite journal mode: WAL, DELETE or TRUNCATE (WAL lets status, export and the dashboard read during capture; the others block readers on every write)")
+	flag.IntVar(&dbCacheSizeKB, "db-cache-size", dbDefaults.CacheSizeKB, "SQLite page cache per connection in KiB")
+	flag.DurationVar(&dbCheckpointInterval, "db-checkpoint-interval", dbDefaults.CheckpointInterval, "How often the write-ahead log is checkpointed and truncated so the -wal file stays small (0 to leave it to SQLite, which never shrinks it)")
+
+	// Enrichment flags
+	flag.StringVar(&geoipDB, "geoip-db", "", "Comma-separated MaxMind MMDB files (e.g. GeoLite2-Country.mmdb,GeoLite2-ASN.mmdb) used to tag external destinations")
+	flag.BoolVar(&reverseDNS, "reverse-dns", defaults.ReverseDNS, "Look up PTR names of destination IPs in the background (set to false to send no lookups)")
+	flag.IntVar(&reverseDNSWorkers, "reverse-dns-workers", defaults.ReverseDNSWorkers, "Reverse DNS lookups in flight at once")
+	flag.DurationVar(&reverseDNSTimeout, "reverse-dns-timeout", defaults.ReverseDNSTimeout, "Give up on a single reverse DNS lookup after this long")
+
+	// Alert flags
+	flag.StringVar(&blocklistPath, "blocklist", "", "File of IP addresses and CIDR ranges, one per line; outgoing traffic to them is flagged and raises an alert (reloaded when it changes)")
+	flag.BoolVar(&alertNewDestinations, "alert-new-destinations", false, "Log a warning the first time an application contacts a destination it has never used before")
+	flag.IntVar(&bandwidthThresholdMB, "bandwidth-threshold-mb", 0, "Alert when an application transfers more than this many MB within -bandwidth-interval (0 to disable)")
+	flag.DurationVar(&bandwidthInterval, "bandwidth-interval", time.Minute, "Measurement window for -bandwidth-threshold-mb")
+	flag.StringVar(&webhookURL, "webhook-url", "", "POST events selected with -webhook-events as JSON to this URL (empty to disable)")
+	flag.StringVar(&webhookAuthHeader, "webhook-auth-header", "", "Header sent with every webhook request, e.g. \"Authorization: Bearer <token>\"; a value without a name is sent as Authorization")
+	flag.StringVar(&webhookEvents, "webhook-events", webhookEventAlerts, "Comma-separated events sent to the webhook: alerts, summary, service")
+	flag.DurationVar(&webhookSummaryInterval, "webhook-summary-interval", time.Hour, "How often a traffic summary is sent when -webhook-events includes summary")
 }
 
 type netmonitor struct{}
@@ -60,17 +253,283 @@ func checkNpcapInstallation() {
 
 }
 
+func databaseConfig() database.Config {
+	return database.Config{
+		Path:               dbPath,
+		BusyTimeout:        dbBusyTimeout,
+		Synchronous:        dbSynchronous,
+		JournalMode:        dbJournalMode,
+		CacheSizeKB:        dbCacheSizeKB,
+		CheckpointInterval: dbCheckpointInterval,
+	}
+}
+
 func initDatabase() {
-	err := database.InitDatabase()
-	if err != nil {
+	err := database.InitDatabase(databaseConfig())
+	var shareErr *database.NetworkShareError
+	if errors.As(err, &shareErr) {
+		logger.Warning("%v", err)
+	} else if err != nil {
 		logger.Error("an Error occured while initializing the database: %v", err)
 		os.Exit(1)
 	}
+	logger.Info("Using database %s", database.Path())
+}
+
+func initReadOnlyDatabase() {
+	err := database.InitReadOnlyDatabase(dbPath)
+	if err != nil {
+		logger.Error("an Error occured while opening the database: %v", err)
+		os.Exit(1)
+	}
+}
+
+func enablePacketDump() error {
+	return capture.EnablePacketDump(capture.DumpConfig{
+		Dir:      dumpDir,
+		MaxSize:  int64(dumpMaxSizeMB) * 1024 * 1024,
+		MaxAge:   dumpMaxAge,
+		MaxFiles: dumpMaxFiles,
+		Filter:   dumpFilter,
+	})
+}
+
+func captureConfig() (capture.CaptureConfig, error) {
+	payloadPorts, err := parsePortList("capture-payload-ports", capturePayloadPorts)
+	if err != nil {
+		return capture.CaptureConfig{}, err
+	}
+
+	return capture.CaptureConfig{
+		SnapshotLen:     snapLen,
+		Promiscuous:     promiscuous,
+		ReadTimeout:     readTimeout,
+		StoreExternal:   storeExternal,
+		SaveInterval:    statsSaveInterval,
+		SavePackets:     statsSavePackets,
+		MaxDestinations: maxDestinations,
+		LoadApps:        loadApps,
+		Interfaces:      splitList(interfaces),
+		Filter:          captureFilter,
+		Retention:       retention,
+		RescanInterval:  interfaceRescan,
+
+		ListenerScanInterval: listenerScan,
+
+		ReverseDNS:        reverseDNS,
+		ReverseDNSWorkers: reverseDNSWorkers,
+		ReverseDNSTimeout: reverseDNSTimeout,
+
+		PayloadBytes: capturePayloadBytes,
+		PayloadPorts: payloadPorts,
+		LogPayload:   logPayload,
+
+		Blocklist: blocklistPath,
+	}, nil
+}
+
+func configureFlows() {
+	capture.ConfigureFlows(capture.FlowConfig{
+		IdleTimeout:        flowIdleTimeout,
+		CheckpointInterval: flowCheckpoint,
+		StorePackets:       storePackets,
+		AggregatePackets:   aggregatePackets,
+		SampleRate:         sampleRate,
+	})
+}
+
+// configureSNIPorts parses the -sni-ports flag and applies it to capture
+func configureSNIPorts() error {
+	ports, err := parseSNIPorts()
+	if err != nil {
+		return err
+	}
+	capture.SetSNIPorts(ports)
+	return nil
+}
+
+// parseSNIPorts parses the -sni-ports list
+func parseSNIPorts() ([]uint16, error) {
+	return parsePortList("sni-ports", sniPorts)
+}
+
+// parsePortList parses a comma-separated list of ports given with the named flag
+func parsePortList(name, value string) ([]uint16, error) {
+	var ports []uint16
+	for _, field := range splitList(value) {
+		port, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q in -%s: %v", field, name, err)
+		}
+		ports = append(ports, uint16(port))
+	}
+	return ports, nil
+}
+
+// loadGeoIP opens the GeoIP databases given with -geoip-db
+func loadGeoIP() error {
+	if geoipDB == "" {
+		return nil
+	}
+
+	return capture.LoadGeoIP(splitList(geoipDB))
+}
+
+// startMonitoring applies the flags and starts capture with start and the
+// HTTP endpoint for debug, dashboard and replay mode
+func startMonitoring(start func(capture.CaptureConfig) error) error {
+	if err := configureLogging(); err != nil {
+		return fmt.Errorf("Failed to configure logging: %v", err)
+	}
+	if err := enablePacketDump(); err != nil {
+		return err
+	}
+	if err := configureSNIPorts(); err != nil {
+		return err
+	}
+	configureFlows()
+	if err := configureAlerts(); err != nil {
+		return err
+	}
+	if err := loadGeoIP(); err != nil {
+		return err
+	}
+	config, err := captureConfig()
+	if err != nil {
+		return err
+	}
+	if err := start(config); err != nil {
+		return err
+	}
+	startWebhookSummary()
+	return startHTTPServer()
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// configureAlerts logs the enabled alert kinds and forwards them to the webhook, if any
+func configureAlerts() error {
+	capture.ConfigureThresholds(capture.ThresholdConfig{
+		BytesPerInterval: uint64(bandwidthThresholdMB) * 1024 * 1024,
+		Interval:         bandwidthInterval,
+	})
+	if err := configureWebhook(); err != nil {
+		return err
+	}
+
+	enabled := make(map[string]bool)
+	if alertNewDestinations {
+		enabled[capture.AlertNewDestination] = true
+	}
+	if bandwidthThresholdMB > 0 {
+		enabled[capture.AlertBandwidthThreshold] = true
+	}
+	if blocklistPath != "" {
+		enabled[capture.AlertBlockedDestination] = true
+	}
+	if listenerScan > 0 {
+		enabled[capture.AlertNewListener] = true
+	}
+	if len(enabled) == 0 {
+		return nil
+	}
+
+	forward := webhookEnabled(webhookEventAlerts)
+	capture.RegisterAlertHandler(func(alert capture.Alert) {
+		if !enabled[alert.Kind] {
+			return
+		}
+		capture.LogAlert(alert)
+		if forward {
+			eventWebhook.Notify(alert)
+		}
+	})
+	return nil
+}
+
+func startHTTPServer() error {
+	if err := api.Start(api.Config{
+		Addr:         httpAddr,
+		MaxAppSeries: metricsMaxApps,
+	}); err != nil {
+		return err
+	}
+	if err := stream.Start(stream.Config{Addr: grpcAddr}); err != nil {
+		api.Stop()
+		return err
+	}
+	if err := ipc.Start(ipc.Config{Name: pipeName, SetPaused: setCapturePaused}); err != nil {
+		stream.Stop()
+		api.Stop()
+		return err
+	}
+	if err := api.StartDebug(debugAddr); err != nil {
+		ipc.Stop()
+		stream.Stop()
+		api.Stop()
+		return err
+	}
+	return nil
+}
+
+// stopHTTPServer stops the metrics endpoint, the packet stream, the pipe and
+// the profiling endpoint
+func stopHTTPServer() {
+	api.StopDebug()
+	ipc.Stop()
+	stream.Stop()
+	api.Stop()
+}
+
+// setCapturePaused pauses or resumes capture for a pipe client. Under the
+// service manager the request goes through it, so the service state follows.
+func setCapturePaused(pause bool) error {
+	if !runningAsService {
+		capture.SetPaused(pause)
+		return nil
+	}
+	if pause {
+		return controlService(svc.Pause)
+	}
+	return controlService(svc.Continue)
+}
+
+// stopMonitoring prints the final statistics, stops everything started by
+// startMonitoring and exits. A second signal on signalChan forces an exit in
+// case shutdown hangs.
+func stopMonitoring(signalChan <-chan os.Signal) {
+	go func() {
+		<-signalChan
+		logger.Warning("Second shutdown signal received, exiting immediately")
+		os.Exit(1)
+	}()
+
+	// Print final statistics
+	printStatistics()
+
+	// Stop serving metrics and streams, then stop capture and close database and logger
+	stopWebhookSummary()
+	stopHTTPServer()
+	capture.StopCapture()
+	closeWebhook()
+
+	logger.Info("Shutdown complete")
+	os.Exit(0)
 }
 
 func (m *netmonitor) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
 	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
 	changes <- svc.Status{State: svc.StartPending}
+	runningAsService = true
 
 	checkNpcapInstallation()
 	initDatabase()
@@ -81,13 +540,44 @@ func (m *netmonitor) Execute(args []string, r <-chan svc.ChangeRequest, changes
 		return true, 1
 	}
 
+	if err := enablePacketDump(); err != nil {
+		logger.Error("Failed to enable packet dump: %v", err)
+		return true, 1
+	}
+	if err := configureSNIPorts(); err != nil {
+		logger.Error("%v", err)
+		return true, 1
+	}
+	configureFlows()
+	if err := configureAlerts(); err != nil {
+		logger.Error("Failed to configure alerts: %v", err)
+		return true, 1
+	}
+	if err := loadGeoIP(); err != nil {
+		logger.Error("%v", err)
+		return true, 1
+	}
+
 	// Start packet capture
-	if err := capture.StartCapture(); err != nil {
+	config, err := captureConfig()
+	if err != nil {
+		logger.Error("%v", err)
+		return true, 1
+	}
+	if err := capture.StartCapture(config); err != nil {
 		logger.Error("Failed to start capture: %v", err)
 		return true, 1
 	}
+	startWebhookSummary()
+
+	if err := startHTTPServer(); err != nil {
+		logger.Error("Failed to start HTTP server: %v", err)
+		return true, 1
+	}
 
 	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+	logger.Event("%s service started", svcName)
+	notifyService("service_started", "%s service started", svcName)
 
 	// Start statistics reporting in a goroutine
 	ticker := time.NewTicker(1 * time.Minute)
@@ -102,16 +592,31 @@ func (m *netmonitor) Execute(args []string, r <-chan svc.ChangeRequest, changes
 		case svc.Interrogate:
 			changes <- c.CurrentStatus
 		case svc.Stop, svc.Shutdown:
+			// Capture shutdown closes the logger, so record the stop first
+			logger.Event("%s service stopping", svcName)
+			notifyService("service_stopping", "%s service stopping", svcName)
 			ticker.Stop()
+			stopWebhookSummary()
+			stopHTTPServer()
 			capture.StopCapture()
+			closeWebhook()
 			printStatistics() // Print final statistics
 			changes <- svc.Status{State: svc.StopPending}
 			return
 		case svc.Pause:
+			capture.SetPaused(true)
 			changes <- svc.Status{State: svc.Paused, Accepts: cmdsAccepted}
+			logger.Event("%s service paused", svcName)
 		case svc.Continue:
+			capture.SetPaused(false)
 			changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+			logger.Event("%s service resumed", svcName)
 		default:
+			if level, ok := logLevelFromControl(c.Cmd); ok {
+				logger.SetLevel(level)
+				logger.Warning("Log level changed to %s", level)
+				continue
+			}
 			logger.Warning("Unexpected control request #%d", c)
 		}
 	}
@@ -125,8 +630,63 @@ func main() {
 		usage("no command specified")
 	}
 
-	checkNpcapInstallation()
-	initDatabase()
+	command := strings.ToLower(flag.Args()[0])
+
+	// Config validation reports problems itself instead of exiting on them
+	if command == "config" {
+		if err := runConfig(flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if path := resolveConfigPath(); path != "" {
+		if err := loadConfigFile(path); err != nil {
+			usage(err.Error())
+		}
+	}
+
+	if err := resolveLogLevels(); err != nil {
+		usage(err.Error())
+	}
+
+	// These commands need neither the database nor Npcap
+	var helper func([]string) error
+	switch command {
+	case "blocklist":
+		helper = runBlocklist
+	case "webhook":
+		helper = runWebhook
+	case "firewall":
+		helper = runFirewall
+	case "tail":
+		helper = runTail
+	}
+	if helper != nil {
+		if err := helper(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	switch command {
+	case "status":
+		// Reports on Npcap and the database itself instead of exiting
+	case "maintenance":
+		// Opens the database itself once the service is known to be stopped
+	case "export", "query":
+		// Reporting commands only read, so they can run alongside the service
+		initReadOnlyDatabase()
+	case "top":
+		// Reads from the service, opening the database itself only if that fails.
+		// It owns the terminal, so log messages only go to the log file.
+		enableConsole = false
+	default:
+		checkNpcapInstallation()
+		initDatabase()
+	}
 
 	// Initialize main logger before anything else
 	if err := initMainLogger(); err != nil {
@@ -134,17 +694,20 @@ func main() {
 		os.Exit(1)
 	}
 
-	command := strings.ToLower(flag.Args()[0])
-
 	switch command {
-	case "debug":
-		logger.Info("Starting in debug mode")
-		if err := configureLogging(); err != nil {
-			logger.Error("Failed to configure logging: %v", err)
-			os.Exit(1)
+	case "debug", "dashboard":
+		// The dashboard owns the terminal, so log messages only go to the log file
+		dashboard := command == "dashboard"
+		if dashboard {
+			enableConsole = false
 		}
-		if err := capture.StartCapture(); err != nil {
+
+		logger.Info("Starting in debug mode")
+		if err := startMonitoring(capture.StartCapture); err != nil {
 			logger.Error("%v", err)
+			if dashboard {
+				fmt.Fprintln(os.Stderr, err)
+			}
 			os.Exit(1)
 		}
 
@@ -152,22 +715,86 @@ func main() {
 		signalChan := make(chan os.Signal, 1)
 		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
-		logger.Info("Press Ctrl+C to stop capturing")
+		if dashboard {
+			// Returns on q or the first signal
+			if err := runDashboard(signalChan); err != nil {
+				fmt.Fprintf(os.Stderr, "Dashboard failed: %v\n", err)
+			}
+		} else {
+			logger.Info("Press Ctrl+C to stop capturing")
 
-		// Wait for termination signal
-		<-signalChan
+			// Wait for termination signal
+			<-signalChan
+		}
 
 		logger.Info("Shutdown signal received, stopping capture...")
+		stopMonitoring(signalChan)
+	case "top":
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
 
-		// Print final statistics
-		printStatistics()
+		// Returns on q or the first signal
+		if err := runTop(signalChan); err != nil {
+			fmt.Fprintf(os.Stderr, "Top failed: %v\n", err)
+			os.Exit(1)
+		}
+	case "replay":
+		if err := runReplay(flag.Args()[1:]); err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+	case "analyze":
+		if len(flag.Args()) < 2 {
+			usage("analyze requires a capture file path")
+		}
+		if err := configureLogging(); err != nil {
+			logger.Error("Failed to configure logging: %v", err)
+			os.Exit(1)
+		}
+		configureFlows()
+		if err := capture.AnalyzeFile(flag.Args()[1]); err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
 
-		// Stop capture and close database
+		// Print summary statistics for the file and persist them
+		printStatistics()
 		capture.StopCapture()
-
-		logger.Info("Shutdown complete")
-		os.Exit(0)
+	case "status":
+		// A non-zero exit code means the service is stopped or the status is unknown
+		if err := printStatus(); err != nil {
+			logger.Error("%v", err)
+			os.Exit(1)
+		}
+	case "export":
+		if err := runExport(flag.Args()[1:]); err != nil {
+			logger.Error("Export failed: %v", err)
+			os.Exit(1)
+		}
+	case "query":
+		if err := runQuery(flag.Args()[1:]); err != nil {
+			logger.Error("Query failed: %v", err)
+			os.Exit(1)
+		}
+	case "maintenance":
+		if err := runMaintenance(flag.Args()[1:]); err != nil {
+			logger.Error("Maintenance failed: %v", err)
+			os.Exit(1)
+		}
 	case "install":
+		installFlags := flag.NewFlagSet("install", flag.ExitOnError)
+		writeConfig := installFlags.Bool("write-config", false, "Write a config file with the current settings unless one exists")
+		installFlags.Parse(flag.Args()[1:])
+		if *writeConfig {
+			path := configPath
+			if path == "" {
+				path = defaultConfigPath()
+			}
+			if err := writeDefaultConfig(path); err != nil {
+				logger.Warning("Not writing config: %v", err)
+			}
+		}
+
 		err := installService()
 		if err != nil {
 			logger.Error("Failed to install: %v", err)
@@ -181,6 +808,19 @@ func main() {
 			os.Exit(1)
 		}
 		logger.Info("Service removed successfully")
+	case "loglevel":
+		if len(flag.Args()) < 2 {
+			usage("loglevel requires a level: error, warn, info, debug or trace")
+		}
+		level, err := logger.ParseLevel(flag.Args()[1])
+		if err != nil {
+			usage(err.Error())
+		}
+		if err := setServiceLogLevel(level); err != nil {
+			logger.Error("Failed to change service log level: %v", err)
+			os.Exit(1)
+		}
+		logger.Info("Service log level set to %s", level)
 	case "start", "stop", "pause", "continue":
 		runService(false)
 	default:
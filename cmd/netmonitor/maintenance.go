@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"grip/internal/database"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// runMaintenance compacts and optimizes the database, reporting the space
+// reclaimed. It refuses to run while the service is using the database
+// unless -force is given.
+func runMaintenance(args []string) error {
+	fs := flag.NewFlagSet("maintenance", flag.ContinueOnError)
+	force := fs.Bool("force", false, "Run even if the service is not stopped; capture may stall or fail while the database is rebuilt")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	installed, state, err := queryServiceState()
+	if err != nil {
+		return fmt.Errorf("could not check whether %s is running: %v", svcName, err)
+	}
+	if installed && state != svc.Stopped {
+		if !*force {
+			return fmt.Errorf("service %s is %s; stop it first or pass -force", svcName, serviceStateName(state))
+		}
+		fmt.Printf("Warning: service %s is %s, continuing because of -force\n", svcName, serviceStateName(state))
+	}
+
+	err = database.InitDatabase(databaseConfig())
+	var shareErr *database.NetworkShareError
+	if errors.As(err, &shareErr) {
+		fmt.Printf("Warning: %v\n", err)
+	} else if err != nil {
+		return err
+	}
+	defer database.CloseDatabase()
+
+	path := database.Path()
+	before := databaseSize(path)
+	fmt.Printf("Compacting %s (%s)...\n", path, formatBytes(uint64(before)))
+
+	if err := database.Vacuum(); err != nil {
+		return err
+	}
+
+	after := databaseSize(path)
+	fmt.Printf("Database size: %s -> %s", formatBytes(uint64(before)), formatBytes(uint64(after)))
+	if after < before {
+		fmt.Printf(" (%s reclaimed)", formatBytes(uint64(before-after)))
+	}
+	fmt.Println()
+	return nil
+}
+
+// databaseSize returns the size of the database file and its write-ahead log
+func databaseSize(path string) int64 {
+	var size int64
+	for _, file := range []string{path, path + "-wal"} {
+		if info, err := os.Stat(file); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
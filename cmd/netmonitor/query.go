@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"grip/internal/database"
+)
+
+// runQuery prints top-N reports aggregated from the stored flows, the
+// traffic history of applications from the hourly and daily rollups, or the
+// listening sockets recorded by the last scan
+func runQuery(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("query requires a report: top-apps, top-destinations, top-ports, app-history or listeners")
+	}
+	report := args[0]
+
+	fs := flag.NewFlagSet("query "+report, flag.ContinueOnError)
+	since := fs.String("since", "24h", "Only include flows active since this long ago (e.g. 1h) or this time (RFC3339 or YYYY-MM-DD)")
+	limit := fs.Int("limit", 10, "Number of rows to show")
+	asJSON := fs.Bool("json", false, "Print the result as JSON instead of a table")
+	interval := fs.String("interval", "daily", "Bucket size of app-history: hourly or daily")
+	process := fs.String("process", "", "Only show this application in app-history")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *limit <= 0 {
+		return fmt.Errorf("-limit must be positive")
+	}
+
+	from, err := parseSince(*since)
+	if err != nil {
+		return fmt.Errorf("invalid -since: %v", err)
+	}
+
+	var result interface{}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	switch report {
+	case "top-apps":
+		apps, err := database.GetTopAppsByBytes(from, *limit)
+		if err != nil {
+			return err
+		}
+		result = apps
+		fmt.Fprintln(w, "APPLICATION\tBYTES\tPACKETS\tFLOWS")
+		for _, app := range apps {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\n", app.ProcessName, formatBytes(app.Bytes), app.Packets, app.Flows)
+		}
+	case "top-destinations":
+		destinations, err := database.GetTopDestinations(from, *limit)
+		if err != nil {
+			return err
+		}
+		result = destinations
+		fmt.Fprintln(w, "DESTINATION\tHOST\tBYTES\tPACKETS\tFLOWS")
+		for _, dest := range destinations {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", dest.IP, dest.Host, formatBytes(dest.Bytes), dest.Packets, dest.Flows)
+		}
+	case "top-ports":
+		ports, err := database.GetTopPorts(from, *limit)
+		if err != nil {
+			return err
+		}
+		result = ports
+		fmt.Fprintln(w, "PROTOCOL\tPORT\tBYTES\tPACKETS\tFLOWS")
+		for _, port := range ports {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\n", port.Protocol, port.Port, formatBytes(port.Bytes), port.Packets, port.Flows)
+		}
+	case "app-history":
+		points, err := database.GetAppTimeSeries(database.RollupInterval(*interval), *process, from, time.Now())
+		if err != nil {
+			return err
+		}
+		result = points
+		fmt.Fprintln(w, "START\tAPPLICATION\tBYTES\tPACKETS\tKEY")
+		for _, point := range points {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", point.Start.Local().Format("2006-01-02 15:04"),
+				point.ProcessName, formatBytes(point.Bytes), point.Packets, point.AppKey)
+		}
+	case "listeners":
+		listeners, err := database.GetOpenListeners()
+		if err != nil {
+			return err
+		}
+		result = listeners
+		fmt.Fprintln(w, "PROTOCOL\tADDRESS\tPORT\tPID\tPROCESS\tFIRST SEEN\tPATH")
+		for _, l := range listeners {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", l.Protocol, l.Address, l.Port, l.ProcessID,
+				l.ProcessName, l.FirstSeen.Local().Format("2006-01-02 15:04"), l.ProcessPath)
+		}
+	default:
+		return fmt.Errorf("unknown report %q, expected top-apps, top-destinations, top-ports, app-history or listeners", report)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	return w.Flush()
+}
+
+// parseSince accepts a duration before now or an absolute time
+func parseSince(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return parseExportTime(value)
+}
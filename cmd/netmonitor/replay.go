@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"grip/internal/capture"
+	"grip/internal/logger"
+)
+
+// runReplay feeds a capture file through the live pipeline, with the HTTP
+// endpoint, packet stream, alerts and webhooks enabled as in debug mode, and
+// stops once the file is done or on Ctrl+C
+func runReplay(args []string) error {
+	if len(args) < 1 || args[0] == "" || args[0][0] == '-' {
+		return fmt.Errorf("replay requires a capture file path")
+	}
+	path := args[0]
+
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	speed := fs.Float64("speed", 1, "Replay speed relative to the capture timestamps; 0 replays as fast as possible")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *speed < 0 {
+		return fmt.Errorf("-speed must not be negative")
+	}
+
+	var replayed <-chan struct{}
+	err := startMonitoring(func(config capture.CaptureConfig) error {
+		var err error
+		replayed, err = capture.StartCaptureFromFile(config, path, *speed)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+
+	logger.Info("Press Ctrl+C to stop the replay")
+	select {
+	case <-replayed:
+		logger.Info("Replay finished, stopping capture...")
+	case <-signalChan:
+		logger.Info("Shutdown signal received, stopping capture...")
+	}
+	stopMonitoring(signalChan)
+	return nil
+}
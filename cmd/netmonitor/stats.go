@@ -1,13 +1,22 @@
 package main
 
-
 import (
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"grip/internal/capture"
+	"grip/internal/database"
 	"grip/internal/logger"
 )
 
+// maxTopTalkers limits how many applications are included in the periodic report
+const maxTopTalkers = 10
+
+// maxActiveFlows limits how many open connections are listed in the periodic report
+const maxActiveFlows = 5
+
 func printStatistics() {
 	stats := capture.GetStatistics()
 	uptime := time.Since(stats.StartTime)
@@ -16,51 +25,196 @@ func printStatistics() {
 	logger.Info("Uptime: %v", uptime.Round(time.Second))
 	logger.Info("Total Packets: %d", stats.TotalPackets.Load())
 	logger.Info("Total Bytes: %d", stats.TotalBytes.Load())
-	logger.Info("Packets/Second: %.2f", float64(stats.TotalPackets.Load())/uptime.Seconds())
-	logger.Info("Bytes/Second: %.2f", float64(stats.TotalBytes.Load())/uptime.Seconds())
+	if seconds := uptime.Seconds(); seconds > 0 {
+		logger.Info("Average Packets/Second: %.2f", float64(stats.TotalPackets.Load())/seconds)
+		logger.Info("Average Bytes/Second: %.2f", float64(stats.TotalBytes.Load())/seconds)
+	}
+	logger.Info("Current Rate: %s/s over %s; %s", formatBytes(uint64(capture.CurrentRate(""))),
+		capture.CurrentRateWindow, formatRates(capture.GetRates()))
+
+	// Driver counters show whether packets are lost before grip ever sees them
+	interfaceStats := capture.GetInterfaceStats()
+	if len(interfaceStats) > 0 {
+		logger.Info("Interface Statistics:")
+		for device, ifStats := range interfaceStats {
+			state := "capturing"
+			if !ifStats.Active.Load() {
+				state = "stopped " + ifStats.StateChanged().Format("2006-01-02 15:04:05")
+			}
+			logger.Info("  %s (%s): %d packets, %d bytes (driver: received %d, dropped %d, interface dropped %d)",
+				capture.InterfaceDisplayName(device), state, ifStats.TotalPackets.Load(), ifStats.TotalBytes.Load(),
+				ifStats.PacketsReceived.Load(), ifStats.PacketsDropped.Load(), ifStats.PacketsIfDropped.Load())
+		}
+	}
+
+	// Traffic that could not be attributed to a local process, e.g. inbound scans
+	if failures := capture.GetLookupFailures(); len(failures) > 0 {
+		categories := make([]string, 0, len(failures))
+		for category := range failures {
+			categories = append(categories, category)
+		}
+		sort.Strings(categories)
+
+		logger.Info("Process Lookup Failures:")
+		for _, category := range categories {
+			logger.Info("  %s: %d", category, failures[category])
+		}
+	}
+
+	// What monitoring costs, so its overhead can be weighed in the field
+	if self := stats.Self.Load(); self != nil {
+		logger.Info("Monitor: %s resident, %s heap, %d goroutines, %.1f packets/s processed", formatBytes(self.ResidentBytes),
+			formatBytes(self.HeapBytes), self.Goroutines, self.PacketsPerSec)
+		logger.Info("Monitor GC: %d collections, %v paused in total, last pause %v", self.GCCount,
+			self.GCPauseTotal.Round(time.Microsecond), self.GCPauseLast.Round(time.Microsecond))
+		logger.Info("Monitor Queues: storage %d/%d, dump %d/%d", self.StorageQueue, self.StorageQueueSize,
+			self.DumpQueue, self.DumpQueueSize)
+	}
+
+	// Writes lost to lock conflicts or other database errors
+	if dropped := database.DroppedWrites(); dropped > 0 {
+		logger.Warning("Dropped database writes: %d", dropped)
+	}
+
+	if hits := stats.BlockedHits.Load(); hits > 0 {
+		logger.Warning("Packets to blocklisted destinations: %d", hits)
+	}
+
+	// Rows stand for many packets, so the database loses per-packet detail
+	if aggregatePackets {
+		packets, rows := capture.GetPacketAggregation()
+		logger.Info("Packet Rows: %d stored for %d packets (aggregated per second: rates are exact, individual packet timing is lost)",
+			rows, packets)
+	}
+
+	if skipped := capture.GetSampleSkipped(); skipped > 0 {
+		logger.Info("Packet Sampling: 1 in %d packets stored, %d packet rows skipped (totals and flows are exact)", sampleRate, skipped)
+	}
+
+	if dropped := capture.GetStorageDropped(); dropped > 0 {
+		logger.Warning("Storage backlog drops: %d packet records and flows not written to the database", dropped)
+	}
+
+	if dropped := capture.GetDumpDropped(); dropped > 0 {
+		logger.Warning("Packet dump queue full, %d packets not written to pcap files", dropped)
+	}
 
 	logger.Info("Protocol Distribution:")
-	stats.PacketsByProtocol.Range(func(key, value interface{}) bool {
-		protocol := key.(string)
-		count := value.(uint64)
-		percentage := float64(count) / float64(stats.TotalPackets.Load()) * 100
-		logger.Info("  %s: %d (%.1f%%)", protocol, count, percentage)
-		return true
-	})
-
-	// Get per-application statistics
+	for protocol, count := range capture.GetProtocolCounts() {
+		percentage := percentOf(count.Packets, stats.TotalPackets.Load())
+		logger.Info("  %s: %d packets (%.1f%%), %d bytes", protocol, count.Packets, percentage, count.Bytes)
+	}
+
+	logger.Info("Direction Breakdown:")
+	directions := capture.GetDirectionCounts()
+	for _, direction := range capture.Directions {
+		count := directions[direction]
+		percentage := percentOf(count.Packets, stats.TotalPackets.Load())
+		label := direction
+		if direction == "external" {
+			label = "external (forwarded/observed)"
+		}
+		logger.Info("  %s: %d packets (%.1f%%), %d bytes", label, count.Packets, percentage, count.Bytes)
+	}
+
+	// Only shown on trunked interfaces that deliver tagged packets
+	if vlanCounts := capture.GetVLANCounts(); len(vlanCounts) > 0 {
+		vlans := make([]uint16, 0, len(vlanCounts))
+		for vlan := range vlanCounts {
+			vlans = append(vlans, vlan)
+		}
+		sort.Slice(vlans, func(i, j int) bool { return vlans[i] < vlans[j] })
+
+		logger.Info("VLAN Breakdown:")
+		for _, vlan := range vlans {
+			count := vlanCounts[vlan]
+			percentage := percentOf(count.Packets, stats.TotalPackets.Load())
+			logger.Info("  VLAN %d: %d packets (%.1f%%), %d bytes", vlan, count.Packets, percentage, count.Bytes)
+		}
+	}
+
+	// Largest connections that are still open
+	flows := capture.GetActiveFlows()
+	logger.Info("Active Flows: %d", len(flows))
+	for i := 0; i < len(flows) && i < maxActiveFlows; i++ {
+		flow := flows[i]
+		logger.Info("  %s %s:%s -> %s:%s (%s): %d packets, %d bytes, process: %s",
+			flow.Protocol, flow.SrcIP, flow.SrcPort, flow.DstIP, flow.DstPort,
+			flow.Direction, flow.Packets, flow.Bytes, flow.ProcessName)
+	}
+
+	// Get per-application statistics, limited to the heaviest talkers
 	appStats := capture.GetApplicationStats()
 	if len(appStats) > 0 {
-		logger.Info("=== Application Statistics ===")
+		topTalkers := capture.TopTalkers(maxTopTalkers)
+		logger.Info("=== Application Statistics (top %d of %d by bytes) ===", len(topTalkers), len(appStats))
+
+		for _, talker := range topTalkers {
+			appName := talker.ProcessName
+			app, ok := appStats[talker.Key]
+			if !ok {
+				continue
+			}
 
-		for appName, app := range appStats {
-			logger.Info("Application: %s (PID: %d)", appName, app.ProcessID)
-			logger.Info("  Total Packets: %d", app.TotalPackets.Load())
-			logger.Info("  Total Bytes: %d", app.TotalBytes.Load())
+			details := fmt.Sprintf("PID: %d", app.ProcessID)
+			if app.Owner != "" {
+				details += ", user: " + app.Owner
+			}
+			logger.Info("Application: %s (%s)", appName, details)
+			if app.ProcessPath != "" {
+				logger.Info("  Path: %s", app.ProcessPath)
+			}
+			if commandLine := app.CommandLine(); commandLine != "" {
+				logger.Info("  Command Line: %s", commandLine)
+			}
+			if parent := app.ParentProcessName(); parent != "" {
+				logger.Info("  Started By: %s", parent)
+			}
+			if exe := app.Executable(); exe != nil {
+				publisher := exe.Publisher
+				if publisher == "" {
+					publisher = "no embedded signature"
+				}
+				if exe.Error != "" {
+					logger.Info("  Executable: not hashed (%s), publisher: %s", exe.Error, publisher)
+				} else {
+					logger.Info("  Executable: SHA-256 %s, publisher: %s", exe.SHA256, publisher)
+				}
+			}
+			logger.Info("  Total Packets: %d (lifetime %d)", app.TotalPackets.Load(), app.LifetimePackets())
+			logger.Info("  Total Bytes: %d (lifetime %d)", app.TotalBytes.Load(), app.LifetimeBytes())
+			in, out, other := app.LifetimeByDirection()
+			logger.Info("  Lifetime In: %s in %d packets, Out: %s in %d packets, Other: %s in %d packets",
+				formatBytes(in.Bytes), in.Packets, formatBytes(out.Bytes), out.Packets,
+				formatBytes(other.Bytes), other.Packets)
+			logger.Info("  Current Rate: %s/s over %s; %s", formatBytes(uint64(capture.CurrentRate(talker.Key))),
+				capture.CurrentRateWindow, formatRates(app.Rates()))
+			logger.Info("  Unique Destinations: %s, Unique Remote Ports: %d",
+				formatCount(app.UniqueDestinations(), app.UniqueDestinationsCapped()), app.UniqueRemotePorts())
+			if hits := app.BlockedHits.Load(); hits > 0 {
+				logger.Warning("  Blocked Destination Hits: %d", hits)
+			}
 
 			// Protocol breakdown for this app
 			logger.Info("  Protocol Distribution:")
-			app.PacketsByProtocol.Range(func(key, value interface{}) bool {
-				protocol := key.(string)
-				count := value.(uint64)
-				percentage := float64(count) / float64(app.TotalPackets.Load()) * 100
-				logger.Info("    %s: %d (%.1f%%)", protocol, count, percentage)
-				return true
-			})
-
-			// List destinations this app has connected to
-			destinations := capture.GetDestinationsForApp(appName)
+			for protocol, count := range app.ProtocolCounts() {
+				percentage := percentOf(count.Packets, app.TotalPackets.Load())
+				logger.Info("    %s: %d packets (%.1f%%), %d bytes", protocol, count.Packets, percentage, count.Bytes)
+			}
+
+			// List the destinations this app exchanged the most bytes with
+			destinations := capture.GetDestinationsForApp(talker.Key)
 			if len(destinations) > 0 {
-				logger.Info("  Connected to %d destinations:", len(destinations))
+				logger.Info("  Connected to %d destinations, top by bytes:", len(destinations))
 
 				// Limit to max 10 destinations in log to avoid spam
-				maxDisplay := 10
-				if len(destinations) < maxDisplay {
-					maxDisplay = len(destinations)
-				}
-
-				for i := 0; i < maxDisplay; i++ {
-					logger.Info("    %s", destinations[i])
+				maxDisplay := min(len(destinations), 10)
+				for _, dest := range destinations[:maxDisplay] {
+					name := dest.Destination
+					if dest.ReverseHost != "" {
+						name = fmt.Sprintf("%s [%s]", dest.Destination, dest.ReverseHost)
+					}
+					logger.Info("    %s: %d packets, %s", name, dest.Packets, formatBytes(dest.Bytes))
 				}
 
 				if len(destinations) > maxDisplay {
@@ -74,3 +228,26 @@ func printStatistics() {
 
 	logger.Info("=====================")
 }
+
+// percentOf returns part as a percentage of total, or 0 when total is 0
+func percentOf(part, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) / float64(total) * 100
+}
+
+// formatRates renders rolling-window rates, e.g. "1.2 MiB/s (10s), 800.0 KiB/s (1m0s)".
+// A window that has not filled yet shows the history it covers.
+func formatRates(rates []capture.Rate) string {
+	parts := make([]string, 0, len(rates))
+	for _, rate := range rates {
+		window := rate.Window.String()
+		if rate.Covered < rate.Window {
+			window = fmt.Sprintf("%s, %s so far", window, rate.Covered)
+		}
+		parts = append(parts, fmt.Sprintf("%s/s, %.1f pkt/s (%s)",
+			formatBytes(uint64(rate.BytesPerSec)), rate.PacketsPerSec, window))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestPercentOf(t *testing.T) {
+	tests := []struct {
+		part, total uint64
+		want        float64
+	}{
+		{0, 0, 0},
+		{5, 0, 0},
+		{0, 10, 0},
+		{1, 4, 25},
+		{10, 10, 100},
+	}
+	for _, tt := range tests {
+		if got := percentOf(tt.part, tt.total); got != tt.want {
+			t.Errorf("percentOf(%d, %d) = %v, want %v", tt.part, tt.total, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	util "grip/internal"
+	"grip/internal/api"
+	"grip/internal/database"
+	"grip/internal/ipc"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// statusTimeout bounds a request to the running instance
+const statusTimeout = 2 * time.Second
+
+// printStatus reports the service state, the Npcap installation and a summary
+// of today's traffic, taken from the running service over its pipe or HTTP
+// endpoint when it can be reached, otherwise from the database opened
+// read-only. It returns an error if the service is installed but not running,
+// or its state could not be read.
+func printStatus() error {
+	installed, state, stateErr := queryServiceState()
+	switch {
+	case stateErr != nil:
+		fmt.Printf("Service:       %s (%v)\n", svcName, stateErr)
+	case !installed:
+		fmt.Printf("Service:       %s is not installed\n", svcName)
+	default:
+		fmt.Printf("Service:       %s is %s\n", svcName, serviceStateName(state))
+	}
+	running := installed && state == svc.Running
+	captureState := "inactive"
+	if running {
+		captureState = "active"
+	}
+	fmt.Printf("Capture:       %s\n", captureState)
+
+	if err := util.CheckNpcapInstallation(); err != nil {
+		fmt.Printf("Npcap:         not found (%v)\n", err)
+	} else {
+		fmt.Printf("Npcap:         found\n")
+	}
+
+	if err := database.InitReadOnlyDatabase(dbPath); err != nil {
+		fmt.Printf("Database:      %v\n", err)
+	} else {
+		defer database.CloseDatabase()
+		if err := printDatabaseStatus(); err != nil {
+			return err
+		}
+	}
+
+	if !printLiveStatus() {
+		if err := printTodayFromDatabase(); err != nil {
+			return err
+		}
+	}
+
+	if stateErr != nil {
+		return fmt.Errorf("could not read the state of service %s: %v", svcName, stateErr)
+	}
+	if installed && !running {
+		return fmt.Errorf("service %s is installed but %s", svcName, serviceStateName(state))
+	}
+	return nil
+}
+
+// printDatabaseStatus summarizes what the database holds
+func printDatabaseStatus() error {
+	path := database.Path()
+	fmt.Printf("Database:      %s\n", path)
+	if info, err := os.Stat(path); err == nil {
+		fmt.Printf("Database size: %s\n", formatBytes(uint64(info.Size())))
+	}
+	if version, err := database.SchemaVersion(); err == nil {
+		fmt.Printf("Schema:        version %d (this build: %d)\n", version, database.LatestSchemaVersion())
+	}
+
+	summary, err := database.GetSummary()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Packets:       %d\n", summary.TotalPackets)
+	fmt.Printf("Flows:         %d\n", summary.TotalFlows)
+	fmt.Printf("Applications:  %d\n", summary.TrackedApps)
+	if summary.TotalPackets > 0 {
+		fmt.Printf("Time range:    %s to %s\n",
+			summary.FirstPacket.Local().Format(time.RFC3339),
+			summary.LastPacket.Local().Format(time.RFC3339))
+		fmt.Printf("Last packet:   %s ago\n", time.Since(summary.LastPacket).Round(time.Second))
+	}
+
+	return nil
+}
+
+// printLiveStatus prints the running instance's session statistics and
+// reports whether it could be reached
+func printLiveStatus() bool {
+	status, source, err := fetchLiveStatus(nil)
+	if err != nil {
+		return false
+	}
+
+	fmt.Printf("\nLive session (from %s):\n", source)
+	fmt.Printf("  Running for: %s\n", time.Since(status.StartTime).Round(time.Second))
+	if status.Paused {
+		fmt.Printf("  Capture:     paused\n")
+	}
+	fmt.Printf("  Traffic:     %d packets, %s\n", status.TotalPackets, formatBytes(status.TotalBytes))
+	for _, rate := range status.Rates {
+		fmt.Printf("  Rate (%s): %s/s, %.1f pkt/s\n", rate.Window, formatBytes(uint64(rate.BytesPerSec)), rate.PacketsPerSec)
+	}
+	if len(status.TopApps) > 0 {
+		fmt.Printf("  Top applications:\n")
+		for _, app := range status.TopApps {
+			fmt.Printf("    %-30s %10s %10d packets %10s/s now\n", app.ProcessName, formatBytes(app.Bytes), app.Packets,
+				formatBytes(uint64(app.BytesPerSec)))
+		}
+	}
+	return true
+}
+
+// fetchLiveStatus asks the running instance for its status with the given
+// parameters, over its pipe or else its HTTP endpoint on -http-addr. It also
+// returns where the status came from.
+func fetchLiveStatus(params map[string]string) (*api.Status, string, error) {
+	var status api.Status
+	client, err := dialService()
+	if err == nil {
+		defer client.Close()
+		if err := client.Call(ipc.MethodGetStats, params, &status, statusTimeout); err != nil {
+			return nil, "", err
+		}
+		return &status, client.Path(), nil
+	}
+	if httpAddr == "" {
+		return nil, "", err
+	}
+
+	query := url.Values{}
+	for name, value := range params {
+		query.Set(name, value)
+	}
+	endpoint := "http://" + localAddr(httpAddr) + "/status"
+	httpClient := http.Client{Timeout: statusTimeout}
+	resp, err := httpClient.Get(endpoint + "?" + query.Encode())
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("status endpoint returned %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, "", fmt.Errorf("invalid status: %v", err)
+	}
+	return &status, endpoint, nil
+}
+
+// dialService connects to the running instance's pipe. It returns
+// ipc.ErrNotRunning if nothing serves the pipe.
+func dialService() (*ipc.Client, error) {
+	if pipeName == "" {
+		return nil, fmt.Errorf("-pipe-name is not set")
+	}
+	return ipc.Dial(pipeName)
+}
+
+// printTodayFromDatabase prints today's totals and top applications from the stored flows
+func printTodayFromDatabase() error {
+	if !database.IsInitialized() {
+		return nil
+	}
+
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	totals, err := database.GetTotalsSince(midnight)
+	if err != nil {
+		return err
+	}
+	apps, err := database.GetTopAppsByBytes(midnight, 5)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\nToday:\n")
+	fmt.Printf("  Traffic:     %d flows, %d packets, %s\n", totals.Flows, totals.Packets, formatBytes(totals.Bytes))
+	if len(apps) > 0 {
+		fmt.Printf("  Top applications:\n")
+		for _, app := range apps {
+			fmt.Printf("    %-30s %10s %10d packets\n", app.ProcessName, formatBytes(app.Bytes), app.Packets)
+		}
+	}
+	return nil
+}
+
+// localAddr turns a listen address such as ":9183" or "0.0.0.0:9183" into one
+// that can be dialled from this machine
+func localAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// formatCount renders a count, with a "+" when counting stopped at a cap
+func formatCount(n int64, capped bool) string {
+	if capped {
+		return fmt.Sprintf("%d+", n)
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// formatBytes renders a byte count with a binary unit suffix
+func formatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := uint64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
@@ -1,17 +1,38 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 
+	"grip/internal/ipc"
 	"grip/internal/logger"
 
+	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/debug"
 	"golang.org/x/sys/windows/svc/eventlog"
 	"golang.org/x/sys/windows/svc/mgr"
 )
 
+// cmdLogLevel is the first of the custom service control codes used to
+// change the log level at runtime; the level is added to it.
+const cmdLogLevel = svc.Cmd(128)
+
+// logLevelControl returns the service control code that selects the given log level
+func logLevelControl(level logger.LogLevel) svc.Cmd {
+	return cmdLogLevel + svc.Cmd(level)
+}
+
+// logLevelFromControl maps a custom service control code back to a log level
+func logLevelFromControl(cmd svc.Cmd) (logger.LogLevel, bool) {
+	if cmd < cmdLogLevel || cmd > logLevelControl(logger.LevelTrace) {
+		return 0, false
+	}
+	return logger.LogLevel(cmd - cmdLogLevel), true
+}
+
 func runService(isDebug bool) {
 	var err error
 	if isDebug {
@@ -42,11 +63,23 @@ func installService() error {
 		return fmt.Errorf("service %s already exists", svcName)
 	}
 
+	// The service reads its settings from the config file on every start, so
+	// edits take effect after a restart without reinstalling
+	var args []string
+	if configPath != "" {
+		path, err := filepath.Abs(configPath)
+		if err != nil {
+			return fmt.Errorf("failed to resolve config path: %v", err)
+		}
+		args = append(args, "-config", path)
+	}
+	args = append(args, "start")
+
 	s, err = m.CreateService(svcName, exepath, mgr.Config{
 		DisplayName: "Grip Network Monitor",
 		Description: "Monitors and logs network traffic in real-time",
 		StartType:   mgr.StartAutomatic,
-	})
+	}, args...)
 	if err != nil {
 		return err
 	}
@@ -61,6 +94,93 @@ func installService() error {
 	return nil
 }
 
+// controlService sends a control code to the running service
+func controlService(cmd svc.Cmd) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return err
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(svcName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", svcName)
+	}
+	defer s.Close()
+
+	if _, err := s.Control(cmd); err != nil {
+		return fmt.Errorf("could not send control %d: %v", cmd, err)
+	}
+	return nil
+}
+
+// setServiceLogLevel changes the running instance's log level over its pipe,
+// or with a control code if the pipe can't be reached
+func setServiceLogLevel(level logger.LogLevel) error {
+	client, err := dialService()
+	if err != nil {
+		return controlService(logLevelControl(level))
+	}
+	defer client.Close()
+	return client.Call(ipc.MethodSetLogLevel, map[string]string{"level": level.String()}, nil, statusTimeout)
+}
+
+// queryServiceState reports whether the service is installed and its current
+// state. It asks only for the rights needed to read the status, which users
+// without administrator rights have, rather than the full access mgr.Connect
+// and OpenService request.
+func queryServiceState() (installed bool, state svc.State, err error) {
+	h, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return false, 0, fmt.Errorf("could not connect to the service manager: %v", err)
+	}
+	m := &mgr.Mgr{Handle: h}
+	defer m.Disconnect()
+
+	name, err := windows.UTF16PtrFromString(svcName)
+	if err != nil {
+		return false, 0, err
+	}
+	sh, err := windows.OpenService(h, name, windows.SERVICE_QUERY_STATUS)
+	if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, fmt.Errorf("could not open service: %v", err)
+	}
+	s := &mgr.Service{Name: svcName, Handle: sh}
+	defer s.Close()
+
+	status, err := s.Query()
+	if err != nil {
+		return true, 0, fmt.Errorf("could not query service status: %v", err)
+	}
+
+	return true, status.State, nil
+}
+
+// serviceStateName returns a human-readable name for a service state
+func serviceStateName(state svc.State) string {
+	switch state {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "starting"
+	case svc.StopPending:
+		return "stopping"
+	case svc.Running:
+		return "running"
+	case svc.ContinuePending:
+		return "resuming"
+	case svc.PausePending:
+		return "pausing"
+	case svc.Paused:
+		return "paused"
+	default:
+		return fmt.Sprintf("unknown (%d)", state)
+	}
+}
+
 func removeService() error {
 	m, err := mgr.Connect()
 	if err != nil {
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/sys/windows/svc"
+
+	"grip/internal/logger"
+)
+
+func TestLogLevelControl(t *testing.T) {
+	for _, level := range []logger.LogLevel{logger.LevelError, logger.LevelWarning, logger.LevelInfo, logger.LevelDebug, logger.LevelTrace} {
+		got, ok := logLevelFromControl(logLevelControl(level))
+		if !ok || got != level {
+			t.Errorf("control code of %v maps back to %v, %v", level, got, ok)
+		}
+	}
+
+	tests := []struct {
+		name string
+		cmd  svc.Cmd
+	}{
+		{"stop", svc.Stop},
+		{"interrogate", svc.Interrogate},
+		{"below the range", cmdLogLevel - 1},
+		{"above the range", logLevelControl(logger.LevelTrace) + 1},
+	}
+	for _, tt := range tests {
+		if level, ok := logLevelFromControl(tt.cmd); ok {
+			t.Errorf("%s: logLevelFromControl(%d) = %v, want no level", tt.name, tt.cmd, level)
+		}
+	}
+}
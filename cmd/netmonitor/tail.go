@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"grip/internal/capture"
+	"grip/internal/ipc"
+)
+
+// runTail prints the packets captured by the running service as they arrive,
+// read over its pipe or else its /packets endpoint on -http-addr
+func runTail(args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	app := fs.String("app", "", "Only show packets of these applications, comma-separated, e.g. chrome.exe")
+	protocol := fs.String("protocol", "", "Only show these protocols, comma-separated, e.g. udp")
+	direction := fs.String("direction", "", "Only show these directions, comma-separated, e.g. outgoing")
+	dst := fs.String("dst", "", "Only show packets to these IPs or CIDR ranges, comma-separated, e.g. 10.0.0.0/8")
+	asJSON := fs.Bool("json", false, "Print each packet as a JSON line instead of the log format")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	params := make(map[string]string)
+	for name, value := range map[string]string{"app": *app, "protocol": *protocol, "direction": *direction, "dst": *dst} {
+		if value != "" {
+			params[name] = value
+		}
+	}
+
+	client, err := dialService()
+	if err == nil {
+		defer client.Close()
+		return tailPipe(client, params, *asJSON)
+	}
+	if httpAddr == "" {
+		return fmt.Errorf("tail reads from the running service: %v", err)
+	}
+	return tailHTTP(params, *asJSON)
+}
+
+// tailPipe prints the packets streamed over the service's pipe
+func tailPipe(client *ipc.Client, params map[string]string, asJSON bool) error {
+	if err := client.Subscribe(params, statusTimeout); err != nil {
+		return fmt.Errorf("tail failed: %v", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Streaming packets from %s, press Ctrl+C to stop\n", client.Path())
+	for {
+		packet, err := client.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("lost the connection to the service: %v", err)
+		}
+		if err := printTailPacket(packet, asJSON); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "The service stopped capturing")
+	return nil
+}
+
+// tailHTTP prints the packets streamed by the service's /packets endpoint
+func tailHTTP(params map[string]string, asJSON bool) error {
+	query := url.Values{}
+	for name, value := range params {
+		query.Set(name, value)
+	}
+	endpoint := "http://" + localAddr(httpAddr) + "/packets?" + query.Encode()
+
+	// No timeout: the response lasts as long as the tail
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return fmt.Errorf("the service is not running or not serving on %s: %v", httpAddr, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("the service on %s does not support tail; update it", httpAddr)
+		}
+		if resp.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("the service only streams packets when -http-addr is a loopback address, not %s", httpAddr)
+		}
+		return fmt.Errorf("tail failed: %s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	fmt.Fprintf(os.Stderr, "Streaming packets from %s, press Ctrl+C to stop\n", localAddr(httpAddr))
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024) // Payloads can make long lines
+	for scanner.Scan() {
+		if err := printTailPacket(scanner.Bytes(), asJSON); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("lost the connection to the service: %v", err)
+	}
+
+	fmt.Fprintln(os.Stderr, "The service stopped capturing")
+	return nil
+}
+
+// printTailPacket prints a packet received as JSON, as is or in the log format
+func printTailPacket(data []byte, asJSON bool) error {
+	if asJSON {
+		fmt.Println(string(data))
+		return nil
+	}
+	var packet capture.PacketLog
+	if err := json.Unmarshal(data, &packet); err != nil {
+		return fmt.Errorf("invalid packet from the service: %v", err)
+	}
+	fmt.Printf("%s %s\n", packet.Timestamp.Local().Format("15:04:05.000"), capture.FormatPacket(packet))
+	return nil
+}
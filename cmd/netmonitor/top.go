@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"grip/internal/api"
+	"grip/internal/database"
+)
+
+// topRefresh is how often top polls the running service and redraws
+const topRefresh = 2 * time.Second
+
+// topOrders are the application sort orders of top, by key
+var topOrders = map[byte]string{'r': "rate", 'b': "bytes", 'p': "packets"}
+
+// topView is what top shows: the running service's status, or the stored
+// traffic when the service can't be reached
+type topView struct {
+	order  string
+	paused bool
+
+	live    *api.Status
+	source  string // Where live came from
+	liveErr error
+
+	// Historical summary, read from the database once it is first needed
+	history    *topHistory
+	historyErr error
+}
+
+// topHistory is the static summary shown while the service is unreachable
+type topHistory struct {
+	since        time.Time
+	totals       database.TrafficTotals
+	apps         []database.TopApp
+	destinations []database.TopDestination
+}
+
+// runTop draws the running service's live statistics, read over its pipe or
+// HTTP endpoint, until q is pressed or a signal arrives. Unlike dashboard it
+// captures nothing itself. When the service can't be reached, it shows a
+// summary of the stored flows instead and keeps trying to reach it.
+func runTop(signals <-chan os.Signal) error {
+	restore, err := prepareConsole()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	fmt.Print(ansiAltScreen + ansiHideCursor)
+	defer fmt.Print(ansiShowCursor + ansiMainScreen)
+
+	keys := readKeys()
+	ticker := time.NewTicker(topRefresh)
+	defer ticker.Stop()
+
+	view := &topView{order: "rate"}
+	refresh := true
+	for {
+		width, height := consoleSize()
+		if refresh && !view.paused {
+			view.update(height)
+		}
+		fmt.Print(ansiHome + view.render(width, height) + ansiClearScreen)
+
+		refresh = false
+		select {
+		case <-signals:
+			return nil
+		case key := <-keys:
+			switch key {
+			case 'q', 'Q', 3: // 3 is Ctrl-C without processed input
+				return nil
+			case ' ':
+				view.paused = !view.paused
+				refresh = !view.paused
+			case 'r', 'b', 'p':
+				view.order = topOrders[key]
+				refresh = true
+			}
+		case <-ticker.C:
+			refresh = true
+		}
+	}
+}
+
+// update polls the service, falling back to the database if it is unreachable
+func (v *topView) update(height int) {
+	params := map[string]string{
+		"apps": strconv.Itoa(min(max(height, 1), 100)),
+		"sort": v.order,
+	}
+	v.live, v.source, v.liveErr = fetchLiveStatus(params)
+	if v.liveErr == nil || v.history != nil || v.historyErr != nil {
+		return
+	}
+	v.history, v.historyErr = loadTopHistory()
+}
+
+// loadTopHistory summarizes the flows stored over the last 24 hours
+func loadTopHistory() (*topHistory, error) {
+	if !database.IsInitialized() {
+		if err := database.InitReadOnlyDatabase(dbPath); err != nil {
+			return nil, err
+		}
+	}
+
+	history := &topHistory{since: time.Now().Add(-24 * time.Hour)}
+	var err error
+	if history.totals, err = database.GetTotalsSince(history.since); err != nil {
+		return nil, err
+	}
+	if history.apps, err = database.GetTopAppsByBytes(history.since, 50); err != nil {
+		return nil, err
+	}
+	if history.destinations, err = database.GetTopDestinations(history.since, 10); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// render builds one frame that fits in width x height characters
+func (v *topView) render(width, height int) string {
+	var lines []string
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, fitWidth(fmt.Sprintf(format, args...), width))
+	}
+
+	state := ""
+	if v.paused {
+		state = "   PAUSED"
+	}
+	add("%sGrip Network Monitor%s   sort: %s%s   (r/b/p sort by rate/bytes/packets, space pauses, q quits)",
+		ansiBold, ansiReset, v.order, state)
+
+	if v.live != nil {
+		v.renderLive(add, height-len(lines))
+	} else {
+		v.renderHistory(add, height-len(lines))
+	}
+	return strings.Join(lines, ansiClearLine+"\r\n") + ansiClearLine
+}
+
+// renderLive draws the service's rates, interfaces and busiest applications
+func (v *topView) renderLive(add func(string, ...interface{}), rows int) {
+	status := v.live
+
+	paused := ""
+	if status.Paused {
+		paused = ", capture paused"
+	}
+	add("Live from %s, up %s%s", v.source, time.Since(status.StartTime).Round(time.Second), paused)
+	add("Total: %d packets, %s   now %s/s", status.TotalPackets, formatBytes(status.TotalBytes), formatBytes(uint64(status.BytesPerSec)))
+	for _, rate := range status.Rates {
+		add("Rate %-4s %10s/s %10.1f pkt/s", rate.Window, formatBytes(uint64(rate.BytesPerSec)), rate.PacketsPerSec)
+	}
+	rows -= 2 + len(status.Rates)
+
+	add("")
+	add("%s%-24s %8s %12s %12s %10s %10s%s", ansiBold, "Interface", "State", "Packets", "Bytes", "Dropped", "IfDropped", ansiReset)
+	rows -= 2
+	for _, iface := range status.Interfaces {
+		state := "stopped"
+		if iface.Active {
+			state = "active"
+		}
+		add("%-24s %8s %12d %12s %10d %10d", truncate(iface.Name, 24), state, iface.Packets, formatBytes(iface.Bytes),
+			iface.Dropped, iface.IfDropped)
+		rows--
+	}
+
+	// Applications fill the remaining rows
+	add("")
+	add("%s%-32s %12s %12s %12s %12s%s", ansiBold, "Application", "Now", "Bytes", "Packets", "Destinations", ansiReset)
+	rows -= 3
+	for i := 0; i < len(status.TopApps) && i < rows; i++ {
+		app := status.TopApps[i]
+		add("%-32s %12s %12s %12d %12s", truncate(app.ProcessName, 32), formatBytes(uint64(app.BytesPerSec))+"/s",
+			formatBytes(app.Bytes), app.Packets, formatCount(app.Destinations, app.DestinationsCapped))
+	}
+}
+
+// renderHistory draws the banner and the stored traffic summary
+func (v *topView) renderHistory(add func(string, ...interface{}), rows int) {
+	add("%sHISTORICAL DATA%s: %v. Showing stored flows; retrying every %s.", ansiBold, ansiReset, v.liveErr, topRefresh)
+	rows--
+
+	if v.historyErr != nil {
+		add("Could not read the database: %v", v.historyErr)
+		return
+	}
+	if v.history == nil {
+		return
+	}
+	history := v.history
+
+	add("Last 24 hours: %d flows, %d packets, %s", history.totals.Flows, history.totals.Packets, formatBytes(history.totals.Bytes))
+	rows--
+
+	add("")
+	add("%s%-40s %12s %12s%s", ansiBold, "Destination", "Bytes", "Flows", ansiReset)
+	rows -= 2
+	for _, dest := range history.destinations {
+		name := dest.IP
+		if dest.Host != "" {
+			name = dest.Host + " (" + dest.IP + ")"
+		}
+		add("%-40s %12s %12d", truncate(name, 40), formatBytes(dest.Bytes), dest.Flows)
+		rows--
+	}
+
+	add("")
+	add("%s%-32s %12s %12s %12s%s", ansiBold, "Application", "Bytes", "Packets", "Flows", ansiReset)
+	rows -= 3
+	for i := 0; i < len(history.apps) && i < rows; i++ {
+		app := history.apps[i]
+		add("%-32s %12s %12d %12d", truncate(app.ProcessName, 32), formatBytes(app.Bytes), app.Packets, app.Flows)
+	}
+}
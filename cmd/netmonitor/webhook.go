@@ -0,0 +1,220 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"grip/internal/database"
+	"grip/internal/logger"
+	"grip/internal/notify"
+)
+
+// Event types that can be sent to the webhook with -webhook-events
+const (
+	webhookEventAlerts  = "alerts"
+	webhookEventSummary = "summary"
+	webhookEventService = "service"
+)
+
+// webhookSummaryTop is how many applications and destinations a summary lists
+const webhookSummaryTop = 5
+
+var (
+	eventWebhook       *notify.Webhook
+	eventWebhookTypes  map[string]bool
+	webhookSummaryDone chan struct{}
+	webhookSummaryStop chan struct{}
+)
+
+// webhookEvent is the payload of service and test events. Like alerts, every
+// event has a time, a kind and a human-readable message.
+type webhookEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Host    string    `json:"host"`
+	Message string    `json:"message"`
+}
+
+// webhookSummary is the periodic traffic summary
+type webhookSummary struct {
+	webhookEvent
+	Since           time.Time                 `json:"since"`
+	Totals          database.TrafficTotals    `json:"totals"`
+	TopApps         []database.TopApp         `json:"top_apps"`
+	TopDestinations []database.TopDestination `json:"top_destinations"`
+}
+
+// parseWebhookEvents parses the -webhook-events list
+func parseWebhookEvents() (map[string]bool, error) {
+	types := make(map[string]bool)
+	for _, name := range splitList(strings.ToLower(webhookEvents)) {
+		switch name {
+		case webhookEventAlerts, webhookEventSummary, webhookEventService:
+			types[name] = true
+		default:
+			return nil, fmt.Errorf("unknown webhook event type %q in -webhook-events, expected %s, %s or %s",
+				name, webhookEventAlerts, webhookEventSummary, webhookEventService)
+		}
+	}
+	return types, nil
+}
+
+// webhookConfig builds the webhook configuration from the flags
+func webhookConfig() notify.WebhookConfig {
+	config := notify.WebhookConfig{
+		URL:        webhookURL,
+		Timeout:    10 * time.Second,
+		MaxRetries: 3,
+	}
+	if webhookAuthHeader != "" {
+		// "Name: value", or just a value for the Authorization header
+		name, value, ok := strings.Cut(webhookAuthHeader, ":")
+		if !ok {
+			name, value = "Authorization", webhookAuthHeader
+		}
+		config.Headers = http.Header{}
+		config.Headers.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return config
+}
+
+// configureWebhook starts the webhook if -webhook-url is set
+func configureWebhook() error {
+	if webhookURL == "" || eventWebhook != nil {
+		return nil
+	}
+
+	types, err := parseWebhookEvents()
+	if err != nil {
+		return err
+	}
+	webhook, err := notify.NewWebhook(webhookConfig())
+	if err != nil {
+		return err
+	}
+	eventWebhook = webhook
+	eventWebhookTypes = types
+	return nil
+}
+
+// webhookEnabled reports whether events of a type are sent to the webhook
+func webhookEnabled(eventType string) bool {
+	return eventWebhook != nil && eventWebhookTypes[eventType]
+}
+
+// newWebhookEvent fills in the common event fields
+func newWebhookEvent(kind, format string, args ...interface{}) webhookEvent {
+	host, _ := os.Hostname()
+	return webhookEvent{
+		Time:    time.Now(),
+		Kind:    kind,
+		Host:    host,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// notifyService sends a service lifecycle event, e.g. "service_started"
+func notifyService(kind, format string, args ...interface{}) {
+	if webhookEnabled(webhookEventService) {
+		eventWebhook.Notify(newWebhookEvent(kind, format, args...))
+	}
+}
+
+// startWebhookSummary sends a traffic summary every -webhook-summary-interval.
+// It reads the database, so it must run between StartCapture and StopCapture.
+func startWebhookSummary() {
+	if !webhookEnabled(webhookEventSummary) || webhookSummaryStop != nil {
+		return
+	}
+	webhookSummaryStop = make(chan struct{})
+	webhookSummaryDone = make(chan struct{})
+	go sendWebhookSummaries(webhookSummaryInterval, webhookSummaryStop, webhookSummaryDone)
+}
+
+// stopWebhookSummary stops the summary goroutine
+func stopWebhookSummary() {
+	if webhookSummaryStop != nil {
+		close(webhookSummaryStop)
+		<-webhookSummaryDone
+		webhookSummaryStop = nil
+	}
+}
+
+func sendWebhookSummaries(interval time.Duration, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			summary, err := buildWebhookSummary(now.Add(-interval))
+			if err != nil {
+				logger.Error("Failed to build webhook summary: %v", err)
+				continue
+			}
+			eventWebhook.Notify(summary)
+		}
+	}
+}
+
+// buildWebhookSummary totals the flows active since the given time
+func buildWebhookSummary(since time.Time) (webhookSummary, error) {
+	summary := webhookSummary{Since: since}
+
+	var err error
+	if summary.Totals, err = database.GetTotalsSince(since); err != nil {
+		return summary, err
+	}
+	if summary.TopApps, err = database.GetTopAppsByBytes(since, webhookSummaryTop); err != nil {
+		return summary, err
+	}
+	if summary.TopDestinations, err = database.GetTopDestinations(since, webhookSummaryTop); err != nil {
+		return summary, err
+	}
+
+	summary.webhookEvent = newWebhookEvent("summary", "%s in %d flows since %s",
+		formatBytes(summary.Totals.Bytes), summary.Totals.Flows, since.Format(time.RFC3339))
+	return summary, nil
+}
+
+// closeWebhook stops the summaries and delivers any events still queued
+func closeWebhook() {
+	stopWebhookSummary()
+	if eventWebhook != nil {
+		eventWebhook.Close()
+	}
+}
+
+// runWebhook implements the webhook subcommands
+func runWebhook(args []string) error {
+	if len(args) < 1 || args[0] != "test" {
+		return fmt.Errorf("webhook requires a subcommand: test")
+	}
+	if webhookURL == "" {
+		return fmt.Errorf("no webhook configured, set -webhook-url")
+	}
+	if _, err := parseWebhookEvents(); err != nil {
+		return err
+	}
+
+	// Post directly rather than through the queue so failures are reported
+	webhook, err := notify.NewWebhook(webhookConfig())
+	if err != nil {
+		return err
+	}
+	defer webhook.Close()
+
+	event := newWebhookEvent("test", "Test event from %s", svcName)
+	if err := webhook.Post(event); err != nil {
+		return err
+	}
+	fmt.Printf("Sent test event to %s\n", webhookURL)
+	return nil
+}
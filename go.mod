@@ -5,5 +5,14 @@ go 1.21
 require (
 	github.com/google/gopacket v1.1.19
 	github.com/mattn/go-sqlite3 v1.14.24
-	golang.org/x/sys v0.15.0
+	github.com/oschwald/maxminddb-golang v1.12.0
+	golang.org/x/sys v0.20.0
+	google.golang.org/grpc v1.65.0
+	google.golang.org/protobuf v1.34.2
+)
+
+require (
+	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/text v0.15.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240528184218-531527333157 // indirect
 )
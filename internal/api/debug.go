@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	util "grip/internal"
+	"grip/internal/logger"
+)
+
+var debugServer *http.Server
+
+// StartDebug serves net/http/pprof on addr in the background. Profiles expose
+// memory contents and can be costly to take, so only loopback addresses are
+// accepted. An empty addr disables the server.
+func StartDebug(addr string) error {
+	if addr == "" {
+		return nil
+	}
+	listener, err := util.ListenLoopback(addr)
+	if err != nil {
+		return fmt.Errorf("debug server: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	debugServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := debugServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("Debug server stopped: %v", err)
+		}
+	}()
+
+	logger.Warning("Serving profiles on http://%s/debug/pprof/", listener.Addr())
+	return nil
+}
+
+// StopDebug shuts the pprof server down. CPU profiles and traces still being
+// taken are cut off after a few seconds.
+func StopDebug() {
+	if debugServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := debugServer.Shutdown(ctx); err != nil {
+		debugServer.Close()
+	}
+	debugServer = nil
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"grip/internal/capture"
+	"grip/internal/database"
+)
+
+// HistoryPackets is one page served from /history/packets
+type HistoryPackets struct {
+	Packets    []capture.PacketLog `json:"packets"`
+	NextCursor string              `json:"next_cursor,omitempty"` // Pass as cursor for the next page
+}
+
+// HistoryApps is one page served from /history/apps
+type HistoryApps struct {
+	Apps       []*database.ApplicationStats `json:"apps"`
+	NextCursor string                       `json:"next_cursor,omitempty"`
+}
+
+// handleHistoryPackets writes a page of stored packets, oldest first. The
+// query parameters from and to bound the time range and take RFC3339 times,
+// dates or durations ago, e.g. 24h; process, protocol and direction filter
+// the packets. Payloads are never served.
+func handleHistoryPackets(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := historyFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Protocol = query.Get("protocol")
+	filter.Direction = query.Get("direction")
+	limit, err := historyLimit(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !database.IsInitialized() {
+		http.Error(w, "history is not being stored", http.StatusServiceUnavailable)
+		return
+	}
+
+	page, err := database.QueryPackets(database.PacketQuery{PacketFilter: filter, Cursor: query.Get("cursor"), Limit: limit})
+	if err != nil {
+		http.Error(w, err.Error(), queryErrorStatus(err))
+		return
+	}
+	interfaces, err := database.GetInterfaces()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	deviceNames := make(map[int64]string, len(interfaces))
+	for _, iface := range interfaces {
+		deviceNames[iface.ID] = iface.Name
+	}
+
+	result := HistoryPackets{Packets: make([]capture.PacketLog, 0, len(page.Packets)), NextCursor: page.NextCursor}
+	for _, record := range page.Packets {
+		result.Packets = append(result.Packets, capture.PacketLog{
+			Timestamp:    record.Timestamp,
+			Device:       deviceNames[record.DeviceID],
+			SrcIP:        record.SrcIP,
+			SrcPort:      record.SrcPort,
+			DstIP:        record.DstIP,
+			DstPort:      record.DstPort,
+			SrcMAC:       record.SrcMAC,
+			DstMAC:       record.DstMAC,
+			VLAN:         record.VLAN,
+			DstHost:      record.DstHost,
+			ReverseHost:  record.ReverseHost,
+			GeoIP:        record.GeoIP,
+			Protocol:     record.Protocol,
+			Length:       record.Length,
+			PacketCount:  record.PacketCount,
+			TotalBytes:   record.TotalBytes,
+			Direction:    record.Direction,
+			Scope:        record.Scope,
+			ProcessID:    record.ProcessID,
+			ProcessName:  record.ProcessName,
+			ProcessPath:  record.ProcessPath,
+			ServiceName:  record.ServiceName,
+			ProcessOwner: record.ProcessOwner,
+			Flagged:      record.Flagged,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleHistoryApps writes a page of stored application rows last seen
+// between from and to, filtered by process, in the order they were stored
+func handleHistoryApps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter, err := historyFilter(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := historyLimit(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !database.IsInitialized() {
+		http.Error(w, "history is not being stored", http.StatusServiceUnavailable)
+		return
+	}
+
+	page, err := database.QueryAppStats(database.AppStatsQuery{PacketFilter: filter, Cursor: query.Get("cursor"), Limit: limit})
+	if err != nil {
+		http.Error(w, err.Error(), queryErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HistoryApps{Apps: page.Apps, NextCursor: page.NextCursor})
+}
+
+// historyFilter reads the from, to and process parameters
+func historyFilter(query url.Values) (database.PacketFilter, error) {
+	var filter database.PacketFilter
+	var err error
+	if filter.From, err = parseHistoryTime(query.Get("from")); err != nil {
+		return filter, fmt.Errorf("invalid from: %v", err)
+	}
+	if filter.To, err = parseHistoryTime(query.Get("to")); err != nil {
+		return filter, fmt.Errorf("invalid to: %v", err)
+	}
+	filter.ProcessName = query.Get("process")
+	return filter, nil
+}
+
+// historyLimit reads the limit parameter. Larger pages are refused rather
+// than cut short, so a client never mistakes a capped page for the limit
+// it asked for.
+func historyLimit(query url.Values) (int, error) {
+	value := query.Get("limit")
+	if value == "" {
+		return database.DefaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > database.MaxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", database.MaxPageSize)
+	}
+	return limit, nil
+}
+
+// queryErrorStatus is the status for a failed history query: a bad cursor
+// is the client's mistake, anything else is the database's
+func queryErrorStatus(err error) int {
+	if errors.Is(err, database.ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// parseHistoryTime accepts a duration ago, an RFC3339 time or a local date,
+// and returns the time in UTC like the stored timestamps
+func parseHistoryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	return t.UTC(), err
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"grip/internal/capture"
+	"grip/internal/database"
+)
+
+// handleMetrics writes the current statistics in the Prometheus text exposition format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	stats := capture.GetStatistics()
+
+	writeHeader(w, "grip_uptime_seconds", "gauge", "Seconds since statistics collection started.")
+	fmt.Fprintf(w, "grip_uptime_seconds %g\n", time.Since(stats.StartTime).Seconds())
+
+	writeHeader(w, "grip_captured_packets_total", "counter", "Total packets captured across all protocols.")
+	fmt.Fprintf(w, "grip_captured_packets_total %d\n", stats.TotalPackets.Load())
+
+	writeHeader(w, "grip_captured_bytes_total", "counter", "Total bytes captured across all protocols.")
+	fmt.Fprintf(w, "grip_captured_bytes_total %d\n", stats.TotalBytes.Load())
+
+	directions := capture.GetDirectionCounts()
+	writeHeader(w, "grip_direction_packets_total", "counter", "Packets captured per direction.")
+	for _, direction := range capture.Directions {
+		fmt.Fprintf(w, "grip_direction_packets_total{direction=\"%s\"} %d\n", direction, directions[direction].Packets)
+	}
+
+	writeHeader(w, "grip_direction_bytes_total", "counter", "Bytes captured per direction.")
+	for _, direction := range capture.Directions {
+		fmt.Fprintf(w, "grip_direction_bytes_total{direction=\"%s\"} %d\n", direction, directions[direction].Bytes)
+	}
+
+	// Tagged packets only, so untagged setups export no VLAN series
+	vlanCounts := capture.GetVLANCounts()
+	vlans := make([]uint16, 0, len(vlanCounts))
+	for vlan := range vlanCounts {
+		vlans = append(vlans, vlan)
+	}
+	sort.Slice(vlans, func(i, j int) bool { return vlans[i] < vlans[j] })
+
+	writeHeader(w, "grip_vlan_packets_total", "counter", "Packets captured per 802.1Q VLAN ID.")
+	for _, vlan := range vlans {
+		fmt.Fprintf(w, "grip_vlan_packets_total{vlan=\"%d\"} %d\n", vlan, vlanCounts[vlan].Packets)
+	}
+
+	writeHeader(w, "grip_vlan_bytes_total", "counter", "Bytes captured per 802.1Q VLAN ID.")
+	for _, vlan := range vlans {
+		fmt.Fprintf(w, "grip_vlan_bytes_total{vlan=\"%d\"} %d\n", vlan, vlanCounts[vlan].Bytes)
+	}
+
+	// The monitor's own resource use, as of the last sample
+	if self := stats.Self.Load(); self != nil {
+		writeHeader(w, "grip_self_resident_bytes", "gauge", "Working set of the monitor process.")
+		fmt.Fprintf(w, "grip_self_resident_bytes %d\n", self.ResidentBytes)
+
+		writeHeader(w, "grip_self_heap_bytes", "gauge", "Go heap in use by the monitor.")
+		fmt.Fprintf(w, "grip_self_heap_bytes %d\n", self.HeapBytes)
+
+		writeHeader(w, "grip_self_goroutines", "gauge", "Goroutines running in the monitor.")
+		fmt.Fprintf(w, "grip_self_goroutines %d\n", self.Goroutines)
+
+		writeHeader(w, "grip_self_gc_pause_seconds_total", "counter", "Time the monitor spent stopped for garbage collection.")
+		fmt.Fprintf(w, "grip_self_gc_pause_seconds_total %g\n", self.GCPauseTotal.Seconds())
+
+		writeHeader(w, "grip_self_queue_depth", "gauge", "Items waiting in the monitor's internal queues.")
+		fmt.Fprintf(w, "grip_self_queue_depth{queue=\"storage\"} %d\n", self.StorageQueue)
+		fmt.Fprintf(w, "grip_self_queue_depth{queue=\"dump\"} %d\n", self.DumpQueue)
+	}
+
+	writeHeader(w, "grip_db_dropped_writes_total", "counter", "Database writes that failed after retrying, losing their data.")
+	fmt.Fprintf(w, "grip_db_dropped_writes_total %d\n", database.DroppedWrites())
+
+	writeHeader(w, "grip_storage_backlog_drops_total", "counter", "Packet records and flows dropped because database writes fell behind capture.")
+	fmt.Fprintf(w, "grip_storage_backlog_drops_total %d\n", capture.GetStorageDropped())
+
+	// Collect protocol counters in a stable order
+	protocols := capture.GetProtocolCounts()
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeHeader(w, "grip_packets_total", "counter", "Packets captured per protocol.")
+	for _, name := range names {
+		fmt.Fprintf(w, "grip_packets_total{protocol=\"%s\"} %d\n", escapeLabel(name), protocols[name].Packets)
+	}
+
+	writeHeader(w, "grip_bytes_total", "counter", "Bytes captured per protocol.")
+	for _, name := range names {
+		fmt.Fprintf(w, "grip_bytes_total{protocol=\"%s\"} %d\n", escapeLabel(name), protocols[name].Bytes)
+	}
+
+	if config.MaxAppSeries == 0 {
+		return
+	}
+
+	// Only the heaviest talkers get series to keep label cardinality bounded
+	talkers := capture.TopTalkers(config.MaxAppSeries)
+
+	writeHeader(w, "grip_app_bytes_total", "counter", "Bytes attributed to an application.")
+	for _, app := range talkers {
+		fmt.Fprintf(w, "grip_app_bytes_total{process=\"%s\",path=\"%s\"} %d\n",
+			escapeLabel(app.ProcessName), escapeLabel(app.ProcessPath), app.TotalBytes)
+	}
+
+	writeHeader(w, "grip_app_packets_total", "counter", "Packets attributed to an application.")
+	for _, app := range talkers {
+		fmt.Fprintf(w, "grip_app_packets_total{process=\"%s\",path=\"%s\"} %d\n",
+			escapeLabel(app.ProcessName), escapeLabel(app.ProcessPath), app.TotalPackets)
+	}
+}
+
+func writeHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// escapeLabel escapes a label value per the exposition format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
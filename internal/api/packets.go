@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+
+	"grip/internal/capture"
+	"grip/internal/logger"
+)
+
+// handlePackets streams captured packets as JSON lines until the client goes
+// away or capture stops. The query parameters app, protocol, direction and dst
+// filter the packets; each takes a comma-separated list, and dst takes IPs or
+// CIDR ranges. An unknown direction or unparsable dst is refused.
+func handlePackets(w http.ResponseWriter, r *http.Request) {
+	filter, err := PacketFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	logger.Info("Packet tail client %s connected", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// The request context also ends when the server shuts down
+	enc := json.NewEncoder(w)
+	dropped, _ := capture.StreamPackets(r.Context(), filter, func(packet capture.PacketLog, _ uint64) error {
+		if err := enc.Encode(packet); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	logger.Info("Packet tail client %s disconnected, %d packets dropped", r.RemoteAddr, dropped)
+}
+
+// PacketFilterFromQuery reads a packet filter from the query parameters of
+// the /packets endpoint
+func PacketFilterFromQuery(query url.Values) (capture.PacketFilter, error) {
+	filter := capture.PacketFilter{
+		ProcessNames: queryList(query["app"]),
+		Protocols:    queryList(query["protocol"]),
+		Directions:   queryList(query["direction"]),
+	}
+	for _, direction := range filter.Directions {
+		if !knownDirection(direction) {
+			return capture.PacketFilter{}, fmt.Errorf("invalid direction %q, expected one of %s",
+				direction, strings.Join(capture.Directions, ", "))
+		}
+	}
+	for _, value := range queryList(query["dst"]) {
+		prefix, err := parsePrefix(value)
+		if err != nil {
+			return capture.PacketFilter{}, fmt.Errorf("invalid dst %q: %v", value, err)
+		}
+		filter.Destinations = append(filter.Destinations, prefix)
+	}
+	return filter, nil
+}
+
+// knownDirection reports whether direction is one of capture.Directions,
+// ignoring case as the filter does
+func knownDirection(direction string) bool {
+	for _, known := range capture.Directions {
+		if strings.EqualFold(direction, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// queryList splits repeated and comma-separated parameter values
+func queryList(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// parsePrefix accepts a CIDR range or a single address
+func parsePrefix(value string) (netip.Prefix, error) {
+	if !strings.Contains(value, "/") {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
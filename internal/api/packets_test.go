@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/netip"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"grip/internal/capture"
+)
+
+func TestPacketFilterFromQuery(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    capture.PacketFilter
+		wantErr bool
+	}{
+		{"", capture.PacketFilter{}, false},
+		{"app=chrome.exe", capture.PacketFilter{ProcessNames: []string{"chrome.exe"}}, false},
+		{"app=chrome.exe,+firefox.exe&app=svchost.exe",
+			capture.PacketFilter{ProcessNames: []string{"chrome.exe", "firefox.exe", "svchost.exe"}}, false},
+		{"app=,,&protocol=", capture.PacketFilter{}, false},
+		{"protocol=tcp,UDP,ICMPv6,IP-47", capture.PacketFilter{Protocols: []string{"tcp", "UDP", "ICMPv6", "IP-47"}}, false},
+		{"direction=outgoing,Incoming&direction=broadcast",
+			capture.PacketFilter{Directions: []string{"outgoing", "Incoming", "broadcast"}}, false},
+		{"direction=outgoing,sideways", capture.PacketFilter{}, true},
+		{"direction=out", capture.PacketFilter{}, true},
+		{"dst=10.0.0.0/8", capture.PacketFilter{Destinations: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}, false},
+		// Host bits are masked and single addresses become one-address ranges
+		{"dst=192.168.1.77/24,1.1.1.1&dst=2001:db8::1",
+			capture.PacketFilter{Destinations: []netip.Prefix{
+				netip.MustParsePrefix("192.168.1.0/24"),
+				netip.MustParsePrefix("1.1.1.1/32"),
+				netip.MustParsePrefix("2001:db8::1/128"),
+			}}, false},
+		{"dst=::ffff:10.1.2.3", capture.PacketFilter{Destinations: []netip.Prefix{netip.MustParsePrefix("10.1.2.3/32")}}, false},
+		{"dst=fe80::/10", capture.PacketFilter{Destinations: []netip.Prefix{netip.MustParsePrefix("fe80::/10")}}, false},
+		{"dst=10.0.0.0/33", capture.PacketFilter{}, true},
+		{"dst=10.0.0.300", capture.PacketFilter{}, true},
+		{"dst=example.com", capture.PacketFilter{}, true},
+		{"dst=10.0.0.0/8,bad", capture.PacketFilter{}, true},
+		{"app=chrome.exe&protocol=tcp&direction=outgoing&dst=10.0.0.0/8",
+			capture.PacketFilter{
+				ProcessNames: []string{"chrome.exe"},
+				Protocols:    []string{"tcp"},
+				Directions:   []string{"outgoing"},
+				Destinations: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+			}, false},
+	}
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := PacketFilterFromQuery(query)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("PacketFilterFromQuery(%q) = %+v, %v, want %+v (error %v)", tt.query, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
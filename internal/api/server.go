@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	util "grip/internal"
+	"grip/internal/logger"
+)
+
+// Config controls the local HTTP endpoint
+type Config struct {
+	// Addr is the listen address, e.g. "127.0.0.1:9183". Empty disables the server.
+	Addr string
+	// MaxAppSeries caps how many applications get their own metric series.
+	// The heaviest talkers are kept; 0 disables per-application metrics.
+	MaxAppSeries int
+}
+
+var (
+	server *http.Server
+	config Config
+)
+
+// Start begins serving the HTTP endpoint in the background
+func Start(cfg Config) error {
+	if cfg.Addr == "" {
+		return nil
+	}
+	if cfg.MaxAppSeries < 0 {
+		return fmt.Errorf("max app series must not be negative")
+	}
+	config = cfg
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", cfg.Addr, err)
+	}
+
+	// Packets and the stored history show who talks to whom and may carry
+	// payloads, so unlike the metrics they are only served on a loopback address
+	local := util.IsLoopback(listener.Addr())
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", handleMetrics)
+	mux.HandleFunc("/status", handleStatus)
+	mux.HandleFunc("/packets", loopbackOnly(local, handlePackets))
+	mux.HandleFunc("/history/packets", loopbackOnly(local, handleHistoryPackets))
+	mux.HandleFunc("/history/apps", loopbackOnly(local, handleHistoryApps))
+
+	// Cancelling the base context on shutdown ends open packet streams, which
+	// would otherwise hold Shutdown until capture stops
+	base, cancel := context.WithCancel(context.Background())
+	server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return base },
+	}
+	server.RegisterOnShutdown(cancel)
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Error("HTTP server stopped: %v", err)
+		}
+	}()
+
+	logger.Info("Serving metrics on http://%s/metrics", listener.Addr())
+	if !local {
+		logger.Warning("%s is not a loopback address; /packets and /history are not served on it", listener.Addr())
+	}
+	return nil
+}
+
+// loopbackOnly returns handler if the server listens on a loopback address,
+// and otherwise a handler refusing every request
+func loopbackOnly(local bool, handler http.HandlerFunc) http.HandlerFunc {
+	if local {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "only served on a loopback address, e.g. 127.0.0.1:9183", http.StatusForbidden)
+	}
+}
+
+// Stop shuts the HTTP endpoint down, waiting briefly for in-flight requests
+func Stop() {
+	if server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Warning("Error shutting down HTTP server: %v", err)
+	}
+	server = nil
+}
@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"grip/internal/capture"
+)
+
+// statusTopApps is how many applications the status endpoint lists unless
+// the apps parameter asks for up to statusMaxApps
+const (
+	statusTopApps = 5
+	statusMaxApps = 100
+)
+
+// Status is the live summary served as JSON from /status
+type Status struct {
+	StartTime    time.Time                `json:"start_time"`
+	Paused       bool                     `json:"paused,omitempty"` // Packets are not being counted
+	TotalPackets uint64                   `json:"total_packets"`
+	TotalBytes   uint64                   `json:"total_bytes"`
+	BytesPerSec  float64                  `json:"bytes_per_sec"` // Over capture.CurrentRateWindow
+	Directions   map[string]StatusTraffic `json:"directions"`
+	VLANs        map[string]StatusTraffic `json:"vlans,omitempty"` // Keyed by 802.1Q VLAN ID; tagged packets only
+	Rates        []StatusRate             `json:"rates"`
+	TopApps      []StatusApp              `json:"top_apps"`
+	Interfaces   []StatusInterface        `json:"interfaces"`
+	Self         *StatusSelf              `json:"self,omitempty"` // Sampled every few seconds; absent before the first sample
+}
+
+// StatusTraffic is the traffic in one direction or VLAN this session
+type StatusTraffic struct {
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// StatusRate is the traffic rate averaged over one window
+type StatusRate struct {
+	Window        string  `json:"window"`
+	PacketsPerSec float64 `json:"packets_per_sec"`
+	BytesPerSec   float64 `json:"bytes_per_sec"`
+}
+
+// StatusApp is one of the applications with the most traffic this session
+type StatusApp struct {
+	ProcessName        string  `json:"process_name"`
+	ProcessPath        string  `json:"process_path,omitempty"`
+	Packets            uint64  `json:"packets"`
+	Bytes              uint64  `json:"bytes"`
+	BytesPerSec        float64 `json:"bytes_per_sec"`                 // Over capture.CurrentRateWindow
+	Destinations       int64   `json:"destinations"`                  // Distinct remote IPs this session
+	DestinationsCapped bool    `json:"destinations_capped,omitempty"` // Destinations is a lower bound
+}
+
+// StatusInterface is the traffic and drop counters of one capture interface
+type StatusInterface struct {
+	Name      string `json:"name"` // Friendly name, e.g. "Ethernet"
+	Device    string `json:"device"`
+	Active    bool   `json:"active"`
+	Packets   uint64 `json:"packets"`
+	Bytes     uint64 `json:"bytes"`
+	Received  uint64 `json:"received"`   // Packets received by the capture driver
+	Dropped   uint64 `json:"dropped"`    // Packets dropped because capture fell behind
+	IfDropped uint64 `json:"if_dropped"` // Packets dropped by the interface or its driver
+}
+
+// StatusSelf is the monitor's own resource use
+type StatusSelf struct {
+	ResidentBytes    uint64  `json:"resident_bytes"` // Working set of the process
+	HeapBytes        uint64  `json:"heap_bytes"`
+	Goroutines       int     `json:"goroutines"`
+	GCCount          uint32  `json:"gc_count"`
+	GCPauseTotalSecs float64 `json:"gc_pause_total_seconds"`
+	GCPauseLastSecs  float64 `json:"gc_pause_last_seconds"`
+	PacketsPerSec    float64 `json:"packets_per_sec"` // Packets processed since the previous sample
+	StorageQueue     int     `json:"storage_queue"`   // Records waiting for the database writer
+	StorageQueueSize int     `json:"storage_queue_size"`
+	DumpQueue        int     `json:"dump_queue"` // Packets waiting for the dump writer
+	DumpQueueSize    int     `json:"dump_queue_size"`
+}
+
+// handleStatus writes a JSON summary of the running capture. The apps
+// parameter sets how many applications are listed and sort orders them by
+// bytes (the default), packets or rate.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := CurrentStatus(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// CurrentStatus summarizes the running capture. It takes the query parameters
+// of the /status endpoint, apps and sort, and fails if they are invalid.
+func CurrentStatus(query url.Values) (*Status, error) {
+	apps := statusTopApps
+	if value := query.Get("apps"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > statusMaxApps {
+			return nil, fmt.Errorf("apps must be between 1 and %d", statusMaxApps)
+		}
+		apps = n
+	}
+	topApps, err := CurrentApps(query.Get("sort"))
+	if err != nil {
+		return nil, err
+	}
+	if len(topApps) > apps {
+		topApps = topApps[:apps]
+	}
+
+	stats := capture.GetStatistics()
+	status := &Status{
+		StartTime:    stats.StartTime,
+		Paused:       capture.IsPaused(),
+		TotalPackets: stats.TotalPackets.Load(),
+		TotalBytes:   stats.TotalBytes.Load(),
+		BytesPerSec:  capture.CurrentRate(""),
+		Directions:   make(map[string]StatusTraffic),
+		Rates:        []StatusRate{},
+		TopApps:      topApps,
+		Interfaces:   []StatusInterface{},
+	}
+
+	for direction, count := range capture.GetDirectionCounts() {
+		status.Directions[direction] = StatusTraffic{Packets: count.Packets, Bytes: count.Bytes}
+	}
+	if vlans := capture.GetVLANCounts(); len(vlans) > 0 {
+		status.VLANs = make(map[string]StatusTraffic, len(vlans))
+		for vlan, count := range vlans {
+			status.VLANs[strconv.Itoa(int(vlan))] = StatusTraffic{Packets: count.Packets, Bytes: count.Bytes}
+		}
+	}
+	for _, rate := range capture.GetRates() {
+		status.Rates = append(status.Rates, StatusRate{
+			Window:        rate.Window.String(),
+			PacketsPerSec: rate.PacketsPerSec,
+			BytesPerSec:   rate.BytesPerSec,
+		})
+	}
+	for device, ifStats := range capture.GetInterfaceStats() {
+		status.Interfaces = append(status.Interfaces, StatusInterface{
+			Name:      capture.InterfaceDisplayName(device),
+			Device:    device,
+			Active:    ifStats.Active.Load(),
+			Packets:   ifStats.TotalPackets.Load(),
+			Bytes:     ifStats.TotalBytes.Load(),
+			Received:  ifStats.PacketsReceived.Load(),
+			Dropped:   ifStats.PacketsDropped.Load(),
+			IfDropped: ifStats.PacketsIfDropped.Load(),
+		})
+	}
+	sort.Slice(status.Interfaces, func(i, j int) bool { return status.Interfaces[i].Name < status.Interfaces[j].Name })
+	if self := stats.Self.Load(); self != nil {
+		status.Self = &StatusSelf{
+			ResidentBytes:    self.ResidentBytes,
+			HeapBytes:        self.HeapBytes,
+			Goroutines:       self.Goroutines,
+			GCCount:          self.GCCount,
+			GCPauseTotalSecs: self.GCPauseTotal.Seconds(),
+			GCPauseLastSecs:  self.GCPauseLast.Seconds(),
+			PacketsPerSec:    self.PacketsPerSec,
+			StorageQueue:     self.StorageQueue,
+			StorageQueueSize: self.StorageQueueSize,
+			DumpQueue:        self.DumpQueue,
+			DumpQueueSize:    self.DumpQueueSize,
+		}
+	}
+	return status, nil
+}
+
+// CurrentApps returns every application seen this session, ordered by bytes
+// (the default or ""), packets or rate
+func CurrentApps(order string) ([]StatusApp, error) {
+	switch order {
+	case "", "bytes", "packets", "rate":
+	default:
+		return nil, fmt.Errorf("sort must be bytes, packets or rate")
+	}
+
+	apps := []StatusApp{}
+	for _, app := range capture.TopTalkers(0) {
+		apps = append(apps, StatusApp{
+			ProcessName:        app.ProcessName,
+			ProcessPath:        app.ProcessPath,
+			Packets:            app.TotalPackets,
+			Bytes:              app.TotalBytes,
+			BytesPerSec:        capture.CurrentRate(app.Key),
+			Destinations:       app.Destinations,
+			DestinationsCapped: app.DestinationsCapped,
+		})
+	}
+	switch order {
+	case "packets":
+		sort.SliceStable(apps, func(i, j int) bool { return apps[i].Packets > apps[j].Packets })
+	case "rate":
+		sort.SliceStable(apps, func(i, j int) bool { return apps[i].BytesPerSec > apps[j].BytesPerSec })
+	}
+	return apps, nil
+}
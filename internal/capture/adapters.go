@@ -0,0 +1,202 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// adapterRefreshInterval is how often adapter names and addresses are re-read
+// so renamed adapters and new DHCP leases show up while capturing
+const adapterRefreshInterval = 30 * time.Second
+
+// Adapter describes a network adapter as Windows reports it
+type Adapter struct {
+	GUID         string // "{...}", as in the \Device\NPF_{...} pcap name
+	FriendlyName string // e.g. "Ethernet" or "Wi-Fi"
+	MAC          string
+	Addresses    []string // Current unicast IP addresses
+}
+
+var (
+	// Adapters by upper-case GUID, refreshed by refreshAdapters
+	adapters      = make(map[string]Adapter)
+	adaptersMutex sync.RWMutex
+
+	// pcap descriptions of the capture devices, the fallback display name
+	deviceDescriptions = make(map[string]string)
+
+	adapterDone    chan struct{}
+	adapterStopped chan struct{}
+)
+
+// readAdapters lists the adapters on this machine with GetAdaptersAddresses
+func readAdapters() (map[string]Adapter, error) {
+	size := uint32(15 * 1024) // Recommended starting size
+	var buf []byte
+	for attempts := 0; ; attempts++ {
+		buf = make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, 0, 0,
+			(*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == nil {
+			break
+		}
+		if err != windows.ERROR_BUFFER_OVERFLOW || attempts >= 3 {
+			return nil, fmt.Errorf("GetAdaptersAddresses failed: %v", err)
+		}
+	}
+
+	result := make(map[string]Adapter)
+	for aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); aa != nil; aa = aa.Next {
+		adapter := Adapter{
+			GUID:         strings.ToUpper(windows.BytePtrToString(aa.AdapterName)),
+			FriendlyName: windows.UTF16PtrToString(aa.FriendlyName),
+		}
+		if aa.PhysicalAddressLength > 0 {
+			adapter.MAC = net.HardwareAddr(aa.PhysicalAddress[:aa.PhysicalAddressLength]).String()
+		}
+		for ua := aa.FirstUnicastAddress; ua != nil; ua = ua.Next {
+			if ip := ua.Address.IP(); ip != nil {
+				adapter.Addresses = append(adapter.Addresses, ip.String())
+			}
+		}
+		sort.Strings(adapter.Addresses)
+		result[adapter.GUID] = adapter
+	}
+	return result, nil
+}
+
+// adapterGUID extracts the upper-case GUID from a pcap device name
+func adapterGUID(deviceName string) string {
+	start := strings.IndexByte(deviceName, '{')
+	end := strings.IndexByte(deviceName, '}')
+	if start < 0 || end < start {
+		return ""
+	}
+	return strings.ToUpper(deviceName[start : end+1])
+}
+
+// lookupAdapter returns the adapter behind a pcap device name
+func lookupAdapter(deviceName string) (Adapter, bool) {
+	guid := adapterGUID(deviceName)
+	if guid == "" {
+		return Adapter{}, false
+	}
+
+	adaptersMutex.RLock()
+	defer adaptersMutex.RUnlock()
+	adapter, ok := adapters[guid]
+	return adapter, ok
+}
+
+// InterfaceDisplayName returns the friendly name of a capture device, such as
+// "Ethernet", falling back to its pcap description and then its name
+func InterfaceDisplayName(deviceName string) string {
+	if adapter, ok := lookupAdapter(deviceName); ok && adapter.FriendlyName != "" {
+		return adapter.FriendlyName
+	}
+
+	adaptersMutex.RLock()
+	description := deviceDescriptions[deviceName]
+	adaptersMutex.RUnlock()
+	if description != "" {
+		return description
+	}
+	return deviceName
+}
+
+// refreshAdapters re-reads the adapters and stores the details of capture
+// devices that are new or changed. The subnet broadcast addresses are
+// recomputed along with them.
+func refreshAdapters() error {
+	refreshBroadcastAddrs()
+
+	current, err := readAdapters()
+	if err != nil {
+		return err
+	}
+
+	adaptersMutex.Lock()
+	previous := adapters
+	adapters = current
+	adaptersMutex.Unlock()
+
+	deviceMapMutex.RLock()
+	devices := make(map[string]int64, len(deviceIDMap))
+	for name, id := range deviceIDMap {
+		devices[name] = id
+	}
+	deviceMapMutex.RUnlock()
+
+	for name, id := range devices {
+		guid := adapterGUID(name)
+		adapter, ok := current[guid]
+		if !ok || adapterEqual(adapter, previous[guid]) {
+			continue
+		}
+
+		err := store.UpdateInterfaceDetails(id, adapter.FriendlyName, adapter.MAC, strings.Join(adapter.Addresses, ","))
+		if err != nil {
+			LogError("Failed to store details of interface %s: %v", adapter.FriendlyName, err)
+		}
+		if _, known := previous[guid]; known {
+			LogInfo("Interface %s changed: MAC %s, addresses %s",
+				adapter.FriendlyName, adapter.MAC, strings.Join(adapter.Addresses, ", "))
+		}
+	}
+	return nil
+}
+
+func adapterEqual(a, b Adapter) bool {
+	return a.FriendlyName == b.FriendlyName && a.MAC == b.MAC &&
+		strings.Join(a.Addresses, ",") == strings.Join(b.Addresses, ",")
+}
+
+// startAdapterWatch records the capture devices' adapter details and keeps
+// them current
+func startAdapterWatch() {
+	if adapterDone != nil {
+		return
+	}
+
+	if err := refreshAdapters(); err != nil {
+		LogWarning("Cannot read adapter names, using pcap descriptions: %v", err)
+	}
+
+	adapterDone = make(chan struct{})
+	adapterStopped = make(chan struct{})
+	go watchAdapters(adapterDone, adapterStopped)
+}
+
+// stopAdapterWatch stops refreshing adapter details
+func stopAdapterWatch() {
+	if adapterDone != nil {
+		close(adapterDone)
+		<-adapterStopped
+		adapterDone = nil
+	}
+}
+
+func watchAdapters(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(adapterRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := refreshAdapters(); err != nil {
+				errorLimiter.log(LogWarning, "adapters", "Failed to refresh adapter details: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package capture
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"grip/internal/database"
+)
+
+// packetBucketKey groups the packets stored as one packet_logs row when
+// AggregatePackets is set
+type packetBucketKey struct {
+	FlowKey
+	DeviceID    int64
+	VLAN        uint16
+	ProcessID   uint32
+	ProcessName string
+}
+
+// packetBucket accumulates the packets of one key within one second
+type packetBucket struct {
+	record       database.PacketRecord
+	second       time.Time
+	lastActivity time.Time // wall clock, so offline timestamps don't look idle
+}
+
+var (
+	packetBuckets      = make(map[packetBucketKey]*packetBucket)
+	packetBucketsMutex sync.Mutex
+
+	aggregatedPackets atomic.Uint64 // Packets stored through buckets
+	aggregatedRows    atomic.Uint64 // Rows those packets were stored as
+
+	aggregateDone    chan struct{}
+	aggregateStopped chan struct{}
+)
+
+// GetPacketAggregation returns how many packets were stored in how many rows
+// since capture started, when AggregatePackets is set
+func GetPacketAggregation() (packets, rows uint64) {
+	return aggregatedPackets.Load(), aggregatedRows.Load()
+}
+
+// startPacketAggregator starts the background flusher of finished seconds
+func startPacketAggregator() {
+	if !flowConfig.AggregatePackets || aggregateDone != nil {
+		return
+	}
+	aggregateDone = make(chan struct{})
+	aggregateStopped = make(chan struct{})
+	go flushPacketBuckets(aggregateDone, aggregateStopped)
+}
+
+// stopPacketAggregator stops the flusher and stores every remaining bucket
+func stopPacketAggregator() {
+	if aggregateDone != nil {
+		close(aggregateDone)
+		<-aggregateStopped
+		aggregateDone = nil
+	}
+
+	packetBucketsMutex.Lock()
+	remaining := make([]*packetBucket, 0, len(packetBuckets))
+	for key, bucket := range packetBuckets {
+		remaining = append(remaining, bucket)
+		delete(packetBuckets, key)
+	}
+	packetBucketsMutex.Unlock()
+
+	for _, bucket := range remaining {
+		storePacketBucket(bucket)
+	}
+}
+
+// aggregatePacket adds a packet to the bucket of its connection and second,
+// storing the previous bucket once the connection moves on to a new second
+func aggregatePacket(record database.PacketRecord) {
+	key := packetBucketKey{
+		FlowKey: FlowKey{
+			SrcIP:     record.SrcIP,
+			SrcPort:   record.SrcPort,
+			DstIP:     record.DstIP,
+			DstPort:   record.DstPort,
+			Protocol:  record.Protocol,
+			Direction: record.Direction,
+		},
+		DeviceID:    record.DeviceID,
+		VLAN:        record.VLAN,
+		ProcessID:   record.ProcessID,
+		ProcessName: record.ProcessName,
+	}
+	second := record.Timestamp.Truncate(time.Second)
+
+	var finished *packetBucket
+	packetBucketsMutex.Lock()
+	bucket, ok := packetBuckets[key]
+	if ok && !bucket.second.Equal(second) {
+		finished = bucket
+		ok = false
+	}
+	if !ok {
+		// The row keeps the details of the first packet, such as its payload
+		bucket = &packetBucket{record: record, second: second}
+		bucket.record.Timestamp = second
+		bucket.record.PacketCount = 0
+		bucket.record.TotalBytes = 0
+		packetBuckets[key] = bucket
+	}
+	// A sampled record stands for more than one packet
+	if record.PacketCount == 0 {
+		record.PacketCount, record.TotalBytes = 1, uint64(record.Length)
+	}
+	bucket.record.PacketCount += record.PacketCount
+	bucket.record.TotalBytes += record.TotalBytes
+	bucket.record.Flagged = bucket.record.Flagged || record.Flagged
+	if bucket.record.DstHost == "" {
+		bucket.record.DstHost = record.DstHost
+	}
+	bucket.lastActivity = time.Now()
+	packetBucketsMutex.Unlock()
+
+	if finished != nil {
+		storePacketBucket(finished)
+	}
+}
+
+// flushConnectionBuckets stores the buckets of a connection's packets that
+// found no process, so a later attribution finds their rows to update
+func flushConnectionBuckets(a *database.ConnectionAttribution) {
+	key := FlowKey{
+		SrcIP:     a.SrcIP,
+		SrcPort:   a.SrcPort,
+		DstIP:     a.DstIP,
+		DstPort:   a.DstPort,
+		Protocol:  a.Protocol,
+		Direction: a.Direction,
+	}
+
+	var pending []*packetBucket
+	packetBucketsMutex.Lock()
+	for bucketKey, bucket := range packetBuckets {
+		if bucketKey.FlowKey == key && bucketKey.ProcessID == 0 && bucketKey.ProcessName == "" {
+			pending = append(pending, bucket)
+			delete(packetBuckets, bucketKey)
+		}
+	}
+	packetBucketsMutex.Unlock()
+
+	for _, bucket := range pending {
+		storePacketBucket(bucket)
+	}
+}
+
+// flushPacketBuckets stores buckets that received no packets for a second.
+// They are stored before the lock is released, so once flushConnectionBuckets
+// holds it, any bucket of the connection is either still in the map or stored.
+func flushPacketBuckets(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			cutoff := time.Now().Add(-time.Second)
+
+			packetBucketsMutex.Lock()
+			for key, bucket := range packetBuckets {
+				if bucket.lastActivity.Before(cutoff) {
+					storePacketBucket(bucket)
+					delete(packetBuckets, key)
+				}
+			}
+			packetBucketsMutex.Unlock()
+		}
+	}
+}
+
+// storePacketBucket writes a bucket as one row. Length holds the average
+// packet size, since the sizes of individual packets are not kept.
+func storePacketBucket(bucket *packetBucket) {
+	record := bucket.record
+	record.Length = int(record.TotalBytes / record.PacketCount)
+
+	aggregatedPackets.Add(record.PacketCount)
+	aggregatedRows.Add(1)
+	StorePacketRecord(record)
+}
@@ -0,0 +1,73 @@
+package capture
+
+import (
+	"sync"
+	"time"
+)
+
+// Alert kinds
+const (
+	AlertNewDestination     = "new_destination"
+	AlertBandwidthThreshold = "bandwidth_threshold"
+	AlertBlockedDestination = "blocked_destination"
+	AlertNewListener        = "new_listener"
+)
+
+// Alert describes a noteworthy event observed while capturing
+type Alert struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	App         string    `json:"app"`
+	ProcessID   uint32    `json:"process_id,omitempty"`
+	Destination string    `json:"destination,omitempty"`
+	Listener    string    `json:"listener,omitempty"` // Protocol, address and port, e.g. "TCP 0.0.0.0:8080"
+	Bytes       uint64    `json:"bytes,omitempty"`
+	Window      string    `json:"window,omitempty"`
+	Message     string    `json:"message"`
+}
+
+// AlertHandler receives alerts. Handlers run on the packet processing path,
+// so anything slow (network calls, disk writes) should be handed off to a
+// separate goroutine.
+type AlertHandler func(Alert)
+
+var (
+	alertHandlers   []AlertHandler
+	alertHandlersMu sync.RWMutex
+)
+
+// RegisterAlertHandler adds a handler that is called for every alert
+func RegisterAlertHandler(handler AlertHandler) {
+	alertHandlersMu.Lock()
+	defer alertHandlersMu.Unlock()
+	alertHandlers = append(alertHandlers, handler)
+}
+
+// OnNewDestination registers a callback for the first time an application
+// contacts a destination it has not been seen talking to before
+func OnNewDestination(fn func(app string, dest string)) {
+	RegisterAlertHandler(func(alert Alert) {
+		if alert.Kind == AlertNewDestination {
+			fn(alert.App, alert.Destination)
+		}
+	})
+}
+
+// LogAlert is an AlertHandler that writes alerts to the log at warning level
+func LogAlert(alert Alert) {
+	LogWarning("Alert [%s]: %s", alert.Kind, alert.Message)
+}
+
+// emitAlert delivers an alert to all registered handlers
+func emitAlert(alert Alert) {
+	alertHandlersMu.RLock()
+	handlers := alertHandlers
+	alertHandlersMu.RUnlock()
+
+	if alert.Time.IsZero() {
+		alert.Time = time.Now()
+	}
+	for _, handler := range handlers {
+		handler(alert)
+	}
+}
@@ -0,0 +1,264 @@
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"grip/internal/database"
+)
+
+// blocklistAlertInterval limits alerts to one per application and
+// destination in this period, since every packet of a connection matches
+const blocklistAlertInterval = time.Minute
+
+// blocklistCheckInterval is how often the blocklist file is checked for changes
+var blocklistCheckInterval = 10 * time.Second
+
+// Blocklist is a set of IP addresses and CIDR ranges. Prefixes are grouped by
+// length, so a lookup costs one map probe per distinct length instead of a
+// scan of every entry.
+type Blocklist struct {
+	prefixes map[int]map[netip.Prefix]struct{}
+	lengths  []int // Distinct prefix lengths, longest first
+	size     int
+}
+
+// ParseBlocklist reads one IP address or CIDR range per line. Blank lines and
+// text after # are ignored. IPv4-mapped IPv6 entries are stored as IPv4.
+func ParseBlocklist(r io.Reader) (*Blocklist, error) {
+	b := &Blocklist{prefixes: make(map[int]map[netip.Prefix]struct{})}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var prefix netip.Prefix
+		if strings.Contains(line, "/") {
+			p, err := netip.ParsePrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			// Match unmaps addresses, so an IPv4-mapped range must be IPv4 too
+			if p.Addr().Is4In6() && p.Bits() >= 96 {
+				p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+			}
+			prefix = p
+		} else {
+			addr, err := netip.ParseAddr(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		b.add(prefix.Masked())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for length := range b.prefixes {
+		b.lengths = append(b.lengths, length)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(b.lengths)))
+	return b, nil
+}
+
+func (b *Blocklist) add(prefix netip.Prefix) {
+	set, ok := b.prefixes[prefix.Bits()]
+	if !ok {
+		set = make(map[netip.Prefix]struct{})
+		b.prefixes[prefix.Bits()] = set
+	}
+	if _, exists := set[prefix]; !exists {
+		set[prefix] = struct{}{}
+		b.size++
+	}
+}
+
+// LoadBlocklist reads a blocklist file
+func LoadBlocklist(path string) (*Blocklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blocklist: %v", err)
+	}
+	defer file.Close()
+
+	b, err := ParseBlocklist(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blocklist %s: %v", path, err)
+	}
+	return b, nil
+}
+
+// Len returns the number of distinct entries
+func (b *Blocklist) Len() int {
+	return b.size
+}
+
+// Match returns the most specific entry containing ip
+func (b *Blocklist) Match(ip string) (netip.Prefix, bool) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap()
+
+	for _, length := range b.lengths {
+		if length > addr.BitLen() {
+			continue
+		}
+		prefix, err := addr.Prefix(length)
+		if err != nil {
+			continue
+		}
+		if _, ok := b.prefixes[length][prefix]; ok {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+var (
+	// activeBlocklist is swapped whole on reload so packets never see a partial list
+	activeBlocklist atomic.Pointer[Blocklist]
+
+	// Last alert per "app|destination", as Unix nanoseconds
+	blocklistAlerts sync.Map
+
+	blocklistDone    chan struct{}
+	blocklistStopped chan struct{}
+)
+
+// startBlocklist loads the configured blocklist and watches it for changes
+func startBlocklist() error {
+	if blocklistDone != nil || captureConfig.Blocklist == "" {
+		return nil
+	}
+
+	path := captureConfig.Blocklist
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to open blocklist: %v", err)
+	}
+	list, err := LoadBlocklist(path)
+	if err != nil {
+		return err
+	}
+	activeBlocklist.Store(list)
+	LogInfo("Loaded %d blocklist entries from %s", list.Len(), path)
+
+	blocklistDone = make(chan struct{})
+	blocklistStopped = make(chan struct{})
+	go watchBlocklist(path, info.ModTime(), blocklistDone, blocklistStopped)
+	return nil
+}
+
+// stopBlocklist stops watching the blocklist file
+func stopBlocklist() {
+	if blocklistDone != nil {
+		close(blocklistDone)
+		<-blocklistStopped
+		blocklistDone = nil
+	}
+}
+
+// watchBlocklist reloads the blocklist when its modification time changes.
+// A file that fails to load leaves the previous list in place.
+func watchBlocklist(path string, modTime time.Time, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(blocklistCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			errorLimiter.log(LogWarning, "blocklist", "Failed to check blocklist %s: %v", path, err)
+			continue
+		}
+		if info.ModTime().Equal(modTime) {
+			continue
+		}
+
+		list, err := LoadBlocklist(path)
+		if err != nil {
+			LogError("Failed to reload blocklist, keeping the previous one: %v", err)
+			modTime = info.ModTime() // Don't retry until the file changes again
+			continue
+		}
+		modTime = info.ModTime()
+		activeBlocklist.Store(list)
+		LogInfo("Reloaded %d blocklist entries from %s", list.Len(), path)
+	}
+}
+
+// checkBlocklist flags an outgoing packet whose destination is on the
+// blocklist, counts the hit and raises an alert
+func checkBlocklist(record *database.PacketRecord) {
+	list := activeBlocklist.Load()
+	if list == nil {
+		return
+	}
+	prefix, ok := list.Match(record.DstIP)
+	if !ok {
+		return
+	}
+
+	record.Flagged = true
+	stats.BlockedHits.Add(1)
+
+	app, alertKey := "unknown", "unknown"
+	if record.ProcessPath != "" {
+		app = appName(record.ProcessPath, record.ServiceName)
+		alertKey = appKey(record.ProcessPath, record.ServiceName)
+		if appStats, ok := stats.ApplicationStats.Load(alertKey); ok {
+			appStats.(*ApplicationStats).BlockedHits.Add(1)
+		}
+	}
+
+	// Alert once per application and destination per interval
+	now := time.Now()
+	key := alertKey + "|" + record.DstIP
+	if last, ok := blocklistAlerts.Load(key); ok && now.Sub(time.Unix(0, last.(int64))) < blocklistAlertInterval {
+		return
+	}
+	blocklistAlerts.Store(key, now.UnixNano())
+
+	destination := record.DstIP
+	if record.DstHost != "" {
+		destination = fmt.Sprintf("%s (%s)", record.DstIP, record.DstHost)
+	}
+	emitAlert(Alert{
+		Time:        now,
+		Kind:        AlertBlockedDestination,
+		App:         app,
+		ProcessID:   record.ProcessID,
+		Destination: record.DstIP,
+		Message: fmt.Sprintf("%s (PID %d) sent traffic to blocklisted destination %s, matching %s",
+			app, record.ProcessID, destination, prefix),
+	})
+}
@@ -0,0 +1,173 @@
+package capture
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBlocklist(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantLen int
+		wantErr string
+	}{
+		{"empty", "", 0, ""},
+		{"comments and blank lines", "# Known bad hosts\n\n203.0.113.7 # seen in logs\n  198.51.100.0/24\t\n#10.0.0.0/8\n", 2, ""},
+		{"IPv6", "2001:db8::/32\n2001:db8::1\n", 2, ""},
+		{"duplicates", "10.0.0.0/8\n10.1.2.3/8\n203.0.113.7\n203.0.113.7/32\n::ffff:203.0.113.7\n", 2, ""},
+		{"hostname", "203.0.113.7\nexample.com\n", 0, "line 2"},
+		{"prefix too long", "10.0.0.0/33\n", 0, "line 1"},
+		{"bad prefix", "# header\n10.0.0.0/\n", 0, "line 2"},
+		{"range", "10.0.0.1-10.0.0.9\n", 0, "line 1"},
+	}
+	for _, tt := range tests {
+		list, err := ParseBlocklist(strings.NewReader(tt.data))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if list.Len() != tt.wantLen {
+			t.Errorf("%s: %d entries, want %d", tt.name, list.Len(), tt.wantLen)
+		}
+	}
+}
+
+func TestBlocklistMatch(t *testing.T) {
+	list, err := ParseBlocklist(strings.NewReader(`
+10.0.0.0/8
+10.1.0.0/16
+10.1.2.3
+2001:db8::/32
+2001:db8:1::/48
+::ffff:192.0.2.0/120
+::ffff:203.0.113.9
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want string // Matching entry, "" for none
+	}{
+		{"10.9.9.9", "10.0.0.0/8"},
+		{"10.1.9.9", "10.1.0.0/16"},
+		{"10.1.2.3", "10.1.2.3/32"},
+		{"::ffff:10.1.2.3", "10.1.2.3/32"},
+		{"192.0.2.55", "192.0.2.0/24"},
+		{"::ffff:192.0.2.55", "192.0.2.0/24"},
+		{"192.0.3.1", ""},
+		{"203.0.113.9", "203.0.113.9/32"},
+		{"203.0.113.10", ""},
+		{"2001:db8::1", "2001:db8::/32"},
+		{"2001:db8:1::1", "2001:db8:1::/48"},
+		{"2001:db9::1", ""},
+		{"::a01:203", ""}, // 10.1.2.3 in an IPv4-compatible, not mapped, address
+		{"11.0.0.1", ""},
+		{"not an address", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		prefix, ok := list.Match(tt.ip)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("Match(%q) = %s, want no match", tt.ip, prefix)
+			}
+			continue
+		}
+		if !ok || prefix != netip.MustParsePrefix(tt.want) {
+			t.Errorf("Match(%q) = %s, %v, want %s", tt.ip, prefix, ok, tt.want)
+		}
+	}
+}
+
+// TestBlocklistReload rewrites the blocklist file while it is watched and
+// checks that changes take effect and that a file that fails to parse leaves
+// the previous list in place
+func TestBlocklistReload(t *testing.T) {
+	db := useTestStore(t)
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	modTime := time.Now().Add(-time.Hour)
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// Coarse file times could otherwise hide a rewrite
+		modTime = modTime.Add(time.Minute)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("203.0.113.0/24\n")
+
+	config := DefaultCaptureConfig()
+	config.Blocklist = path
+	useCaptureConfig(t, config, db)
+	previousInterval, previousList := blocklistCheckInterval, activeBlocklist.Load()
+	blocklistCheckInterval = 5 * time.Millisecond
+	t.Cleanup(func() {
+		stopBlocklist()
+		blocklistCheckInterval = previousInterval
+		activeBlocklist.Store(previousList)
+	})
+	if err := startBlocklist(); err != nil {
+		t.Fatal(err)
+	}
+
+	matches := func(want map[string]bool) bool {
+		list := activeBlocklist.Load()
+		for ip, blocked := range want {
+			if _, ok := list.Match(ip); ok != blocked {
+				return false
+			}
+		}
+		return true
+	}
+
+	steps := []struct {
+		name   string
+		data   string
+		reload bool // Whether the list is replaced
+		want   map[string]bool
+	}{
+		{"loaded", "", false, map[string]bool{"203.0.113.9": true, "198.51.100.7": false}},
+		{"replaced", "198.51.100.7\n", true, map[string]bool{"203.0.113.9": false, "198.51.100.7": true}},
+		{"invalid", "198.51.100.7\n203.0.113.0/24\nnot an address\n", false, map[string]bool{"203.0.113.9": false, "198.51.100.7": true}},
+		{"fixed", "198.51.100.7\n203.0.113.0/24\n", true, map[string]bool{"203.0.113.9": true, "198.51.100.7": true}},
+		{"emptied", "# nothing blocked\n", true, map[string]bool{"203.0.113.9": false, "198.51.100.7": false}},
+	}
+	for i, step := range steps {
+		before := activeBlocklist.Load()
+		if i > 0 {
+			write(step.data)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		if !step.reload {
+			// Nothing to wait for; give the watcher a few checks
+			deadline = time.Now().Add(20 * blocklistCheckInterval)
+		}
+		for time.Now().Before(deadline) && (!step.reload || activeBlocklist.Load() == before) {
+			time.Sleep(blocklistCheckInterval)
+		}
+
+		if reloaded := activeBlocklist.Load() != before; reloaded != step.reload {
+			t.Errorf("%s: reloaded %v, want %v", step.name, reloaded, step.reload)
+		}
+		if !matches(step.want) {
+			t.Errorf("%s: list doesn't match %v", step.name, step.want)
+		}
+	}
+}
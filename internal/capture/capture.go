@@ -2,88 +2,524 @@ package capture
 
 import (
 	"fmt"
-	"log"
 	"net"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 
 	"grip/internal/database"
 	"grip/internal/process"
 )
 
+// Bounds for the configurable snapshot length
+const (
+	minSnapshotLen = 64
+	maxSnapshotLen = 262144
+)
+
+// minSaveInterval keeps the statistics saver from hammering the database
+const minSaveInterval = time.Second
+
+// CaptureConfig holds the options used to open live capture handles
+type CaptureConfig struct {
+	SnapshotLen  int           // Bytes captured per packet
+	Promiscuous  bool          // Put interfaces into promiscuous mode
+	ReadTimeout  time.Duration // How long the driver may buffer packets before handing them over
+	SaveInterval time.Duration // How often statistics are written to the database
+	SavePackets  uint64        // Also save once this many packets arrived since the last save (0 to disable)
+
+	// Destinations held in memory per application (0 for no limit)
+	MaxDestinations int
+
+	// Applications whose stored totals are loaded at start, most recently
+	// active first (0 for all). The others are loaded when next seen.
+	LoadApps int
+
+	// Interfaces limits capture to devices whose name or description contains
+	// one of these strings, ignoring case. Empty captures on every device.
+	Interfaces []string
+	Filter     string        // BPF filter applied to every live capture handle
+	Retention  time.Duration // Delete packets and flows older than this (0 keeps everything)
+
+	// How often the device list is re-read to start capturing on new
+	// interfaces and stop on removed ones (0 to only scan at start)
+	RescanInterval time.Duration
+
+	// How often listening sockets are recorded, raising an alert for ones
+	// never seen before (0 to disable)
+	ListenerScanInterval time.Duration
+
+	// Resolve destination IPs to PTR names in the background
+	ReverseDNS        bool
+	ReverseDNSWorkers int           // Lookups in flight at once
+	ReverseDNSTimeout time.Duration // Give up on a single lookup after this long
+
+	// Store the first PayloadBytes of each packet's application payload (0 to
+	// disable), only for packets to or from PayloadPorts if any are given.
+	// Payloads are only logged with LogPayload.
+	PayloadBytes int
+	PayloadPorts []uint16
+	LogPayload   bool
+
+	// File of IPs and CIDR ranges; outgoing packets to them are flagged and
+	// raise alerts. The file is reloaded when it changes.
+	Blocklist string
+
+	// Store flows and packet rows of external traffic, which passes between
+	// two other hosts and is mostly seen in promiscuous mode. When false it
+	// only counts towards the global, protocol and direction statistics.
+	StoreExternal bool
+
+	// Store that packets and statistics are written to; nil for the
+	// default database opened by database.InitDatabase. StopCapture closes
+	// only the default database; an injected store stays open for its owner.
+	DB database.Store
+}
+
+// DefaultCaptureConfig returns the configuration used when no options are given
+func DefaultCaptureConfig() CaptureConfig {
+	return CaptureConfig{
+		SnapshotLen:     65535,
+		Promiscuous:     true,
+		ReadTimeout:     time.Second,
+		StoreExternal:   true,
+		SaveInterval:    10 * time.Second,
+		MaxDestinations: 10000,
+		LoadApps:        500,
+		RescanInterval:  30 * time.Second,
+
+		ListenerScanInterval: 30 * time.Second,
+
+		ReverseDNS:        true,
+		ReverseDNSWorkers: 4,
+		ReverseDNSTimeout: 2 * time.Second,
+	}
+}
+
+// Validate checks that the configuration values are within sane ranges
+func (c CaptureConfig) Validate() error {
+	if c.SnapshotLen < minSnapshotLen || c.SnapshotLen > maxSnapshotLen {
+		return fmt.Errorf("snapshot length must be between %d and %d bytes, got %d", minSnapshotLen, maxSnapshotLen, c.SnapshotLen)
+	}
+	if c.ReadTimeout < time.Millisecond {
+		return fmt.Errorf("read timeout must be at least 1ms, got %v", c.ReadTimeout)
+	}
+	if c.SaveInterval < minSaveInterval {
+		return fmt.Errorf("statistics save interval must be at least %v, got %v", minSaveInterval, c.SaveInterval)
+	}
+	if c.MaxDestinations < 0 {
+		return fmt.Errorf("destination limit must not be negative, got %d", c.MaxDestinations)
+	}
+	if c.LoadApps < 0 {
+		return fmt.Errorf("application load limit must not be negative, got %d", c.LoadApps)
+	}
+	if c.RescanInterval < 0 {
+		return fmt.Errorf("interface rescan interval must not be negative, got %v", c.RescanInterval)
+	}
+	if c.ListenerScanInterval < 0 {
+		return fmt.Errorf("listener scan interval must not be negative, got %v", c.ListenerScanInterval)
+	}
+	if c.Retention < 0 {
+		return fmt.Errorf("retention must not be negative, got %v", c.Retention)
+	}
+	if c.PayloadBytes < 0 || c.PayloadBytes > maxPayloadBytes {
+		return fmt.Errorf("payload capture must be between 0 and %d bytes, got %d", maxPayloadBytes, c.PayloadBytes)
+	}
+	if c.Blocklist != "" {
+		if _, err := LoadBlocklist(c.Blocklist); err != nil {
+			return err
+		}
+	}
+	if c.ReverseDNS && (c.ReverseDNSWorkers < 1 || c.ReverseDNSTimeout <= 0) {
+		return fmt.Errorf("reverse DNS needs at least one worker and a positive timeout, got %d and %v", c.ReverseDNSWorkers, c.ReverseDNSTimeout)
+	}
+	if c.Filter != "" {
+		if _, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet, c.SnapshotLen, c.Filter); err != nil {
+			return fmt.Errorf("invalid capture filter %q: %v", c.Filter, err)
+		}
+	}
+	return nil
+}
+
 var (
-	snapshot_len int32         = 1024
-	promiscuous  bool          = true
-	timeout      time.Duration = -1 * time.Second
+	captureConfig = DefaultCaptureConfig()
+
+	// Store the capture writes to, set by StartCapture or AnalyzeFile
+	store database.Store
 
 	// Map to track device names to IDs
 	deviceIDMap    = make(map[string]int64)
 	deviceMapMutex sync.RWMutex
 
-	// Process every 1000 packets
-	packetCounter uint64
+	// Whether to attribute packets to local processes; disabled for offline analysis
+	lookupProcesses = true
+
+	// Set while the service is paused; packets are dropped without being counted
+	paused atomic.Bool
+
+	// How often driver receive/drop counters are read from each handle
+	captureStatsInterval = 10 * time.Second
 )
 
-func StartCapture() error {
-	// Get a list of all network devices
-	devices, err := pcap.FindAllDevs()
-	if err != nil {
-		return fmt.Errorf("error finding network devices (make sure you're running as Administrator): %v", err)
+// StartCapture opens every network interface with the given configuration and
+// starts capturing on each in its own goroutine. Byte statistics always use the
+// on-the-wire length, so the snapshot length only limits payload inspection.
+func StartCapture(config CaptureConfig) error {
+	if err := applyConfig(config); err != nil {
+		return err
 	}
 
+	devices, err := findDevices()
+	if err != nil {
+		return err
+	}
 	if len(devices) == 0 {
+		if len(config.Interfaces) > 0 {
+			return fmt.Errorf("no network interfaces match %s", strings.Join(config.Interfaces, ", "))
+		}
 		return fmt.Errorf("no network interfaces found")
 	}
 
-	LogDebug("Starting capture on %d network interfaces", len(devices))
+	mode := "promiscuous"
+	if !config.Promiscuous {
+		mode = "non-promiscuous"
+	}
+	LogInfo("Starting %s capture on %d network interfaces", mode, len(devices))
+
+	// Restore hostnames learned in previous runs
+	loadHostCache()
 
 	// Store network interfaces in database
 	for _, device := range devices {
-		iface := database.NetworkInterface{
-			Name:        device.Name,
-			Description: device.Description,
-			CreatedAt:   time.Now(),
-		}
-		deviceID, err := database.StoreInterface(iface)
-		if err != nil {
-			LogDebug("Error storing interface %s: %v", device.Name, err)
-		} else {
-			// Store device ID in map
-			deviceMapMutex.Lock()
-			deviceIDMap[device.Name] = deviceID
-			deviceMapMutex.Unlock()
-		}
-		LogInterface(device.Name, device.Description)
+		registerDevice(device)
+	}
+
+	// Name the devices after their adapters, e.g. "Ethernet"
+	startAdapterWatch()
+	for _, device := range devices {
+		LogInterface(InterfaceDisplayName(device.Name), device.Name)
+	}
+
+	if err := startWorkers(false); err != nil {
+		return err
 	}
 
 	// Start capturing on each device in a separate goroutine
 	for _, device := range devices {
 		go captureDevice(device.Name)
 	}
+	startDeviceRescan(devices)
+	startListenerScan()
 
 	return nil
 }
 
-func captureDevice(deviceName string) {
-	handle, err := pcap.OpenLive(deviceName, snapshot_len, promiscuous, timeout)
+// applyConfig validates the configuration and makes it the active one
+func applyConfig(config CaptureConfig) error {
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("invalid capture configuration: %v", err)
+	}
+	captureConfig = config
+	saveInterval = config.SaveInterval
+	savePacketThreshold = config.SavePackets
+	maxDestinations = config.MaxDestinations
+	loadAppLimit = config.LoadApps
+
+	store = config.DB
+	if store == nil {
+		store = defaultStore()
+	}
+	if store == nil {
+		return fmt.Errorf("database must be initialized before starting capture")
+	}
+	return nil
+}
+
+// defaultStore returns the default database as a Store, or nil if it hasn't
+// been opened. A nil *DB must not become a non-nil Store.
+func defaultStore() database.Store {
+	if db := database.Default(); db != nil {
+		return db
+	}
+	return nil
+}
+
+// startWorkers starts the background goroutines of a capture session.
+// blockingStorage makes packet processing wait for database writes instead
+// of dropping records, for capture files.
+func startWorkers(blockingStorage bool) error {
+	if err := startBlocklist(); err != nil {
+		return err
+	}
+
+	startStatsSaver()
+	startFlowTracker()
+	startPacketAggregator()
+	startStorageWriter(blockingStorage)
+	startThresholdMonitor()
+	startRetention()
+	startRollup()
+	startLookupSummary()
+	startReverseDNS()
+	startExecutableChecks()
+	startDestinationChecks()
+	startSelfMonitor()
+	return nil
+}
+
+// selectDevices returns the devices whose name or description contains one of
+// the patterns, ignoring case
+func selectDevices(devices []pcap.Interface, patterns []string) []pcap.Interface {
+	var selected []pcap.Interface
+	for _, device := range devices {
+		name := strings.ToLower(device.Name)
+		description := strings.ToLower(device.Description)
+		for _, pattern := range patterns {
+			pattern = strings.ToLower(pattern)
+			if strings.Contains(name, pattern) || strings.Contains(description, pattern) {
+				selected = append(selected, device)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// AnalyzeFile reads packets from a pcap/pcapng file and feeds them through the
+// same pipeline as live capture. Process lookup is skipped because the
+// connections in the file no longer exist on this machine.
+func AnalyzeFile(path string) error {
+	if store == nil {
+		store = defaultStore()
+	}
+	if store == nil {
+		return fmt.Errorf("database must be initialized before analyzing a capture file")
+	}
+
+	source, err := openFileSource(path)
 	if err != nil {
-		log.Printf("Error opening device %s: %v", deviceName, err)
-		return
+		return err
+	}
+	defer source.Close()
+
+	deviceName, err := registerFileDevice(path)
+	if err != nil {
+		return err
 	}
-	defer handle.Close()
 
-	packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
-	for packet := range packetSource.Packets() {
-		// Log basic packet information
+	loadHostCache()
+	startFlowTracker()
+	startPacketAggregator()
+	startStorageWriter(true)
+
+	lookupProcesses = false
+	defer func() { lookupProcesses = true }()
+
+	LogInfo("Analyzing capture file %s", path)
+
+	count := 0
+	for packet := range source.Packets() {
 		processPacket(deviceName, packet)
+		count++
+	}
+
+	LogInfo("Finished analyzing %s: %d packets read", path, count)
+	return nil
+}
+
+// Delays between attempts to reopen a device whose capture failed, doubling
+// from reopenMinBackoff. The delay starts over once a capture has run for
+// reopenMaxBackoff, so a device that keeps failing is retried less often.
+const (
+	reopenMinBackoff = time.Second
+	reopenMaxBackoff = 2 * time.Minute
+)
+
+// deviceCapture tracks a running capture so it can be stopped from StopCapture
+type deviceCapture struct {
+	stop     chan struct{} // closed to ask the capture loop and stats poller to exit
+	finished chan struct{} // closed once the capture loop has returned
+	once     sync.Once
+}
+
+var (
+	activeCaptures = make(map[string]*deviceCapture)
+	capturesMutex  sync.Mutex
+
+	// openDeviceSource opens the packet source of a device; it can be replaced
+	// to run the capture loop on synthetic packets
+	openDeviceSource = openLiveSource
+
+	// How processPacket tells this machine's addresses and finds the process
+	// of a connection; replaced along with openDeviceSource so synthetic
+	// packets get a direction and a process
+	isLocalAddress               = isLocalIP
+	findProcess    ProcessLookup = lookupProcessInfo
+)
+
+// shutdown asks the capture to stop; wait on finished for it to return
+func (c *deviceCapture) shutdown() {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+}
+
+// captureDevice captures on a device until it is stopped. When the handle
+// fails, for example because a VPN or Wi-Fi adapter went down, the device is
+// reopened with exponential backoff.
+func captureDevice(deviceName string) {
+	c := &deviceCapture{
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	capturesMutex.Lock()
+	activeCaptures[deviceName] = c
+	capturesMutex.Unlock()
+
+	defer func() {
+		capturesMutex.Lock()
+		delete(activeCaptures, deviceName)
+		capturesMutex.Unlock()
+		close(c.finished)
+	}()
+
+	ifStats := getInterfaceStats(deviceName)
+	backoff := reopenMinBackoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-c.stop:
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, reopenMaxBackoff)
+		}
+
+		source, err := openDeviceSource(deviceName)
+		if _, ok := err.(filterError); ok {
+			LogError("%v on %s", err, deviceName)
+			return
+		}
+		if err != nil {
+			LogWarning("Error opening device %s, retrying in %v: %v", InterfaceDisplayName(deviceName), backoff, err)
+			continue
+		}
+
+		if attempt > 0 {
+			LogInfo("Capture on %s resumed", InterfaceDisplayName(deviceName))
+		}
+		started := time.Now()
+		ifStats.setActive(true)
+		stopped := c.run(deviceName, source)
+		ifStats.setActive(false)
+		if stopped {
+			return
+		}
+
+		if time.Since(started) >= reopenMaxBackoff {
+			backoff = reopenMinBackoff
+		}
+		LogWarning("Capture on %s ended unexpectedly, reopening in %v", InterfaceDisplayName(deviceName), backoff)
 	}
 }
 
+// run reads packets from an open source until the capture is stopped, which
+// returns true, or the source fails or is exhausted, which returns false. The
+// source is closed on return.
+func (c *deviceCapture) run(deviceName string, source PacketSource) bool {
+	// Poll driver counters until the source is closed
+	pollDone := make(chan struct{})
+	polled := make(chan struct{})
+	go pollCaptureStats(deviceName, source, pollDone, polled)
+
+	defer func() {
+		close(pollDone)
+		<-polled
+		source.Close()
+	}()
+
+	packets := source.Packets()
+	for {
+		select {
+		case <-c.stop:
+			return true
+		case packet, ok := <-packets:
+			if !ok {
+				return false
+			}
+
+			queueDumpPacket(deviceName, source.LinkType(), captureConfig.SnapshotLen, packet)
+
+			// Log basic packet information
+			processPacket(deviceName, packet)
+		}
+	}
+}
+
+// stopDeviceCaptures stops every capture goroutine and waits for in-flight packets to finish
+func stopDeviceCaptures() {
+	capturesMutex.Lock()
+	captures := make([]*deviceCapture, 0, len(activeCaptures))
+	for _, c := range activeCaptures {
+		captures = append(captures, c)
+	}
+	capturesMutex.Unlock()
+
+	for _, c := range captures {
+		c.shutdown()
+	}
+	for _, c := range captures {
+		<-c.finished
+	}
+}
+
+// pollCaptureStats periodically records the driver receive/drop counters for a device
+func pollCaptureStats(deviceName string, source PacketSource, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(captureStatsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			sourceStats, err := source.Stats()
+			if err != nil {
+				LogDebug("Error reading capture stats for %s: %v", deviceName, err)
+				continue
+			}
+
+			ifStats := getInterfaceStats(deviceName)
+			dropped := sourceStats.Dropped
+			ifDropped := sourceStats.IfDropped
+
+			// Rising drop counters mean the monitor itself is losing data
+			newDropped := counterIncrease(dropped, ifStats.PacketsDropped.Swap(dropped))
+			newIfDropped := counterIncrease(ifDropped, ifStats.PacketsIfDropped.Swap(ifDropped))
+			if newDropped > 0 || newIfDropped > 0 {
+				LogWarning("Capture on %s is dropping packets: %d dropped (+%d), %d dropped by interface (+%d)",
+					deviceName, dropped, newDropped, ifDropped, newIfDropped)
+			}
+			ifStats.PacketsReceived.Store(sourceStats.Received)
+		}
+	}
+}
+
+// counterIncrease returns how much a counter grew, treating a reset as no growth
+func counterIncrease(current, previous uint64) uint64 {
+	if current > previous {
+		return current - previous
+	}
+	return 0
+}
+
 // Extract network information from a packet
 func extractNetworkInfo(packet gopacket.Packet) (src, dst, srcPort, dstPort, protocol string, length int, valid bool) {
 	// Get network layer info
@@ -92,24 +528,51 @@ func extractNetworkInfo(packet gopacket.Packet) (src, dst, srcPort, dstPort, pro
 		return "", "", "", "", "", 0, false
 	}
 
-	// Get transport layer info
-	transportLayer := packet.TransportLayer()
-	if transportLayer == nil {
-		return "", "", "", "", "", 0, false
-	}
-
 	// Get source and destination IPs
 	flow := networkLayer.NetworkFlow()
 	src = flow.Src().String()
 	dst = flow.Dst().String()
 
+	// Use the original wire length; the captured data is truncated to the snapshot length
+	length = packet.Metadata().Length
+	if length == 0 {
+		length = len(packet.Data())
+	}
+
+	// Tunnels such as GRE or IP-in-IP are named after the outer protocol even
+	// when gopacket decodes the TCP or UDP packet inside them, whose ports
+	// don't belong to the outer addresses
+	number, ok := ipProtocolNumber(packet)
+	if ok && !portedProtocols[number] && number != layers.IPProtocolICMPv4 && number != layers.IPProtocolICMPv6 {
+		return src, dst, "", "", ipProtocolName(number), length, true
+	}
+
+	// ICMP has no transport layer or ports, but is often the whole story
+	// when diagnosing connectivity problems
+	if packet.Layer(layers.LayerTypeICMPv4) != nil {
+		return src, dst, "", "", "ICMP", length, true
+	}
+	if packet.Layer(layers.LayerTypeICMPv6) != nil {
+		return src, dst, "", "", "ICMPv6", length, true
+	}
+
+	// Get transport layer info. Protocols without ports, such as GRE, ESP or
+	// IGMP, were named after the IP protocol number above.
+	transportLayer := packet.TransportLayer()
+	if transportLayer == nil {
+		if !ok || portedProtocols[number] {
+			// A fragment or truncated header can't be told apart from other connections
+			return "", "", "", "", "", 0, false
+		}
+		return src, dst, "", "", ipProtocolName(number), length, true // ICMP that failed to decode
+	}
+
 	// Get source and destination ports
 	tflow := transportLayer.TransportFlow()
 	srcPort = strings.TrimPrefix(tflow.Src().String(), ":")
 	dstPort = strings.TrimPrefix(tflow.Dst().String(), ":")
 
 	protocol = transportLayer.LayerType().String()
-	length = len(packet.Data())
 
 	return src, dst, srcPort, dstPort, protocol, length, true
 }
@@ -159,13 +622,13 @@ func lookupProcessInfo(protocol string, srcPortInt, dstPortInt uint16, direction
 		// LogDebug("Destination UDP lookup failed for incoming traffic: %v", err)
 	}
 
-	// If we reach here, all applicable checks failed
-	// LogError("Failed to find process for %s traffic (%s) between ports %d and %d",
-	// 	protocol, direction, srcPortInt, dstPortInt)
+	// If we reach here, all applicable checks failed; the caller counts the failure
 	return nil, fmt.Errorf("process not found")
 }
 
-func createPacketRecord(deviceName, src, srcPort, dst, dstPort, protocol string, length int, direction string, processInfo *process.ProcessInfo) database.PacketRecord {
+// enrichPacketRecord adds the device, host name and GeoIP details to a
+// classified record and counts it towards the statistics of its process
+func enrichPacketRecord(deviceName string, record *database.PacketRecord, processInfo *process.ProcessInfo) {
 	// Get device ID from map
 	deviceMapMutex.RLock()
 	deviceID, exists := deviceIDMap[deviceName]
@@ -174,63 +637,63 @@ func createPacketRecord(deviceName, src, srcPort, dst, dstPort, protocol string,
 	if !exists {
 		LogError("No device ID found for device: %s", deviceName)
 	}
-
-	// Create packet record
-	record := database.PacketRecord{
-		Timestamp: time.Now(),
-		DeviceID:  deviceID, // Use device ID instead of name
-		SrcIP:     src,
-		SrcPort:   srcPort,
-		DstIP:     dst,
-		DstPort:   dstPort,
-		Protocol:  protocol,
-		Length:    length,
-		Direction: direction,
+	record.DeviceID = deviceID
+	record.DstHost = lookupHost(record.DstIP)
+
+	peer := remotePeer(record.SrcIP, record.DstIP, record.Direction)
+	geo := lookupGeoIP(peer)
+	record.GeoIP = geo.String()
+
+	// Names observed in DNS answers are better than PTR records, which are
+	// often generic hosting names
+	destination := peerDestination(record.SrcIP, record.DstIP, record.DstHost, record.Direction)
+	if destination == peer {
+		requestReverseDNS(peer)
 	}
 
 	if processInfo != nil {
-		record.ProcessID = processInfo.ProcessID
-		record.ProcessName = processInfo.ProcessName
-		record.ProcessPath = processInfo.ExecutablePath
-
-		// If process name is empty, use the last segment of the process path
-		if record.ProcessName == "" && record.ProcessPath != "" {
-			// Split by both forward and backward slashes for cross-platform compatibility
-			pathParts := strings.FieldsFunc(record.ProcessPath, func(c rune) bool {
-				return c == '/' || c == '\\'
-			})
-			if len(pathParts) > 0 {
-				record.ProcessName = pathParts[len(pathParts)-1]
-			}
-		}
-
-		// Update application-specific statistics
-		destination := dst
 		updateAppStats(
-			processInfo.ProcessID,
-			processInfo.ProcessName,
-			processInfo.ExecutablePath,
-			protocol,
-			uint64(length),
+			processInfo,
+			record.Protocol,
+			record.Direction,
+			1,
+			uint64(record.Length),
+			peer,
 			destination,
+			geo.Country,
+			record.DstPort,
 		)
 	}
+}
 
-	return record
+// peerDestination is how the remote peer of a packet is listed in application
+// statistics: its hostname if known, otherwise its IP. dstHost is the known
+// hostname of dstIP.
+func peerDestination(srcIP, dstIP, dstHost, direction string) string {
+	peer := remotePeer(srcIP, dstIP, direction)
+	if peer == dstIP {
+		if dstHost != "" {
+			return dstHost
+		}
+		return dstIP
+	}
+	if host := lookupHost(peer); host != "" {
+		return host
+	}
+	return peer
 }
 
 // Create and store a packet record
 func StorePacketRecord(packetRecord database.PacketRecord) {
 	// Store in database
-	if err := database.StorePacket(packetRecord); err != nil {
-		LogDebug("Error storing packet in database: %v", err)
+	if err := store.StorePacket(packetRecord); err != nil {
+		errorLimiter.log(LogError, "store packet", "Error storing packet in database: %v", err)
 	}
 }
 
-func logPacket(packetRecord database.PacketRecord) {
-	// Log packet information (still use device name for logging)
+func logPacket(deviceName string, packetRecord database.PacketRecord) {
 	LogPacket(
-		packetRecord.DeviceID,
+		deviceName,
 		packetRecord.SrcIP,
 		packetRecord.SrcPort,
 		packetRecord.DstIP,
@@ -239,15 +702,51 @@ func logPacket(packetRecord database.PacketRecord) {
 		packetRecord.Length,
 		packetRecord.Direction,
 		packetRecord.ProcessPath,
+		packetRecord.ServiceName,
+		packetRecord.ProcessOwner,
 	)
+	logPayload(packetRecord.Payload)
 }
 
+// StopCapture stops all capture goroutines, then persists statistics and
+// closes the logger, and the database if it is the default one. It is safe to
+// call while packets are being processed.
 func StopCapture() {
-	// Save all statistics to database before shutdown
+	// Stop capturing first so nothing writes to the database while it closes
+	stopDeviceRescan()
+	stopDeviceCaptures()
+	closeSubscriptions()
+
+	// Write what the capture loops queued before flows and buckets are flushed
+	stopStorageWriter()
+
+	// Write out flows that are still open, then save statistics
+	stopThresholdMonitor()
+	stopListenerScan()
+	stopRetention()
+	stopRollup()
+	stopLookupSummary()
+	stopReverseDNS()
+	stopExecutableChecks()
+	stopDestinationChecks()
+	stopSelfMonitor()
+	stopAdapterWatch()
+	stopBlocklist()
+	stopStatsSaver()
+	stopPacketAggregator()
+	stopFlowTracker()
+	closeStats()
 	SaveAllStatsToDB()
 
-	// Close database and logger
-	database.CloseDatabase()
+	// Flush and close any pcap dump files
+	closePacketDump()
+	closeGeoIP()
+
+	// Close database and logger. A store passed in CaptureConfig.DB belongs
+	// to the caller.
+	if store != nil && store == defaultStore() {
+		store.Close()
+	}
 	CloseLogger()
 }
 
@@ -288,10 +787,74 @@ func isLocalIP(ip string) bool {
 	return false
 }
 
-// Determine packet direction based on source and destination IPs
-func determinePacketDirection(srcIP, dstIP string) string {
-	srcIsLocal := isLocalIP(srcIP)
-	dstIsLocal := isLocalIP(dstIP)
+// broadcastAddrs holds the broadcast addresses of the subnets this machine is
+// on. Reading the interface addresses is far too slow to do per packet, so
+// they are computed by refreshBroadcastAddrs when the adapters are read or the
+// devices rescanned.
+var broadcastAddrs atomic.Pointer[map[[4]byte]bool]
+
+// refreshBroadcastAddrs recomputes broadcastAddrs from the interface addresses
+func refreshBroadcastAddrs() {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		errorLimiter.log(LogWarning, "broadcast", "Failed to read interface addresses: %v", err)
+		return
+	}
+	broadcasts := make(map[[4]byte]bool)
+	for _, addr := range addrs {
+		network, ok := addr.(*net.IPNet)
+		if !ok || network.IP.To4() == nil || len(network.Mask) != net.IPv4len {
+			continue
+		}
+		ones, bits := network.Mask.Size()
+		if bits-ones < 2 {
+			continue // Point-to-point links and single hosts have no broadcast address
+		}
+		var broadcast [4]byte
+		for i, b := range network.IP.To4() {
+			broadcast[i] = b | ^network.Mask[i]
+		}
+		broadcasts[broadcast] = true
+	}
+	broadcastAddrs.Store(&broadcasts)
+}
+
+// isBroadcastIP reports whether an address is the limited broadcast address
+// or the broadcast address of a subnet this machine is on
+func isBroadcastIP(ip string) bool {
+	parsed := net.ParseIP(ip).To4()
+	if parsed == nil {
+		return false // IPv6 has no broadcast, only multicast
+	}
+	if parsed.Equal(net.IPv4bcast) {
+		return true
+	}
+
+	broadcasts := broadcastAddrs.Load()
+	if broadcasts == nil {
+		// Before the adapters were first read, e.g. when replaying a file
+		refreshBroadcastAddrs()
+		if broadcasts = broadcastAddrs.Load(); broadcasts == nil {
+			return false
+		}
+	}
+	return (*broadcasts)[[4]byte(parsed)]
+}
+
+// Determine packet direction based on source and destination IPs; isLocal
+// reports whether an address belongs to this machine. Packets to a multicast
+// or broadcast address get a direction of their own, whichever side is local,
+// since they are addressed to a group rather than one peer.
+func determinePacketDirection(srcIP, dstIP string, isLocal func(ip string) bool) string {
+	if dst := net.ParseIP(dstIP); dst != nil && dst.IsMulticast() {
+		return "multicast" // 224.0.0.0/4 and ff00::/8, e.g. mDNS and SSDP
+	}
+	if isBroadcastIP(dstIP) {
+		return "broadcast"
+	}
+
+	srcIsLocal := isLocal(srcIP)
+	dstIsLocal := isLocal(dstIP)
 
 	if srcIsLocal && dstIsLocal {
 		return "internal" // Both IPs are local - internal traffic
@@ -304,49 +867,82 @@ func determinePacketDirection(srcIP, dstIP string) string {
 	}
 }
 
+// SetPaused stops or resumes counting and storing captured packets. The
+// interfaces stay open, so capture resumes without missing a beat.
+func SetPaused(pause bool) {
+	if paused.Swap(pause) != pause {
+		if pause {
+			LogInfo("Capture paused")
+		} else {
+			LogInfo("Capture resumed")
+		}
+	}
+}
+
+// IsPaused reports whether capture is paused
+func IsPaused() bool {
+	return paused.Load()
+}
+
 func processPacket(deviceName string, packet gopacket.Packet) {
-	// Extract network information
-	src, dst, srcPort, dstPort, protocol, length, valid := extractNetworkInfo(packet)
-	if !valid {
+	if paused.Load() {
 		return
 	}
 
-	// Update statistics
-	// updateStats(uint64(length))
-	// incrementProtocolCount(protocol)
+	// Extract addresses, ports and direction
+	packetRecord, err := classifyPacket(packet, isLocalAddress)
+	if err != nil {
+		return
+	}
+	length := uint64(packetRecord.Length)
 
-	// Increment packet counter
-	// newCount := atomic.AddUint64(&packetCounter, 1)
+	// Learn hostnames from DNS responses before attributing destinations
+	observeDNS(packet)
 
-	// Every 1000 packets, save stats
-	// if newCount%1000 == 0 {
-	// 	LogDebug("Processing packet #%d, triggering stats save", newCount)
-	// 	go SaveAllStatsToDB()
-	// }
+	// Update statistics before anything can bail out, so the global totals
+	// always match the sum of the protocol counters
+	UpdateGlobalStats(length, packetRecord.Direction)
+	incrementProtocolCount(packetRecord.Protocol, length)
+	incrementVLANCount(packetRecord.VLAN, length)
+	updateInterfaceStats(deviceName, length)
 
-	// Parse port strings to integers for process lookup
-	srcPortInt := uint16(0)
-	dstPortInt := uint16(0)
-	if sp, err := strconv.ParseUint(srcPort, 10, 16); err == nil {
-		srcPortInt = uint16(sp)
-	}
-	if dp, err := strconv.ParseUint(dstPort, 10, 16); err == nil {
-		dstPortInt = uint16(dp)
+	if packetRecord.Direction == "external" && !captureConfig.StoreExternal {
+		return
 	}
 
-	// Determine packet direction
-	direction := determinePacketDirection(src, dst)
-
-	// Look up process information
-	processInfo, err := lookupProcessInfo(protocol, srcPortInt, dstPortInt, direction)
-	if err != nil {
-		LogError("Process lookup failed: %v", err)
+	// Name TLS destinations from the ClientHello; this also updates the host cache
+	// so the rest of the connection is labelled too
+	if packetRecord.Direction == "outgoing" && packetRecord.Protocol == "TCP" {
+		observeSNI(packet, packetRecord.DstIP)
 	}
 
-	packetRecord := createPacketRecord(deviceName, src, srcPort, dst, dstPort, protocol, length, direction, processInfo)
-	StorePacketRecord(packetRecord)
-	logPacket(packetRecord)
-	updateGlobalStats(uint64(length))
+	// Look up process information once per flow; a miss only leaves the
+	// record unattributed
+	var processInfo *process.ProcessInfo
+	if lookupProcesses {
+		processInfo, err = attributeFlowProcess(&packetRecord, findProcess)
+		if err != nil {
+			// Common for short-lived connections; failures are counted and
+			// summarized once a minute rather than logged per packet
+			category := packetRecord.Protocol + "/" + packetRecord.Direction
+			incrementLookupFailures(category)
+			errorLimiter.log(LogDebug, "process lookup "+category,
+				"Process lookup failed for %s %s traffic %s:%s -> %s:%s: %v",
+				packetRecord.Direction, packetRecord.Protocol, packetRecord.SrcIP, packetRecord.SrcPort,
+				packetRecord.DstIP, packetRecord.DstPort, err)
+		}
+	}
 
-	// Create and store packet record
+	enrichPacketRecord(deviceName, &packetRecord, processInfo)
+	srcPort, dstPort := packetPorts(packetRecord)
+	packetRecord.Payload = capturePayload(packet, srcPort, dstPort)
+	if packetRecord.Direction == "outgoing" {
+		checkBlocklist(&packetRecord)
+	}
+	trackFlow(packetRecord, packetTCPFlags(packet))
+	if flowConfig.StorePackets {
+		queuePacketRecord(packetRecord)
+	}
+	publishPacket(deviceName, packetRecord)
+	logPacket(deviceName, packetRecord)
 }
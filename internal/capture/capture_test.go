@@ -0,0 +1,474 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+
+	"grip/internal/database"
+	"grip/internal/process"
+)
+
+// testDevice is the capture device synthetic packets arrive on
+const testDevice = `\Device\NPF_{00000000-0000-0000-0000-000000000001}`
+
+// testLocalIP is this machine's address as far as the tests are concerned
+const testLocalIP = "192.168.1.10"
+
+// useTestStore points the package at a new in-memory database with
+// testDevice registered, and clears the statistics and flows of earlier
+// tests. Everything is put back when the test ends.
+func useTestStore(tb testing.TB) *database.DB {
+	tb.Helper()
+	return useTestStoreAt(tb, database.MemoryPath)
+}
+
+// useTestStoreAt is useTestStore with the database at path
+func useTestStoreAt(tb testing.TB, path string) *database.DB {
+	tb.Helper()
+
+	config := database.DefaultConfig()
+	config.Path = path
+	config.CheckpointInterval = 0
+	db, err := database.Open(config)
+	if err != nil {
+		tb.Fatalf("opening database: %v", err)
+	}
+
+	previousStore, previousFlows := store, flowConfig
+	store = db
+	resetStats()
+	registerDevice(pcap.Interface{Name: testDevice, Description: "Test adapter"})
+
+	tb.Cleanup(func() {
+		stopFlowTracker()
+		resetStats()
+		deviceMapMutex.Lock()
+		delete(deviceIDMap, testDevice)
+		deviceMapMutex.Unlock()
+		store, flowConfig = previousStore, previousFlows
+		db.Close()
+	})
+	return db
+}
+
+// resetStats forgets every in-memory counter, as a restart does
+func resetStats() {
+	clearMap := func(m *sync.Map) {
+		m.Range(func(key, _ interface{}) bool {
+			m.Delete(key)
+			return true
+		})
+	}
+	clearMap(&stats.ApplicationStats)
+	clearMap(&stats.PacketsByProtocol)
+	clearMap(&stats.PacketsByDirection)
+	clearMap(&stats.PacketsByVLAN)
+	clearMap(&stats.InterfaceStats)
+	clearMap(&stats.LookupFailures)
+	stats.TotalPackets.Store(0)
+	stats.TotalBytes.Store(0)
+	appStatsPartial.Store(false)
+
+	statsMutex.Lock()
+	statsClosed = false
+	statsMutex.Unlock()
+}
+
+// useProcesses makes testLocalIP the only local address and attributes
+// connections to processes by their local port
+func useProcesses(tb testing.TB, processes map[uint16]*process.ProcessInfo) {
+	previousLocal, previousFind := isLocalAddress, findProcess
+	isLocalAddress = func(ip string) bool { return ip == testLocalIP }
+	findProcess = func(protocol string, srcPort, dstPort uint16, direction string) (*process.ProcessInfo, error) {
+		port := srcPort
+		if direction == "incoming" {
+			port = dstPort
+		}
+		if info, ok := processes[port]; ok {
+			return info, nil
+		}
+		return nil, fmt.Errorf("process not found")
+	}
+	tb.Cleanup(func() { isLocalAddress, findProcess = previousLocal, previousFind })
+}
+
+// testPacket builds an Ethernet frame carrying an IPv4 packet with the given
+// transport layer and payload, captured at ts
+func testPacket(tb testing.TB, ts time.Time, src, dst string, transport gopacket.SerializableLayer, payload []byte) gopacket.Packet {
+	tb.Helper()
+
+	ip := &layers.IPv4{
+		Version: 4,
+		TTL:     64,
+		SrcIP:   net.ParseIP(src).To4(),
+		DstIP:   net.ParseIP(dst).To4(),
+	}
+	switch layer := transport.(type) {
+	case *layers.TCP:
+		ip.Protocol = layers.IPProtocolTCP
+		layer.SetNetworkLayerForChecksum(ip)
+	case *layers.UDP:
+		ip.Protocol = layers.IPProtocolUDP
+		layer.SetNetworkLayerForChecksum(ip)
+	case *layers.ICMPv4:
+		ip.Protocol = layers.IPProtocolICMPv4
+	}
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0x00, 0x66, 0x77, 0x88, 0x99, 0xaa},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, transport, gopacket.Payload(payload)); err != nil {
+		tb.Fatalf("building packet: %v", err)
+	}
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+	packet.Metadata().Timestamp = ts
+	packet.Metadata().CaptureLength = len(buf.Bytes())
+	packet.Metadata().Length = len(buf.Bytes())
+	return packet
+}
+
+// TestProcessPacket pushes TCP, UDP and ICMP packets through processPacket
+// and checks the stored packets, flows and application totals and the
+// in-memory statistics
+func TestProcessPacket(t *testing.T) {
+	db := useTestStore(t)
+	browser := &process.ProcessInfo{ProcessID: 100, ProcessName: "browser.exe", ExecutablePath: `C:\Apps\browser.exe`}
+	server := &process.ProcessInfo{ProcessID: 200, ProcessName: "server.exe", ExecutablePath: `C:\Apps\server.exe`}
+	useProcesses(t, map[uint16]*process.ProcessInfo{50000: browser, 50001: browser, 9000: server})
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		src, dst  string
+		transport gopacket.SerializableLayer
+		payload   int
+
+		protocol, direction, process string
+	}{
+		{testLocalIP, "93.184.216.34", &layers.TCP{SrcPort: 50000, DstPort: 443, SYN: true}, 0, "TCP", "outgoing", "browser.exe"},
+		{"93.184.216.34", testLocalIP, &layers.TCP{SrcPort: 443, DstPort: 50000, SYN: true, ACK: true}, 0, "TCP", "incoming", "browser.exe"},
+		{testLocalIP, "93.184.216.34", &layers.TCP{SrcPort: 50000, DstPort: 443, ACK: true, PSH: true}, 300, "TCP", "outgoing", "browser.exe"},
+		{testLocalIP, "198.51.100.7", &layers.UDP{SrcPort: 50001, DstPort: 4433}, 1200, "UDP", "outgoing", "browser.exe"},
+		{"203.0.113.5", testLocalIP, &layers.UDP{SrcPort: 4000, DstPort: 9000}, 64, "UDP", "incoming", "server.exe"},
+		{testLocalIP, "1.1.1.1", &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0), Id: 1, Seq: 1}, 32, "ICMP", "outgoing", ""},
+	}
+
+	protocolWant := make(map[string]ProtocolCount)
+	appWant := make(map[string]ProtocolCount)
+	var totalBytes uint64
+	for i, tt := range tests {
+		packet := testPacket(t, start.Add(time.Duration(i)*time.Millisecond), tt.src, tt.dst, tt.transport, make([]byte, tt.payload))
+		length := uint64(len(packet.Data()))
+		processPacket(testDevice, packet)
+
+		count := protocolWant[tt.protocol]
+		protocolWant[tt.protocol] = ProtocolCount{Packets: count.Packets + 1, Bytes: count.Bytes + length}
+		if tt.process != "" {
+			count := appWant[tt.process]
+			appWant[tt.process] = ProtocolCount{Packets: count.Packets + 1, Bytes: count.Bytes + length}
+		}
+		totalBytes += length
+	}
+
+	// In-memory statistics count every packet
+	if got := stats.TotalPackets.Load(); got != uint64(len(tests)) {
+		t.Errorf("total packets = %d, want %d", got, len(tests))
+	}
+	if got := stats.TotalBytes.Load(); got != totalBytes {
+		t.Errorf("total bytes = %d, want %d", got, totalBytes)
+	}
+	protocols := GetProtocolCounts()
+	for protocol, want := range protocolWant {
+		if got := protocols[protocol]; got != want {
+			t.Errorf("%s counts = %+v, want %+v", protocol, got, want)
+		}
+	}
+
+	// Packet rows are written as they are processed
+	var packets []database.PacketRecord
+	err := db.StreamPackets(database.PacketFilter{}, func(p database.PacketRecord) error {
+		packets = append(packets, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != len(tests) {
+		t.Fatalf("stored %d packets, want %d", len(packets), len(tests))
+	}
+	for i, tt := range tests {
+		got := packets[i]
+		if got.Protocol != tt.protocol || got.Direction != tt.direction || got.ProcessName != tt.process || got.SrcIP != tt.src {
+			t.Errorf("packet %d = %s %s %s from %s, want %s %s %s from %s", i,
+				got.Protocol, got.Direction, got.ProcessName, got.SrcIP, tt.protocol, tt.direction, tt.process, tt.src)
+		}
+	}
+
+	// Flows are written once they end, here when the tracker stops
+	stopFlowTracker()
+	var flows, flowPackets int
+	if err := db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(packet_count), 0) FROM flows`).Scan(&flows, &flowPackets); err != nil {
+		t.Fatal(err)
+	}
+	if flows != 5 || flowPackets != len(tests) {
+		t.Errorf("stored %d flows of %d packets, want 5 flows of %d", flows, flowPackets, len(tests))
+	}
+
+	// Application totals are written by the statistics save
+	SaveAllStatsToDB()
+	for _, info := range []*process.ProcessInfo{browser, server} {
+		want := appWant[info.ProcessName]
+		rows, err := db.GetAppStatsForKey(appKey(info.ExecutablePath, info.ServiceName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 || rows[0].TotalPackets != want.Packets || rows[0].TotalBytes != want.Bytes {
+			t.Errorf("%s: stored %+v, want one row of %d packets, %d bytes", info.ProcessName, rows, want.Packets, want.Bytes)
+		}
+	}
+}
+
+// slowStore delays every packet row, like a database on a busy disk
+type slowStore struct {
+	database.Store
+	delay time.Duration
+}
+
+func (s slowStore) StorePacket(packet database.PacketRecord) error {
+	time.Sleep(s.delay)
+	return s.Store.StorePacket(packet)
+}
+
+// BenchmarkCapture measures the per-packet cost of the capture loop on a mix
+// of attributed TCP and UDP packets from a synthetic source, stored in a
+// database file directly and through the storage writer live capture uses,
+// and with every row delayed to show what a slow disk costs each. Packets
+// the storage writer had to drop are reported per packet.
+func BenchmarkCapture(b *testing.B) {
+	benchmarks := []struct {
+		name          string
+		storageWriter bool
+		delay         time.Duration // Added to every packet row
+	}{
+		{"direct", false, 0},
+		{"storage writer", true, 0},
+		{"slow store", false, 200 * time.Microsecond},
+		{"slow store, storage writer", true, 200 * time.Microsecond},
+	}
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			db := useTestStoreAt(b, filepath.Join(b.TempDir(), "grip.db"))
+			if bm.delay > 0 {
+				store = slowStore{db, bm.delay}
+			}
+			browser := &process.ProcessInfo{ProcessID: 100, ProcessName: "browser.exe", ExecutablePath: `C:\Apps\browser.exe`}
+			useProcesses(b, map[uint16]*process.ProcessInfo{50000: browser, 50001: browser})
+			if bm.storageWriter {
+				startStorageWriter(false)
+				b.Cleanup(stopStorageWriter)
+			}
+
+			now := time.Now()
+			mix := []gopacket.Packet{
+				testPacket(b, now, testLocalIP, "93.184.216.34", &layers.TCP{SrcPort: 50000, DstPort: 443, ACK: true, PSH: true}, make([]byte, 512)),
+				testPacket(b, now, "93.184.216.34", testLocalIP, &layers.TCP{SrcPort: 443, DstPort: 50000, ACK: true}, make([]byte, 1400)),
+				testPacket(b, now, testLocalIP, "198.51.100.7", &layers.UDP{SrcPort: 50001, DstPort: 4433}, make([]byte, 1200)),
+			}
+			packets := make([]gopacket.Packet, b.N)
+			for i := range packets {
+				packets[i] = mix[i%len(mix)]
+			}
+			dropped := GetStorageDropped()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			captureSynthetic(b, packets...)
+			b.StopTimer()
+			b.ReportMetric(float64(GetStorageDropped()-dropped)/float64(b.N), "dropped/op")
+		})
+	}
+}
+
+// TestGlobalStatsCountEveryPacket checks that packets processPacket stops
+// early on still count towards the global, protocol and interface totals
+func TestGlobalStatsCountEveryPacket(t *testing.T) {
+	useTestStore(t)
+	useProcesses(t, map[uint16]*process.ProcessInfo{50000: {ProcessID: 100, ProcessName: "client.exe", ExecutablePath: `C:\Apps\client.exe`}})
+	previousExternal := captureConfig.StoreExternal
+	captureConfig.StoreExternal = false
+	t.Cleanup(func() { captureConfig.StoreExternal = previousExternal })
+
+	now := time.Now()
+	tests := []struct {
+		name   string
+		packet gopacket.Packet
+	}{
+		{"attributed", testPacket(t, now, testLocalIP, "192.0.2.1", &layers.UDP{SrcPort: 50000, DstPort: 9999}, nil)},
+		{"process not found", testPacket(t, now, testLocalIP, "192.0.2.1", &layers.TCP{SrcPort: 50001, DstPort: 443, SYN: true}, nil)},
+		{"external, not stored", testPacket(t, now, "198.51.100.1", "198.51.100.2", &layers.TCP{SrcPort: 1000, DstPort: 80, SYN: true}, nil)},
+		{"ICMP", testPacket(t, now, "192.0.2.1", testLocalIP, &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0)}, nil)},
+	}
+
+	var bytes uint64
+	for i, tt := range tests {
+		processPacket(testDevice, tt.packet)
+		bytes += uint64(len(tt.packet.Data()))
+
+		var protocolPackets, protocolBytes uint64
+		for _, count := range GetProtocolCounts() {
+			protocolPackets += count.Packets
+			protocolBytes += count.Bytes
+		}
+		ifStats := getInterfaceStats(testDevice)
+		want := uint64(i + 1)
+		if stats.TotalPackets.Load() != want || protocolPackets != want || ifStats.TotalPackets.Load() != want {
+			t.Errorf("after %s: %d total, %d by protocol and %d on the interface, want %d packets", tt.name,
+				stats.TotalPackets.Load(), protocolPackets, ifStats.TotalPackets.Load(), want)
+		}
+		if stats.TotalBytes.Load() != bytes || protocolBytes != bytes || ifStats.TotalBytes.Load() != bytes {
+			t.Errorf("after %s: %d total, %d by protocol and %d on the interface, want %d bytes", tt.name,
+				stats.TotalBytes.Load(), protocolBytes, ifStats.TotalBytes.Load(), bytes)
+		}
+	}
+}
+
+// useCaptureConfig makes config the active capture configuration, storing
+// into db, until the test ends
+func useCaptureConfig(tb testing.TB, config CaptureConfig, db database.Store) {
+	tb.Helper()
+
+	previousConfig, previousStore := captureConfig, store
+	previousSave, previousThreshold := saveInterval, savePacketThreshold
+	previousDestinations, previousLoad := maxDestinations, loadAppLimit
+	config.DB = db
+	if err := applyConfig(config); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		captureConfig, store = previousConfig, previousStore
+		saveInterval, savePacketThreshold = previousSave, previousThreshold
+		maxDestinations, loadAppLimit = previousDestinations, previousLoad
+	})
+}
+
+// captureSynthetic runs the capture loop of testDevice on a synthetic source
+// delivering packets, and returns once all of them have been processed. It
+// fails if no packet is processed for 5 seconds, and reports whether the
+// source was opened in promiscuous mode.
+func captureSynthetic(tb testing.TB, packets ...gopacket.Packet) (promiscuous bool) {
+	tb.Helper()
+
+	previousOpen := openDeviceSource
+	opened := false
+	openDeviceSource = func(deviceName string) (PacketSource, error) {
+		if opened {
+			return nil, fmt.Errorf("synthetic source already used")
+		}
+		opened = true
+		promiscuous = captureConfig.Promiscuous
+		return NewSyntheticSource(layers.LinkTypeEthernet, packets...), nil
+	}
+	defer func() { openDeviceSource = previousOpen }()
+
+	before := stats.TotalPackets.Load()
+	go captureDevice(testDevice)
+	deadline := time.Now().Add(5 * time.Second)
+	for processed := uint64(0); processed < uint64(len(packets)); {
+		if now := stats.TotalPackets.Load() - before; now > processed {
+			processed, deadline = now, time.Now().Add(5*time.Second)
+		} else if time.Now().After(deadline) {
+			stopDeviceCaptures()
+			tb.Fatalf("processed %d of %d packets", processed, len(packets))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// The last packet is still being processed; stopping waits for it
+	stopDeviceCaptures()
+	return promiscuous
+}
+
+// TestStoreExternal captures a local and an external connection from a
+// synthetic source in and out of promiscuous mode, and checks that external
+// traffic between two other hosts is never looked up, and is only stored
+// when StoreExternal is set, while always counting in the direction
+// statistics
+func TestStoreExternal(t *testing.T) {
+	tests := []struct {
+		promiscuous   bool
+		storeExternal bool
+		wantStored    int
+	}{
+		{true, true, 2},
+		{true, false, 1},
+		{false, true, 2},
+		{false, false, 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("promiscuous=%v,StoreExternal=%v", tt.promiscuous, tt.storeExternal), func(t *testing.T) {
+			db := useTestStore(t)
+			config := DefaultCaptureConfig()
+			config.Promiscuous = tt.promiscuous
+			config.StoreExternal = tt.storeExternal
+			useCaptureConfig(t, config, db)
+
+			// A local process uses the external packet's port, so a lookup would credit it
+			client := &process.ProcessInfo{ProcessID: 100, ProcessName: "client.exe", ExecutablePath: `C:\Apps\client.exe`}
+			useProcesses(t, nil)
+			var mu sync.Mutex
+			var lookedUp []string
+			findProcess = func(protocol string, srcPort, dstPort uint16, direction string) (*process.ProcessInfo, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				lookedUp = append(lookedUp, direction)
+				return client, nil
+			}
+
+			now := time.Now()
+			promiscuous := captureSynthetic(t,
+				testPacket(t, now, testLocalIP, "192.0.2.1", &layers.TCP{SrcPort: 1000, DstPort: 443, SYN: true}, nil),
+				testPacket(t, now, "198.51.100.1", "198.51.100.2", &layers.TCP{SrcPort: 1000, DstPort: 443, SYN: true}, nil),
+			)
+			if promiscuous != tt.promiscuous {
+				t.Errorf("source opened with promiscuous %v", promiscuous)
+			}
+
+			mu.Lock()
+			if len(lookedUp) != 1 || lookedUp[0] != "outgoing" {
+				t.Errorf("looked up %v, want only the outgoing packet", lookedUp)
+			}
+			mu.Unlock()
+
+			if got := GetDirectionCounts()["external"].Packets; got != 1 {
+				t.Errorf("%d external packets counted, want 1", got)
+			}
+			var packets []database.PacketRecord
+			err := db.StreamPackets(database.PacketFilter{}, func(p database.PacketRecord) error {
+				packets = append(packets, p)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(packets) != tt.wantStored {
+				t.Errorf("stored %d packets, want %d", len(packets), tt.wantStored)
+			}
+			for _, packet := range packets {
+				if packet.Direction == "external" && packet.ProcessName != "" {
+					t.Errorf("external packet attributed to %s", packet.ProcessName)
+				}
+			}
+		})
+	}
+}
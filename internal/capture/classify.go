@@ -0,0 +1,199 @@
+package capture
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"grip/internal/database"
+	"grip/internal/process"
+)
+
+// errUnsupportedPacket is returned for packets without a network layer, or
+// without a transport layer unless they are ICMP
+var errUnsupportedPacket = errors.New("packet has no network or transport layer")
+
+// ProcessLookup finds the process owning a TCP or UDP connection from its
+// ports, as seen in a packet travelling in direction
+type ProcessLookup func(protocol string, srcPort, dstPort uint16, direction string) (*process.ProcessInfo, error)
+
+// classifyPacket turns a decoded packet into a record with its timestamp,
+// addresses, ports, protocol, wire length, direction and scope. It depends
+// only on its arguments, so it works without a database or Npcap; isLocal
+// reports whether an address belongs to this machine. The device, host name,
+// GeoIP and process fields are left to the caller.
+func classifyPacket(packet gopacket.Packet, isLocal func(ip string) bool) (database.PacketRecord, error) {
+	src, dst, srcPort, dstPort, protocol, length, valid := extractNetworkInfo(packet)
+	if !valid {
+		return database.PacketRecord{}, errUnsupportedPacket
+	}
+
+	// Prefer the capture timestamp so delayed or offline processing stays accurate
+	timestamp := packet.Metadata().Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	direction := determinePacketDirection(src, dst, isLocal)
+	srcMAC, dstMAC := packetMACs(packet)
+	return database.PacketRecord{
+		Timestamp: timestamp,
+		SrcIP:     src,
+		SrcPort:   srcPort,
+		DstIP:     dst,
+		DstPort:   dstPort,
+		SrcMAC:    srcMAC,
+		DstMAC:    dstMAC,
+		VLAN:      packetVLAN(packet),
+		Protocol:  protocol,
+		Length:    length,
+		Direction: direction,
+		Scope:     packetScope(src, dst, direction),
+	}, nil
+}
+
+// packetMACs returns the source and destination MAC addresses of a packet,
+// or empty strings when its link layer has none, as on loopback or VPN
+// adapters. Across a router the addresses are those of the last hop.
+func packetMACs(packet gopacket.Packet) (src, dst string) {
+	link := packet.LinkLayer()
+	if link == nil {
+		return "", ""
+	}
+	flow := link.LinkFlow()
+	if flow.EndpointType() != layers.EndpointMAC {
+		return "", ""
+	}
+	return flow.Src().String(), flow.Dst().String()
+}
+
+// packetVLAN returns the 802.1Q VLAN ID of a packet, or 0 when it is
+// untagged. Of stacked (Q-in-Q) tags the outer one is used. Most adapters
+// strip the tag before capture unless told to keep it.
+func packetVLAN(packet gopacket.Packet) uint16 {
+	if dot1q, ok := packet.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q); ok {
+		return dot1q.VLANIdentifier
+	}
+	return 0
+}
+
+// packetPorts returns the numeric ports of a record, 0 for ICMP
+func packetPorts(record database.PacketRecord) (srcPort, dstPort uint16) {
+	if port, err := strconv.ParseUint(record.SrcPort, 10, 16); err == nil {
+		srcPort = uint16(port)
+	}
+	if port, err := strconv.ParseUint(record.DstPort, 10, 16); err == nil {
+		dstPort = uint16(port)
+	}
+	return srcPort, dstPort
+}
+
+// attributeProcess finds the process owning the connection of a record with
+// lookup and copies it into the record. Only TCP and UDP sockets have an
+// owning process, so other protocols return nil without an error, as do
+// multicast and broadcast packets, which aren't tied to one connection, and
+// external packets, which belong to no socket on this machine.
+func attributeProcess(record *database.PacketRecord, lookup ProcessLookup) (*process.ProcessInfo, error) {
+	if record.Protocol != "TCP" && record.Protocol != "UDP" {
+		return nil, nil
+	}
+	switch record.Direction {
+	case "multicast", "broadcast", "external":
+		return nil, nil
+	}
+
+	srcPort, dstPort := packetPorts(*record)
+	info, err := lookup(record.Protocol, srcPort, dstPort, record.Direction)
+	if err != nil {
+		return nil, err
+	}
+
+	record.ProcessID = info.ProcessID
+	record.ProcessName = info.ProcessName
+	record.ProcessPath = info.ExecutablePath
+	record.ServiceName = info.ServiceName
+	record.ProcessOwner = info.Owner
+
+	// If process name is empty, use the last segment of the process path
+	if record.ProcessName == "" && record.ProcessPath != "" {
+		// Split by both forward and backward slashes for cross-platform compatibility
+		pathParts := strings.FieldsFunc(record.ProcessPath, func(c rune) bool {
+			return c == '/' || c == '\\'
+		})
+		if len(pathParts) > 0 {
+			record.ProcessName = pathParts[len(pathParts)-1]
+		}
+	}
+	return info, nil
+}
+
+// ipProtocolNames names the IP protocols without ports that are recorded by
+// protocol number. gopacket's own names, such as "IPSecESP", differ from the
+// usual ones.
+var ipProtocolNames = map[layers.IPProtocol]string{
+	layers.IPProtocolIGMP:     "IGMP",
+	layers.IPProtocolIPv4:     "IPIP",
+	layers.IPProtocolIPv6:     "IPv6-in-IP",
+	layers.IPProtocolGRE:      "GRE",
+	layers.IPProtocolESP:      "ESP",
+	layers.IPProtocolAH:       "AH",
+	layers.IPProtocolOSPF:     "OSPF",
+	layers.IPProtocolEtherIP:  "EtherIP",
+	103:                       "PIM",
+	layers.IPProtocolVRRP:     "VRRP",
+	115:                       "L2TP",
+	layers.IPProtocolMPLSInIP: "MPLS-in-IP",
+}
+
+// portedProtocols carry ports in their own header. When gopacket finds no
+// transport layer for them, the packet is a later fragment or truncated.
+var portedProtocols = map[layers.IPProtocol]bool{
+	layers.IPProtocolTCP:     true,
+	layers.IPProtocolUDP:     true,
+	layers.IPProtocolSCTP:    true,
+	layers.IPProtocolUDPLite: true,
+}
+
+// ipProtocolName returns the name of an IP protocol number, or "IP-<number>"
+// for protocols without a well-known name
+func ipProtocolName(number layers.IPProtocol) string {
+	if name, ok := ipProtocolNames[number]; ok {
+		return name
+	}
+	return fmt.Sprintf("IP-%d", number)
+}
+
+// ipProtocolNumber returns the protocol carried by the IP layer of a packet,
+// following IPv6 extension headers
+func ipProtocolNumber(packet gopacket.Packet) (layers.IPProtocol, bool) {
+	var number layers.IPProtocol
+	found := false
+	for _, layer := range packet.Layers() {
+		switch l := layer.(type) {
+		case *layers.IPv4:
+			if found {
+				return number, true // Tunnelled packets are named after the outer protocol
+			}
+			number, found = l.Protocol, true
+		case *layers.IPv6:
+			if found {
+				return number, true
+			}
+			number, found = l.NextHeader, true
+		case *layers.IPv6HopByHop:
+			number = l.NextHeader
+		case *layers.IPv6Routing:
+			number = l.NextHeader
+		case *layers.IPv6Fragment:
+			number = l.NextHeader
+		case *layers.IPv6Destination:
+			number = l.NextHeader
+		}
+	}
+	return number, found
+}
@@ -0,0 +1,168 @@
+package capture
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"grip/internal/database"
+	"grip/internal/process"
+)
+
+// ipPacket builds a raw packet from an IP header and the layers it carries,
+// leaving the protocol number as set so any protocol can be built
+func ipPacket(tb testing.TB, ip gopacket.SerializableLayer, carried ...gopacket.SerializableLayer) gopacket.Packet {
+	tb.Helper()
+
+	first := layers.LayerTypeIPv4
+	if _, ok := ip.(*layers.IPv6); ok {
+		first = layers.LayerTypeIPv6
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, append([]gopacket.SerializableLayer{ip}, carried...)...); err != nil {
+		tb.Fatalf("building packet: %v", err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), first, gopacket.Default)
+}
+
+func testIPv4(protocol layers.IPProtocol) *layers.IPv4 {
+	return &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: protocol,
+		SrcIP:    net.IPv4(192, 168, 1, 10).To4(),
+		DstIP:    net.IPv4(203, 0, 113, 1).To4(),
+	}
+}
+
+// TestExtractNetworkInfoPortless checks that protocols without ports are
+// named after their IP protocol number, and that fragments, which have no
+// ports either, are still skipped
+func TestExtractNetworkInfoPortless(t *testing.T) {
+	tcp := func() *layers.TCP {
+		return &layers.TCP{SrcPort: 50000, DstPort: 443, SYN: true, Window: 64240}
+	}
+	innerTCP := tcp()
+	inner := testIPv4(layers.IPProtocolTCP)
+	innerTCP.SetNetworkLayerForChecksum(inner)
+	plainTCP := tcp()
+	plain := testIPv4(layers.IPProtocolTCP)
+	plainTCP.SetNetworkLayerForChecksum(plain)
+
+	fragment := testIPv4(layers.IPProtocolTCP)
+	fragment.FragOffset = 185
+
+	payload := gopacket.Payload([]byte{0, 0, 0, 1, 0, 0, 0, 1, 0xde, 0xad, 0xbe, 0xef})
+	tests := []struct {
+		name         string
+		packet       gopacket.Packet
+		wantProtocol string
+		wantPorts    bool
+		wantValid    bool
+	}{
+		{"TCP", ipPacket(t, plain, plainTCP), "TCP", true, true},
+		{"GRE tunnel", ipPacket(t, testIPv4(layers.IPProtocolGRE), &layers.GRE{Protocol: layers.EthernetTypeIPv4}, inner, innerTCP), "GRE", false, true},
+		{"ESP", ipPacket(t, testIPv4(layers.IPProtocolESP), payload), "ESP", false, true},
+		{"IGMP", ipPacket(t, testIPv4(layers.IPProtocolIGMP), gopacket.Payload([]byte{0x16, 0, 0xfa, 0x04, 239, 1, 2, 3})), "IGMP", false, true},
+		{"unnamed protocol", ipPacket(t, testIPv4(253), payload), "IP-253", false, true},
+		{"IPv6 ESP", ipPacket(t, &layers.IPv6{
+			Version:    6,
+			NextHeader: layers.IPProtocolESP,
+			HopLimit:   64,
+			SrcIP:      net.ParseIP("2001:db8::10"),
+			DstIP:      net.ParseIP("2001:db8::1"),
+		}, payload), "ESP", false, true},
+		{"TCP fragment", ipPacket(t, fragment, payload), "", false, false},
+	}
+	for _, tt := range tests {
+		src, dst, srcPort, dstPort, protocol, _, valid := extractNetworkInfo(tt.packet)
+		if valid != tt.wantValid || protocol != tt.wantProtocol {
+			t.Errorf("%s: protocol %q, valid %v, want %q, %v", tt.name, protocol, valid, tt.wantProtocol, tt.wantValid)
+			continue
+		}
+		if !valid {
+			continue
+		}
+		// Tunnels are reported with their outer addresses
+		if src != "192.168.1.10" && src != "2001:db8::10" {
+			t.Errorf("%s: source %s, want the outer source address", tt.name, src)
+		}
+		if dst != "203.0.113.1" && dst != "2001:db8::1" {
+			t.Errorf("%s: destination %s, want the outer destination address", tt.name, dst)
+		}
+		if hasPorts := srcPort != "" || dstPort != ""; hasPorts != tt.wantPorts {
+			t.Errorf("%s: ports %q -> %q, want ports %v", tt.name, srcPort, dstPort, tt.wantPorts)
+		}
+	}
+}
+
+func TestIPProtocolName(t *testing.T) {
+	tests := []struct {
+		number layers.IPProtocol
+		want   string
+	}{
+		{layers.IPProtocolGRE, "GRE"},
+		{layers.IPProtocolESP, "ESP"},
+		{layers.IPProtocolIGMP, "IGMP"},
+		{103, "PIM"},
+		{115, "L2TP"},
+		{253, "IP-253"},
+	}
+	for _, tt := range tests {
+		if got := ipProtocolName(tt.number); got != tt.want {
+			t.Errorf("ipProtocolName(%d) = %q, want %q", tt.number, got, tt.want)
+		}
+	}
+}
+
+// TestAttributeProcess checks which packets are looked up: only TCP and UDP
+// packets to or from this machine belong to one of its sockets
+func TestAttributeProcess(t *testing.T) {
+	client := &process.ProcessInfo{ProcessID: 100, ExecutablePath: `C:\Apps\client.exe`}
+	notFound := errors.New("no such connection")
+
+	tests := []struct {
+		protocol, direction string
+		found               bool // Whether the lookup finds the connection
+		wantLookup          bool
+		wantName            string
+		wantErr             bool
+	}{
+		{"TCP", "outgoing", true, true, "client.exe", false},
+		{"UDP", "incoming", true, true, "client.exe", false},
+		{"TCP", "internal", true, true, "client.exe", false},
+		{"TCP", "outgoing", false, true, "", true},
+		{"TCP", "external", true, false, "", false},
+		{"UDP", "multicast", true, false, "", false},
+		{"UDP", "broadcast", true, false, "", false},
+		{"ICMP", "outgoing", true, false, "", false},
+		{"GRE", "incoming", true, false, "", false},
+	}
+	for _, tt := range tests {
+		looked := false
+		lookup := func(protocol string, srcPort, dstPort uint16, direction string) (*process.ProcessInfo, error) {
+			looked = true
+			if srcPort != 50000 || dstPort != 443 || protocol != tt.protocol || direction != tt.direction {
+				t.Errorf("%s %s: looked up %s %d -> %d %s", tt.protocol, tt.direction, protocol, srcPort, dstPort, direction)
+			}
+			if !tt.found {
+				return nil, notFound
+			}
+			return client, nil
+		}
+
+		record := database.PacketRecord{Protocol: tt.protocol, Direction: tt.direction, SrcPort: "50000", DstPort: "443"}
+		info, err := attributeProcess(&record, lookup)
+		if looked != tt.wantLookup || (err != nil) != tt.wantErr {
+			t.Errorf("%s %s: looked up %v with error %v, want lookup %v, error %v",
+				tt.protocol, tt.direction, looked, err, tt.wantLookup, tt.wantErr)
+		}
+		if (info != nil) != (tt.wantName != "") || record.ProcessName != tt.wantName {
+			t.Errorf("%s %s: attributed to %+v named %q, want %q", tt.protocol, tt.direction, info, record.ProcessName, tt.wantName)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package capture
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+
+	"grip/internal/database"
+)
+
+var (
+	// Background rescan state, set by startDeviceRescan
+	rescanDone    chan struct{}
+	rescanStopped chan struct{}
+)
+
+// findDevices lists the capture devices selected by the configuration
+func findDevices() ([]pcap.Interface, error) {
+	devices, err := pcap.FindAllDevs()
+	if err != nil {
+		return nil, fmt.Errorf("error finding network devices (make sure you're running as Administrator): %v", err)
+	}
+
+	if len(captureConfig.Interfaces) > 0 {
+		devices = selectDevices(devices, captureConfig.Interfaces)
+	}
+	return devices, nil
+}
+
+// registerDevice stores a device in the database and records its ID and
+// description for the capture path
+func registerDevice(device pcap.Interface) {
+	iface := database.NetworkInterface{
+		Name:        device.Name,
+		Description: device.Description,
+		CreatedAt:   time.Now(),
+	}
+	deviceID, err := store.StoreInterface(iface)
+	if err != nil {
+		LogDebug("Error storing interface %s: %v", device.Name, err)
+	} else {
+		// Store device ID in map
+		deviceMapMutex.Lock()
+		deviceIDMap[device.Name] = deviceID
+		deviceMapMutex.Unlock()
+	}
+	adaptersMutex.Lock()
+	deviceDescriptions[device.Name] = device.Description
+	adaptersMutex.Unlock()
+}
+
+// startDeviceRescan periodically compares the device list against the devices
+// capture started on, so docking stations, VPNs and USB adapters are picked up
+// without a restart
+func startDeviceRescan(devices []pcap.Interface) {
+	if rescanDone != nil || captureConfig.RescanInterval <= 0 {
+		return
+	}
+
+	known := make(map[string]pcap.Interface, len(devices))
+	for _, device := range devices {
+		known[device.Name] = device
+	}
+
+	rescanDone = make(chan struct{})
+	rescanStopped = make(chan struct{})
+	go rescanDevices(known, captureConfig.RescanInterval, rescanDone, rescanStopped)
+}
+
+// stopDeviceRescan stops the rescan; it must stop before the captures so no
+// new ones are started during shutdown
+func stopDeviceRescan() {
+	if rescanDone != nil {
+		close(rescanDone)
+		<-rescanStopped
+		rescanDone = nil
+	}
+}
+
+func rescanDevices(known map[string]pcap.Interface, interval time.Duration, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := rescanOnce(known); err != nil {
+				errorLimiter.log(LogWarning, "rescan", "Failed to rescan network interfaces: %v", err)
+			}
+		}
+	}
+}
+
+// rescanOnce starts capturing on devices that appeared since the last scan and
+// stops capturing on devices that disappeared, updating known to match
+func rescanOnce(known map[string]pcap.Interface) error {
+	devices, err := findDevices()
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]pcap.Interface, len(devices))
+	var added []pcap.Interface
+	for _, device := range devices {
+		current[device.Name] = device
+		if _, ok := known[device.Name]; !ok {
+			added = append(added, device)
+		}
+	}
+
+	removed := false
+	for name := range known {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		delete(known, name)
+		removed = true
+
+		LogInfo("Interface %s removed, stopping capture", InterfaceDisplayName(name))
+		capturesMutex.Lock()
+		c := activeCaptures[name]
+		capturesMutex.Unlock()
+		if c != nil {
+			c.shutdown()
+			<-c.finished
+		}
+	}
+
+	if len(added) == 0 {
+		if removed {
+			refreshBroadcastAddrs()
+		}
+		return nil
+	}
+
+	for _, device := range added {
+		registerDevice(device)
+	}
+	// Read the new adapters' friendly names before they are logged
+	if err := refreshAdapters(); err != nil {
+		LogDebug("Cannot read adapter names: %v", err)
+	}
+	for _, device := range added {
+		known[device.Name] = device
+		LogInfo("Interface %s (%s) added, starting capture", InterfaceDisplayName(device.Name), device.Name)
+		go captureDevice(device.Name)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package capture
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"grip/internal/database"
+)
+
+// minHostTTL keeps very short DNS TTLs from expiring names while the connection is still active
+const minHostTTL = time.Minute
+
+// hostCachePurgeInterval is how often expired mappings are dropped from the
+// cache and the database. Otherwise an entry is only removed when its IP is
+// looked up again, so answers for addresses never seen again would pile up.
+const hostCachePurgeInterval = 10 * time.Minute
+
+// hostEntry is a cached IP to hostname mapping
+type hostEntry struct {
+	host    string
+	expires time.Time
+}
+
+var (
+	hostCache      = make(map[string]hostEntry)
+	hostCacheMutex sync.RWMutex
+
+	// When expired entries were last purged; guarded by hostCacheMutex
+	hostCachePurged time.Time
+)
+
+// loadHostCache restores unexpired hostname mappings from the database
+func loadHostCache() {
+	entries, err := store.GetDNSEntries()
+	if err != nil {
+		LogError("Failed to load DNS cache: %v", err)
+		return
+	}
+
+	hostCacheMutex.Lock()
+	for _, entry := range entries {
+		hostCache[entry.IP] = hostEntry{host: entry.Hostname, expires: entry.ExpiresAt}
+	}
+	hostCacheMutex.Unlock()
+
+	LogDebug("Loaded %d DNS cache entries from database", len(entries))
+}
+
+// lookupHost returns the hostname an IP was last resolved from, or "" if unknown or expired
+func lookupHost(ip string) string {
+	hostCacheMutex.RLock()
+	entry, ok := hostCache[ip]
+	hostCacheMutex.RUnlock()
+
+	if !ok {
+		return ""
+	}
+
+	if time.Now().After(entry.expires) {
+		hostCacheMutex.Lock()
+		delete(hostCache, ip)
+		hostCacheMutex.Unlock()
+		return ""
+	}
+
+	return entry.host
+}
+
+// rememberHost caches and persists an IP to hostname mapping
+func rememberHost(ip, host string, ttl time.Duration) {
+	if ttl < minHostTTL {
+		ttl = minHostTTL
+	}
+	expires := time.Now().Add(ttl)
+
+	hostCacheMutex.Lock()
+	previous, existed := hostCache[ip]
+	hostCache[ip] = hostEntry{host: host, expires: expires}
+	hostCacheMutex.Unlock()
+
+	// Avoid rewriting the row when a repeated answer only nudges the expiry
+	if existed && previous.host == host && expires.Sub(previous.expires) < minHostTTL {
+		return
+	}
+
+	if err := store.StoreDNSEntry(database.DNSEntry{IP: ip, Hostname: host, ExpiresAt: expires}); err != nil {
+		LogDebug("Error storing DNS entry for %s: %v", ip, err)
+	}
+}
+
+// purgeHostCache forgets hostname mappings that expired before now, in memory
+// and in the database, at most once every hostCachePurgeInterval
+func purgeHostCache(now time.Time) {
+	hostCacheMutex.Lock()
+	if now.Sub(hostCachePurged) < hostCachePurgeInterval {
+		hostCacheMutex.Unlock()
+		return
+	}
+	hostCachePurged = now
+
+	purged := 0
+	for ip, entry := range hostCache {
+		if now.After(entry.expires) {
+			delete(hostCache, ip)
+			purged++
+		}
+	}
+	remaining := len(hostCache)
+	hostCacheMutex.Unlock()
+
+	if _, err := store.PurgeDNSEntries(now); err != nil {
+		LogDebug("Error purging expired DNS entries: %v", err)
+	}
+	if purged > 0 {
+		LogDebug("Purged %d expired DNS cache entries, %d remain", purged, remaining)
+	}
+}
+
+// observeDNS learns hostnames from DNS responses carried over UDP or TCP port 53
+func observeDNS(packet gopacket.Packet) {
+	var dns *layers.DNS
+
+	if layer := packet.Layer(layers.LayerTypeDNS); layer != nil {
+		dns = layer.(*layers.DNS)
+	} else if tcp, ok := packet.TransportLayer().(*layers.TCP); ok && (tcp.SrcPort == 53 || tcp.DstPort == 53) {
+		// DNS over TCP is prefixed with a two-byte length and isn't decoded automatically
+		payload := tcp.Payload
+		if len(payload) < 2 {
+			return
+		}
+		msgLen := int(payload[0])<<8 | int(payload[1])
+		if len(payload) < 2+msgLen {
+			return // Message spans multiple segments
+		}
+		dns = &layers.DNS{}
+		if err := dns.DecodeFromBytes(payload[2:2+msgLen], gopacket.NilDecodeFeedback); err != nil {
+			return
+		}
+	}
+
+	if dns == nil || !dns.QR || dns.ResponseCode != layers.DNSResponseCodeNoErr || len(dns.Answers) == 0 {
+		return
+	}
+
+	// Follow CNAME chains so addresses map back to the name that was asked for
+	aliases := make(map[string]string)
+	for _, answer := range dns.Answers {
+		if answer.Type == layers.DNSTypeCNAME {
+			aliases[normalizeDNSName(answer.Name)] = normalizeDNSName(answer.CNAME)
+		}
+	}
+
+	queried := make(map[string]string)
+	for _, question := range dns.Questions {
+		name := normalizeDNSName(question.Name)
+		queried[name] = name
+		for hops := 0; hops < 16; hops++ {
+			target, ok := aliases[name]
+			if !ok {
+				break
+			}
+			queried[target] = normalizeDNSName(question.Name)
+			name = target
+		}
+	}
+
+	for _, answer := range dns.Answers {
+		if answer.Type != layers.DNSTypeA && answer.Type != layers.DNSTypeAAAA {
+			continue
+		}
+		if answer.IP == nil {
+			continue
+		}
+
+		name := normalizeDNSName(answer.Name)
+		if original, ok := queried[name]; ok {
+			name = original
+		}
+		if name == "" {
+			continue
+		}
+
+		rememberHost(answer.IP.String(), name, time.Duration(answer.TTL)*time.Second)
+	}
+}
+
+func normalizeDNSName(name []byte) string {
+	return strings.ToLower(strings.TrimSuffix(string(name), "."))
+}
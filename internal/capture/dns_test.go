@@ -0,0 +1,61 @@
+package capture
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPurgeHostCache checks that expired hostname mappings are dropped from
+// memory and the database without being looked up again, at most once per
+// purge interval
+func TestPurgeHostCache(t *testing.T) {
+	db := useTestStore(t)
+	hostCacheMutex.Lock()
+	previousCache, previousPurged := hostCache, hostCachePurged
+	hostCache, hostCachePurged = make(map[string]hostEntry), time.Time{}
+	hostCacheMutex.Unlock()
+	t.Cleanup(func() {
+		hostCacheMutex.Lock()
+		hostCache, hostCachePurged = previousCache, previousPurged
+		hostCacheMutex.Unlock()
+	})
+
+	now := time.Now()
+	rememberHost("192.0.2.1", "short.example", time.Second)
+	rememberHost("192.0.2.2", "long.example", 3*time.Hour)
+
+	tests := []struct {
+		name      string
+		at        time.Time
+		wantCache []string // IPs still cached
+		wantRows  int
+	}{
+		{"nothing expired", now, []string{"192.0.2.1", "192.0.2.2"}, 2},
+		{"expired within the interval", now.Add(2 * time.Minute), []string{"192.0.2.1", "192.0.2.2"}, 2},
+		{"expired after the interval", now.Add(hostCachePurgeInterval + time.Minute), []string{"192.0.2.2"}, 1},
+		{"everything expired", now.Add(4 * time.Hour), nil, 0},
+	}
+	for _, tt := range tests {
+		purgeHostCache(tt.at)
+
+		hostCacheMutex.RLock()
+		cached := len(hostCache)
+		for _, ip := range tt.wantCache {
+			if _, ok := hostCache[ip]; !ok {
+				t.Errorf("%s: %s not cached", tt.name, ip)
+			}
+		}
+		hostCacheMutex.RUnlock()
+		if cached != len(tt.wantCache) {
+			t.Errorf("%s: %d entries cached, want %d", tt.name, cached, len(tt.wantCache))
+		}
+
+		var rows int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM dns_cache`).Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+		if rows != tt.wantRows {
+			t.Errorf("%s: %d rows stored, want %d", tt.name, rows, tt.wantRows)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package capture
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// DumpConfig controls mirroring of raw packets into rotating pcap files
+type DumpConfig struct {
+	Dir      string        // Directory for pcap files; empty disables dumping
+	MaxSize  int64         // Rotate once a file exceeds this many bytes (0 for no limit)
+	MaxAge   time.Duration // Rotate once a file has been open this long (0 for no limit)
+	MaxFiles int           // Files retained per interface, including the active one (0 for no limit)
+	Filter   string        // Optional BPF expression selecting which packets are written
+}
+
+// dumpPacket is a copy of a captured packet queued for the dump writer
+type dumpPacket struct {
+	device   string
+	linkType layers.LinkType
+	snaplen  int
+	ci       gopacket.CaptureInfo
+	data     []byte
+}
+
+// dumpFile is the active pcap file for a single interface
+type dumpFile struct {
+	file    *os.File
+	buf     *bufio.Writer
+	writer  *pcapgo.Writer
+	size    int64
+	opened  time.Time
+	filter  *pcap.BPF
+	skipAll bool // filter failed to compile for this link type
+}
+
+const dumpQueueSize = 4096
+
+var (
+	dumpConfig  DumpConfig
+	dumpQueue   chan dumpPacket
+	dumpDone    chan struct{}
+	dumpMutex   sync.RWMutex
+	dumpDropped atomic.Uint64
+)
+
+// EnablePacketDump validates the configuration and starts the background writer.
+// It must be called before StartCapture for packets to be mirrored.
+func EnablePacketDump(config DumpConfig) error {
+	if config.Dir == "" {
+		return nil
+	}
+	if config.MaxSize < 0 || config.MaxAge < 0 || config.MaxFiles < 0 {
+		return fmt.Errorf("packet dump limits must not be negative")
+	}
+	if err := os.MkdirAll(config.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create packet dump directory: %v", err)
+	}
+
+	// Validate the filter up front so a typo fails at startup rather than per interface
+	if config.Filter != "" {
+		if _, err := pcap.NewBPF(layers.LinkTypeEthernet, 65535, config.Filter); err != nil {
+			return fmt.Errorf("invalid packet dump filter %q: %v", config.Filter, err)
+		}
+	}
+
+	dumpMutex.Lock()
+	defer dumpMutex.Unlock()
+
+	dumpConfig = config
+	dumpQueue = make(chan dumpPacket, dumpQueueSize)
+	dumpDone = make(chan struct{})
+	go runDumpWriter(dumpQueue, dumpDone)
+
+	LogInfo("Mirroring raw packets to %s", config.Dir)
+	return nil
+}
+
+// GetDumpDropped returns how many packets were not written because the dump queue was full
+func GetDumpDropped() uint64 {
+	return dumpDropped.Load()
+}
+
+// queueDumpPacket hands a packet to the dump writer without blocking the capture loop
+func queueDumpPacket(device string, linkType layers.LinkType, snaplen int, packet gopacket.Packet) {
+	dumpMutex.RLock()
+	defer dumpMutex.RUnlock()
+
+	if dumpQueue == nil {
+		return
+	}
+
+	// The packet source may reuse its buffer, so copy the bytes before queueing
+	data := make([]byte, len(packet.Data()))
+	copy(data, packet.Data())
+
+	select {
+	case dumpQueue <- dumpPacket{
+		device:   device,
+		linkType: linkType,
+		snaplen:  snaplen,
+		ci:       packet.Metadata().CaptureInfo,
+		data:     data,
+	}:
+	default:
+		dumpDropped.Add(1)
+	}
+}
+
+// closePacketDump stops accepting packets, drains the queue and closes all files
+func closePacketDump() {
+	dumpMutex.Lock()
+	queue, done := dumpQueue, dumpDone
+	dumpQueue = nil
+	dumpMutex.Unlock()
+
+	if queue == nil {
+		return
+	}
+
+	close(queue)
+	<-done
+}
+
+// runDumpWriter writes queued packets until the queue is closed
+func runDumpWriter(queue <-chan dumpPacket, done chan<- struct{}) {
+	defer close(done)
+
+	files := make(map[string]*dumpFile)
+	defer func() {
+		for device, f := range files {
+			if err := f.close(); err != nil {
+				LogError("Error closing packet dump for %s: %v", device, err)
+			}
+		}
+	}()
+
+	for p := range queue {
+		f := files[p.device]
+
+		// Rotate when the active file is too large or too old
+		if f != nil && ((dumpConfig.MaxSize > 0 && f.size >= dumpConfig.MaxSize) ||
+			(dumpConfig.MaxAge > 0 && time.Since(f.opened) >= dumpConfig.MaxAge)) {
+			if err := f.close(); err != nil {
+				LogError("Error closing packet dump for %s: %v", p.device, err)
+			}
+			delete(files, p.device)
+			f = nil
+		}
+
+		if f == nil {
+			var err error
+			f, err = openDumpFile(p.device, p.linkType, p.snaplen)
+			if err != nil {
+				LogError("Error opening packet dump for %s: %v", p.device, err)
+				continue
+			}
+			files[p.device] = f
+		}
+
+		if f.skipAll || (f.filter != nil && !f.filter.Matches(p.ci, p.data)) {
+			continue
+		}
+
+		if err := f.writer.WritePacket(p.ci, p.data); err != nil {
+			LogError("Error writing packet dump for %s: %v", p.device, err)
+			continue
+		}
+		// 16-byte record header plus the captured bytes
+		f.size += int64(16 + len(p.data))
+	}
+}
+
+// openDumpFile creates a new pcap file for a device and prunes old ones
+func openDumpFile(device string, linkType layers.LinkType, snaplen int) (*dumpFile, error) {
+	prefix := dumpFilePrefix(device)
+	path := filepath.Join(dumpConfig.Dir, prefix+time.Now().Format("20060102-150405.000")+".pcap")
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewWriter(file)
+	writer := pcapgo.NewWriter(buf)
+	if err := writer.WriteFileHeader(uint32(snaplen), linkType); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &dumpFile{
+		file:   file,
+		buf:    buf,
+		writer: writer,
+		size:   24, // pcap global header
+		opened: time.Now(),
+	}
+
+	if dumpConfig.Filter != "" {
+		f.filter, err = pcap.NewBPF(linkType, snaplen, dumpConfig.Filter)
+		if err != nil {
+			LogWarning("Packet dump filter does not compile for %s (%s), skipping: %v", device, linkType, err)
+			f.skipAll = true
+		}
+	}
+
+	pruneDumpFiles(prefix)
+	LogDebug("Opened packet dump file %s", path)
+	return f, nil
+}
+
+// close flushes buffered packets and closes the file
+func (f *dumpFile) close() error {
+	if err := f.buf.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// pruneDumpFiles deletes the oldest files for a device beyond MaxFiles
+func pruneDumpFiles(prefix string) {
+	if dumpConfig.MaxFiles <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dumpConfig.Dir, prefix+"*.pcap"))
+	if err != nil || len(matches) <= dumpConfig.MaxFiles {
+		return
+	}
+
+	// File names embed a sortable timestamp, so lexical order is chronological
+	sort.Strings(matches)
+	for _, old := range matches[:len(matches)-dumpConfig.MaxFiles] {
+		if err := os.Remove(old); err != nil {
+			LogWarning("Error removing old packet dump %s: %v", old, err)
+		}
+	}
+}
+
+// dumpFilePrefix turns a device name like \Device\NPF_{GUID} into a safe file name prefix
+func dumpFilePrefix(device string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.TrimPrefix(device, `\Device\`))
+	return name + "_"
+}
@@ -0,0 +1,200 @@
+package capture
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"grip/internal/database"
+)
+
+// PacketFilter selects the packets a subscriber receives. Empty lists match
+// everything; names are compared case-insensitively.
+type PacketFilter struct {
+	ProcessNames []string
+	Protocols    []string
+	Directions   []string
+	Destinations []netip.Prefix // Ranges the destination IP must fall in
+}
+
+// matches reports whether a packet passes the filter
+func (f PacketFilter) matches(packet *PacketLog) bool {
+	return matchesAny(f.ProcessNames, packet.ProcessName) && matchesAny(f.Protocols, packet.Protocol) &&
+		matchesAny(f.Directions, packet.Direction) && matchesPrefix(f.Destinations, packet.DstIP)
+}
+
+func matchesPrefix(prefixes []netip.Prefix, ip string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// SubscriberBuffer is how many packets are held for a streaming client that
+// is not keeping up; newer packets are dropped once it is full
+const SubscriberBuffer = 1024
+
+// Subscription receives captured packets until it is closed. Packets are
+// dropped, not queued, once its buffer is full, so a slow reader never
+// holds up capture.
+type Subscription struct {
+	filter  PacketFilter
+	packets chan PacketLog
+	dropped atomic.Uint64 // Packets lost to a full buffer since the last TakeDropped
+	once    sync.Once
+}
+
+var (
+	subscribers     = make(map[*Subscription]struct{})
+	subscribersMu   sync.RWMutex
+	subscriberCount atomic.Int32 // Lets the packet path skip publishing when nobody listens
+)
+
+// Subscribe starts delivering packets matching filter to a channel holding up
+// to buffer packets. Call Close when done.
+func Subscribe(filter PacketFilter, buffer int) *Subscription {
+	sub := &Subscription{
+		filter:  filter,
+		packets: make(chan PacketLog, max(buffer, 1)),
+	}
+
+	subscribersMu.Lock()
+	subscribers[sub] = struct{}{}
+	subscriberCount.Store(int32(len(subscribers)))
+	subscribersMu.Unlock()
+	return sub
+}
+
+// Packets returns the channel packets are delivered on. It is closed by Close.
+func (s *Subscription) Packets() <-chan PacketLog {
+	return s.packets
+}
+
+// TakeDropped returns how many packets were dropped since the last call
+func (s *Subscription) TakeDropped() uint64 {
+	return s.dropped.Swap(0)
+}
+
+// Close stops delivery and closes the packet channel
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		subscribersMu.Lock()
+		delete(subscribers, s)
+		subscriberCount.Store(int32(len(subscribers)))
+		subscribersMu.Unlock()
+
+		// Publishers send under the read lock, so nothing sends after this
+		close(s.packets)
+	})
+}
+
+// StreamPackets subscribes to the packets matching filter and passes each one
+// to send, with the number dropped since the one before, until ctx is done,
+// capture stops or send fails. It returns send's error and the number of
+// packets dropped in total.
+func StreamPackets(ctx context.Context, filter PacketFilter, send func(packet PacketLog, dropped uint64) error) (uint64, error) {
+	sub := Subscribe(filter, SubscriberBuffer)
+	defer sub.Close()
+
+	var dropped uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return dropped + sub.TakeDropped(), nil
+		case packet, ok := <-sub.Packets():
+			if !ok {
+				return dropped + sub.TakeDropped(), nil
+			}
+			n := sub.TakeDropped()
+			dropped += n
+			if err := send(packet, n); err != nil {
+				return dropped, err
+			}
+		}
+	}
+}
+
+// closeSubscriptions ends every subscription, closing their channels
+func closeSubscriptions() {
+	subscribersMu.RLock()
+	subs := make([]*Subscription, 0, len(subscribers))
+	for sub := range subscribers {
+		subs = append(subs, sub)
+	}
+	subscribersMu.RUnlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// publishPacket hands a processed packet to every matching subscriber without blocking
+func publishPacket(deviceName string, record database.PacketRecord) {
+	if subscriberCount.Load() == 0 {
+		return
+	}
+
+	packet := PacketLog{
+		Timestamp:    record.Timestamp,
+		Device:       deviceName,
+		Interface:    InterfaceDisplayName(deviceName),
+		SrcIP:        record.SrcIP,
+		SrcPort:      record.SrcPort,
+		DstIP:        record.DstIP,
+		DstPort:      record.DstPort,
+		SrcMAC:       record.SrcMAC,
+		DstMAC:       record.DstMAC,
+		VLAN:         record.VLAN,
+		DstHost:      record.DstHost,
+		ReverseHost:  ReverseName(remotePeer(record.SrcIP, record.DstIP, record.Direction)),
+		GeoIP:        record.GeoIP,
+		Protocol:     record.Protocol,
+		Length:       record.Length,
+		Direction:    record.Direction,
+		Scope:        record.Scope,
+		ProcessID:    record.ProcessID,
+		ProcessName:  record.ProcessName,
+		ProcessPath:  record.ProcessPath,
+		ServiceName:  record.ServiceName,
+		ProcessOwner: record.ProcessOwner,
+		Payload:      record.Payload,
+		Flagged:      record.Flagged,
+	}
+
+	subscribersMu.RLock()
+	defer subscribersMu.RUnlock()
+	for sub := range subscribers {
+		if !sub.filter.matches(&packet) {
+			continue
+		}
+		select {
+		case sub.packets <- packet:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
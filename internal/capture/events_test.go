@@ -0,0 +1,157 @@
+package capture
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"grip/internal/database"
+)
+
+// TestSubscribe publishes packets to subscribers with one-packet buffers and
+// checks what each receives and drops, and that closing every subscription
+// closes their channels
+func TestSubscribe(t *testing.T) {
+	t.Cleanup(closeSubscriptions)
+	all := Subscribe(PacketFilter{}, 1)
+	browser := Subscribe(PacketFilter{ProcessNames: []string{"BROWSER.EXE"}, Protocols: []string{"tcp"}}, 1)
+	nobody := Subscribe(PacketFilter{ProcessNames: []string{"other.exe"}}, 1)
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []database.PacketRecord{
+		{SrcIP: testLocalIP, DstIP: "192.0.2.1", Protocol: "UDP", Direction: "outgoing", ProcessName: "browser.exe"},
+		{SrcIP: testLocalIP, DstIP: "192.0.2.2", Protocol: "TCP", Direction: "outgoing", ProcessName: "browser.exe"},
+		{SrcIP: testLocalIP, DstIP: "192.0.2.3", Protocol: "TCP", Direction: "outgoing", ProcessName: "agent.exe"},
+		{SrcIP: testLocalIP, DstIP: "192.0.2.4", Protocol: "TCP", Direction: "outgoing", ProcessName: "browser.exe"},
+	}
+	for i, record := range records {
+		record.Timestamp = start.Add(time.Duration(i) * time.Millisecond)
+		publishPacket(testDevice, record)
+	}
+
+	tests := []struct {
+		name        string
+		sub         *Subscription
+		wantDstIPs  []string // Delivered packets, in order
+		wantDropped uint64
+	}{
+		{"unfiltered", all, []string{"192.0.2.1"}, 3},
+		{"process and protocol", browser, []string{"192.0.2.2"}, 1},
+		{"no match", nobody, nil, 0},
+	}
+	for _, tt := range tests {
+		var got []string
+		for len(tt.sub.Packets()) > 0 {
+			packet := <-tt.sub.Packets()
+			if packet.Device != testDevice {
+				t.Errorf("%s: packet from device %q, want %q", tt.name, packet.Device, testDevice)
+			}
+			got = append(got, packet.DstIP)
+		}
+		if !reflect.DeepEqual(got, tt.wantDstIPs) {
+			t.Errorf("%s: received %v, want %v", tt.name, got, tt.wantDstIPs)
+		}
+		if dropped := tt.sub.TakeDropped(); dropped != tt.wantDropped {
+			t.Errorf("%s: dropped %d, want %d", tt.name, dropped, tt.wantDropped)
+		}
+		if dropped := tt.sub.TakeDropped(); dropped != 0 {
+			t.Errorf("%s: dropped %d after taking the count, want 0", tt.name, dropped)
+		}
+	}
+
+	// Shutdown closes every channel; publishing afterwards reaches nobody
+	closeSubscriptions()
+	publishPacket(testDevice, records[0])
+	for _, tt := range tests {
+		if _, ok := <-tt.sub.Packets(); ok {
+			t.Errorf("%s: channel still open after shutdown", tt.name)
+		}
+	}
+	if n := subscriberCount.Load(); n != 0 {
+		t.Errorf("%d subscribers after shutdown, want 0", n)
+	}
+	all.Close() // Closing again is harmless
+}
+
+// TestStreamPackets streams packets until the send function fails, and checks
+// that a cancelled context or closed subscriptions end the stream too
+func TestStreamPackets(t *testing.T) {
+	t.Cleanup(closeSubscriptions)
+
+	// stream runs StreamPackets in the background once its subscription is
+	// in place
+	type result struct {
+		dropped uint64
+		err     error
+	}
+	stream := func(ctx context.Context, send func(PacketLog, uint64) error) <-chan result {
+		before := subscriberCount.Load()
+		done := make(chan result, 1)
+		go func() {
+			dropped, err := StreamPackets(ctx, PacketFilter{Protocols: []string{"TCP"}}, send)
+			done <- result{dropped, err}
+		}()
+		for subscriberCount.Load() == before {
+			time.Sleep(time.Millisecond)
+		}
+		return done
+	}
+	wait := func(name string, done <-chan result) result {
+		t.Helper()
+		select {
+		case r := <-done:
+			return r
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: stream did not end", name)
+			return result{}
+		}
+	}
+
+	errGone := errors.New("client gone")
+	var sent []string
+	done := stream(context.Background(), func(packet PacketLog, dropped uint64) error {
+		if dropped != 0 {
+			t.Errorf("%d packets dropped before %s", dropped, packet.DstIP)
+		}
+		sent = append(sent, packet.DstIP)
+		if len(sent) == 2 {
+			return errGone
+		}
+		return nil
+	})
+	for _, record := range []database.PacketRecord{
+		{DstIP: "192.0.2.1", Protocol: "UDP"},
+		{DstIP: "192.0.2.2", Protocol: "TCP"},
+		{DstIP: "192.0.2.3", Protocol: "TCP"},
+	} {
+		publishPacket(testDevice, record)
+	}
+	if r := wait("send failed", done); r.err != errGone || r.dropped != 0 {
+		t.Errorf("send failed: returned %d, %v; want 0, %v", r.dropped, r.err, errGone)
+	}
+	if want := []string{"192.0.2.2", "192.0.2.3"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+
+	never := func(packet PacketLog, _ uint64) error {
+		t.Errorf("sent %s after the stream ended", packet.DstIP)
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done = stream(ctx, never)
+	cancel()
+	if r := wait("cancelled", done); r.err != nil {
+		t.Errorf("cancelled: %v", r.err)
+	}
+
+	done = stream(context.Background(), never)
+	closeSubscriptions()
+	if r := wait("shut down", done); r.err != nil {
+		t.Errorf("shut down: %v", r.err)
+	}
+	if n := subscriberCount.Load(); n != 0 {
+		t.Errorf("%d subscribers left, want 0", n)
+	}
+}
@@ -0,0 +1,85 @@
+package capture
+
+import (
+	"path/filepath"
+
+	"grip/internal/process"
+)
+
+const (
+	// maxExecutableHashBytes caps the size of executables that are hashed
+	maxExecutableHashBytes = 512 * 1024 * 1024
+
+	executableQueueSize = 256
+)
+
+var (
+	// Background hashing state, set by startExecutableChecks
+	executableQueue   chan *ApplicationStats
+	executableDone    chan struct{}
+	executableStopped chan struct{}
+)
+
+// startExecutableChecks starts the goroutine that hashes the executables of
+// newly seen applications
+func startExecutableChecks() {
+	if executableQueue != nil {
+		return
+	}
+
+	executableQueue = make(chan *ApplicationStats, executableQueueSize)
+	executableDone = make(chan struct{})
+	executableStopped = make(chan struct{})
+	go executableWorker(executableQueue, executableDone, executableStopped)
+}
+
+// stopExecutableChecks stops the worker, abandoning queued checks
+func stopExecutableChecks() {
+	if executableQueue != nil {
+		close(executableDone)
+		<-executableStopped
+		executableQueue = nil
+	}
+}
+
+// requestExecutableCheck queues the first check of an application's executable
+// this session. Failures are recorded rather than retried, so each application
+// is checked at most once unless the queue was full.
+func requestExecutableCheck(appStats *ApplicationStats) {
+	queue := executableQueue
+	if queue == nil || !filepath.IsAbs(appStats.ProcessPath) {
+		return // Not running, or a pseudo-process such as System
+	}
+	if !appStats.executableQueued.CompareAndSwap(false, true) {
+		return
+	}
+
+	select {
+	case queue <- appStats:
+	default:
+		appStats.executableQueued.Store(false) // Retry on a later packet
+		errorLimiter.log(LogDebug, "executable queue", "Executable check queue full, skipping %s", appStats.ProcessPath)
+	}
+}
+
+func executableWorker(queue <-chan *ApplicationStats, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	for {
+		select {
+		case <-done:
+			return
+		case appStats := <-queue:
+			info := process.IdentifyExecutable(appStats.ProcessPath, maxExecutableHashBytes)
+			if info.Error != "" {
+				LogWarning("Could not hash %s: %s", appStats.ProcessPath, info.Error)
+			} else {
+				LogDebug("Executable %s: SHA-256 %s, publisher %q", appStats.ProcessPath, info.SHA256, info.Publisher)
+			}
+			appStats.executable.Store(&info)
+
+			// Write the result with the next save even if no new traffic arrives
+			appStats.executableSaved.Store(false)
+		}
+	}
+}
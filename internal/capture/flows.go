@@ -0,0 +1,595 @@
+package capture
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"grip/internal/database"
+	"grip/internal/process"
+)
+
+// TCP flag bits accumulated per flow
+const (
+	flagFIN uint8 = 1 << iota
+	flagSYN
+	flagRST
+	flagPSH
+	flagACK
+	flagURG
+)
+
+// Flow attribution states, stored in the attribution column of flows
+const (
+	attributionFound   = "attributed"
+	attributionPending = "pending"
+	attributionFailed  = "failed"
+)
+
+// flowLookupRetries are the delays after a flow's first packet at which a
+// failed process lookup is retried. A miss is common when the first SYN is
+// captured before the socket shows up in the owner table.
+var flowLookupRetries = []time.Duration{50 * time.Millisecond, 500 * time.Millisecond}
+
+// flowCloseLinger is how long a flow that has sent FIN waits for the FIN of
+// the other direction before it is stored anyway. Until then the ACKs and
+// half-closed data that follow a FIN still count for it instead of starting
+// a new flow.
+var flowCloseLinger = 5 * time.Second
+
+// FlowConfig controls flow aggregation
+type FlowConfig struct {
+	IdleTimeout        time.Duration // Flush a flow after this long without packets
+	CheckpointInterval time.Duration // Write long-lived flows to the database this often (0 disables)
+	StorePackets       bool          // Also store one packet_logs row per packet
+	AggregatePackets   bool          // Store one packet_logs row per connection and second instead
+
+	// Store only one packet row in SampleRate (0 or 1 stores all). Statistics,
+	// destinations and flows still count every packet.
+	SampleRate uint64
+}
+
+// FlowKey identifies a flow by its 5-tuple and direction
+type FlowKey struct {
+	SrcIP     string
+	SrcPort   string
+	DstIP     string
+	DstPort   string
+	Protocol  string
+	Direction string
+}
+
+// Flow aggregates the packets of a single connection in one direction
+type Flow struct {
+	FlowKey
+	DeviceID    int64
+	DstHost     string
+	Scope       string
+	Packets     uint64
+	Bytes       uint64
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	TCPFlags    uint8
+	ProcessID   uint32
+	ProcessName string
+	ProcessPath string
+	Attribution string // attributionFound, attributionPending, attributionFailed or "" when no lookup applies
+
+	lastActivity time.Time // wall clock, so offline timestamps don't look idle
+	closing      time.Time // wall clock time the flow's first FIN was seen, zero while open
+	peerClosed   bool      // the other direction has sent FIN as well and been stored
+
+	// Process found for the flow, shared by its packets, and whether the
+	// first lookup was made; guarded by flowMutex
+	process *process.ProcessInfo
+	looked  bool
+
+	// Packets attributed without a process, counted in the same critical
+	// section as process is read so a lookup retry credits each exactly once;
+	// guarded by flowMutex
+	unattributedPackets uint64
+	unattributedBytes   uint64
+
+	// Database row of a flow that has been checkpointed; guarded by both
+	// flowStoreMutex and flowMutex for writes
+	id             int64
+	lastCheckpoint time.Time
+	finished       bool // final state written, guarded by flowStoreMutex
+}
+
+var (
+	flowConfig = FlowConfig{IdleTimeout: 60 * time.Second, StorePackets: true}
+
+	activeFlows = make(map[FlowKey]*Flow)
+	flowMutex   sync.Mutex
+
+	// flowStoreMutex serializes database writes of a flow so a checkpoint
+	// and the final store never race to insert the same row
+	flowStoreMutex sync.Mutex
+
+	flowDone    chan struct{}
+	flowStopped chan struct{}
+
+	// flowRetries tracks scheduled lookup retries so shutdown can wait for them
+	flowRetries sync.WaitGroup
+)
+
+// ConfigureFlows sets flow aggregation options. It must be called before capture starts.
+func ConfigureFlows(config FlowConfig) {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 60 * time.Second
+	}
+	flowConfig = config
+}
+
+// startFlowTracker starts the background idle-flow flusher
+func startFlowTracker() {
+	if flowDone != nil {
+		return
+	}
+	flowDone = make(chan struct{})
+	flowStopped = make(chan struct{})
+	go flushIdleFlows(flowDone, flowStopped)
+}
+
+// stopFlowTracker stops the flusher and writes every remaining flow to the database
+func stopFlowTracker() {
+	if flowDone != nil {
+		close(flowDone)
+		<-flowStopped
+		flowDone = nil
+	}
+	flowRetries.Wait()
+
+	flowMutex.Lock()
+	remaining := make([]*Flow, 0, len(activeFlows))
+	for key, flow := range activeFlows {
+		remaining = append(remaining, flow)
+		delete(activeFlows, key)
+	}
+	flowMutex.Unlock()
+
+	for _, flow := range remaining {
+		storeFlow(flow)
+	}
+	if len(remaining) > 0 {
+		LogDebug("Flushed %d active flows on shutdown", len(remaining))
+	}
+}
+
+// reverse returns the key of the other direction of the same connection
+func (k FlowKey) reverse() FlowKey {
+	direction := k.Direction
+	switch direction {
+	case "outgoing":
+		direction = "incoming"
+	case "incoming":
+		direction = "outgoing"
+	}
+	return FlowKey{
+		SrcIP:     k.DstIP,
+		SrcPort:   k.DstPort,
+		DstIP:     k.SrcIP,
+		DstPort:   k.SrcPort,
+		Protocol:  k.Protocol,
+		Direction: direction,
+	}
+}
+
+// recordFlowKey returns the key of the flow a packet belongs to
+func recordFlowKey(record database.PacketRecord) FlowKey {
+	return FlowKey{
+		SrcIP:     record.SrcIP,
+		SrcPort:   record.SrcPort,
+		DstIP:     record.DstIP,
+		DstPort:   record.DstPort,
+		Protocol:  record.Protocol,
+		Direction: record.Direction,
+	}
+}
+
+// activeFlow returns the flow of a packet, starting it if needed. flowMutex
+// must be held.
+func activeFlow(key FlowKey, record database.PacketRecord) *Flow {
+	flow, ok := activeFlows[key]
+	if !ok {
+		now := time.Now()
+		flow = &Flow{
+			FlowKey:        key,
+			Scope:          record.Scope,
+			FirstSeen:      record.Timestamp,
+			lastActivity:   now,
+			lastCheckpoint: now,
+		}
+		activeFlows[key] = flow
+	}
+	return flow
+}
+
+// attributeFlowProcess attributes a record to the process of its flow. The
+// process is looked up with lookup for the first packet of the flow and
+// reused for the rest, so most packets cost no lookup. When the first lookup
+// fails, the flow's packets stay unattributed while it is retried at
+// flowLookupRetries in the background.
+func attributeFlowProcess(record *database.PacketRecord, lookup ProcessLookup) (*process.ProcessInfo, error) {
+	key := recordFlowKey(*record)
+
+	flowMutex.Lock()
+	flow := activeFlow(key, *record)
+	info, first := flow.process, !flow.looked
+	flow.looked = true
+	if info == nil {
+		flow.unattributedPackets++
+		flow.unattributedBytes += uint64(record.Length)
+	}
+	flowMutex.Unlock()
+
+	if info != nil {
+		return attributeProcess(record, func(string, uint16, uint16, string) (*process.ProcessInfo, error) {
+			return info, nil
+		})
+	}
+	if !first {
+		return nil, nil
+	}
+
+	looked := false
+	info, err := attributeProcess(record, func(protocol string, srcPort, dstPort uint16, direction string) (*process.ProcessInfo, error) {
+		looked = true
+		return lookup(protocol, srcPort, dstPort, direction)
+	})
+	if !looked {
+		return nil, nil // No socket owns this traffic
+	}
+
+	flowMutex.Lock()
+	if err != nil {
+		flow.Attribution = attributionPending
+		flowRetries.Add(1)
+		time.AfterFunc(flowLookupRetries[0], func() { retryFlowLookup(flow, lookup, 0) })
+		flowMutex.Unlock()
+		return nil, err
+	}
+	flow.process = info
+	flow.Attribution = attributionFound
+	// This packet is counted through the record; others that arrived during
+	// the lookup found no process
+	packets, bytes := flow.unattributedPackets-1, flow.unattributedBytes-uint64(record.Length)
+	flow.unattributedPackets, flow.unattributedBytes = 0, 0
+	flowMutex.Unlock()
+
+	if packets > 0 {
+		peer := remotePeer(record.SrcIP, record.DstIP, record.Direction)
+		updateAppStats(info, record.Protocol, record.Direction, packets, bytes, peer,
+			peerDestination(record.SrcIP, record.DstIP, record.DstHost, record.Direction),
+			lookupGeoIP(peer).Country, record.DstPort)
+	}
+	return info, nil
+}
+
+// retryFlowLookup looks up the process of a flow again, scheduling the next
+// retry if it is still missing. Once found, the packets that were attributed
+// without it are added to the application's statistics and their stored rows
+// updated.
+func retryFlowLookup(flow *Flow, lookup ProcessLookup, attempt int) {
+	record := database.PacketRecord{
+		SrcIP:     flow.SrcIP,
+		SrcPort:   flow.SrcPort,
+		DstIP:     flow.DstIP,
+		DstPort:   flow.DstPort,
+		Protocol:  flow.Protocol,
+		Direction: flow.Direction,
+	}
+	info, err := attributeProcess(&record, lookup)
+	if err != nil {
+		if attempt+1 < len(flowLookupRetries) {
+			time.AfterFunc(flowLookupRetries[attempt+1]-flowLookupRetries[attempt], func() {
+				retryFlowLookup(flow, lookup, attempt+1)
+			})
+			return
+		}
+		flowMutex.Lock()
+		flow.Attribution = attributionFailed
+		flowMutex.Unlock()
+		flowRetries.Done()
+		return
+	}
+	defer flowRetries.Done()
+
+	flowMutex.Lock()
+	flow.process = info
+	flow.Attribution = attributionFound
+	flow.ProcessID = record.ProcessID
+	flow.ProcessName = record.ProcessName
+	flow.ProcessPath = record.ProcessPath
+	packets, bytes := flow.unattributedPackets, flow.unattributedBytes
+	flow.unattributedPackets, flow.unattributedBytes = 0, 0
+	dstHost, since, until := flow.DstHost, flow.FirstSeen, flow.LastSeen
+	flowMutex.Unlock()
+
+	LogDebug("Found %s for %s %s:%s -> %s:%s on retry %d, after %d packets",
+		record.ProcessName, flow.Protocol, flow.SrcIP, flow.SrcPort, flow.DstIP, flow.DstPort, attempt+1, packets)
+	if packets > 0 {
+		peer := remotePeer(flow.SrcIP, flow.DstIP, flow.Direction)
+		updateAppStats(info, flow.Protocol, flow.Direction, packets, bytes, peer,
+			peerDestination(flow.SrcIP, flow.DstIP, dstHost, flow.Direction),
+			lookupGeoIP(peer).Country, flow.DstPort)
+	}
+	if flowConfig.StorePackets {
+		// Aggregated rows carry the start of their second
+		if flowConfig.AggregatePackets {
+			since = since.Truncate(time.Second)
+		}
+		queueStorage(storageItem{attribution: &database.ConnectionAttribution{
+			SrcIP:        flow.SrcIP,
+			SrcPort:      flow.SrcPort,
+			DstIP:        flow.DstIP,
+			DstPort:      flow.DstPort,
+			Protocol:     flow.Protocol,
+			Direction:    flow.Direction,
+			Since:        since,
+			Until:        until,
+			ProcessID:    record.ProcessID,
+			ProcessName:  record.ProcessName,
+			ProcessPath:  record.ProcessPath,
+			ServiceName:  record.ServiceName,
+			ProcessOwner: record.ProcessOwner,
+		}})
+	}
+}
+
+// trackFlow adds a packet to its flow. A RST ends the flow at once. A FIN
+// puts it in a closing state, so the ACKs and half-closed data that follow
+// still count for it. It is stored with its first packet after the other
+// direction has sent FIN too, which is the last ACK of the close, or
+// flowCloseLinger after its own FIN if that never comes.
+func trackFlow(record database.PacketRecord, tcpFlags uint8) {
+	key := recordFlowKey(record)
+
+	flowMutex.Lock()
+	flow := activeFlow(key, record)
+	if flow.DeviceID == 0 {
+		flow.DeviceID = record.DeviceID
+	}
+
+	now := time.Now()
+	flow.Packets++
+	flow.Bytes += uint64(record.Length)
+	flow.LastSeen = record.Timestamp
+	flow.TCPFlags |= tcpFlags
+	flow.lastActivity = now
+	if flow.DstHost == "" {
+		flow.DstHost = record.DstHost
+	}
+	if flow.ProcessID == 0 && record.ProcessID != 0 {
+		flow.ProcessID = record.ProcessID
+		flow.ProcessName = record.ProcessName
+		flow.ProcessPath = record.ProcessPath
+	}
+	if tcpFlags&flagFIN != 0 && flow.closing.IsZero() {
+		flow.closing = now
+	}
+
+	closed := tcpFlags&flagRST != 0 || flow.peerClosed
+	if !closed && !flow.closing.IsZero() {
+		// Once both directions have sent FIN, this one is done and the
+		// other has at most the ACK of this FIN left to send
+		if reverse, ok := activeFlows[key.reverse()]; ok && !reverse.closing.IsZero() {
+			reverse.peerClosed = true
+			closed = true
+		}
+	}
+	if closed {
+		delete(activeFlows, key)
+	}
+	flowMutex.Unlock()
+
+	if closed {
+		queueFlow(flow)
+	}
+}
+
+// flowTickInterval is how often flushIdleFlows looks for flows to store:
+// often enough for both the idle timeout and the close linger
+func flowTickInterval() time.Duration {
+	interval := flowConfig.IdleTimeout / 2
+	if flowCloseLinger < interval {
+		interval = flowCloseLinger
+	}
+	return interval
+}
+
+// flushIdleFlows periodically stores and forgets flows that have gone quiet
+// or have lingered long enough after their FIN
+func flushIdleFlows(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(flowTickInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			cutoff := now.Add(-flowConfig.IdleTimeout)
+			lingerCutoff := now.Add(-flowCloseLinger)
+
+			var idle []*Flow
+			flowMutex.Lock()
+			for key, flow := range activeFlows {
+				lingered := !flow.closing.IsZero() && flow.closing.Before(lingerCutoff)
+				if lingered || flow.lastActivity.Before(cutoff) {
+					idle = append(idle, flow)
+					delete(activeFlows, key)
+				}
+			}
+			flowMutex.Unlock()
+
+			for _, flow := range idle {
+				storeFlow(flow)
+			}
+			if len(idle) > 0 {
+				LogDebug("Flushed %d idle flows", len(idle))
+			}
+
+			checkpointFlows()
+		}
+	}
+}
+
+// checkpointFlows writes the current state of long-lived flows to the
+// database so connections that stay open for hours are visible before they end
+func checkpointFlows() {
+	if flowConfig.CheckpointInterval <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-flowConfig.CheckpointInterval)
+
+	var due []*Flow
+	flowMutex.Lock()
+	for _, flow := range activeFlows {
+		if flow.lastCheckpoint.Before(cutoff) {
+			due = append(due, flow)
+		}
+	}
+	flowMutex.Unlock()
+
+	for _, flow := range due {
+		flowStoreMutex.Lock()
+		if !flow.finished {
+			flowMutex.Lock()
+			record := flowRecord(flow)
+			flowMutex.Unlock()
+
+			id, err := store.UpsertFlow(record)
+			if err != nil {
+				errorLimiter.log(LogError, "store flow", "Error checkpointing flow in database: %v", err)
+			} else {
+				flowMutex.Lock()
+				flow.id = id
+				flow.lastCheckpoint = time.Now()
+				flowMutex.Unlock()
+			}
+		}
+		flowStoreMutex.Unlock()
+	}
+	if len(due) > 0 {
+		LogDebug("Checkpointed %d long-lived flows", len(due))
+	}
+}
+
+// storeFlow writes a finished flow to the database, updating its row if the
+// flow was checkpointed earlier. The flow must already be removed from activeFlows.
+func storeFlow(flow *Flow) {
+	flowStoreMutex.Lock()
+	defer flowStoreMutex.Unlock()
+
+	flow.finished = true
+	// A pending lookup retry may still attribute the flow
+	flowMutex.Lock()
+	record := flowRecord(flow)
+	flowMutex.Unlock()
+
+	if _, err := store.UpsertFlow(record); err != nil {
+		errorLimiter.log(LogError, "store flow", "Error storing flow in database: %v", err)
+	}
+}
+
+// flowRecord converts a flow to its database form
+func flowRecord(flow *Flow) database.FlowRecord {
+	attribution := flow.Attribution
+	if flow.finished && attribution == attributionPending {
+		attribution = attributionFailed // No packets are left to retry with
+	}
+	return database.FlowRecord{
+		ID:          flow.id,
+		DeviceID:    flow.DeviceID,
+		SrcIP:       flow.SrcIP,
+		SrcPort:     flow.SrcPort,
+		DstIP:       flow.DstIP,
+		DstPort:     flow.DstPort,
+		DstHost:     flow.DstHost,
+		Protocol:    flow.Protocol,
+		Direction:   flow.Direction,
+		Scope:       flow.Scope,
+		PacketCount: flow.Packets,
+		ByteCount:   flow.Bytes,
+		FirstSeen:   flow.FirstSeen,
+		LastSeen:    flow.LastSeen,
+		TCPFlags:    formatTCPFlags(flow.TCPFlags),
+		ProcessID:   flow.ProcessID,
+		ProcessName: flow.ProcessName,
+		ProcessPath: flow.ProcessPath,
+		Attribution: attribution,
+	}
+}
+
+// GetActiveFlows returns a snapshot of flows that haven't been flushed yet, largest first
+func GetActiveFlows() []Flow {
+	flowMutex.Lock()
+	flows := make([]Flow, 0, len(activeFlows))
+	for _, flow := range activeFlows {
+		flows = append(flows, *flow)
+	}
+	flowMutex.Unlock()
+
+	sort.Slice(flows, func(i, j int) bool {
+		return flows[i].Bytes > flows[j].Bytes
+	})
+
+	return flows
+}
+
+// packetTCPFlags returns the TCP flags of a packet as a bitmask
+func packetTCPFlags(packet gopacket.Packet) uint8 {
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	if !ok {
+		return 0
+	}
+
+	var flags uint8
+	if tcp.FIN {
+		flags |= flagFIN
+	}
+	if tcp.SYN {
+		flags |= flagSYN
+	}
+	if tcp.RST {
+		flags |= flagRST
+	}
+	if tcp.PSH {
+		flags |= flagPSH
+	}
+	if tcp.ACK {
+		flags |= flagACK
+	}
+	if tcp.URG {
+		flags |= flagURG
+	}
+	return flags
+}
+
+// formatTCPFlags renders a flag bitmask like "SYN|ACK|FIN"
+func formatTCPFlags(flags uint8) string {
+	names := []struct {
+		bit  uint8
+		name string
+	}{
+		{flagSYN, "SYN"}, {flagACK, "ACK"}, {flagPSH, "PSH"},
+		{flagURG, "URG"}, {flagFIN, "FIN"}, {flagRST, "RST"},
+	}
+
+	var set []string
+	for _, n := range names {
+		if flags&n.bit != 0 {
+			set = append(set, n.name)
+		}
+	}
+	return strings.Join(set, "|")
+}
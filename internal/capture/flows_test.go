@@ -0,0 +1,287 @@
+package capture
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"grip/internal/database"
+	"grip/internal/process"
+)
+
+// TestFlowLookupRetry processes a connection whose process only shows up
+// after some lookups, and checks that once it is found, the packets captured
+// before are counted for the application and their stored rows back-filled
+func TestFlowLookupRetry(t *testing.T) {
+	previousRetries := flowLookupRetries
+	flowLookupRetries = []time.Duration{20 * time.Millisecond, 40 * time.Millisecond}
+	t.Cleanup(func() { flowLookupRetries = previousRetries })
+
+	tests := []struct {
+		failures        int // Lookups that fail before the process is found
+		wantLookups     int
+		wantAttribution string
+	}{
+		{0, 1, attributionFound},
+		{1, 2, attributionFound},
+		{2, 3, attributionFound},
+		{3, 3, attributionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d failures", tt.failures), func(t *testing.T) {
+			db := useTestStore(t)
+			useProcesses(t, nil)
+			client := &process.ProcessInfo{ProcessID: 100, ProcessName: "client.exe", ExecutablePath: `C:\Apps\client.exe`}
+
+			// Retries run on timers, so the lookup is called concurrently
+			var mu sync.Mutex
+			lookups := 0
+			findProcess = func(protocol string, srcPort, dstPort uint16, direction string) (*process.ProcessInfo, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				lookups++
+				if lookups <= tt.failures {
+					return nil, fmt.Errorf("process not found")
+				}
+				return client, nil
+			}
+
+			const packets = 3
+			now := time.Now()
+			for i := 0; i < packets; i++ {
+				processPacket(testDevice, testPacket(t, now.Add(time.Duration(i)*time.Millisecond), testLocalIP, "192.0.2.1",
+					&layers.TCP{SrcPort: 50000, DstPort: 443, ACK: true}, make([]byte, 100)))
+			}
+			flowRetries.Wait()
+
+			mu.Lock()
+			if lookups != tt.wantLookups {
+				t.Errorf("%d lookups, want %d", lookups, tt.wantLookups)
+			}
+			mu.Unlock()
+
+			flows := GetActiveFlows()
+			if len(flows) != 1 || flows[0].Attribution != tt.wantAttribution {
+				t.Fatalf("flows %+v, want one %s", flows, tt.wantAttribution)
+			}
+			found := tt.wantAttribution == attributionFound
+
+			// Every packet counts for the application once its process is known
+			var wantPackets uint64
+			if found {
+				wantPackets = packets
+			}
+			var gotPackets uint64
+			if value, ok := stats.ApplicationStats.Load(appKey(client.ExecutablePath, "")); ok {
+				gotPackets = value.(*ApplicationStats).LifetimePackets()
+			}
+			if gotPackets != wantPackets {
+				t.Errorf("application counted %d packets, want %d", gotPackets, wantPackets)
+			}
+
+			var stored []database.PacketRecord
+			err := db.StreamPackets(database.PacketFilter{}, func(p database.PacketRecord) error {
+				stored = append(stored, p)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) != packets {
+				t.Fatalf("stored %d packets, want %d", len(stored), packets)
+			}
+			for i, packet := range stored {
+				if attributed := packet.ProcessName == client.ProcessName; attributed != found {
+					t.Errorf("packet %d stored with process %q, want attributed %v", i, packet.ProcessName, found)
+				}
+			}
+		})
+	}
+
+	// A packet that found no process before the retry did, but was added to
+	// its flow only after the retry had credited the flow, still counts
+	t.Run("packet tracked after the retry", func(t *testing.T) {
+		useTestStore(t)
+		useProcesses(t, nil)
+		client := &process.ProcessInfo{ProcessID: 100, ProcessName: "client.exe", ExecutablePath: `C:\Apps\client.exe`}
+		failed := false
+		lookup := func(protocol string, srcPort, dstPort uint16, direction string) (*process.ProcessInfo, error) {
+			if !failed {
+				failed = true
+				return nil, fmt.Errorf("process not found")
+			}
+			return client, nil
+		}
+
+		now := time.Now()
+		var wantBytes uint64
+		records := make([]database.PacketRecord, 2)
+		for i := range records {
+			records[i] = database.PacketRecord{
+				Timestamp: now.Add(time.Duration(i) * time.Millisecond), DeviceID: 1,
+				SrcIP: testLocalIP, SrcPort: "50000", DstIP: "192.0.2.1", DstPort: "443",
+				Protocol: "TCP", Length: 100 + i, Direction: "outgoing",
+			}
+			wantBytes += uint64(records[i].Length)
+		}
+
+		if _, err := attributeFlowProcess(&records[0], lookup); err == nil {
+			t.Fatal("first lookup succeeded, want a retry")
+		}
+		trackFlow(records[0], 0)
+		if info, _ := attributeFlowProcess(&records[1], lookup); info != nil {
+			t.Fatalf("second packet attributed to %s before the retry", info.ProcessName)
+		}
+		flowRetries.Wait()
+		trackFlow(records[1], 0)
+
+		value, ok := stats.ApplicationStats.Load(appKey(client.ExecutablePath, ""))
+		if !ok {
+			t.Fatal("application not counted")
+		}
+		appStats := value.(*ApplicationStats)
+		if packets, bytes := appStats.LifetimePackets(), appStats.LifetimeBytes(); packets != 2 || bytes != wantBytes {
+			t.Errorf("application counted %d packets, %d bytes, want 2, %d", packets, bytes, wantBytes)
+		}
+	})
+}
+
+// TestFlowLookupRetryAggregated back-fills a connection whose packets are
+// stored aggregated per second, and checks that the rows of the packets
+// still held in their buckets when the process is found are attributed
+func TestFlowLookupRetryAggregated(t *testing.T) {
+	previousRetries := flowLookupRetries
+	flowLookupRetries = []time.Duration{20 * time.Millisecond}
+	t.Cleanup(func() { flowLookupRetries = previousRetries })
+
+	db := useTestStore(t) // Restores flowConfig
+	flowConfig.AggregatePackets = true
+	t.Cleanup(stopPacketAggregator)
+	useProcesses(t, nil)
+	client := &process.ProcessInfo{ProcessID: 100, ProcessName: "client.exe", ExecutablePath: `C:\Apps\client.exe`}
+	var mu sync.Mutex
+	lookups := 0
+	findProcess = func(protocol string, srcPort, dstPort uint16, direction string) (*process.ProcessInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		lookups++
+		if lookups == 1 {
+			return nil, fmt.Errorf("process not found")
+		}
+		return client, nil
+	}
+
+	// The first packet is past the start of its second, which the bucket's
+	// row is stored under
+	const packets = 3
+	start := time.Now().Truncate(time.Second).Add(400 * time.Millisecond)
+	for i := 0; i < packets; i++ {
+		processPacket(testDevice, testPacket(t, start.Add(time.Duration(i)*time.Millisecond), testLocalIP, "192.0.2.1",
+			&layers.TCP{SrcPort: 50000, DstPort: 443, ACK: true}, make([]byte, 100)))
+	}
+	flowRetries.Wait()
+
+	var stored []database.PacketRecord
+	err := db.StreamPackets(database.PacketFilter{}, func(p database.PacketRecord) error {
+		stored = append(stored, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 {
+		t.Fatalf("stored %d rows, want one for the second", len(stored))
+	}
+	if row := stored[0]; row.ProcessName != client.ProcessName || row.PacketCount != packets || !row.Timestamp.Equal(start.Truncate(time.Second)) {
+		t.Errorf("stored %d packets at %v for %q, want %d at %v for %s", row.PacketCount, row.Timestamp, row.ProcessName,
+			packets, start.Truncate(time.Second), client.ProcessName)
+	}
+}
+
+// TestTrackFlowClose follows connections to their end packet by packet and
+// checks that each direction is stored once, with the packets that follow its
+// FIN, and that a FIN the other side never answers is stored after the linger
+func TestTrackFlowClose(t *testing.T) {
+	previousLinger := flowCloseLinger
+	flowCloseLinger = 20 * time.Millisecond
+	t.Cleanup(func() { flowCloseLinger = previousLinger })
+
+	client := database.PacketRecord{DeviceID: 1, SrcIP: testLocalIP, SrcPort: "50000",
+		DstIP: "192.0.2.1", DstPort: "443", Protocol: "TCP", Direction: "outgoing", Length: 60}
+	server := database.PacketRecord{DeviceID: 1, SrcIP: "192.0.2.1", SrcPort: "443",
+		DstIP: testLocalIP, DstPort: "50000", Protocol: "TCP", Direction: "incoming", Length: 60}
+	type packet struct {
+		record database.PacketRecord
+		flags  uint8
+	}
+
+	tests := []struct {
+		name       string
+		packets    []packet
+		linger     bool              // Wait for the idle flusher afterwards
+		wantStored map[string]uint64 // Packets of the stored flow of each direction
+	}{
+		{"client closes first", []packet{
+			{client, flagFIN | flagACK},
+			{server, flagACK},
+			{server, flagFIN | flagACK},
+			{client, flagACK},
+		}, false, map[string]uint64{"outgoing": 2, "incoming": 2}},
+		{"half-closed data", []packet{
+			{client, flagFIN | flagACK},
+			{server, flagACK | flagPSH},
+			{client, flagACK},
+			{server, flagFIN | flagACK},
+			{client, flagACK},
+		}, false, map[string]uint64{"outgoing": 3, "incoming": 2}},
+		{"reset", []packet{
+			{client, flagACK},
+			{server, flagRST},
+		}, false, map[string]uint64{"incoming": 1}},
+		{"unanswered FIN", []packet{
+			{client, flagACK},
+			{client, flagFIN | flagACK},
+		}, true, map[string]uint64{"outgoing": 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := useTestStore(t)
+			if tt.linger {
+				startFlowTracker()
+			}
+
+			now := time.Now()
+			for i, p := range tt.packets {
+				p.record.Timestamp = now.Add(time.Duration(i) * time.Millisecond)
+				trackFlow(p.record, p.flags)
+			}
+
+			stored := make(map[string]uint64)
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				clear(stored)
+				err := db.StreamFlows(database.PacketFilter{}, func(flow database.FlowRecord) error {
+					if _, ok := stored[flow.Direction]; ok {
+						t.Errorf("%s flow stored twice", flow.Direction)
+					}
+					stored[flow.Direction] = flow.PacketCount
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !tt.linger || len(stored) == len(tt.wantStored) || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			if !reflect.DeepEqual(stored, tt.wantStored) {
+				t.Errorf("stored %v, want %v", stored, tt.wantStored)
+			}
+		})
+	}
+}
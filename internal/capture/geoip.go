@@ -0,0 +1,106 @@
+package capture
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoRecord holds the fields read from GeoLite2/GeoIP2 Country, City and ASN databases
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// GeoInfo is the location and network owner of an IP address
+type GeoInfo struct {
+	Country string // ISO country code, e.g. "US"
+	ASN     uint   // Autonomous system number
+	ASOrg   string // Autonomous system organization
+}
+
+// String formats the info for storage, e.g. "US AS15169 Google LLC"
+func (g GeoInfo) String() string {
+	parts := make([]string, 0, 3)
+	if g.Country != "" {
+		parts = append(parts, g.Country)
+	}
+	if g.ASN != 0 {
+		parts = append(parts, fmt.Sprintf("AS%d", g.ASN))
+	}
+	if g.ASOrg != "" {
+		parts = append(parts, g.ASOrg)
+	}
+	return strings.Join(parts, " ")
+}
+
+// geoipReaders are opened once at startup and read concurrently afterwards
+var geoipReaders []*maxminddb.Reader
+
+// LoadGeoIP opens the MaxMind databases at the given paths. Country and ASN
+// data usually ship as separate files, so several paths may be given and their
+// fields are merged. Missing files are skipped with a warning.
+func LoadGeoIP(paths []string) error {
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			LogWarning("GeoIP database %s not found, skipping", path)
+			continue
+		}
+
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			return fmt.Errorf("failed to open GeoIP database %s: %v", path, err)
+		}
+		geoipReaders = append(geoipReaders, reader)
+		LogInfo("Loaded GeoIP database %s (%s)", path, reader.Metadata.DatabaseType)
+	}
+	return nil
+}
+
+// closeGeoIP releases the GeoIP databases
+func closeGeoIP() {
+	for _, reader := range geoipReaders {
+		reader.Close()
+	}
+	geoipReaders = nil
+}
+
+// lookupGeoIP returns location information for an external IP. Tests
+// replace it, as the databases can't be shipped with them.
+var lookupGeoIP = geoIPLookup
+
+// geoIPLookup looks ip up in the loaded databases. Local addresses and
+// lookups without a loaded database return an empty GeoInfo.
+func geoIPLookup(ip string) GeoInfo {
+	var info GeoInfo
+	if len(geoipReaders) == 0 || isLocalIP(ip) {
+		return info
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsPrivate() || parsed.IsLinkLocalUnicast() || parsed.IsMulticast() {
+		return info
+	}
+
+	for _, reader := range geoipReaders {
+		var record geoRecord
+		if err := reader.Lookup(parsed, &record); err != nil {
+			LogDebug("GeoIP lookup failed for %s: %v", ip, err)
+			continue
+		}
+		if info.Country == "" {
+			info.Country = record.Country.ISOCode
+		}
+		if info.ASN == 0 {
+			info.ASN = record.ASN
+			info.ASOrg = record.ASOrg
+		}
+	}
+	return info
+}
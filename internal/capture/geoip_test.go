@@ -0,0 +1,83 @@
+package capture
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"grip/internal/database"
+	"grip/internal/process"
+)
+
+// TestRemotePeerGeoIP processes packets in both directions and checks that
+// the GeoIP details of each packet and the country of each application
+// destination are those of the remote peer, with the country kept out of the
+// destination name
+func TestRemotePeerGeoIP(t *testing.T) {
+	db := useTestStore(t)
+	browser := &process.ProcessInfo{ProcessID: 100, ProcessName: "browser.exe", ExecutablePath: `C:\Apps\browser.exe`}
+	server := &process.ProcessInfo{ProcessID: 200, ProcessName: "server.exe", ExecutablePath: `C:\Apps\server.exe`}
+	useProcesses(t, map[uint16]*process.ProcessInfo{50000: browser, 9000: server})
+
+	previousLookup := lookupGeoIP
+	countries := map[string]string{"93.184.216.34": "US", "203.0.113.5": "AU"}
+	lookupGeoIP = func(ip string) GeoInfo { return GeoInfo{Country: countries[ip]} }
+	t.Cleanup(func() { lookupGeoIP = previousLookup })
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		src, dst  string
+		transport gopacket.SerializableLayer
+
+		app, wantDestination, wantCountry string
+	}{
+		{testLocalIP, "93.184.216.34", &layers.TCP{SrcPort: 50000, DstPort: 443, ACK: true}, "browser.exe", "93.184.216.34", "US"},
+		{"93.184.216.34", testLocalIP, &layers.TCP{SrcPort: 443, DstPort: 50000, ACK: true}, "browser.exe", "93.184.216.34", "US"},
+		{"203.0.113.5", testLocalIP, &layers.UDP{SrcPort: 4000, DstPort: 9000}, "server.exe", "203.0.113.5", "AU"},
+	}
+	for i, tt := range tests {
+		processPacket(testDevice, testPacket(t, start.Add(time.Duration(i)*time.Millisecond), tt.src, tt.dst, tt.transport, nil))
+	}
+
+	var packets []database.PacketRecord
+	err := db.StreamPackets(database.PacketFilter{}, func(p database.PacketRecord) error {
+		packets = append(packets, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != len(tests) {
+		t.Fatalf("stored %d packets, want %d", len(packets), len(tests))
+	}
+	for i, tt := range tests {
+		if packets[i].GeoIP != tt.wantCountry {
+			t.Errorf("packet %d from %s: GeoIP %q, want %q", i, tt.src, packets[i].GeoIP, tt.wantCountry)
+		}
+	}
+
+	for _, info := range []*process.ProcessInfo{browser, server} {
+		var want []DestinationTraffic
+		for _, tt := range tests {
+			if tt.app == info.ProcessName && len(want) == 0 {
+				want = append(want, DestinationTraffic{Destination: tt.wantDestination, Country: tt.wantCountry})
+			}
+		}
+		got := GetDestinationsForApp(appKey(info.ExecutablePath, info.ServiceName))
+		if len(got) != len(want) || got[0].Destination != want[0].Destination || got[0].Country != want[0].Country {
+			t.Errorf("%s: destinations %+v, want %+v", info.ProcessName, got, want)
+		}
+	}
+
+	// The country is stored beside the destination, which stays the plain address
+	SaveAllStatsToDB()
+	stored, err := db.GetDestinationsForApp(appKey(browser.ExecutablePath, browser.ServiceName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].Destination != "93.184.216.34" || stored[0].Country != "US" || stored[0].PacketCount != 2 {
+		t.Errorf("stored browser.exe destinations %+v, want 93.184.216.34 in US with 2 packets", stored)
+	}
+}
@@ -0,0 +1,132 @@
+package capture
+
+import (
+	"fmt"
+	"time"
+
+	"grip/internal/database"
+	"grip/internal/process"
+)
+
+// dynamicPortStart is the first port of the Windows dynamic port range. UDP
+// sockets bound there are almost always clients, such as DNS lookups, and
+// would raise an alert for every new port they pick.
+const dynamicPortStart = 49152
+
+// Background listener scan state, set by startListenerScan
+var (
+	listenerDone    chan struct{}
+	listenerStopped chan struct{}
+)
+
+// startListenerScan records the listening sockets of this machine every
+// ListenerScanInterval and alerts when a new one appears
+func startListenerScan() {
+	if listenerDone != nil || captureConfig.ListenerScanInterval <= 0 {
+		return
+	}
+	listenerDone = make(chan struct{})
+	listenerStopped = make(chan struct{})
+	go scanListeners(captureConfig.ListenerScanInterval, listenerDone, listenerStopped)
+}
+
+// stopListenerScan stops the scan and waits for a running one to finish
+func stopListenerScan() {
+	if listenerDone != nil {
+		close(listenerDone)
+		<-listenerStopped
+		listenerDone = nil
+	}
+}
+
+func scanListeners(interval time.Duration, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := scanListenersOnce(); err != nil {
+			errorLimiter.log(LogWarning, "listener scan", "Failed to scan listening sockets: %v", err)
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scanListenersOnce records the current listeners and alerts on those never
+// seen before
+func scanListenersOnce() error {
+	sockets, err := process.GetListeners()
+	if err != nil {
+		return err
+	}
+
+	// Many sockets belong to the same few processes
+	owners := make(map[uint32]*process.ProcessInfo)
+	listeners := make([]database.Listener, 0, len(sockets))
+	for _, socket := range sockets {
+		if socket.Protocol == "UDP" && socket.Port >= dynamicPortStart {
+			continue
+		}
+		info, ok := owners[socket.ProcessID]
+		if !ok {
+			if info, err = process.GetProcessDetails(socket.ProcessID); err != nil {
+				info = nil // The process exited or can't be opened; record the PID only
+			}
+			owners[socket.ProcessID] = info
+		}
+
+		listener := database.Listener{
+			Protocol:  socket.Protocol,
+			Address:   socket.Address,
+			Port:      socket.Port,
+			ProcessID: socket.ProcessID,
+		}
+		if info != nil {
+			listener.ProcessName = appName(info.ExecutablePath, info.ServiceName)
+			listener.ProcessPath = info.ExecutablePath
+		}
+		listeners = append(listeners, listener)
+	}
+
+	return recordListeners(listeners, time.Now())
+}
+
+// recordListeners stores the listeners of a scan and alerts on the ones it
+// added. A scan that finds the table empty is recorded as the baseline
+// without alerts, so a fresh database doesn't alert on every open socket.
+func recordListeners(listeners []database.Listener, seen time.Time) error {
+	added, baseline, err := store.RecordListeners(listeners, seen)
+	if err != nil {
+		return err
+	}
+	if baseline {
+		LogInfo("Recorded %d listening sockets", len(listeners))
+		return nil
+	}
+	for _, listener := range added {
+		alertNewListener(listener)
+	}
+	return nil
+}
+
+// alertNewListener emits a new listener alert
+func alertNewListener(listener database.Listener) {
+	name := listener.ProcessName
+	if name == "" {
+		name = fmt.Sprintf("PID %d", listener.ProcessID)
+	}
+	address := fmt.Sprintf("%s %s:%d", listener.Protocol, listener.Address, listener.Port)
+	emitAlert(Alert{
+		Kind:      AlertNewListener,
+		App:       name,
+		ProcessID: listener.ProcessID,
+		Listener:  address,
+		Message:   fmt.Sprintf("%s (PID %d) started listening on %s", name, listener.ProcessID, address),
+	})
+}
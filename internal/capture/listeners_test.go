@@ -0,0 +1,52 @@
+package capture
+
+import (
+	"testing"
+	"time"
+
+	"grip/internal/database"
+)
+
+// TestRecordListeners checks that a scan of an empty table is recorded
+// without alerts and that later scans alert once per new listener, including
+// a scan in which every listener is new
+func TestRecordListeners(t *testing.T) {
+	useTestStore(t)
+	alerts := captureAlerts(t, AlertNewListener)
+
+	web := database.Listener{Protocol: "TCP", Address: "0.0.0.0", Port: 8080, ProcessID: 100, ProcessName: "server.exe", ProcessPath: `C:\Apps\server.exe`}
+	dns := database.Listener{Protocol: "UDP", Address: "127.0.0.1", Port: 53, ProcessID: 200, ProcessName: "dns.exe", ProcessPath: `C:\Apps\dns.exe`}
+	ssh := database.Listener{Protocol: "TCP", Address: "0.0.0.0", Port: 22, ProcessID: 300, ProcessPath: `C:\Apps\sshd.exe`}
+
+	steps := []struct {
+		name string
+		scan []database.Listener
+		want []string // Listener of each alert
+		app  string   // App of the first alert
+	}{
+		{"baseline", []database.Listener{web, dns}, nil, ""},
+		{"unchanged", []database.Listener{web, dns}, nil, ""},
+		{"new listener", []database.Listener{web, dns, ssh}, []string{"TCP 0.0.0.0:22"}, "PID 300"},
+		// After a reboot every socket may be new, which is still a change
+		{"all new", []database.Listener{{Protocol: "TCP", Address: "0.0.0.0", Port: 3389, ProcessID: 400, ProcessName: "svchost.exe:TermService"}},
+			[]string{"TCP 0.0.0.0:3389"}, "svchost.exe:TermService"},
+	}
+	for i, step := range steps {
+		*alerts = nil
+		if err := recordListeners(step.scan, time.Now().Add(time.Duration(i)*time.Second)); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if len(*alerts) != len(step.want) {
+			t.Errorf("%s: alerts %+v, want %v", step.name, *alerts, step.want)
+			continue
+		}
+		for j, alert := range *alerts {
+			if alert.Listener != step.want[j] {
+				t.Errorf("%s: alert for %q, want %q", step.name, alert.Listener, step.want[j])
+			}
+		}
+		if len(*alerts) > 0 && (*alerts)[0].App != step.app {
+			t.Errorf("%s: alert app %q, want %q", step.name, (*alerts)[0].App, step.app)
+		}
+	}
+}
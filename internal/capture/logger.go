@@ -1,25 +1,41 @@
 package capture
 
 import (
+	"fmt"
 	"os"
 	"time"
 
 	"grip/internal/logger"
+	"grip/internal/process"
 )
 
 type PacketLog struct {
-	Timestamp   time.Time `json:"timestamp"`
-	Device      string    `json:"device"`
-	SrcIP       string    `json:"src_ip"`
-	SrcPort     string    `json:"src_port"`
-	DstIP       string    `json:"dst_ip"`
-	DstPort     string    `json:"dst_port"`
-	Protocol    string    `json:"protocol"`
-	Length      int       `json:"length"`
-	Direction   string    `json:"direction"`
-	ProcessID   uint32    `json:"process_id,omitempty"`
-	ProcessName string    `json:"process_name,omitempty"`
-	ProcessPath string    `json:"process_path,omitempty"`
+	Timestamp    time.Time `json:"timestamp"`
+	Device       string    `json:"device"`
+	Interface    string    `json:"interface,omitempty"` // Friendly name of Device, e.g. "Ethernet"
+	SrcIP        string    `json:"src_ip"`
+	SrcPort      string    `json:"src_port"`
+	DstIP        string    `json:"dst_ip"`
+	DstPort      string    `json:"dst_port"`
+	SrcMAC       string    `json:"src_mac,omitempty"`
+	DstMAC       string    `json:"dst_mac,omitempty"`
+	VLAN         uint16    `json:"vlan,omitempty"` // 802.1Q VLAN ID, 0 for untagged packets
+	DstHost      string    `json:"dst_host,omitempty"`
+	ReverseHost  string    `json:"reverse_host,omitempty"`
+	GeoIP        string    `json:"geoip,omitempty"`
+	Protocol     string    `json:"protocol"`
+	Length       int       `json:"length"`
+	PacketCount  uint64    `json:"packet_count,omitempty"` // Packets a stored row stands for; set by exports
+	TotalBytes   uint64    `json:"total_bytes,omitempty"`
+	Direction    string    `json:"direction"`
+	Scope        string    `json:"scope,omitempty"`
+	ProcessID    uint32    `json:"process_id,omitempty"`
+	ProcessName  string    `json:"process_name,omitempty"`
+	ProcessPath  string    `json:"process_path,omitempty"`
+	ServiceName  string    `json:"service_name,omitempty"`
+	ProcessOwner string    `json:"process_owner,omitempty"`
+	Payload      []byte    `json:"payload,omitempty"` // Encoded as base64
+	Flagged      bool      `json:"flagged,omitempty"`
 }
 
 var (
@@ -34,6 +50,9 @@ func InitializeLogger(config logger.LoggerConfig) error {
 		return err
 	}
 
+	// Route process lookup diagnostics through the shared logger
+	process.SetLogger(logger.Trace)
+
 	// If we need to log to JSON files, set that up here
 	if config.EnableFile {
 		// Setup could go here if needed
@@ -53,20 +72,42 @@ func CloseLogger() {
 }
 
 // LogPacket handles packet logging with process information
-func LogPacket(device_id int64, src, srcPort, dst, dstPort, protocol string, length int, direction string, ProcessPath string) {
+func LogPacket(deviceName string, src, srcPort, dst, dstPort, protocol string, length int, direction string, ProcessPath string, serviceName string, owner string) {
 	// Skip if info logging is disabled
 	if !logger.IsInfoEnabled() {
 		return
 	}
+	logger.Info("%s", packetLine(InterfaceDisplayName(deviceName), src, srcPort, dst, dstPort, protocol, length, direction, ProcessPath, serviceName, owner))
+}
+
+// FormatPacket formats a packet the way LogPacket writes it to the log
+func FormatPacket(packet PacketLog) string {
+	iface := packet.Interface
+	if iface == "" {
+		iface = packet.Device
+	}
+	return packetLine(iface, packet.SrcIP, packet.SrcPort, packet.DstIP, packet.DstPort, packet.Protocol,
+		packet.Length, packet.Direction, packet.ProcessPath, packet.ServiceName, packet.ProcessOwner)
+}
+
+func packetLine(iface, src, srcPort, dst, dstPort, protocol string, length int, direction, processPath, serviceName, owner string) string {
+	// Distinguish shared svchost.exe processes by the services they host
+	if serviceName != "" {
+		processPath = fmt.Sprintf("%s (%s)", processPath, serviceName)
+	}
+	// Terminal servers run the same program for many users
+	if owner != "" {
+		processPath = fmt.Sprintf("%s, User: %s", processPath, owner)
+	}
 
-	logger.Info("[%d] %s:%s -> %s:%s, Protocol: %s, Length: %d bytes, Direction: %s, Process: %s",
-		device_id,
+	return fmt.Sprintf("[%s] %s:%s -> %s:%s, Protocol: %s, Length: %d bytes, Direction: %s, Process: %s",
+		iface,
 		src, srcPort,
 		dst, dstPort,
 		protocol,
 		length,
 		direction,
-		ProcessPath,
+		processPath,
 	)
 }
 
@@ -80,7 +121,7 @@ func LogInterface(name, description string) {
 
 // LogDebug logs debug information
 func LogDebug(format string, v ...interface{}) {
-	logger.Debug(format, v...)
+	logger.DebugDepth(1, format, v...)
 }
 
 // LogInfo logs information
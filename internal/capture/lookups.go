@@ -0,0 +1,87 @@
+package capture
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lookupSummaryInterval is how often failed process lookups are summarized
+const lookupSummaryInterval = time.Minute
+
+// Background summary state, set by startLookupSummary
+var (
+	lookupSummaryDone    chan struct{}
+	lookupSummaryStopped chan struct{}
+)
+
+// startLookupSummary logs one line per interval with the process lookups that
+// failed since the previous one, instead of an error per packet
+func startLookupSummary() {
+	if lookupSummaryDone != nil {
+		return
+	}
+	lookupSummaryDone = make(chan struct{})
+	lookupSummaryStopped = make(chan struct{})
+	go summarizeLookupFailures(lookupSummaryDone, lookupSummaryStopped)
+}
+
+// stopLookupSummary stops the summary goroutine
+func stopLookupSummary() {
+	if lookupSummaryDone != nil {
+		close(lookupSummaryDone)
+		<-lookupSummaryStopped
+		lookupSummaryDone = nil
+	}
+}
+
+func summarizeLookupFailures(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(lookupSummaryInterval)
+	defer ticker.Stop()
+
+	previous := GetLookupFailures()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		current := GetLookupFailures()
+		if summary := lookupFailureSummary(previous, current); summary != "" {
+			LogInfo("Process lookup failed for %s in the last %v", summary, lookupSummaryInterval)
+		}
+		previous = current
+	}
+}
+
+// lookupFailureSummary describes the failures counted between two snapshots,
+// e.g. "42 packets (TCP/incoming: 40, UDP/outgoing: 2)", or "" if there were none
+func lookupFailureSummary(previous, current map[string]uint64) string {
+	var (
+		total uint64
+		parts []string
+	)
+	categories := make([]string, 0, len(current))
+	for category := range current {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	for _, category := range categories {
+		delta := current[category] - previous[category]
+		if delta == 0 {
+			continue
+		}
+		total += delta
+		parts = append(parts, fmt.Sprintf("%s: %d", category, delta))
+	}
+
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d packets (%s)", total, strings.Join(parts, ", "))
+}
@@ -0,0 +1,56 @@
+package capture
+
+import (
+	"github.com/google/gopacket"
+)
+
+// maxPayloadBytes bounds CaptureConfig.PayloadBytes so a large snapshot
+// length can't put whole packets into every packet_logs row
+const maxPayloadBytes = 4096
+
+// capturePayload returns a copy of the first captureConfig.PayloadBytes of the
+// packet's application payload, or nil if payload capture is disabled, the
+// packet has no payload or neither port is selected
+func capturePayload(packet gopacket.Packet, srcPort, dstPort uint16) []byte {
+	limit := captureConfig.PayloadBytes
+	if limit == 0 || !payloadPortSelected(srcPort, dstPort) {
+		return nil
+	}
+
+	app := packet.ApplicationLayer()
+	if app == nil {
+		return nil
+	}
+	payload := app.Payload()
+	if len(payload) == 0 {
+		return nil
+	}
+	if len(payload) > limit {
+		payload = payload[:limit]
+	}
+
+	// Copy so the record doesn't keep the whole packet buffer alive
+	return append([]byte(nil), payload...)
+}
+
+// payloadPortSelected reports whether payloads of traffic between these ports are stored
+func payloadPortSelected(srcPort, dstPort uint16) bool {
+	if len(captureConfig.PayloadPorts) == 0 {
+		return true
+	}
+	for _, port := range captureConfig.PayloadPorts {
+		if port == srcPort || port == dstPort {
+			return true
+		}
+	}
+	return false
+}
+
+// logPayload writes a stored payload to the debug log if LogPayload is set.
+// Payloads may hold credentials or personal data, so they are left out otherwise.
+func logPayload(payload []byte) {
+	if len(payload) == 0 || !captureConfig.LogPayload {
+		return
+	}
+	LogDebug("  Payload (%d bytes): %x", len(payload), payload)
+}
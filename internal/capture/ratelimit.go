@@ -0,0 +1,78 @@
+package capture
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// logLimiter logs each message category at most once per interval and
+// reports how many messages of that category were suppressed in between.
+// It is meant for errors that can repeat on every packet.
+type logLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	entries  map[string]*limitedCategory
+	now      func() time.Time // Replaced by tests
+}
+
+type limitedCategory struct {
+	lastLogged time.Time
+	suppressed int
+}
+
+// errorLimiter is shared by the hot-path error logs in this package
+var errorLimiter = newLogLimiter(30 * time.Second)
+
+// SetErrorLogInterval sets how often a repeated error is logged (0 logs every occurrence)
+func SetErrorLogInterval(interval time.Duration) {
+	errorLimiter.mu.Lock()
+	defer errorLimiter.mu.Unlock()
+	errorLimiter.interval = interval
+}
+
+func newLogLimiter(interval time.Duration) *logLimiter {
+	return &logLimiter{
+		interval: interval,
+		entries:  make(map[string]*limitedCategory),
+		now:      time.Now,
+	}
+}
+
+// allow reports whether a message in category may be logged now, and if so
+// how many messages were suppressed since the last one
+func (l *logLimiter) allow(category string) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry, ok := l.entries[category]
+	if !ok {
+		entry = &limitedCategory{}
+		l.entries[category] = entry
+	}
+
+	now := l.now()
+	if ok && now.Sub(entry.lastLogged) < l.interval {
+		entry.suppressed++
+		return false, 0
+	}
+
+	suppressed := entry.suppressed
+	entry.lastLogged = now
+	entry.suppressed = 0
+	return true, suppressed
+}
+
+// log writes the message through logf unless category was logged recently
+func (l *logLimiter) log(logf func(string, ...interface{}), category, format string, args ...interface{}) {
+	ok, suppressed := l.allow(category)
+	if !ok {
+		return
+	}
+
+	message := fmt.Sprintf(format, args...)
+	if suppressed > 0 {
+		message = fmt.Sprintf("%s (suppressed %d similar messages)", message, suppressed)
+	}
+	logf("%s", message)
+}
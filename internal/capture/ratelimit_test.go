@@ -0,0 +1,60 @@
+package capture
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestLogLimiter logs messages at set times and checks which are written and
+// how many suppressed messages each one reports
+func TestLogLimiter(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newLogLimiter(30 * time.Second)
+	var now time.Time
+	limiter.now = func() time.Time { return now }
+
+	var logged []string
+	logf := func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+
+	tests := []struct {
+		at       time.Duration
+		category string
+		want     string // Message written, "" if suppressed
+	}{
+		{0, "lookup", "lookup failed"},
+		{time.Second, "lookup", ""},
+		{10 * time.Second, "write", "write failed"}, // Categories are limited separately
+		{29 * time.Second, "lookup", ""},
+		{30 * time.Second, "lookup", "lookup failed (suppressed 2 similar messages)"},
+		{31 * time.Second, "write", ""},
+		{45 * time.Second, "write", "write failed (suppressed 1 similar messages)"},
+		{2 * time.Minute, "lookup", "lookup failed"},
+	}
+	for _, tt := range tests {
+		now = start.Add(tt.at)
+		logged = nil
+		limiter.log(logf, tt.category, "%s failed", tt.category)
+
+		var want []string
+		if tt.want != "" {
+			want = []string{tt.want}
+		}
+		if !reflect.DeepEqual(logged, want) {
+			t.Errorf("%s at %v: logged %q, want %q", tt.category, tt.at, logged, want)
+		}
+	}
+}
+
+// TestLogLimiterDisabled checks that an interval of 0 logs every message
+func TestLogLimiterDisabled(t *testing.T) {
+	limiter := newLogLimiter(0)
+	for i := 0; i < 3; i++ {
+		if ok, suppressed := limiter.allow("lookup"); !ok || suppressed != 0 {
+			t.Errorf("message %d: allowed %v with %d suppressed, want allowed with none", i, ok, suppressed)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package capture
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// rateHistory is the number of one-second buckets kept, enough for the longest window
+const rateHistory = 300
+
+// RateWindows are the rolling windows reported by GetRates and ApplicationStats.Rates
+var RateWindows = []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute}
+
+// Rate is the average traffic over a rolling window
+type Rate struct {
+	Window        time.Duration // Requested window
+	Covered       time.Duration // History actually available, at most Window
+	PacketsPerSec float64
+	BytesPerSec   float64
+}
+
+type rateBucket struct {
+	second  atomic.Int64 // Unix second this bucket holds; stale buckets are ignored
+	packets atomic.Int64
+	bytes   atomic.Int64
+}
+
+// rateTracker keeps per-second packet and byte counts in a ring buffer of
+// atomic buckets, so capture goroutines counting packets never wait on each
+// other. The zero value is ready to use.
+type rateTracker struct {
+	started atomic.Int64 // Unix second of the first packet
+	buckets [rateHistory]rateBucket
+}
+
+// add counts one packet of the given size in the current second
+func (r *rateTracker) add(now time.Time, bytes uint64) {
+	second := now.Unix()
+	r.started.CompareAndSwap(0, second)
+
+	bucket := &r.buckets[second%rateHistory]
+	if old := bucket.second.Load(); old != second {
+		// The first packet of a new second takes the bucket over. It removes
+		// the counts it saw rather than zeroing them, so packets counted by
+		// others since the takeover are kept.
+		packets, byteCount := bucket.packets.Load(), bucket.bytes.Load()
+		if bucket.second.CompareAndSwap(old, second) {
+			bucket.packets.Add(-packets)
+			bucket.bytes.Add(-byteCount)
+		}
+	}
+	bucket.packets.Add(1)
+	bucket.bytes.Add(int64(bytes))
+}
+
+// rates returns the average rate over each window. Before a window has filled
+// up, the rate is taken over the history that exists so far.
+func (r *rateTracker) rates(now time.Time, windows []time.Duration) []Rate {
+	second := now.Unix()
+	started := r.started.Load()
+
+	result := make([]Rate, len(windows))
+	for i, window := range windows {
+		result[i].Window = window
+		if started == 0 {
+			continue
+		}
+
+		seconds := int64(window / time.Second)
+		if seconds > rateHistory {
+			seconds = rateHistory
+		}
+		// The current second counts as one even though it is still filling
+		if available := second - started + 1; available < seconds {
+			seconds = available
+		}
+		if seconds < 1 {
+			seconds = 1
+		}
+
+		var packets, bytes int64
+		for s := second - seconds + 1; s <= second; s++ {
+			bucket := &r.buckets[s%rateHistory]
+			if bucket.second.Load() == s {
+				packets += bucket.packets.Load()
+				bytes += bucket.bytes.Load()
+			}
+		}
+
+		result[i].Covered = time.Duration(seconds) * time.Second
+		result[i].PacketsPerSec = float64(packets) / float64(seconds)
+		result[i].BytesPerSec = float64(bytes) / float64(seconds)
+	}
+	return result
+}
+
+// globalRates tracks all captured traffic
+var globalRates rateTracker
+
+// GetRates returns the global traffic rate over each of RateWindows
+func GetRates() []Rate {
+	return globalRates.rates(time.Now(), RateWindows)
+}
+
+// Rates returns the application's traffic rate over each of RateWindows
+func (a *ApplicationStats) Rates() []Rate {
+	return a.rates.rates(time.Now(), RateWindows)
+}
+
+// CurrentRateWindow is the short window CurrentRate averages over, so bursts
+// show up within seconds
+const CurrentRateWindow = 5 * time.Second
+
+// CurrentRate returns the bytes per second of the application with the given
+// key over the last CurrentRateWindow, or of all traffic when app is empty.
+// Applications that haven't been seen have a rate of 0.
+func CurrentRate(app string) float64 {
+	tracker := &globalRates
+	if app != "" {
+		value, ok := stats.ApplicationStats.Load(app)
+		if !ok {
+			return 0
+		}
+		tracker = &value.(*ApplicationStats).rates
+	}
+	return tracker.rates(time.Now(), []time.Duration{CurrentRateWindow})[0].BytesPerSec
+}
@@ -0,0 +1,97 @@
+package capture
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestRates counts packets at known seconds and checks the rate of each
+// window, including while history is still shorter than the window and once
+// old buckets have been reused
+func TestRates(t *testing.T) {
+	var tracker rateTracker
+	start := time.Unix(1714557600, 0)
+	windows := []time.Duration{10 * time.Second, time.Minute, 5 * time.Minute}
+
+	if got := tracker.rates(start, windows); got[0].Covered != 0 || got[0].BytesPerSec != 0 {
+		t.Errorf("before any packet: %+v, want no rate", got[0])
+	}
+
+	// 10 packets of 100 bytes in the first second, then one of 1000 bytes
+	// every second for a minute
+	for i := 0; i < 10; i++ {
+		tracker.add(start.Add(time.Duration(i)*time.Millisecond), 100)
+	}
+	for s := 1; s <= 60; s++ {
+		tracker.add(start.Add(time.Duration(s)*time.Second), 1000)
+	}
+
+	type want struct {
+		covered        time.Duration
+		packets, bytes float64
+	}
+	tests := []struct {
+		name string
+		at   time.Duration // After start
+		want []want        // Per window
+	}{
+		{"first second", 500 * time.Millisecond, []want{
+			{time.Second, 10, 1000}, {time.Second, 10, 1000}, {time.Second, 10, 1000},
+		}},
+		{"five seconds", 4 * time.Second, []want{
+			{5 * time.Second, 14.0 / 5, 5000.0 / 5}, {5 * time.Second, 14.0 / 5, 5000.0 / 5}, {5 * time.Second, 14.0 / 5, 5000.0 / 5},
+		}},
+		{"short window full", 30 * time.Second, []want{
+			{10 * time.Second, 1, 1000}, {31 * time.Second, 40.0 / 31, 31000.0 / 31}, {31 * time.Second, 40.0 / 31, 31000.0 / 31},
+		}},
+		{"traffic stopped", 65 * time.Second, []want{
+			{10 * time.Second, 0.5, 500}, {time.Minute, 55.0 / 60, 55000.0 / 60}, {66 * time.Second, 70.0 / 66, 61000.0 / 66},
+		}},
+		{"history wrapped", 400 * time.Second, []want{
+			{10 * time.Second, 0, 0}, {time.Minute, 0, 0}, {5 * time.Minute, 0, 0},
+		}},
+	}
+	for _, tt := range tests {
+		got := tracker.rates(start.Add(tt.at), windows)
+		for i, w := range tt.want {
+			if got[i].Window != windows[i] || got[i].Covered != w.covered ||
+				got[i].PacketsPerSec != w.packets || got[i].BytesPerSec != w.bytes {
+				t.Errorf("%s, %v window: got %+v, want covered %v, %v pkt/s, %v B/s",
+					tt.name, windows[i], got[i], w.covered, w.packets, w.bytes)
+			}
+		}
+	}
+
+	// A bucket reused 300 seconds later holds only the new second
+	tracker.add(start.Add(rateHistory*time.Second), 7)
+	got := tracker.rates(start.Add(rateHistory*time.Second), []time.Duration{time.Second})
+	if got[0].PacketsPerSec != 1 || got[0].BytesPerSec != 7 {
+		t.Errorf("reused bucket: %+v, want 1 packet of 7 bytes", got[0])
+	}
+}
+
+// TestRatesConcurrent counts packets from several goroutines at once and
+// checks that none are lost
+func TestRatesConcurrent(t *testing.T) {
+	var tracker rateTracker
+	now := time.Unix(1714557600, 0)
+	const goroutines, packets = 8, 1000
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < packets; i++ {
+				tracker.add(now, 10)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := tracker.rates(now, []time.Duration{time.Second})[0]
+	if got.PacketsPerSec != goroutines*packets || got.BytesPerSec != goroutines*packets*10 {
+		t.Errorf("got %v pkt/s, %v B/s; want %d and %d", got.PacketsPerSec, got.BytesPerSec, goroutines*packets, goroutines*packets*10)
+	}
+}
@@ -0,0 +1,235 @@
+package capture
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"grip/internal/database"
+)
+
+// Reverse DNS cache lifetimes. PTR records rarely change and LookupAddr does
+// not report TTLs, so answers are kept for a day and failures for an hour.
+const (
+	reverseDNSTTL         = 24 * time.Hour
+	reverseDNSNegativeTTL = time.Hour
+	reverseDNSQueueSize   = 1024
+)
+
+// reverseCachePurgeInterval is how often expired results are dropped from
+// memory. The rows stay in the database so exports of older traffic still
+// show the name.
+const reverseCachePurgeInterval = 10 * time.Minute
+
+// maxReverseCacheEntries caps the results held in memory between purges
+var maxReverseCacheEntries = 100000
+
+// reverseEntry is a cached PTR lookup result; an empty host records a failure
+type reverseEntry struct {
+	host    string
+	expires time.Time
+}
+
+var (
+	reverseCache      = make(map[string]reverseEntry)
+	reversePending    = make(map[string]bool)
+	reverseCacheMutex sync.RWMutex
+
+	// When expired entries were last purged; guarded by reverseCacheMutex
+	reverseCachePurged time.Time
+
+	// Background resolver state, set by startReverseDNS
+	reverseQueue   chan string
+	reverseDone    chan struct{}
+	reverseStopped sync.WaitGroup
+)
+
+// startReverseDNS restores cached names and starts the resolver workers, if
+// reverse DNS is enabled
+func startReverseDNS() {
+	if reverseQueue != nil || !captureConfig.ReverseDNS {
+		return
+	}
+
+	loadReverseDNSCache()
+
+	reverseQueue = make(chan string, reverseDNSQueueSize)
+	reverseDone = make(chan struct{})
+	for i := 0; i < captureConfig.ReverseDNSWorkers; i++ {
+		reverseStopped.Add(1)
+		go reverseDNSWorker(reverseQueue, reverseDone, captureConfig.ReverseDNSTimeout)
+	}
+	LogDebug("Started %d reverse DNS workers", captureConfig.ReverseDNSWorkers)
+}
+
+// stopReverseDNS stops the workers, abandoning queued lookups, and waits for
+// lookups in progress to time out or finish
+func stopReverseDNS() {
+	if reverseQueue != nil {
+		close(reverseDone)
+		reverseStopped.Wait()
+		reverseQueue = nil
+	}
+}
+
+// loadReverseDNSCache restores unexpired lookup results from the database
+func loadReverseDNSCache() {
+	entries, err := store.GetReverseDNSEntries()
+	if err != nil {
+		LogError("Failed to load reverse DNS cache: %v", err)
+		return
+	}
+
+	reverseCacheMutex.Lock()
+	for _, entry := range entries {
+		cacheReverseEntry(entry.IP, reverseEntry{host: entry.Hostname, expires: entry.ExpiresAt})
+	}
+	reverseCacheMutex.Unlock()
+
+	LogDebug("Loaded %d reverse DNS cache entries from database", len(entries))
+}
+
+// requestReverseDNS queues a PTR lookup for an external IP that has no
+// current cache entry. It never blocks; when the queue is full the request is
+// dropped and retried the next time a packet for the IP arrives.
+func requestReverseDNS(ip string) {
+	queue := reverseQueue
+	if queue == nil {
+		return
+	}
+
+	reverseCacheMutex.RLock()
+	entry, cached := reverseCache[ip]
+	pending := reversePending[ip]
+	reverseCacheMutex.RUnlock()
+	if pending || (cached && time.Now().Before(entry.expires)) {
+		return
+	}
+
+	reverseCacheMutex.Lock()
+	if reversePending[ip] {
+		reverseCacheMutex.Unlock()
+		return
+	}
+	if isLocalIP(ip) || net.ParseIP(ip) == nil {
+		// Never worth resolving; remember that so the check isn't repeated per packet
+		cacheReverseEntry(ip, reverseEntry{expires: time.Now().Add(reverseDNSTTL)})
+		reverseCacheMutex.Unlock()
+		return
+	}
+	reversePending[ip] = true
+	reverseCacheMutex.Unlock()
+
+	select {
+	case queue <- ip:
+	default:
+		reverseCacheMutex.Lock()
+		delete(reversePending, ip)
+		reverseCacheMutex.Unlock()
+		errorLimiter.log(LogDebug, "reverse dns queue", "Reverse DNS queue full, skipping lookup of %s", ip)
+	}
+}
+
+// cacheReverseEntry caches the result for ip. When the cache is full an
+// arbitrary other entry makes room; it is looked up again if still in use.
+// The caller must hold reverseCacheMutex.
+func cacheReverseEntry(ip string, entry reverseEntry) {
+	if _, ok := reverseCache[ip]; !ok && len(reverseCache) >= maxReverseCacheEntries {
+		for other := range reverseCache {
+			delete(reverseCache, other)
+			break
+		}
+	}
+	reverseCache[ip] = entry
+}
+
+// purgeReverseCache forgets lookup results that expired before now, at most
+// once every reverseCachePurgeInterval
+func purgeReverseCache(now time.Time) {
+	reverseCacheMutex.Lock()
+	if now.Sub(reverseCachePurged) < reverseCachePurgeInterval {
+		reverseCacheMutex.Unlock()
+		return
+	}
+	reverseCachePurged = now
+
+	purged := 0
+	for ip, entry := range reverseCache {
+		if now.After(entry.expires) {
+			delete(reverseCache, ip)
+			purged++
+		}
+	}
+	remaining := len(reverseCache)
+	reverseCacheMutex.Unlock()
+
+	if purged > 0 {
+		LogDebug("Purged %d expired reverse DNS cache entries, %d remain", purged, remaining)
+	}
+}
+
+// reverseDNSWorker resolves queued IPs one at a time, so the number of
+// workers bounds the lookups in flight
+func reverseDNSWorker(queue <-chan string, done <-chan struct{}, timeout time.Duration) {
+	defer reverseStopped.Done()
+
+	for {
+		select {
+		case <-done:
+			return
+		case ip := <-queue:
+			resolveReverseDNS(ip, timeout)
+		}
+	}
+}
+
+// resolveReverseDNS looks up the PTR record of an IP and caches and persists
+// the first name, or the failure
+func resolveReverseDNS(ip string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	cancel()
+
+	entry := reverseEntry{expires: time.Now().Add(reverseDNSNegativeTTL)}
+	if err == nil && len(names) > 0 {
+		entry = reverseEntry{host: strings.TrimSuffix(names[0], "."), expires: time.Now().Add(reverseDNSTTL)}
+	} else if err != nil {
+		LogDebug("Reverse DNS lookup of %s failed: %v", ip, err)
+	}
+
+	reverseCacheMutex.Lock()
+	cacheReverseEntry(ip, entry)
+	delete(reversePending, ip)
+	reverseCacheMutex.Unlock()
+
+	err = store.StoreReverseDNSEntry(database.ReverseDNSEntry{
+		IP:         ip,
+		Hostname:   entry.host,
+		ResolvedAt: time.Now(),
+		ExpiresAt:  entry.expires,
+	})
+	if err != nil {
+		errorLimiter.log(LogError, "store reverse dns", "Error storing reverse DNS entry for %s: %v", ip, err)
+	}
+}
+
+// ReverseName returns the PTR name resolved for a destination, or "" if
+// reverse DNS is disabled, the lookup failed or hasn't completed. Destinations
+// may carry a country suffix, e.g. "203.0.113.5 (US)", which is ignored.
+func ReverseName(destination string) string {
+	if !captureConfig.ReverseDNS {
+		return ""
+	}
+
+	ip := destination
+	if i := strings.Index(ip, " ("); i >= 0 {
+		ip = ip[:i]
+	}
+
+	reverseCacheMutex.RLock()
+	entry := reverseCache[ip]
+	reverseCacheMutex.RUnlock()
+	return entry.host
+}
@@ -0,0 +1,148 @@
+package capture
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"grip/internal/database"
+	"grip/internal/process"
+)
+
+// useReverseCache gives the test an empty reverse DNS cache holding at most
+// maxEntries results
+func useReverseCache(t *testing.T, maxEntries int) {
+	t.Helper()
+
+	reverseCacheMutex.Lock()
+	previousCache, previousPending, previousPurged := reverseCache, reversePending, reverseCachePurged
+	previousMax := maxReverseCacheEntries
+	reverseCache, reversePending, reverseCachePurged = make(map[string]reverseEntry), make(map[string]bool), time.Time{}
+	maxReverseCacheEntries = maxEntries
+	reverseCacheMutex.Unlock()
+	t.Cleanup(func() {
+		reverseCacheMutex.Lock()
+		reverseCache, reversePending, reverseCachePurged = previousCache, previousPending, previousPurged
+		maxReverseCacheEntries = previousMax
+		reverseCacheMutex.Unlock()
+	})
+}
+
+// TestPurgeReverseCache checks that expired lookup results are dropped from
+// memory at most once per purge interval, and that the cache never holds more
+// than its cap
+func TestPurgeReverseCache(t *testing.T) {
+	useReverseCache(t, 3)
+
+	now := time.Now()
+	reverseCacheMutex.Lock()
+	cacheReverseEntry("192.0.2.1", reverseEntry{expires: now.Add(time.Second)})
+	cacheReverseEntry("192.0.2.2", reverseEntry{host: "long.example", expires: now.Add(3 * time.Hour)})
+	reverseCacheMutex.Unlock()
+
+	tests := []struct {
+		name      string
+		at        time.Time
+		wantCache []string // IPs still cached
+	}{
+		{"nothing expired", now, []string{"192.0.2.1", "192.0.2.2"}},
+		{"expired within the interval", now.Add(2 * time.Minute), []string{"192.0.2.1", "192.0.2.2"}},
+		{"expired after the interval", now.Add(reverseCachePurgeInterval + time.Minute), []string{"192.0.2.2"}},
+		{"everything expired", now.Add(4 * time.Hour), nil},
+	}
+	for _, tt := range tests {
+		purgeReverseCache(tt.at)
+
+		reverseCacheMutex.RLock()
+		cached := len(reverseCache)
+		for _, ip := range tt.wantCache {
+			if _, ok := reverseCache[ip]; !ok {
+				t.Errorf("%s: %s not cached", tt.name, ip)
+			}
+		}
+		reverseCacheMutex.RUnlock()
+		if cached != len(tt.wantCache) {
+			t.Errorf("%s: %d entries cached, want %d", tt.name, cached, len(tt.wantCache))
+		}
+	}
+
+	// A full cache makes room for new addresses but not for updates
+	reverseCacheMutex.Lock()
+	defer reverseCacheMutex.Unlock()
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3", "198.51.100.4", "198.51.100.4"} {
+		cacheReverseEntry(ip, reverseEntry{host: "new.example", expires: now.Add(time.Hour)})
+		if len(reverseCache) > maxReverseCacheEntries {
+			t.Errorf("after caching %s: %d entries, want at most %d", ip, len(reverseCache), maxReverseCacheEntries)
+		}
+		if _, ok := reverseCache[ip]; !ok {
+			t.Errorf("%s not cached", ip)
+		}
+	}
+	if len(reverseCache) != maxReverseCacheEntries {
+		t.Errorf("%d entries cached, want %d", len(reverseCache), maxReverseCacheEntries)
+	}
+}
+
+// TestReverseDNSRemotePeer processes packets in both directions and checks
+// that the remote peer is queued for a PTR lookup rather than the local
+// address, and that stored packets show the name of the peer
+func TestReverseDNSRemotePeer(t *testing.T) {
+	db := useTestStore(t)
+	useReverseCache(t, 100)
+	browser := &process.ProcessInfo{ProcessID: 100, ProcessName: "browser.exe", ExecutablePath: `C:\Apps\browser.exe`}
+	server := &process.ProcessInfo{ProcessID: 200, ProcessName: "server.exe", ExecutablePath: `C:\Apps\server.exe`}
+	useProcesses(t, map[uint16]*process.ProcessInfo{50000: browser, 9000: server})
+
+	previousQueue := reverseQueue
+	queue := make(chan string, 8)
+	reverseQueue = queue
+	t.Cleanup(func() { reverseQueue = previousQueue })
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		src, dst  string
+		transport gopacket.SerializableLayer
+		wantHost  string
+	}{
+		{testLocalIP, "93.184.216.34", &layers.TCP{SrcPort: 50000, DstPort: 443, ACK: true}, "example.test"},
+		{"93.184.216.34", testLocalIP, &layers.TCP{SrcPort: 443, DstPort: 50000, ACK: true}, "example.test"},
+		{"203.0.113.5", testLocalIP, &layers.UDP{SrcPort: 4000, DstPort: 9000}, "client.test"},
+	}
+	for i, tt := range tests {
+		processPacket(testDevice, testPacket(t, start.Add(time.Duration(i)*time.Millisecond), tt.src, tt.dst, tt.transport, nil))
+	}
+
+	var queued []string
+	for len(queue) > 0 {
+		queued = append(queued, <-queue)
+	}
+	if want := []string{"93.184.216.34", "203.0.113.5"}; !reflect.DeepEqual(queued, want) {
+		t.Errorf("queued %v for lookup, want %v", queued, want)
+	}
+
+	for ip, host := range map[string]string{"93.184.216.34": "example.test", "203.0.113.5": "client.test", testLocalIP: "local.test"} {
+		err := db.StoreReverseDNSEntry(database.ReverseDNSEntry{IP: ip, Hostname: host, ResolvedAt: start, ExpiresAt: start.Add(reverseDNSTTL)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	var packets []database.PacketRecord
+	err := db.StreamPackets(database.PacketFilter{}, func(p database.PacketRecord) error {
+		packets = append(packets, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != len(tests) {
+		t.Fatalf("stored %d packets, want %d", len(packets), len(tests))
+	}
+	for i, tt := range tests {
+		if packets[i].ReverseHost != tt.wantHost {
+			t.Errorf("packet %d from %s: reverse host %q, want %q", i, tt.src, packets[i].ReverseHost, tt.wantHost)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package capture
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"grip/internal/database"
+)
+
+// registerFileDevice stores a synthetic interface for a capture file so its
+// packet records reference a valid device, and returns the device name
+func registerFileDevice(path string) (string, error) {
+	deviceName := "file:" + path
+	iface := database.NetworkInterface{
+		Name:        deviceName,
+		Description: "Offline capture " + filepath.Base(path),
+		CreatedAt:   time.Now(),
+	}
+	deviceID, err := store.StoreInterface(iface)
+	if err != nil {
+		return "", fmt.Errorf("error storing interface for %s: %v", path, err)
+	}
+
+	deviceMapMutex.Lock()
+	deviceIDMap[deviceName] = deviceID
+	deviceMapMutex.Unlock()
+	adaptersMutex.Lock()
+	deviceDescriptions[deviceName] = iface.Description
+	adaptersMutex.Unlock()
+	return deviceName, nil
+}
+
+// StartCaptureFromFile replays a pcap/pcapng file through the live capture
+// pipeline instead of opening network interfaces, so statistics, alerts, the
+// HTTP endpoint and the packet stream can be exercised without Npcap or
+// administrator rights. Packets are paced by their capture timestamps divided
+// by speed; a speed of 0 replays as fast as possible.
+//
+// Process lookup is skipped because the connections in the file don't exist
+// on this machine. The returned channel is closed once the whole file has
+// been replayed; StopCapture ends the replay early.
+func StartCaptureFromFile(config CaptureConfig, path string, speed float64) (<-chan struct{}, error) {
+	if speed < 0 {
+		return nil, fmt.Errorf("replay speed must not be negative, got %g", speed)
+	}
+	if err := applyConfig(config); err != nil {
+		return nil, err
+	}
+
+	source, err := openFileSource(path)
+	if err != nil {
+		return nil, err
+	}
+
+	deviceName, err := registerFileDevice(path)
+	if err != nil {
+		source.Close()
+		return nil, err
+	}
+
+	loadHostCache()
+	if err := startWorkers(true); err != nil {
+		source.Close()
+		return nil, err
+	}
+	lookupProcesses = false
+
+	c := &deviceCapture{
+		stop:     make(chan struct{}),
+		finished: make(chan struct{}),
+	}
+	capturesMutex.Lock()
+	activeCaptures[deviceName] = c
+	capturesMutex.Unlock()
+
+	replayed := make(chan struct{})
+	go func() {
+		defer func() {
+			capturesMutex.Lock()
+			delete(activeCaptures, deviceName)
+			capturesMutex.Unlock()
+			lookupProcesses = true
+			close(c.finished)
+		}()
+
+		LogInfo("Replaying capture file %s", path)
+		ifStats := getInterfaceStats(deviceName)
+		ifStats.setActive(true)
+		stopped := c.run(deviceName, paceSource(source, speed))
+		ifStats.setActive(false)
+
+		sourceStats, _ := source.Stats()
+		if stopped {
+			LogInfo("Replay of %s stopped after %d packets", path, sourceStats.Received)
+			return
+		}
+		LogInfo("Finished replaying %s: %d packets", path, sourceStats.Received)
+		close(replayed)
+	}()
+
+	return replayed, nil
+}
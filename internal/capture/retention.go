@@ -0,0 +1,60 @@
+package capture
+
+import "time"
+
+// retentionCheckInterval is how often expired rows are deleted
+const retentionCheckInterval = time.Hour
+
+// Background retention state, set by startRetention
+var (
+	retentionDone    chan struct{}
+	retentionStopped chan struct{}
+)
+
+// startRetention deletes expired packets and flows now and then every hour,
+// if a retention period is configured
+func startRetention() {
+	if retentionDone != nil || captureConfig.Retention == 0 {
+		return
+	}
+	retentionDone = make(chan struct{})
+	retentionStopped = make(chan struct{})
+	go enforceRetention(captureConfig.Retention, retentionDone, retentionStopped)
+}
+
+// stopRetention stops the retention goroutine and waits for a running purge to finish
+func stopRetention() {
+	if retentionDone != nil {
+		close(retentionDone)
+		<-retentionStopped
+		retentionDone = nil
+	}
+}
+
+func enforceRetention(retention time.Duration, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		// Count packets in the rollups before they are deleted
+		if flowConfig.StorePackets {
+			catchUpRollup(done)
+		}
+
+		cutoff := time.Now().Add(-retention)
+		packets, flows, err := store.PurgeBefore(cutoff)
+		if err != nil {
+			LogError("Failed to delete data older than %v: %v", retention, err)
+		} else if packets > 0 || flows > 0 {
+			LogInfo("Deleted %d packets and %d flows older than %s", packets, flows, cutoff.Format(time.RFC3339))
+		}
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
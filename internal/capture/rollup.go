@@ -0,0 +1,85 @@
+package capture
+
+import "time"
+
+const (
+	// rollupInterval is how often new packet rows are added to the hourly and daily rollups
+	rollupInterval = 5 * time.Minute
+
+	// rollupBatch bounds the packet rows counted per transaction, so the
+	// capture path isn't locked out of the database for long
+	rollupBatch = 50000
+
+	// rollupGrace keeps the newest packet rows out of the rollups for a
+	// while, so rows whose process is filled in after they were stored,
+	// like those of retried lookups, are counted under it
+	rollupGrace = time.Minute
+)
+
+// Background rollup state, set by startRollup
+var (
+	rollupDone    chan struct{}
+	rollupStopped chan struct{}
+)
+
+// startRollup keeps the hourly and daily application rollups up to date
+// while packet rows are stored
+func startRollup() {
+	if rollupDone != nil || !flowConfig.StorePackets {
+		return
+	}
+	rollupDone = make(chan struct{})
+	rollupStopped = make(chan struct{})
+	go rollupPackets(rollupDone, rollupStopped)
+}
+
+// stopRollup stops the rollup goroutine and waits for a running batch to finish
+func stopRollup() {
+	if rollupDone != nil {
+		close(rollupDone)
+		<-rollupStopped
+		rollupDone = nil
+	}
+}
+
+// catchUpRollup adds the packet rows stored since the last run to the rollups
+// in batches, stopping early when done is closed
+func catchUpRollup(done <-chan struct{}) {
+	var total int64
+	for {
+		added, err := store.RollupAppStats(rollupBatch, time.Now().Add(-rollupGrace))
+		if err != nil {
+			LogError("%v", err)
+			break
+		}
+		total += added
+		if added < rollupBatch {
+			break
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+	}
+	if total > 0 {
+		LogDebug("Added %d packet rows to the hourly and daily rollups", total)
+	}
+}
+
+func rollupPackets(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(rollupInterval)
+	defer ticker.Stop()
+
+	for {
+		catchUpRollup(done)
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
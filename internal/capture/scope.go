@@ -0,0 +1,69 @@
+package capture
+
+import "net"
+
+// Scopes of the remote end of a packet
+const (
+	ScopeLocal    = "local"    // Loopback or traffic between this machine's own addresses
+	ScopeLAN      = "lan"      // Private, link-local, multicast or broadcast peer
+	ScopeInternet = "internet" // Globally routable peer
+)
+
+// lanNetworks are address ranges that are never routed on the internet
+var lanNetworks = mustParseCIDRs(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"224.0.0.0/4",
+	"255.255.255.255/32",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// ipScope classifies a single address as local, lan or internet
+func ipScope(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ScopeInternet
+	}
+	if parsed.IsLoopback() || parsed.IsUnspecified() {
+		return ScopeLocal
+	}
+	for _, network := range lanNetworks {
+		if network.Contains(parsed) {
+			return ScopeLAN
+		}
+	}
+	return ScopeInternet
+}
+
+// remotePeer returns the address at the other end of a packet from this
+// machine: the source of incoming traffic and the destination otherwise
+func remotePeer(src, dst, direction string) string {
+	if direction == "incoming" {
+		return src
+	}
+	return dst
+}
+
+// packetScope classifies a packet by its remote peer
+func packetScope(src, dst, direction string) string {
+	if direction == "internal" {
+		return ScopeLocal
+	}
+	return ipScope(remotePeer(src, dst, direction))
+}
@@ -0,0 +1,144 @@
+package capture
+
+import (
+	"runtime"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// selfSampleInterval is how often the monitor's own resource use is sampled.
+// Reading the runtime statistics briefly stops the world, so it is never
+// done per packet.
+const selfSampleInterval = 5 * time.Second
+
+var (
+	modPsapi                 = windows.NewLazySystemDLL("psapi.dll")
+	procGetProcessMemoryInfo = modPsapi.NewProc("GetProcessMemoryInfo")
+)
+
+// processMemoryCounters is PROCESS_MEMORY_COUNTERS
+type processMemoryCounters struct {
+	cb                         uint32
+	PageFaultCount             uint32
+	PeakWorkingSetSize         uintptr
+	WorkingSetSize             uintptr
+	QuotaPeakPagedPoolUsage    uintptr
+	QuotaPagedPoolUsage        uintptr
+	QuotaPeakNonPagedPoolUsage uintptr
+	QuotaNonPagedPoolUsage     uintptr
+	PagefileUsage              uintptr
+	PeakPagefileUsage          uintptr
+}
+
+// SelfStats is a sample of the monitor's own resource use
+type SelfStats struct {
+	Sampled          time.Time
+	ResidentBytes    uint64 // Working set of the process; 0 if it couldn't be read
+	HeapBytes        uint64 // Go heap in use
+	Goroutines       int
+	GCCount          uint32
+	GCPauseTotal     time.Duration
+	GCPauseLast      time.Duration
+	PacketsPerSec    float64 // Packets processed between the last two samples
+	StorageQueue     int     // Records waiting for the database writer
+	StorageQueueSize int
+	DumpQueue        int // Packets waiting for the dump writer; 0 without -dump-dir
+	DumpQueueSize    int
+}
+
+var (
+	selfDone    chan struct{}
+	selfStopped chan struct{}
+)
+
+// startSelfMonitor starts sampling the monitor's resource use into stats.Self
+func startSelfMonitor() {
+	if selfDone != nil {
+		return
+	}
+	selfDone = make(chan struct{})
+	selfStopped = make(chan struct{})
+	go sampleSelf(selfDone, selfStopped)
+}
+
+// stopSelfMonitor stops the sampling; the last sample stays available
+func stopSelfMonitor() {
+	if selfDone != nil {
+		close(selfDone)
+		<-selfStopped
+		selfDone = nil
+	}
+}
+
+// sampleSelf stores a sample every selfSampleInterval until done is closed
+func sampleSelf(done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(selfSampleInterval)
+	defer ticker.Stop()
+
+	var previous *SelfStats
+	packets := stats.TotalPackets.Load()
+	for {
+		sample := readSelfStats()
+		total := stats.TotalPackets.Load()
+		if previous != nil {
+			if elapsed := sample.Sampled.Sub(previous.Sampled).Seconds(); elapsed > 0 {
+				sample.PacketsPerSec = float64(total-packets) / elapsed
+			}
+		}
+		packets = total
+		previous = sample
+		stats.Self.Store(sample)
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// readSelfStats samples everything but the packet rate
+func readSelfStats() *SelfStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	sample := &SelfStats{
+		Sampled:          time.Now(),
+		ResidentBytes:    residentBytes(),
+		HeapBytes:        mem.HeapAlloc,
+		Goroutines:       runtime.NumGoroutine(),
+		GCCount:          mem.NumGC,
+		GCPauseTotal:     time.Duration(mem.PauseTotalNs),
+		StorageQueueSize: storageQueueSize,
+		DumpQueueSize:    dumpQueueSize,
+	}
+	if mem.NumGC > 0 {
+		sample.GCPauseLast = time.Duration(mem.PauseNs[(mem.NumGC+255)%256])
+	}
+
+	storageMutex.RLock()
+	sample.StorageQueue = len(storageQueue)
+	storageMutex.RUnlock()
+
+	dumpMutex.RLock()
+	sample.DumpQueue = len(dumpQueue)
+	dumpMutex.RUnlock()
+
+	return sample
+}
+
+// residentBytes returns the working set of this process, or 0 on failure
+func residentBytes() uint64 {
+	var counters processMemoryCounters
+	counters.cb = uint32(unsafe.Sizeof(counters))
+	ret, _, _ := procGetProcessMemoryInfo.Call(uintptr(windows.CurrentProcess()),
+		uintptr(unsafe.Pointer(&counters)), uintptr(counters.cb))
+	if ret == 0 {
+		return 0
+	}
+	return uint64(counters.WorkingSetSize)
+}
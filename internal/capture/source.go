@@ -0,0 +1,267 @@
+package capture
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// SourceStats are the receive and drop counters of a packet source
+type SourceStats struct {
+	Received  uint64 // Packets delivered by the source
+	Dropped   uint64 // Packets dropped because the capture fell behind
+	IfDropped uint64 // Packets dropped by the interface or its driver
+}
+
+// PacketSource delivers packets to a capture loop. The packet channel is
+// closed when the source is exhausted or fails; Close may be called while a
+// packet is being read.
+type PacketSource interface {
+	Packets() <-chan gopacket.Packet
+	LinkType() layers.LinkType
+	Stats() (SourceStats, error)
+	Close()
+}
+
+// packetReader is the part of a pcap handle a pcapSource uses
+type packetReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+	Stats() (*pcap.Stats, error)
+	Close()
+}
+
+// pcapSource reads packets from a live interface or a capture file
+type pcapSource struct {
+	handle  packetReader
+	packets chan gopacket.Packet
+	stop    chan struct{}
+	once    sync.Once
+	offline bool
+	count   atomic.Uint64 // packets read from a file, which has no driver counters
+}
+
+// filterError reports that the capture filter could not be applied to an
+// opened handle, which reopening won't fix
+type filterError struct {
+	error
+}
+
+// openLiveSource opens a device for live capture with the active configuration.
+// A negative timeout makes reads block between packets, as pcap.BlockForever.
+func openLiveSource(deviceName string) (PacketSource, error) {
+	handle, err := pcap.OpenLive(deviceName, int32(captureConfig.SnapshotLen), captureConfig.Promiscuous, -captureConfig.ReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return newPcapSource(handle, false)
+}
+
+// openFileSource opens a pcap/pcapng file, applying the configured filter
+func openFileSource(path string) (PacketSource, error) {
+	handle, err := pcap.OpenOffline(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening capture file %s: %v", path, err)
+	}
+	return newPcapSource(handle, true)
+}
+
+func newPcapSource(handle *pcap.Handle, offline bool) (*pcapSource, error) {
+	if captureConfig.Filter != "" {
+		if err := handle.SetBPFFilter(captureConfig.Filter); err != nil {
+			handle.Close()
+			return nil, filterError{fmt.Errorf("error applying capture filter %q: %v", captureConfig.Filter, err)}
+		}
+	}
+	return startPcapSource(handle, offline), nil
+}
+
+// startPcapSource starts reading packets from handle
+func startPcapSource(handle packetReader, offline bool) *pcapSource {
+	s := &pcapSource{
+		handle:  handle,
+		packets: make(chan gopacket.Packet),
+		stop:    make(chan struct{}),
+		offline: offline,
+	}
+	go s.read()
+	return s
+}
+
+// read decodes and forwards packets, counting them for files. A read timeout
+// only means no packet arrived; any other error, including the end of a file,
+// closes the packet channel so a failed device is reopened instead of polled.
+func (s *pcapSource) read() {
+	defer close(s.packets)
+
+	linkType := s.handle.LinkType()
+	for {
+		data, ci, err := s.handle.ReadPacketData()
+		if err == pcap.NextErrorTimeoutExpired {
+			select {
+			case <-s.stop:
+				return
+			default:
+				continue
+			}
+		}
+		if err != nil {
+			select {
+			case <-s.stop: // Closed while reading
+			default:
+				if err != io.EOF {
+					LogWarning("Reading packets failed: %v", err)
+				}
+			}
+			return
+		}
+
+		packet := gopacket.NewPacket(data, linkType, gopacket.Default)
+		metadata := packet.Metadata()
+		metadata.CaptureInfo = ci
+		metadata.Truncated = metadata.Truncated || ci.CaptureLength < ci.Length
+
+		select {
+		case <-s.stop:
+			return
+		case s.packets <- packet:
+			s.count.Add(1)
+		}
+	}
+}
+
+func (s *pcapSource) Packets() <-chan gopacket.Packet { return s.packets }
+
+func (s *pcapSource) LinkType() layers.LinkType { return s.handle.LinkType() }
+
+func (s *pcapSource) Stats() (SourceStats, error) {
+	if s.offline {
+		return SourceStats{Received: s.count.Load()}, nil
+	}
+	stats, err := s.handle.Stats()
+	if err != nil {
+		return SourceStats{}, err
+	}
+	return SourceStats{
+		Received:  uint64(stats.PacketsReceived),
+		Dropped:   uint64(stats.PacketsDropped),
+		IfDropped: uint64(stats.PacketsIfDropped),
+	}, nil
+}
+
+// Close stops reading; closing the handle is safe while a packet is being read
+func (s *pcapSource) Close() {
+	s.once.Do(func() {
+		close(s.stop)
+		s.handle.Close()
+	})
+}
+
+// SyntheticSource delivers constructed packets, for exercising the capture
+// pipeline without Npcap or a network adapter. Packets can be built with
+// gopacket.SerializeLayers and gopacket.NewPacket.
+type SyntheticSource struct {
+	linkType layers.LinkType
+	packets  chan gopacket.Packet
+	stop     chan struct{}
+	once     sync.Once
+	sent     atomic.Uint64
+}
+
+// NewSyntheticSource returns a source that delivers packets of the given link
+// type in order and then closes its packet channel
+func NewSyntheticSource(linkType layers.LinkType, packets ...gopacket.Packet) *SyntheticSource {
+	s := &SyntheticSource{
+		linkType: linkType,
+		packets:  make(chan gopacket.Packet),
+		stop:     make(chan struct{}),
+	}
+	go func() {
+		defer close(s.packets)
+		for _, packet := range packets {
+			select {
+			case <-s.stop:
+				return
+			case s.packets <- packet:
+				s.sent.Add(1)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *SyntheticSource) Packets() <-chan gopacket.Packet { return s.packets }
+
+func (s *SyntheticSource) LinkType() layers.LinkType { return s.linkType }
+
+func (s *SyntheticSource) Stats() (SourceStats, error) {
+	return SourceStats{Received: s.sent.Load()}, nil
+}
+
+func (s *SyntheticSource) Close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// pacedSource delays the packets of another source to reproduce the spacing
+// of their capture timestamps divided by speed
+type pacedSource struct {
+	PacketSource
+	packets chan gopacket.Packet
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// paceSource wraps a source so packets keep their original spacing, sped up
+// by speed. A speed of 0 returns the source unchanged.
+func paceSource(source PacketSource, speed float64) PacketSource {
+	if speed <= 0 {
+		return source
+	}
+	s := &pacedSource{
+		PacketSource: source,
+		packets:      make(chan gopacket.Packet),
+		stop:         make(chan struct{}),
+	}
+	go s.pace(speed)
+	return s
+}
+
+func (s *pacedSource) pace(speed float64) {
+	defer close(s.packets)
+
+	var firstPacket, started time.Time
+	for packet := range s.PacketSource.Packets() {
+		if timestamp := packet.Metadata().Timestamp; !timestamp.IsZero() {
+			if firstPacket.IsZero() {
+				firstPacket, started = timestamp, time.Now()
+			}
+			due := started.Add(time.Duration(float64(timestamp.Sub(firstPacket)) / speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-s.stop:
+					return
+				case <-time.After(wait):
+				}
+			}
+		}
+
+		select {
+		case <-s.stop:
+			return
+		case s.packets <- packet:
+		}
+	}
+}
+
+func (s *pacedSource) Packets() <-chan gopacket.Packet { return s.packets }
+
+func (s *pacedSource) Close() {
+	s.once.Do(func() { close(s.stop) })
+	s.PacketSource.Close()
+}
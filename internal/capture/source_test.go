@@ -0,0 +1,155 @@
+package capture
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+
+	"grip/internal/process"
+)
+
+// fakeReader returns its reads in order, then blocks like an idle device
+// until closed
+type fakeReader struct {
+	reads  []fakeRead
+	closed chan struct{}
+	once   sync.Once
+}
+
+type fakeRead struct {
+	packet gopacket.Packet
+	err    error
+}
+
+func newFakeReader(reads ...fakeRead) *fakeReader {
+	return &fakeReader{reads: reads, closed: make(chan struct{})}
+}
+
+func (r *fakeReader) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if len(r.reads) == 0 {
+		<-r.closed
+		return nil, gopacket.CaptureInfo{}, errors.New("read on closed handle")
+	}
+	read := r.reads[0]
+	r.reads = r.reads[1:]
+	if read.err != nil {
+		return nil, gopacket.CaptureInfo{}, read.err
+	}
+	data := read.packet.Data()
+	return data, gopacket.CaptureInfo{Timestamp: read.packet.Metadata().Timestamp, CaptureLength: len(data), Length: len(data)}, nil
+}
+
+func (r *fakeReader) LinkType() layers.LinkType { return layers.LinkTypeEthernet }
+
+func (r *fakeReader) Stats() (*pcap.Stats, error) { return &pcap.Stats{}, nil }
+
+func (r *fakeReader) Close() { r.once.Do(func() { close(r.closed) }) }
+
+// TestPcapSourceReadError reads from a handle that times out and then fails
+// partway, and checks that timeouts are skipped and the failure ends the
+// source rather than being retried
+func TestPcapSourceReadError(t *testing.T) {
+	now := time.Now()
+	first := testPacket(t, now, testLocalIP, "192.0.2.1", &layers.UDP{SrcPort: 1000, DstPort: 53}, nil)
+	second := testPacket(t, now.Add(time.Millisecond), testLocalIP, "192.0.2.2", &layers.UDP{SrcPort: 1000, DstPort: 53}, nil)
+	reader := newFakeReader(
+		fakeRead{packet: first},
+		fakeRead{err: pcap.NextErrorTimeoutExpired},
+		fakeRead{err: pcap.NextErrorTimeoutExpired},
+		fakeRead{packet: second},
+		fakeRead{err: errors.New("adapter removed")},
+		fakeRead{packet: first},
+	)
+	source := startPcapSource(reader, false)
+	defer source.Close()
+
+	var received []gopacket.Packet
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case packet, ok := <-source.Packets():
+			if !ok {
+				done = true
+				break
+			}
+			received = append(received, packet)
+		case <-timeout:
+			t.Fatalf("packet channel still open after %d packets", len(received))
+		}
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("received %d packets, want 2", len(received))
+	}
+	for i, want := range []gopacket.Packet{first, second} {
+		if got := received[i]; string(got.Data()) != string(want.Data()) || !got.Metadata().Timestamp.Equal(want.Metadata().Timestamp) {
+			t.Errorf("packet %d differs from the one read", i)
+		}
+		if received[i].Layer(layers.LayerTypeUDP) == nil {
+			t.Errorf("packet %d not decoded", i)
+		}
+	}
+}
+
+// TestCaptureReopensFailedSource captures from a device whose first handle
+// fails partway and checks that the device is reopened and the packets of
+// both handles are processed
+func TestCaptureReopensFailedSource(t *testing.T) {
+	db := useTestStore(t)
+	useCaptureConfig(t, DefaultCaptureConfig(), db)
+	useProcesses(t, map[uint16]*process.ProcessInfo{
+		1000: {ProcessID: 100, ProcessName: "client.exe", ExecutablePath: `C:\Apps\client.exe`},
+	})
+
+	now := time.Now()
+	packet := func(i int) fakeRead {
+		return fakeRead{packet: testPacket(t, now.Add(time.Duration(i)*time.Millisecond), testLocalIP, "192.0.2.1",
+			&layers.UDP{SrcPort: 1000, DstPort: 53}, nil)}
+	}
+	readers := []*fakeReader{
+		newFakeReader(packet(0), packet(1), fakeRead{err: errors.New("adapter removed")}),
+		newFakeReader(packet(2), packet(3), packet(4)),
+	}
+
+	var mu sync.Mutex
+	opened := 0
+	previousOpen := openDeviceSource
+	openDeviceSource = func(deviceName string) (PacketSource, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if opened == len(readers) {
+			return nil, errors.New("no more handles")
+		}
+		opened++
+		return startPcapSource(readers[opened-1], false), nil
+	}
+	defer func() { openDeviceSource = previousOpen }()
+
+	go captureDevice(testDevice)
+	deadline := time.Now().Add(reopenMinBackoff + 5*time.Second)
+	for stats.TotalPackets.Load() < 5 {
+		if time.Now().After(deadline) {
+			stopDeviceCaptures()
+			t.Fatalf("processed %d of 5 packets", stats.TotalPackets.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !getInterfaceStats(testDevice).Active.Load() {
+		t.Error("interface not active after reopening")
+	}
+	stopDeviceCaptures()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if opened != 2 {
+		t.Errorf("device opened %d times, want 2", opened)
+	}
+	if got := stats.TotalPackets.Load(); got != 5 {
+		t.Errorf("processed %d packets, want 5", got)
+	}
+}
@@ -1,109 +1,839 @@
 package capture
 
 import (
-	"encoding/json"
+	"context"
+	"fmt"
+	"net/netip"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"grip/internal/database"
+	"grip/internal/process"
 )
 
-// ApplicationStats tracks statistics for a specific application
+// ProtocolCount holds the packet and byte totals for a single protocol
+type ProtocolCount struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// protocolCounter is the live form of a ProtocolCount, safe for concurrent updates
+type protocolCounter struct {
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+// addProtocolCount counts packets totalling bytes against a protocol in m
+func addProtocolCount(m *sync.Map, protocol string, packets, bytes uint64) {
+	value, ok := m.Load(protocol)
+	if !ok {
+		value, _ = m.LoadOrStore(protocol, &protocolCounter{})
+	}
+	counter := value.(*protocolCounter)
+	counter.packets.Add(packets)
+	counter.bytes.Add(bytes)
+}
+
+// protocolCounts returns a snapshot of the protocol counters in m
+func protocolCounts(m *sync.Map) map[string]ProtocolCount {
+	result := make(map[string]ProtocolCount)
+	m.Range(func(key, value interface{}) bool {
+		counter := value.(*protocolCounter)
+		result[key.(string)] = ProtocolCount{Packets: counter.packets.Load(), Bytes: counter.bytes.Load()}
+		return true
+	})
+	return result
+}
+
+// ApplicationStats tracks statistics for a specific application. The Total
+// counters and PacketsByProtocol cover the current session only and start at
+// zero on every run; lifetime totals add the counts loaded from the database.
+//
+// The counters are atomics and the maps sync.Maps holding atomic counters, so
+// saves and readers use them while packets are being counted without a lock.
+// A save can see a packet in one counter before the next; it writes only the
+// difference to the counts it saved last, so the following save catches up.
 type ApplicationStats struct {
 	ProcessID         uint32
 	ProcessName       string
 	ProcessPath       string
+	ServiceName       string // Services hosted by svchost.exe, if any
+	Owner             string // DOMAIN\user account the process runs as, if known
 	TotalPackets      atomic.Uint64
 	TotalBytes        atomic.Uint64
-	PacketsByProtocol sync.Map // map[string]uint64
-	Destinations      sync.Map // map[string]bool - set of IPs/domains
+	PacketsByProtocol sync.Map // map[string]*protocolCounter - use ProtocolCounts for a snapshot
+	Destinations      sync.Map // map[string]*destinationStats - key is IP or domain
 	LastSavedToDB     time.Time
+	BlockedHits       atomic.Uint64 // Outgoing packets to blocklisted destinations
+
+	// Incoming and outgoing traffic. Internal, external, multicast and
+	// broadcast traffic counts in neither; it is the rest of the totals.
+	PacketsIn  atomic.Uint64
+	BytesIn    atomic.Uint64
+	PacketsOut atomic.Uint64
+	BytesOut   atomic.Uint64
+
+	// Number of entries in Destinations, kept at or below maxDestinations.
+	// partialDestinations is set once the map no longer holds every destination
+	// stored for the app, so unknown ones must be checked against the database.
+	destinationCount    atomic.Int64
+	partialDestinations atomic.Bool
+
+	// Destinations evicted from the map whose counts the next save writes,
+	// oldest first. evicting keeps two packets from evicting at once.
+	evictedMutex        sync.Mutex
+	evictedDestinations []evictedDestination
+	evicting            atomic.Bool
+
+	// Distinct remote IPs and outgoing destination ports seen this session.
+	// Unlike Destinations these sets are never evicted, so they hold only the
+	// addresses and port numbers, and stop growing at maxUniqueDestinations.
+	seenDestinations         sync.Map // map[netip.Addr]struct{}
+	seenPorts                sync.Map // map[uint16]struct{}
+	uniqueDestinations       atomic.Int64
+	uniqueDestinationsCapped atomic.Bool
+	uniquePorts              atomic.Int64
+
+	// Rolling per-second history for rate reporting
+	rates rateTracker
+
+	// Command line and parent of the most recent process seen for the app,
+	// e.g. which script python.exe is running
+	process atomic.Pointer[processOrigin]
+
+	// Hash and publisher of ProcessPath, checked once per session in the
+	// background. executableSaved is cleared when a new result needs saving.
+	executable       atomic.Pointer[process.ExecutableInfo]
+	executableQueued atomic.Bool
+	executableSaved  atomic.Bool
+
+	// Totals stored in the database before this session, added when the app
+	// is loaded at start or, for apps left out then, when it is first seen
+	historyMutex       sync.RWMutex
+	previousPackets    uint64
+	previousBytes      uint64
+	previousIn         ProtocolCount
+	previousOut        ProtocolCount
+	previousByProtocol map[string]ProtocolCount
+
+	// Session counts already written to the database, guarded by saveMutex
+	saveMutex       sync.Mutex
+	savedPackets    uint64
+	savedBytes      uint64
+	savedIn         ProtocolCount
+	savedOut        ProtocolCount
+	savedByProtocol map[string]ProtocolCount
+}
+
+// processOrigin is how the most recent process of an application was started
+type processOrigin struct {
+	commandLine string
+	parentName  string
+}
+
+// noteProcess remembers the command line and parent of a process of the app.
+// The pointer is only swapped when they change, so it is cheap per packet.
+func (a *ApplicationStats) noteProcess(info *process.ProcessInfo) {
+	if info.CommandLine == "" && info.ParentProcessName == "" {
+		return // Access was denied; keep what is known
+	}
+	current := a.process.Load()
+	if current != nil && current.commandLine == info.CommandLine && current.parentName == info.ParentProcessName {
+		return
+	}
+	a.process.Store(&processOrigin{commandLine: info.CommandLine, parentName: info.ParentProcessName})
+}
+
+// CommandLine returns the command line of the most recent process seen for
+// the application, or "" if it couldn't be read
+func (a *ApplicationStats) CommandLine() string {
+	if origin := a.process.Load(); origin != nil {
+		return origin.commandLine
+	}
+	return ""
+}
+
+// ParentProcessName returns the executable that started the most recent
+// process seen for the application, or "" if unknown. It isn't stored.
+func (a *ApplicationStats) ParentProcessName() string {
+	if origin := a.process.Load(); origin != nil {
+		return origin.parentName
+	}
+	return ""
+}
+
+// destinationStats tracks an application's traffic to one destination
+type destinationStats struct {
+	firstSeen time.Time
+	country   string       // ISO country code of the destination's IP, if known
+	lastSeen  atomic.Int64 // Unix nanoseconds
+	packets   atomic.Uint64
+	bytes     atomic.Uint64
+
+	// Counts already written to the database, guarded by the app's saveMutex
+	savedPackets uint64
+	savedBytes   uint64
+}
+
+// evictedDestination is a destination dropped from an application's map,
+// kept until a save has written what it counted
+type evictedDestination struct {
+	key  string
+	dest *destinationStats
+}
+
+// Executable returns the hash and publisher of the application's executable,
+// or nil if it hasn't been checked yet
+func (a *ApplicationStats) Executable() *process.ExecutableInfo {
+	return a.executable.Load()
+}
+
+// UniqueDestinations returns how many distinct remote IPs the application
+// exchanged traffic with this session, including ones evicted from
+// Destinations. The count is a lower bound once UniqueDestinationsCapped.
+func (a *ApplicationStats) UniqueDestinations() int64 {
+	return a.uniqueDestinations.Load()
+}
+
+// UniqueDestinationsCapped reports whether the application reached
+// maxUniqueDestinations, so further remote IPs were not counted
+func (a *ApplicationStats) UniqueDestinationsCapped() bool {
+	return a.uniqueDestinationsCapped.Load()
+}
+
+// UniqueRemotePorts returns how many distinct destination ports the
+// application sent to this session. A high count next to many destinations
+// suggests scanning.
+func (a *ApplicationStats) UniqueRemotePorts() int64 {
+	return a.uniquePorts.Load()
+}
+
+// LifetimePackets returns the packets seen across all runs, including this session
+func (a *ApplicationStats) LifetimePackets() uint64 {
+	a.historyMutex.RLock()
+	defer a.historyMutex.RUnlock()
+	return a.previousPackets + a.TotalPackets.Load()
+}
+
+// LifetimeBytes returns the bytes seen across all runs, including this session
+func (a *ApplicationStats) LifetimeBytes() uint64 {
+	a.historyMutex.RLock()
+	defer a.historyMutex.RUnlock()
+	return a.previousBytes + a.TotalBytes.Load()
+}
+
+// LifetimeByDirection splits the lifetime totals into incoming, outgoing and
+// other (internal, external, multicast and broadcast) traffic
+func (a *ApplicationStats) LifetimeByDirection() (in, out, other ProtocolCount) {
+	a.historyMutex.RLock()
+	defer a.historyMutex.RUnlock()
+	in = ProtocolCount{
+		Packets: a.previousIn.Packets + a.PacketsIn.Load(),
+		Bytes:   a.previousIn.Bytes + a.BytesIn.Load(),
+	}
+	out = ProtocolCount{
+		Packets: a.previousOut.Packets + a.PacketsOut.Load(),
+		Bytes:   a.previousOut.Bytes + a.BytesOut.Load(),
+	}
+	// Load the totals last so they include every packet counted above
+	other = ProtocolCount{
+		Packets: a.previousPackets + a.TotalPackets.Load() - in.Packets - out.Packets,
+		Bytes:   a.previousBytes + a.TotalBytes.Load() - in.Bytes - out.Bytes,
+	}
+	return in, out, other
 }
 
-// Statistics tracks overall system statistics and per-application statistics
+// noCopy makes go vet's copylocks check report any copy of the struct that
+// embeds it. It has no effect at run time.
+type noCopy struct{}
+
+func (*noCopy) Lock()   {}
+func (*noCopy) Unlock() {}
+
+// Statistics tracks overall system statistics and per-application statistics.
+// It is shared through GetStatistics and must never be copied.
 type Statistics struct {
-	StartTime         time.Time
-	TotalPackets      atomic.Uint64
-	TotalBytes        atomic.Uint64
-	PacketsByProtocol sync.Map // map[string]uint64
-	ApplicationStats  sync.Map // map[string]ApplicationStats - key is process name
-	LastSavedToDB     time.Time
+	noCopy noCopy
+
+	StartTime          time.Time
+	TotalPackets       atomic.Uint64
+	TotalBytes         atomic.Uint64
+	PacketsByProtocol  sync.Map      // map[string]*protocolCounter - use GetProtocolCounts for a snapshot
+	PacketsByDirection sync.Map      // map[string]*protocolCounter - use GetDirectionCounts for a snapshot
+	PacketsByVLAN      sync.Map      // map[string]*protocolCounter - key is the VLAN ID; use GetVLANCounts for a snapshot
+	ApplicationStats   sync.Map      // map[string]*ApplicationStats - key is appKey: the lowercased path plus any hosted service
+	InterfaceStats     sync.Map      // map[string]*InterfaceStats - key is device name
+	LookupFailures     sync.Map      // map[string]*atomic.Uint64 - key is "protocol/direction"
+	BlockedHits        atomic.Uint64 // Outgoing packets to blocklisted destinations
+	LastSavedToDB      time.Time
+	Self               atomic.Pointer[SelfStats] // The monitor's own resource use, sampled every few seconds; nil before the first sample
 }
 
-var stats Statistics
-var statsMutex sync.RWMutex
-var saveInterval = 10 * time.Second // Changed to 10 seconds
+// InterfaceStats tracks traffic seen on a single network interface together
+// with the receive/drop counters reported by the capture driver
+type InterfaceStats struct {
+	TotalPackets     atomic.Uint64
+	TotalBytes       atomic.Uint64
+	PacketsReceived  atomic.Uint64
+	PacketsDropped   atomic.Uint64
+	PacketsIfDropped atomic.Uint64
 
-func init() {
-	stats = Statistics{
-		StartTime:     time.Now(),
-		LastSavedToDB: time.Now(),
+	// Capture state, changed as interfaces appear and disappear
+	Active       atomic.Bool  // A capture is running on the interface
+	stateChanged atomic.Int64 // Unix nanoseconds of the last start or stop
+}
+
+// setActive records that capture on the interface started or stopped
+func (s *InterfaceStats) setActive(active bool) {
+	s.Active.Store(active)
+	s.stateChanged.Store(time.Now().UnixNano())
+}
+
+// StateChanged returns when capture on the interface last started or stopped
+func (s *InterfaceStats) StateChanged() time.Time {
+	return time.Unix(0, s.stateChanged.Load())
+}
+
+// CaptureStats holds the packet counters reported by the capture driver for one interface
+type CaptureStats struct {
+	Received  uint64 // Packets received by the filter
+	Dropped   uint64 // Packets dropped because the buffer was full
+	IfDropped uint64 // Packets dropped by the network interface or driver
+}
+
+var stats = Statistics{
+	StartTime:     time.Now(),
+	LastSavedToDB: time.Now(),
+}
+var saveInterval = 10 * time.Second
+
+// savePacketThreshold requests an early save once this many packets arrived
+// since the last one; zero leaves saving to the interval timer alone
+var savePacketThreshold uint64
+var packetsSinceSave atomic.Uint64
+
+// saveRequests wakes the background saver early; a pending request absorbs
+// further ones so bursts turn into a single save
+var saveRequests = make(chan struct{}, 1)
+
+// saveAllMutex keeps the background saver and shutdown from saving concurrently
+var saveAllMutex sync.Mutex
+
+// maxDestinations caps the destinations held in memory per application; zero
+// means no limit. A new destination beyond the cap evicts the least recently
+// used ones, whose counts the next save writes.
+var maxDestinations = 10000
+
+// loadAppLimit is how many applications LoadStatsFromDB reads, most recently
+// active first; zero reads all of them. appStatsPartial is set when the limit
+// left some out, so new entries look for their stored totals.
+var (
+	loadAppLimit    = 500
+	appStatsPartial atomic.Bool
+)
+
+// statsMutex coordinates the final save with the packet path. updateAppStats
+// holds it for reading while it counts a packet; closeStats takes it for
+// writing to set statsClosed, so once closeStats returns no packet is half
+// counted and later ones are dropped rather than counted after the last save.
+// statsTasks tracks the goroutines updateAppStats starts to load an
+// application's history, which must finish before the database is closed.
+var (
+	statsMutex  sync.RWMutex
+	statsClosed bool
+	statsTasks  sync.WaitGroup
+)
+
+// Background saver state, set by StartStatsSaver
+var (
+	statsSaverCancel  context.CancelFunc
+	statsSaverStopped chan struct{}
+)
+
+// incrementProtocolCount increments the packet and byte counts for a specific protocol
+func incrementProtocolCount(protocol string, bytes uint64) {
+	addProtocolCount(&stats.PacketsByProtocol, protocol, 1, bytes)
+}
+
+// GetProtocolCounts returns the packet and byte totals per protocol for this session
+func GetProtocolCounts() map[string]ProtocolCount {
+	return protocolCounts(&stats.PacketsByProtocol)
+}
+
+// Directions lists the packet directions in reporting order. External
+// packets are forwarded or observed traffic between two other hosts.
+var Directions = []string{"incoming", "outgoing", "internal", "external", "multicast", "broadcast"}
+
+// GetDirectionCounts returns the packet and byte totals per direction for this
+// session, with an entry for every one of Directions
+func GetDirectionCounts() map[string]ProtocolCount {
+	counts := protocolCounts(&stats.PacketsByDirection)
+	for _, direction := range Directions {
+		if _, ok := counts[direction]; !ok {
+			counts[direction] = ProtocolCount{}
+		}
 	}
+	return counts
+}
 
-	// Start goroutine to periodically save stats to database
-	go saveStatsPeriodically()
+// incrementVLANCount counts a packet towards its VLAN; untagged packets aren't counted
+func incrementVLANCount(vlan uint16, bytes uint64) {
+	if vlan == 0 {
+		return
+	}
+	addProtocolCount(&stats.PacketsByVLAN, strconv.Itoa(int(vlan)), 1, bytes)
 }
 
-// incrementProtocolCount increments the count for a specific protocol
-func incrementProtocolCount(protocol string) {
-	value, _ := stats.PacketsByProtocol.LoadOrStore(protocol, uint64(0))
-	stats.PacketsByProtocol.Store(protocol, value.(uint64)+1)
+// GetVLANCounts returns the packet and byte totals per 802.1Q VLAN ID for this
+// session. It is empty when no tagged packets were captured.
+func GetVLANCounts() map[uint16]ProtocolCount {
+	counts := make(map[uint16]ProtocolCount)
+	for name, count := range protocolCounts(&stats.PacketsByVLAN) {
+		if vlan, err := strconv.ParseUint(name, 10, 16); err == nil {
+			counts[uint16(vlan)] = count
+		}
+	}
+	return counts
+}
+
+// GetStatistics returns the live statistics. Statistics holds atomics and
+// sync.Maps, which must not be copied, so callers get a pointer and read the
+// counters through it.
+func GetStatistics() *Statistics {
+	return &stats
+}
+
+// getInterfaceStats returns the statistics for a device, creating them if needed
+func getInterfaceStats(deviceName string) *InterfaceStats {
+	value, _ := stats.InterfaceStats.LoadOrStore(deviceName, &InterfaceStats{})
+	return value.(*InterfaceStats)
+}
+
+// updateInterfaceStats counts a packet against the interface it was captured on
+func updateInterfaceStats(deviceName string, bytes uint64) {
+	ifStats := getInterfaceStats(deviceName)
+	ifStats.TotalPackets.Add(1)
+	ifStats.TotalBytes.Add(bytes)
+}
+
+// incrementLookupFailures counts a failed process lookup for a "protocol/direction" category
+func incrementLookupFailures(category string) {
+	value, _ := stats.LookupFailures.LoadOrStore(category, new(atomic.Uint64))
+	value.(*atomic.Uint64).Add(1)
+}
+
+// GetLookupFailures returns the number of failed process lookups keyed by "protocol/direction"
+func GetLookupFailures() map[string]uint64 {
+	result := make(map[string]uint64)
+
+	stats.LookupFailures.Range(func(key, value interface{}) bool {
+		result[key.(string)] = value.(*atomic.Uint64).Load()
+		return true
+	})
+
+	return result
 }
 
-// GetStatistics returns a copy of the current statistics
-func GetStatistics() Statistics {
-	return stats
+// GetCaptureStats returns the most recent driver counters keyed by device name
+func GetCaptureStats() map[string]CaptureStats {
+	result := make(map[string]CaptureStats)
+
+	stats.InterfaceStats.Range(func(key, value interface{}) bool {
+		ifStats := value.(*InterfaceStats)
+		result[key.(string)] = CaptureStats{
+			Received:  ifStats.PacketsReceived.Load(),
+			Dropped:   ifStats.PacketsDropped.Load(),
+			IfDropped: ifStats.PacketsIfDropped.Load(),
+		}
+		return true
+	})
+
+	return result
 }
 
-// updateGlobalStats updates the total packet and byte counts
-func updateGlobalStats(bytes uint64) {
+// GetInterfaceStats returns the per-interface statistics keyed by device name
+func GetInterfaceStats() map[string]*InterfaceStats {
+	result := make(map[string]*InterfaceStats)
+
+	stats.InterfaceStats.Range(func(key, value interface{}) bool {
+		result[key.(string)] = value.(*InterfaceStats)
+		return true
+	})
+
+	return result
+}
+
+// saveInterfaceStatsToDB persists the per-interface counters
+func saveInterfaceStatsToDB() {
+	stats.InterfaceStats.Range(func(key, value interface{}) bool {
+		deviceName := key.(string)
+		ifStats := value.(*InterfaceStats)
+
+		deviceMapMutex.RLock()
+		deviceID, ok := deviceIDMap[deviceName]
+		deviceMapMutex.RUnlock()
+		if !ok {
+			return true
+		}
+
+		err := store.StoreInterfaceStats(database.InterfaceStats{
+			InterfaceID:      deviceID,
+			TotalPackets:     ifStats.TotalPackets.Load(),
+			TotalBytes:       ifStats.TotalBytes.Load(),
+			PacketsReceived:  ifStats.PacketsReceived.Load(),
+			PacketsDropped:   ifStats.PacketsDropped.Load(),
+			PacketsIfDropped: ifStats.PacketsIfDropped.Load(),
+		})
+		if err != nil {
+			LogError("Failed to save interface stats for %s: %v", deviceName, err)
+		}
+		return true
+	})
+}
+
+// UpdateGlobalStats counts a packet of the given size and direction towards
+// the session totals
+func UpdateGlobalStats(bytes uint64, direction string) {
 	stats.TotalPackets.Add(1)
 	stats.TotalBytes.Add(bytes)
+	addProtocolCount(&stats.PacketsByDirection, direction, 1, bytes)
+	globalRates.add(time.Now(), bytes)
+
+	// Reset the count with the request, so it fires once per threshold even
+	// when the saver skips it or another packet gets past the threshold first
+	if savePacketThreshold > 0 {
+		if n := packetsSinceSave.Add(1); n >= savePacketThreshold && packetsSinceSave.CompareAndSwap(n, 0) {
+			requestSave()
+		}
+	}
 }
 
-// updateAppStats updates statistics for a specific application
-func updateAppStats(processID uint32, processName, processPath string,
-	protocol string, bytes uint64, destination string) {
-	if processPath == "" {
+// requestSave asks the background saver to save soon without waiting for its timer
+func requestSave() {
+	select {
+	case saveRequests <- struct{}{}:
+	default:
+	}
+}
+
+// appName returns the name an application is shown and saved under as its
+// process_name: the executable name, followed by the hosted services for
+// svchost.exe so "svchost.exe (Dhcp)" and "svchost.exe (wuauserv)" are
+// tracked separately
+func appName(processPath, serviceName string) string {
+	name := filepath.Base(processPath)
+	if serviceName != "" {
+		name = fmt.Sprintf("%s (%s)", name, serviceName)
+	}
+	return name
+}
+
+// appKey returns the key of an application in the stats map. It uses the full
+// executable path, compared case-insensitively like Windows paths, so two
+// different updater.exe in different folders aren't merged, and appends the
+// hosted services like appName. The database stores applications under the
+// same key.
+func appKey(processPath, serviceName string) string {
+	return database.AppKey(appName(processPath, serviceName), processPath, serviceName)
+}
+
+// key returns the key the application is stored under in the stats map and
+// the database
+func (a *ApplicationStats) key() string {
+	return database.AppKey(a.ProcessName, a.ProcessPath, a.ServiceName)
+}
+
+// updateAppStats counts packets of the process described by info, totalling
+// bytes, towards the statistics of its application. It is called with one
+// packet at a time, or with the packets of a connection whose process was
+// found after they were captured.
+func updateAppStats(info *process.ProcessInfo, protocol, direction string, packets, bytes uint64, remoteIP, destination, country, dstPort string) {
+	if info.ExecutablePath == "" {
 		return // Skip unknown applications
 	}
+	statsMutex.RLock()
+	defer statsMutex.RUnlock()
+	if statsClosed {
+		return // Shutting down; the final save has started
+	}
 
-	// Use last segment of process path as key for the app stats
-	key := filepath.Base(processPath)
+	processID := info.ProcessID
+	key := appKey(info.ExecutablePath, info.ServiceName)
 
 	// Get or create application stats
-	appStatsObj, _ := stats.ApplicationStats.LoadOrStore(key, &ApplicationStats{
+	appStatsObj, loaded := stats.ApplicationStats.LoadOrStore(key, &ApplicationStats{
 		ProcessID:     processID,
-		ProcessName:   processName,
-		ProcessPath:   processPath,
+		ProcessName:   appName(info.ExecutablePath, info.ServiceName),
+		ProcessPath:   info.ExecutablePath,
+		ServiceName:   info.ServiceName,
+		Owner:         info.Owner,
 		LastSavedToDB: time.Now(),
 	})
 
 	appStats := appStatsObj.(*ApplicationStats)
+	appStats.noteProcess(info)
+	if !loaded && appStatsPartial.Load() && store != nil {
+		// Keep the database lookup off the packet path
+		startStatsTask(func() { loadAppHistory(appStats) })
+	}
+
+	// Hash the executable the first time the app is seen this session
+	if !appStats.executableQueued.Load() {
+		requestExecutableCheck(appStats)
+	}
 
 	// Update app stats
-	appStats.TotalPackets.Add(1)
+	switch direction {
+	case "incoming":
+		appStats.PacketsIn.Add(packets)
+		appStats.BytesIn.Add(bytes)
+	case "outgoing":
+		appStats.PacketsOut.Add(packets)
+		appStats.BytesOut.Add(bytes)
+	}
+	appStats.TotalPackets.Add(packets)
 	appStats.TotalBytes.Add(bytes)
+	appStats.rates.add(time.Now(), bytes)
 
 	// Update protocol count for app
-	protoValue, _ := appStats.PacketsByProtocol.LoadOrStore(protocol, uint64(0))
-	appStats.PacketsByProtocol.Store(protocol, protoValue.(uint64)+1)
+	addProtocolCount(&appStats.PacketsByProtocol, protocol, packets, bytes)
 
-	// Add destination to set (use bool value since sync.Map doesn't have a Set type)
+	countDestination(appStats, remoteIP)
 	if destination != "" {
-		appStats.Destinations.Store(destination, true)
+		updateDestinationStats(appStats, appStats.ProcessName, processID, destination, country, packets, bytes)
+	}
+	if direction == "outgoing" && dstPort != "" {
+		countRemotePort(appStats, dstPort)
+	}
+}
+
+// maxUniqueDestinations caps the remote IPs counted per application, so a
+// scan of many addresses can't grow the set without bound
+var maxUniqueDestinations = 100000
+
+// countDestination adds a remote IP to the app's distinct destinations. The
+// IP, not the name it is listed under, is counted, so learning a host's name
+// later doesn't count it twice.
+func countDestination(appStats *ApplicationStats, remoteIP string) {
+	addr, err := netip.ParseAddr(remoteIP)
+	if err != nil {
+		return
+	}
+	addr = addr.Unmap()
+	if _, ok := appStats.seenDestinations.Load(addr); ok {
+		return
+	}
+	if appStats.uniqueDestinations.Load() >= int64(maxUniqueDestinations) {
+		appStats.uniqueDestinationsCapped.Store(true)
+		return
+	}
+	if _, loaded := appStats.seenDestinations.LoadOrStore(addr, struct{}{}); !loaded {
+		appStats.uniqueDestinations.Add(1)
 	}
+}
 
-	// Save to database if enough time has passed
-	if time.Since(appStats.LastSavedToDB) > saveInterval {
-		go saveAppStatsToDB(appStats)
-		appStats.LastSavedToDB = time.Now()
+// countRemotePort adds a destination port to the app's distinct ports
+func countRemotePort(appStats *ApplicationStats, dstPort string) {
+	port, err := strconv.ParseUint(dstPort, 10, 16)
+	if err != nil {
+		return
+	}
+	if _, ok := appStats.seenPorts.Load(uint16(port)); ok {
+		return
+	}
+	if _, loaded := appStats.seenPorts.LoadOrStore(uint16(port), struct{}{}); !loaded {
+		appStats.uniquePorts.Add(1)
 	}
 }
 
-// GetApplicationStats returns a map of process names to their statistics
+// updateDestinationStats counts packets against one of an application's
+// destinations, alerting the first time the destination is seen
+func updateDestinationStats(appStats *ApplicationStats, name string, processID uint32, destination, country string, packets, bytes uint64) {
+	now := time.Now()
+
+	value, ok := appStats.Destinations.Load(destination)
+	if !ok {
+		if maxDestinations > 0 && appStats.destinationCount.Load() >= int64(maxDestinations) {
+			evictDestinations(appStats)
+		}
+
+		var loaded bool
+		dest := &destinationStats{firstSeen: now, country: country}
+		value, loaded = appStats.Destinations.LoadOrStore(destination, dest)
+		if !loaded {
+			appStats.destinationCount.Add(1)
+			alertNewDestination(appStats, name, processID, destination)
+		}
+	}
+
+	dest := value.(*destinationStats)
+	dest.lastSeen.Store(now.UnixNano())
+	dest.packets.Add(packets)
+	dest.bytes.Add(bytes)
+}
+
+// destinationCheckQueueSize bounds the new destination alerts waiting for the
+// database to tell whether the destination is really new
+const destinationCheckQueueSize = 1024
+
+// destinationCheck is a new destination alert waiting for its lookup
+type destinationCheck struct {
+	appStats *ApplicationStats
+	alert    Alert
+}
+
+var (
+	// Background lookup state, set by startDestinationChecks
+	destinationCheckQueue   chan destinationCheck
+	destinationCheckDone    chan struct{}
+	destinationCheckStopped chan struct{}
+)
+
+// startDestinationChecks starts the goroutine that looks up destinations
+// missing from an application's map before alerting on them
+func startDestinationChecks() {
+	if destinationCheckQueue != nil {
+		return
+	}
+
+	destinationCheckQueue = make(chan destinationCheck, destinationCheckQueueSize)
+	destinationCheckDone = make(chan struct{})
+	destinationCheckStopped = make(chan struct{})
+	go destinationCheckWorker(destinationCheckQueue, destinationCheckDone, destinationCheckStopped)
+}
+
+// stopDestinationChecks stops the worker, abandoning queued checks
+func stopDestinationChecks() {
+	if destinationCheckQueue != nil {
+		close(destinationCheckDone)
+		<-destinationCheckStopped
+		destinationCheckQueue = nil
+	}
+}
+
+func destinationCheckWorker(queue <-chan destinationCheck, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	for {
+		select {
+		case <-done:
+			return
+		case check := <-queue:
+			checkNewDestination(check.appStats, check.alert)
+		}
+	}
+}
+
+// alertNewDestination emits a new destination alert. When earlier destinations
+// were evicted or never loaded, the database decides whether it is really new.
+func alertNewDestination(appStats *ApplicationStats, name string, processID uint32, destination string) {
+	alert := Alert{
+		Kind:        AlertNewDestination,
+		App:         name,
+		ProcessID:   processID,
+		Destination: destination,
+		Message:     fmt.Sprintf("%s (PID %d) contacted new destination %s", name, processID, destination),
+	}
+
+	if !appStats.partialDestinations.Load() || store == nil {
+		emitAlert(alert)
+		return
+	}
+
+	// Keep the database lookup off the packet path. Without the worker, as in
+	// tests, look it up here.
+	queue := destinationCheckQueue
+	if queue == nil {
+		checkNewDestination(appStats, alert)
+		return
+	}
+	select {
+	case queue <- destinationCheck{appStats, alert}:
+	default:
+		// Better a repeated alert than a missed one
+		errorLimiter.log(LogDebug, "destination queue", "Destination check queue full, alerting on %s unchecked", destination)
+		emitAlert(alert)
+	}
+}
+
+// checkNewDestination emits alert unless the destination was evicted from the
+// application's map or is stored in the database
+func checkNewDestination(appStats *ApplicationStats, alert Alert) {
+	if appStats.wasEvicted(alert.Destination) {
+		return
+	}
+	known, err := store.HasAppDestination(appStats.key(), alert.Destination)
+	if err != nil {
+		errorLimiter.log(LogError, "destinations:lookup", "Failed to check destination %s of %s: %v", alert.Destination, alert.App, err)
+		return
+	}
+	if !known {
+		emitAlert(alert)
+	}
+}
+
+// wasEvicted reports whether destination was evicted from the application's
+// map and not saved yet
+func (a *ApplicationStats) wasEvicted(destination string) bool {
+	a.evictedMutex.Lock()
+	defer a.evictedMutex.Unlock()
+	for _, e := range a.evictedDestinations {
+		if e.key == destination {
+			return true
+		}
+	}
+	return false
+}
+
+// startStatsTask runs task in a goroutine that closeStats waits for. The
+// caller must hold statsMutex for reading, as updateAppStats does.
+func startStatsTask(task func()) {
+	statsTasks.Add(1)
+	go func() {
+		defer statsTasks.Done()
+		task()
+	}()
+}
+
+// closeStats stops updateAppStats from counting packets and waits for the
+// history loads it started, so the save that follows is the last one and
+// sees every application as it will stay
+func closeStats() {
+	statsMutex.Lock()
+	statsClosed = true
+	statsMutex.Unlock()
+
+	statsTasks.Wait()
+}
+
+// LifetimeProtocolCounts returns the per-protocol totals across all runs, including this session
+func (a *ApplicationStats) LifetimeProtocolCounts() map[string]ProtocolCount {
+	a.historyMutex.RLock()
+	result := make(map[string]ProtocolCount, len(a.previousByProtocol))
+	for protocol, count := range a.previousByProtocol {
+		result[protocol] = count
+	}
+	a.historyMutex.RUnlock()
+
+	for protocol, count := range a.ProtocolCounts() {
+		previous := result[protocol]
+		result[protocol] = ProtocolCount{Packets: previous.Packets + count.Packets, Bytes: previous.Bytes + count.Bytes}
+	}
+
+	return result
+}
+
+// ProtocolCounts returns the packet and byte totals per protocol for this session
+func (a *ApplicationStats) ProtocolCounts() map[string]ProtocolCount {
+	return protocolCounts(&a.PacketsByProtocol)
+}
+
+// GetApplicationStats returns a map of application keys to their statistics.
+// Keys are normalized executable paths; ProcessName holds the display name.
 func GetApplicationStats() map[string]*ApplicationStats {
 	result := make(map[string]*ApplicationStats)
 
@@ -115,26 +845,166 @@ func GetApplicationStats() map[string]*ApplicationStats {
 	return result
 }
 
-// GetDestinationsForApp returns all destinations for a specific application
-func GetDestinationsForApp(processName string) []string {
-	appStatsObj, ok := stats.ApplicationStats.Load(processName)
-	if !ok {
-		return []string{}
+// AppBandwidth is a point-in-time snapshot of an application's traffic totals
+type AppBandwidth struct {
+	Key                string // key used in the application stats map
+	ProcessName        string
+	ProcessPath        string
+	ProcessID          uint32
+	TotalBytes         uint64
+	TotalPackets       uint64
+	Destinations       int64 // Distinct remote IPs this session
+	DestinationsCapped bool  // Destinations stopped counting at maxUniqueDestinations
+}
+
+// TopTalkers returns the n applications with the highest total bytes, sorted descending.
+// A non-positive n returns every application.
+func TopTalkers(n int) []AppBandwidth {
+	talkers := []AppBandwidth{}
+
+	stats.ApplicationStats.Range(func(key, value interface{}) bool {
+		app := value.(*ApplicationStats)
+		talkers = append(talkers, AppBandwidth{
+			Key:                key.(string),
+			ProcessName:        app.ProcessName,
+			ProcessPath:        app.ProcessPath,
+			ProcessID:          app.ProcessID,
+			TotalBytes:         app.TotalBytes.Load(),
+			TotalPackets:       app.TotalPackets.Load(),
+			Destinations:       app.UniqueDestinations(),
+			DestinationsCapped: app.UniqueDestinationsCapped(),
+		})
+		return true
+	})
+
+	sort.Slice(talkers, func(i, j int) bool {
+		return talkers[i].TotalBytes > talkers[j].TotalBytes
+	})
+
+	if n > 0 && len(talkers) > n {
+		talkers = talkers[:n]
 	}
 
+	return talkers
+}
+
+// DestinationTraffic is the traffic an application exchanged with one
+// destination, across this session and earlier ones
+type DestinationTraffic struct {
+	Destination string    `json:"destination"` // IP or domain
+	ReverseHost string    `json:"reverse_host,omitempty"`
+	Country     string    `json:"country,omitempty"` // ISO country code, from the GeoIP databases
+	Packets     uint64    `json:"packets"`
+	Bytes       uint64    `json:"bytes"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// GetDestinationsForApp returns the destinations of the application with the
+// given key, most bytes first. Totals stored in the database under the same
+// key are combined with the traffic seen since the last save, so destinations
+// evicted from memory are included.
+func GetDestinationsForApp(key string) []DestinationTraffic {
+	destinations := []DestinationTraffic{}
+
+	appStatsObj, ok := stats.ApplicationStats.Load(key)
+	if !ok {
+		return destinations
+	}
 	appStats := appStatsObj.(*ApplicationStats)
-	destinations := []string{}
+	processName := appStats.ProcessName
+
+	// Hold off saves so no count is in both the stored totals and the
+	// unsaved remainder, or in neither
+	appStats.saveMutex.Lock()
+	defer appStats.saveMutex.Unlock()
+
+	index := make(map[string]int)
+	if store != nil {
+		stored, err := store.GetDestinationsForApp(key)
+		if err != nil {
+			LogError("Failed to load destinations for %s: %v", processName, err)
+		}
+		for _, dest := range stored {
+			index[dest.Destination] = len(destinations)
+			destinations = append(destinations, DestinationTraffic{
+				Destination: dest.Destination,
+				ReverseHost: dest.ReverseHost,
+				Country:     dest.Country,
+				Packets:     dest.PacketCount,
+				Bytes:       dest.ByteCount,
+				FirstSeen:   dest.FirstSeen,
+				LastSeen:    dest.LastSeen,
+			})
+		}
+	}
+
+	// Saved counts are already in the stored totals, so add only the rest
+	add := func(key string, dest *destinationStats) {
+		packets := dest.packets.Load() - dest.savedPackets
+		bytes := dest.bytes.Load() - dest.savedBytes
+		lastSeen := time.Unix(0, dest.lastSeen.Load())
 
+		i, ok := index[key]
+		if !ok {
+			i = len(destinations)
+			index[key] = i
+			destinations = append(destinations, DestinationTraffic{
+				Destination: key,
+				Country:     dest.country,
+				FirstSeen:   dest.firstSeen,
+				LastSeen:    lastSeen,
+			})
+		}
+		d := &destinations[i]
+		d.Packets += packets
+		d.Bytes += bytes
+		if dest.firstSeen.Before(d.FirstSeen) {
+			d.FirstSeen = dest.firstSeen
+		}
+		if lastSeen.After(d.LastSeen) {
+			d.LastSeen = lastSeen
+		}
+	}
+	appStats.evictedMutex.Lock()
+	for _, e := range appStats.evictedDestinations {
+		add(e.key, e.dest)
+	}
+	appStats.evictedMutex.Unlock()
 	appStats.Destinations.Range(func(key, value interface{}) bool {
-		destinations = append(destinations, key.(string))
+		add(key.(string), value.(*destinationStats))
 		return true
 	})
 
+	for i := range destinations {
+		if name := ReverseName(destinations[i].Destination); name != "" {
+			destinations[i].ReverseHost = name
+		}
+	}
+	sort.SliceStable(destinations, func(i, j int) bool {
+		return destinations[i].Bytes > destinations[j].Bytes
+	})
 	return destinations
 }
 
-// SaveAllStatsToDB saves all statistics to the database
+// GetDestinationNamesForApp returns just the destinations of
+// GetDestinationsForApp, most bytes first
+func GetDestinationNamesForApp(key string) []string {
+	destinations := GetDestinationsForApp(key)
+	names := make([]string, len(destinations))
+	for i, dest := range destinations {
+		names[i] = dest.Destination
+	}
+	return names
+}
+
+// SaveAllStatsToDB saves all statistics to the database. Calls are serialized,
+// so a save requested while another is running waits for it to finish.
 func SaveAllStatsToDB() {
+	saveAllMutex.Lock()
+	defer saveAllMutex.Unlock()
+
+	packetsSinceSave.Store(0)
 	LogInfo("Saving all application statistics to database...")
 
 	// Count how many apps we're saving
@@ -144,6 +1014,9 @@ func SaveAllStatsToDB() {
 		return true
 	})
 
+	// Interface counters are cheap and useful even without attributed traffic
+	saveInterfaceStatsToDB()
+
 	if appCount == 0 {
 		LogInfo("No application statistics to save")
 		return
@@ -190,145 +1063,404 @@ func SaveAllStatsToDB() {
 	LogInfo("Statistics saved to database: %d successful, %d failed", successCount, failureCount)
 }
 
-// saveAppStatsToDB saves a single application's statistics to the database
+// saveAppStatsToDB adds the counts an application has accumulated since its
+// last save to the totals stored in the database
 func saveAppStatsToDB(appStats *ApplicationStats) {
 	if appStats == nil {
 		LogError("Cannot save nil application stats")
 		return
 	}
 
-	// Skip if no packets were recorded for this app
-	if appStats.TotalPackets.Load() == 0 {
-		return
-	}
-
 	// Check if database is initialized
-	if !database.IsInitialized() {
+	if store == nil {
 		LogError("Cannot save stats for %s: database not initialized", appStats.ProcessName)
 		return
 	}
 
-	LogDebug("Saving stats for application: %s (PID: %d)", appStats.ProcessName, appStats.ProcessID)
+	// Only one save per application at a time, so each delta is written once
+	appStats.saveMutex.Lock()
+	defer appStats.saveMutex.Unlock()
 
-	// Convert destinations map to JSON array
-	destinations := []string{}
-	appStats.Destinations.Range(func(key, value interface{}) bool {
-		destinations = append(destinations, key.(string))
-		return true
-	})
+	// Snapshot the direction counters first; a packet counted between the
+	// loads is then at most in the totals, never only in a direction
+	in := ProtocolCount{Packets: appStats.PacketsIn.Load(), Bytes: appStats.BytesIn.Load()}
+	out := ProtocolCount{Packets: appStats.PacketsOut.Load(), Bytes: appStats.BytesOut.Load()}
+	totalPackets := appStats.TotalPackets.Load()
+	totalBytes := appStats.TotalBytes.Load()
 
-	destinationsJSON, err := json.Marshal(destinations)
-	if err != nil {
-		LogError("Failed to marshal destinations to JSON: %v", err)
+	// Skip if nothing new was recorded for this app
+	executable := appStats.executable.Load()
+	if totalPackets == appStats.savedPackets && (executable == nil || appStats.executableSaved.Load()) {
 		return
 	}
 
-	// Create database stats object
+	LogDebug("Saving stats for application: %s (PID: %d)", appStats.ProcessName, appStats.ProcessID)
+
+	// Create database stats object holding the unsaved deltas
 	dbStats := &database.ApplicationStats{
 		ProcessID:    appStats.ProcessID,
 		ProcessName:  appStats.ProcessName,
 		ProcessPath:  appStats.ProcessPath,
-		TotalPackets: appStats.TotalPackets.Load(),
-		TotalBytes:   appStats.TotalBytes.Load(),
-		Destinations: string(destinationsJSON),
+		ServiceName:  appStats.ServiceName,
+		ProcessOwner: appStats.Owner,
+		CommandLine:  appStats.CommandLine(),
+		TotalPackets: totalPackets - appStats.savedPackets,
+		TotalBytes:   totalBytes - appStats.savedBytes,
+		PacketsIn:    in.Packets - appStats.savedIn.Packets,
+		BytesIn:      in.Bytes - appStats.savedIn.Bytes,
+		PacketsOut:   out.Packets - appStats.savedOut.Packets,
+		BytesOut:     out.Bytes - appStats.savedOut.Bytes,
+	}
+	if executable != nil {
+		dbStats.ExeSHA256 = executable.SHA256
+		dbStats.ExePublisher = executable.Publisher
+		dbStats.ExeError = executable.Error
 	}
 
 	// Save to database
-	if err := database.StoreAppStats(dbStats); err != nil {
+	if err := store.StoreAppStats(dbStats); err != nil {
 		LogError("Failed to save application stats to database: %v", err)
 		return
 	}
+	if executable != nil {
+		appStats.executableSaved.Store(true)
+	}
+	appStats.savedPackets = totalPackets
+	appStats.savedBytes = totalBytes
+	appStats.savedIn = in
+	appStats.savedOut = out
+	appStats.LastSavedToDB = time.Now()
 
 	// Save protocol statistics
-	appStats.PacketsByProtocol.Range(func(key, value interface{}) bool {
-		protocol := key.(string)
-		count := value.(uint64)
+	if appStats.savedByProtocol == nil {
+		appStats.savedByProtocol = make(map[string]ProtocolCount)
+	}
+	for protocol, count := range appStats.ProtocolCounts() {
+		saved := appStats.savedByProtocol[protocol]
+		if count.Packets == saved.Packets {
+			continue
+		}
 
-		if err := database.StoreProtocolStats(appStats.ProcessName, appStats.ProcessID, protocol, count); err != nil {
+		if err := store.StoreProtocolStats(appStats.key(), appStats.ProcessID, protocol,
+			count.Packets-saved.Packets, count.Bytes-saved.Bytes); err != nil {
 			LogError("Failed to save protocol stats for %s: %v", appStats.ProcessName, err)
+			continue
+		}
+		appStats.savedByProtocol[protocol] = count
+	}
+
+	saveDestinationsToDB(appStats)
+
+	LogDebug("Successfully saved stats for application: %s", appStats.ProcessName)
+}
+
+// saveDestinationsToDB writes the per-destination counts an application has
+// accumulated since its last save, those of evicted destinations first. The
+// caller must hold appStats.saveMutex.
+func saveDestinationsToDB(appStats *ApplicationStats) {
+	type pending struct {
+		dest           *destinationStats
+		packets, bytes uint64
+	}
+	var (
+		updates []database.AppDestination
+		saved   []pending
+	)
+
+	add := func(key string, dest *destinationStats) {
+		packets, bytes := dest.packets.Load(), dest.bytes.Load()
+		if packets == dest.savedPackets {
+			return
 		}
 
+		updates = append(updates, database.AppDestination{
+			Destination: key,
+			ReverseHost: ReverseName(key),
+			Country:     dest.country,
+			FirstSeen:   dest.firstSeen,
+			LastSeen:    time.Unix(0, dest.lastSeen.Load()),
+			PacketCount: packets - dest.savedPackets,
+			ByteCount:   bytes - dest.savedBytes,
+		})
+		saved = append(saved, pending{dest, packets, bytes})
+	}
+
+	// Evicted destinations stay listed until written, so a lookup meanwhile
+	// doesn't take them for new ones
+	appStats.evictedMutex.Lock()
+	evicted := appStats.evictedDestinations
+	appStats.evictedMutex.Unlock()
+	for _, e := range evicted {
+		add(e.key, e.dest)
+	}
+	appStats.Destinations.Range(func(key, value interface{}) bool {
+		add(key.(string), value.(*destinationStats))
 		return true
 	})
 
-	LogDebug("Successfully saved stats for application: %s", appStats.ProcessName)
+	if err := store.StoreAppDestinations(appStats.key(), appStats.ProcessID, updates); err != nil {
+		LogError("Failed to save destinations for %s: %v", appStats.ProcessName, err)
+		return
+	}
+	for _, p := range saved {
+		p.dest.savedPackets = p.packets
+		p.dest.savedBytes = p.bytes
+	}
+
+	if len(evicted) > 0 {
+		appStats.evictedMutex.Lock()
+		appStats.evictedDestinations = append([]evictedDestination(nil), appStats.evictedDestinations[len(evicted):]...)
+		appStats.evictedMutex.Unlock()
+	}
 }
 
-// LoadStatsFromDB loads existing statistics from the database
+// evictDestinations drops the least recently used destinations until the
+// application is back under three quarters of the cap, so eviction doesn't
+// run for every new destination. The evicted ones are kept aside until the
+// save it requests has written their counts.
+func evictDestinations(appStats *ApplicationStats) {
+	if !appStats.evicting.CompareAndSwap(false, true) {
+		return // Another packet is evicting; the cap is exceeded briefly
+	}
+	defer appStats.evicting.Store(false)
+
+	type candidate struct {
+		key      string
+		lastSeen int64
+	}
+	var candidates []candidate
+
+	appStats.Destinations.Range(func(key, value interface{}) bool {
+		candidates = append(candidates, candidate{key.(string), value.(*destinationStats).lastSeen.Load()})
+		return true
+	})
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastSeen < candidates[j].lastSeen
+	})
+
+	target := int64(maxDestinations) * 3 / 4
+	var evicted []evictedDestination
+	for _, c := range candidates {
+		if appStats.destinationCount.Load() <= target {
+			break
+		}
+		if value, ok := appStats.Destinations.LoadAndDelete(c.key); ok {
+			appStats.destinationCount.Add(-1)
+			evicted = append(evicted, evictedDestination{c.key, value.(*destinationStats)})
+		}
+	}
+	if len(evicted) == 0 {
+		return
+	}
+
+	appStats.evictedMutex.Lock()
+	appStats.evictedDestinations = append(appStats.evictedDestinations, evicted...)
+	appStats.evictedMutex.Unlock()
+	appStats.partialDestinations.Store(true)
+	requestSave()
+	LogDebug("Evicted %d least recently used destinations of %s from memory", len(evicted), appStats.ProcessName)
+}
+
+// LoadStatsFromDB loads existing statistics from the database. Only the
+// loadAppLimit most recently active applications are read; the others are
+// loaded when they next send traffic.
 func LoadStatsFromDB() {
 	LogInfo("Loading statistics from database...")
 
 	// Check if database is initialized
-	if !database.IsInitialized() {
+	if store == nil {
 		LogError("Cannot load stats: database not initialized")
 		return
 	}
 
 	// Load application stats
-	appStats, err := database.GetAllAppStats()
+	appStats, err := store.GetAppStats(database.AppStatsByLastSeen, loadAppLimit)
 	if err != nil {
 		LogError("Failed to load application statistics: %v", err)
 		return
 	}
 
-	count := 0
-	// Process each app's stats
+	// Process each app's stats. Rows for earlier PIDs of the same executable
+	// are merged into one in-memory entry under the key packets use, so a
+	// restart continues the same entry instead of starting a second one.
+	loadedApps := make(map[string]bool)
 	for _, dbAppStat := range appStats {
-		appStat := &ApplicationStats{
-			ProcessID:     dbAppStat.ProcessID,
-			ProcessName:   dbAppStat.ProcessName,
-			ProcessPath:   dbAppStat.ProcessPath,
-			LastSavedToDB: time.Now(),
+		key := database.AppKey(dbAppStat.ProcessName, dbAppStat.ProcessPath, dbAppStat.ServiceName)
+		loadedApps[key] = true
+		value, loaded := stats.ApplicationStats.LoadOrStore(key, &ApplicationStats{
+			ProcessID:          dbAppStat.ProcessID,
+			ProcessName:        dbAppStat.ProcessName,
+			ProcessPath:        dbAppStat.ProcessPath,
+			ServiceName:        dbAppStat.ServiceName,
+			Owner:              dbAppStat.ProcessOwner,
+			LastSavedToDB:      time.Now(),
+			previousByProtocol: make(map[string]ProtocolCount),
+		})
+		appStat := value.(*ApplicationStats)
+		if !loaded && dbAppStat.CommandLine != "" {
+			// Shown until a process of the app is seen this session
+			appStat.process.Store(&processOrigin{commandLine: dbAppStat.CommandLine})
 		}
+		if !loaded && (dbAppStat.ExeSHA256 != "" || dbAppStat.ExeError != "") {
+			// Shown until this session's check replaces it
+			appStat.executable.Store(&process.ExecutableInfo{
+				SHA256:    dbAppStat.ExeSHA256,
+				Publisher: dbAppStat.ExePublisher,
+				Error:     dbAppStat.ExeError,
+			})
+			appStat.executableSaved.Store(true)
+		}
+		loadAppStatsRow(appStat, dbAppStat)
+	}
 
-		// Set packet and byte counts
-		appStat.TotalPackets.Store(dbAppStat.TotalPackets)
-		appStat.TotalBytes.Store(dbAppStat.TotalBytes)
+	appStatsPartial.Store(loadAppLimit > 0 && len(loadedApps) >= loadAppLimit)
+	LogInfo("Loaded statistics for %d applications from database", len(loadedApps))
+}
 
-		// Load protocol stats for this app
-		protocols, err := database.GetProtocolStatsForApp(dbAppStat.ID)
-		if err != nil {
-			LogError("Failed to load protocol stats for %s: %v", dbAppStat.ProcessName, err)
-		} else {
-			// Store protocol stats
-			for _, proto := range protocols {
-				appStat.PacketsByProtocol.Store(proto.Protocol, proto.PacketCount)
-			}
+// loadAppHistory adds the stored totals of an application that wasn't loaded
+// at start, once it is seen again
+func loadAppHistory(appStat *ApplicationStats) {
+	rows, err := store.GetAppStatsForKey(appStat.key())
+	if err != nil {
+		errorLimiter.log(LogError, "stats:load", "Failed to load statistics of %s: %v", appStat.ProcessName, err)
+		return
+	}
+	for _, row := range rows {
+		loadAppStatsRow(appStat, row)
+	}
+	if len(rows) > 0 {
+		LogDebug("Loaded stored statistics of %s", appStat.ProcessName)
+	}
+}
+
+// loadAppStatsRow adds one database row of an application to its lifetime
+// baseline and seeds its destinations
+func loadAppStatsRow(appStat *ApplicationStats, dbAppStat *database.ApplicationStats) {
+	// Load protocol stats for this app
+	protocols, err := store.GetProtocolStatsForApp(dbAppStat.ID)
+	if err != nil {
+		LogError("Failed to load protocol stats for %s: %v", dbAppStat.ProcessName, err)
+	}
+
+	appStat.historyMutex.Lock()
+	if appStat.previousByProtocol == nil {
+		// Traffic arrived before the database was read; keep its session counts
+		appStat.previousByProtocol = make(map[string]ProtocolCount)
+	}
+
+	// Session counters start at zero; database totals are the lifetime baseline
+	appStat.previousPackets += dbAppStat.TotalPackets
+	appStat.previousBytes += dbAppStat.TotalBytes
+	appStat.previousIn.Packets += dbAppStat.PacketsIn
+	appStat.previousIn.Bytes += dbAppStat.BytesIn
+	appStat.previousOut.Packets += dbAppStat.PacketsOut
+	appStat.previousOut.Bytes += dbAppStat.BytesOut
+
+	for _, proto := range protocols {
+		previous := appStat.previousByProtocol[proto.Protocol]
+		appStat.previousByProtocol[proto.Protocol] = ProtocolCount{
+			Packets: previous.Packets + proto.PacketCount,
+			Bytes:   previous.Bytes + proto.ByteCount,
 		}
+	}
+	appStat.historyMutex.Unlock()
 
-		// Load destinations
-		if dbAppStat.Destinations != "" {
-			var destinations []string
-			if err := json.Unmarshal([]byte(dbAppStat.Destinations), &destinations); err != nil {
-				LogError("Failed to parse destinations for %s: %v", dbAppStat.ProcessName, err)
-			} else {
-				// Store destinations in map
-				for _, dest := range destinations {
-					appStat.Destinations.Store(dest, true)
-				}
-			}
+	// Load the most recent destinations, up to the in-memory cap
+	loadDestinationsFromDB(appStat, dbAppStat.ID)
+}
+
+// loadDestinationsFromDB seeds an application's destinations from one of its
+// database rows so known destinations don't raise new destination alerts
+func loadDestinationsFromDB(appStat *ApplicationStats, appStatsID int64) {
+	limit := 0
+	if maxDestinations > 0 {
+		// Ask for one extra row to tell whether the cap cut the list short
+		limit = maxDestinations - int(appStat.destinationCount.Load()) + 1
+		if limit <= 1 {
+			appStat.partialDestinations.Store(true)
+			return
+		}
+	}
+
+	destinations, err := store.GetAppDestinations(appStatsID, limit)
+	if err != nil {
+		LogError("Failed to load destinations for %s: %v", appStat.ProcessName, err)
+		appStat.partialDestinations.Store(true)
+		return
+	}
+	if limit > 0 && len(destinations) == limit {
+		destinations = destinations[:limit-1]
+		appStat.partialDestinations.Store(true)
+	}
+
+	for _, d := range destinations {
+		dest := &destinationStats{firstSeen: d.FirstSeen, country: d.Country}
+		dest.lastSeen.Store(d.LastSeen.UnixNano())
+		if _, loaded := appStat.Destinations.LoadOrStore(d.Destination, dest); !loaded {
+			appStat.destinationCount.Add(1)
 		}
+	}
+}
 
-		// Store in memory
-		stats.ApplicationStats.Store(dbAppStat.ProcessName, appStat)
-		count++
+// StartStatsSaver loads the totals stored by previous runs and then saves
+// statistics to the database every save interval until ctx is cancelled.
+// The database must already be initialized.
+func StartStatsSaver(ctx context.Context) {
+	LoadStatsFromDB()
+
+	stopped := make(chan struct{})
+	statsSaverStopped = stopped
+	go saveStatsPeriodically(ctx, stopped)
+}
+
+// startStatsSaver starts the saver with a context that stopStatsSaver cancels
+func startStatsSaver() {
+	if statsSaverCancel != nil {
+		return
 	}
+	statsMutex.Lock()
+	statsClosed = false
+	statsMutex.Unlock()
 
-	LogInfo("Loaded statistics for %d applications from database", count)
+	ctx, cancel := context.WithCancel(context.Background())
+	statsSaverCancel = cancel
+	StartStatsSaver(ctx)
 }
 
-// saveStatsPeriodically saves statistics to the database at regular intervals
-func saveStatsPeriodically() {
-	// Wait a moment for the database to initialize
-	time.Sleep(2 * time.Second)
+// stopStatsSaver stops the periodic saver and waits for an in-progress save to finish
+func stopStatsSaver() {
+	if statsSaverCancel == nil {
+		return
+	}
+	statsSaverCancel()
+	<-statsSaverStopped
+	statsSaverCancel = nil
+}
 
-	// Load existing stats from database
-	LoadStatsFromDB()
+// saveStatsPeriodically saves statistics to the database at regular intervals
+func saveStatsPeriodically(ctx context.Context, stopped chan<- struct{}) {
+	defer close(stopped)
 
 	ticker := time.NewTicker(saveInterval)
 	defer ticker.Stop()
+	lastSave := time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-saveRequests:
+			// Packet-triggered saves never run closer together than the
+			// minimum interval; the timer picks up anything skipped
+			if time.Since(lastSave) < minSaveInterval {
+				continue
+			}
+			ticker.Reset(saveInterval)
+		}
+		lastSave = time.Now()
 
-	for range ticker.C {
 		// Check if we have any stats to save
 		hasStats := false
 		stats.ApplicationStats.Range(func(key, value interface{}) bool {
@@ -340,5 +1472,7 @@ func saveStatsPeriodically() {
 			LogDebug("Periodic saving of statistics to database...")
 			SaveAllStatsToDB()
 		}
+		purgeHostCache(time.Now())
+		purgeReverseCache(time.Now())
 	}
 }
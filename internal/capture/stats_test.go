@@ -0,0 +1,315 @@
+package capture
+
+import (
+	"reflect"
+	"testing"
+
+	"grip/internal/process"
+)
+
+// TestSaveRestartLoad saves statistics, restarts by forgetting them and
+// loading the database, and saves again, checking that the stored and
+// in-memory lifetime totals count every packet exactly once
+func TestSaveRestartLoad(t *testing.T) {
+	db := useTestStore(t)
+	const path = `C:\Apps\agent.exe`
+	key := appKey(path, "")
+
+	steps := []struct {
+		name    string
+		restart bool
+		pid     uint32
+		packets uint64
+		want    uint64 // Lifetime packets after the save
+	}{
+		{"first save", false, 100, 3, 3},
+		{"save without traffic", false, 100, 0, 3},
+		{"save more traffic", false, 100, 2, 5},
+		{"restart", true, 100, 0, 5},
+		{"traffic after restart", false, 100, 4, 9},
+		{"restart with a new PID", true, 200, 1, 10},
+		{"restart again", true, 200, 0, 10},
+	}
+	for _, step := range steps {
+		if step.restart {
+			resetStats()
+			LoadStatsFromDB()
+		}
+		info := &process.ProcessInfo{ProcessID: step.pid, ProcessName: "agent.exe", ExecutablePath: path}
+		for i := uint64(0); i < step.packets; i++ {
+			updateAppStats(info, "TCP", "outgoing", 1, 100, "192.0.2.1", "192.0.2.1", "", "443")
+		}
+		SaveAllStatsToDB()
+
+		rows, err := db.GetAppStatsForKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var packets, bytes, out, tcp uint64
+		for _, row := range rows {
+			packets += row.TotalPackets
+			bytes += row.TotalBytes
+			out += row.PacketsOut
+			protocols, err := db.GetProtocolStatsForApp(row.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, protocol := range protocols {
+				if protocol.Protocol == "TCP" {
+					tcp += protocol.PacketCount
+				}
+			}
+		}
+		if packets != step.want || bytes != step.want*100 || out != step.want || tcp != step.want {
+			t.Errorf("%s: stored %d packets, %d bytes, %d outgoing, %d TCP; want %d packets of 100 bytes",
+				step.name, packets, bytes, out, tcp, step.want)
+		}
+
+		destinations, err := db.GetDestinationsForApp(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if step.want > 0 && (len(destinations) != 1 || destinations[0].PacketCount != step.want) {
+			t.Errorf("%s: stored destinations %+v, want 192.0.2.1 with %d packets", step.name, destinations, step.want)
+		}
+
+		value, ok := stats.ApplicationStats.Load(key)
+		if !ok {
+			t.Fatalf("%s: %s not in memory", step.name, key)
+		}
+		if got := value.(*ApplicationStats).LifetimePackets(); got != step.want {
+			t.Errorf("%s: lifetime packets = %d, want %d", step.name, got, step.want)
+		}
+	}
+}
+
+// TestDestinationEviction contacts more destinations than the cap and checks
+// that the least recently used one is evicted rather than the new one dropped,
+// that its counts are kept until saved, and that only destinations neither in
+// memory nor stored raise an alert
+func TestDestinationEviction(t *testing.T) {
+	db := useTestStore(t)
+	previous := maxDestinations
+	maxDestinations = 4
+	t.Cleanup(func() { maxDestinations = previous })
+	alerts := captureAlerts(t, AlertNewDestination)
+
+	const path = `C:\Apps\agent.exe`
+	key := appKey(path, "")
+	info := &process.ProcessInfo{ProcessID: 100, ProcessName: "agent.exe", ExecutablePath: path}
+	// Order the destinations by hand, as the clock may not tick between packets
+	var tick int64
+	contact := func(destination string) {
+		updateAppStats(info, "TCP", "outgoing", 1, 100, destination, destination, "", "443")
+		tick++
+		value, _ := stats.ApplicationStats.Load(key)
+		dest, _ := value.(*ApplicationStats).Destinations.Load(destination)
+		dest.(*destinationStats).lastSeen.Store(tick)
+	}
+	for _, destination := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3", "192.0.2.4", "192.0.2.1"} {
+		contact(destination)
+	}
+
+	steps := []struct {
+		destination string
+		wantEvicted string
+		wantAlert   bool
+	}{
+		{"192.0.2.5", "192.0.2.2", true},
+		{"192.0.2.2", "192.0.2.3", false}, // Evicted but not saved yet
+		{"192.0.2.6", "192.0.2.4", true},
+		{"192.0.2.3", "192.0.2.1", false}, // Saved before it came back
+	}
+	for i, step := range steps {
+		if i == 3 {
+			SaveAllStatsToDB()
+		}
+		*alerts = nil
+		contact(step.destination)
+
+		value, _ := stats.ApplicationStats.Load(key)
+		appStats := value.(*ApplicationStats)
+		if _, ok := appStats.Destinations.Load(step.destination); !ok {
+			t.Errorf("%s: not tracked", step.destination)
+		}
+		if _, ok := appStats.Destinations.Load(step.wantEvicted); ok {
+			t.Errorf("%s: %s still in memory", step.destination, step.wantEvicted)
+		}
+		if got := appStats.destinationCount.Load(); got != 4 {
+			t.Errorf("%s: %d destinations in memory, want 4", step.destination, got)
+		}
+		if gotAlert := len(*alerts) == 1 && (*alerts)[0].Destination == step.destination; gotAlert != step.wantAlert || len(*alerts) > 1 {
+			t.Errorf("%s: alerts %+v, want alert %v", step.destination, *alerts, step.wantAlert)
+		}
+	}
+
+	// Evicted traffic shows before and after the save
+	want := map[string]uint64{"192.0.2.1": 2, "192.0.2.2": 2, "192.0.2.3": 2, "192.0.2.4": 1, "192.0.2.5": 1, "192.0.2.6": 1}
+	check := func(when string) {
+		got := make(map[string]uint64)
+		for _, dest := range GetDestinationsForApp(key) {
+			got[dest.Destination] = dest.Packets
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: destinations %v, want %v", when, got, want)
+		}
+	}
+	check("before saving")
+	SaveAllStatsToDB()
+	check("after saving")
+
+	stored, err := db.GetDestinationsForApp(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != len(want) {
+		t.Errorf("stored %d destinations, want %d", len(stored), len(want))
+	}
+}
+
+// TestCountDestination counts the remote IPs of an application, listed under
+// their address or a hostname, and checks that each IP is counted once and
+// that counting stops at the cap
+func TestCountDestination(t *testing.T) {
+	useTestStore(t)
+	previous := maxUniqueDestinations
+	maxUniqueDestinations = 3
+	t.Cleanup(func() { maxUniqueDestinations = previous })
+
+	const path = `C:\Apps\agent.exe`
+	info := &process.ProcessInfo{ProcessID: 100, ProcessName: "agent.exe", ExecutablePath: path}
+	tests := []struct {
+		remoteIP, destination string
+		want                  int64
+		wantCapped            bool
+	}{
+		{"93.184.216.34", "93.184.216.34", 1, false},
+		{"93.184.216.34", "example.com", 1, false},
+		{"::ffff:93.184.216.34", "example.com", 1, false},
+		{"2001:db8::1", "2001:db8::1", 2, false},
+		{"", "", 2, false},
+		{"not an address", "", 2, false},
+		{"198.51.100.1", "198.51.100.1", 3, false},
+		{"198.51.100.2", "198.51.100.2", 3, true},
+		{"93.184.216.34", "example.com", 3, true},
+	}
+	for _, tt := range tests {
+		updateAppStats(info, "TCP", "outgoing", 1, 100, tt.remoteIP, tt.destination, "", "443")
+
+		value, _ := stats.ApplicationStats.Load(appKey(path, ""))
+		app := value.(*ApplicationStats)
+		if got, capped := app.UniqueDestinations(), app.UniqueDestinationsCapped(); got != tt.want || capped != tt.wantCapped {
+			t.Errorf("after %q as %q: %d destinations, capped %v; want %d, %v",
+				tt.remoteIP, tt.destination, got, capped, tt.want, tt.wantCapped)
+		}
+	}
+}
+
+// TestSavePacketThreshold counts packets with no save running and checks
+// that a save is requested every time the threshold is reached, not only the
+// first time
+func TestSavePacketThreshold(t *testing.T) {
+	useTestStore(t)
+	previous := savePacketThreshold
+	savePacketThreshold = 3
+	t.Cleanup(func() { savePacketThreshold = previous })
+	packetsSinceSave.Store(0)
+
+	requested := func() bool {
+		select {
+		case <-saveRequests:
+			return true
+		default:
+			return false
+		}
+	}
+	requested()
+
+	for i, want := range []bool{false, false, true, false, false, true, false, false, true} {
+		UpdateGlobalStats(100, "outgoing")
+		if got := requested(); got != want {
+			t.Errorf("packet %d: save requested %v, want %v", i+1, got, want)
+		}
+	}
+
+	// A save resets the count
+	UpdateGlobalStats(100, "outgoing")
+	SaveAllStatsToDB()
+	for i, want := range []bool{false, false, true} {
+		UpdateGlobalStats(100, "outgoing")
+		if got := requested(); got != want {
+			t.Errorf("packet %d after saving: save requested %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+// TestGetStatisticsIsLive checks that the statistics returned before packets
+// are counted show them, as they are shared rather than copied
+func TestGetStatisticsIsLive(t *testing.T) {
+	useTestStore(t)
+	live := GetStatistics()
+
+	tests := []struct {
+		bytes                  uint64
+		wantPackets, wantBytes uint64
+	}{
+		{100, 1, 100},
+		{1500, 2, 1600},
+		{0, 3, 1600},
+	}
+	for _, tt := range tests {
+		UpdateGlobalStats(tt.bytes, "outgoing")
+		if live.TotalPackets.Load() != tt.wantPackets || live.TotalBytes.Load() != tt.wantBytes {
+			t.Errorf("after %d bytes: %d packets, %d bytes, want %d, %d", tt.bytes,
+				live.TotalPackets.Load(), live.TotalBytes.Load(), tt.wantPackets, tt.wantBytes)
+		}
+		if again := GetStatistics(); again != live {
+			t.Errorf("GetStatistics returned %p, then %p", live, again)
+		}
+	}
+}
+
+func TestGetDirectionCounts(t *testing.T) {
+	useTestStore(t)
+
+	packets := []struct {
+		bytes     uint64
+		direction string
+	}{
+		{100, "incoming"},
+		{1500, "outgoing"},
+		{60, "incoming"},
+		{40, "internal"},
+	}
+	for _, packet := range packets {
+		UpdateGlobalStats(packet.bytes, packet.direction)
+	}
+
+	tests := []struct {
+		direction string
+		want      ProtocolCount
+	}{
+		{"incoming", ProtocolCount{Packets: 2, Bytes: 160}},
+		{"outgoing", ProtocolCount{Packets: 1, Bytes: 1500}},
+		{"internal", ProtocolCount{Packets: 1, Bytes: 40}},
+		{"external", ProtocolCount{}}, // Listed even without traffic
+	}
+	counts := GetDirectionCounts()
+	if len(counts) != len(Directions) {
+		t.Errorf("GetDirectionCounts() has %d directions, want %d: %+v", len(counts), len(Directions), counts)
+	}
+	var packetsSum, bytesSum uint64
+	for _, tt := range tests {
+		got, ok := counts[tt.direction]
+		if !ok || got != tt.want {
+			t.Errorf("%s: %+v (present %v), want %+v", tt.direction, got, ok, tt.want)
+		}
+		packetsSum += got.Packets
+		bytesSum += got.Bytes
+	}
+	// Every packet has exactly one direction
+	if packetsSum != stats.TotalPackets.Load() || bytesSum != stats.TotalBytes.Load() {
+		t.Errorf("directions sum to %d packets, %d bytes, totals are %d, %d",
+			packetsSum, bytesSum, stats.TotalPackets.Load(), stats.TotalBytes.Load())
+	}
+}
@@ -0,0 +1,161 @@
+package capture
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"grip/internal/database"
+)
+
+// storageQueueSize bounds the records waiting for the storage writer. Once it
+// is full, new records are dropped so a slow disk never stalls capture. The
+// in-memory statistics are updated before queueing, so they stay exact.
+const storageQueueSize = 16384
+
+// storageItem is a packet row, a finished flow or the late attribution of a
+// connection queued for the database. Queueing attributions behind the rows
+// they update makes sure those rows are written first.
+type storageItem struct {
+	packet      database.PacketRecord
+	flow        *Flow                           // set for a finished flow instead of a packet
+	attribution *database.ConnectionAttribution // set for an attribution instead of a packet
+}
+
+var (
+	storageQueue   chan storageItem
+	storageDone    chan struct{}
+	storageMutex   sync.RWMutex
+	storageDropped atomic.Uint64
+
+	// Packets considered and skipped by SampleRate
+	sampleCounter atomic.Uint64
+	sampleSkipped atomic.Uint64
+
+	// storageBlocking makes producers wait for room instead of dropping, for
+	// capture files where nothing is lost by reading more slowly
+	storageBlocking bool
+)
+
+// GetStorageDropped returns how many packet rows and flows were not stored
+// because the storage queue was full
+func GetStorageDropped() uint64 {
+	return storageDropped.Load()
+}
+
+// GetSampleSkipped returns how many packet rows were not stored because of
+// the sample rate
+func GetSampleSkipped() uint64 {
+	return sampleSkipped.Load()
+}
+
+// startStorageWriter starts the goroutine that writes queued records
+func startStorageWriter(blocking bool) {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
+
+	if storageQueue != nil {
+		return
+	}
+	storageBlocking = blocking
+	storageQueue = make(chan storageItem, storageQueueSize)
+	storageDone = make(chan struct{})
+	go runStorageWriter(storageQueue, storageDone)
+}
+
+// stopStorageWriter stops accepting records and waits until the queued ones
+// are written. Capture must have stopped so nothing is queued concurrently.
+func stopStorageWriter() {
+	storageMutex.Lock()
+	queue, done := storageQueue, storageDone
+	storageQueue = nil
+	storageMutex.Unlock()
+
+	if queue == nil {
+		return
+	}
+
+	close(queue)
+	<-done
+}
+
+// queueStorage hands a record to the storage writer without blocking the
+// capture loop, or stores it directly if the writer isn't running
+func queueStorage(item storageItem) {
+	storageMutex.RLock()
+	defer storageMutex.RUnlock()
+
+	if storageQueue == nil {
+		writeStorageItem(item)
+		return
+	}
+
+	if storageBlocking {
+		storageQueue <- item
+		return
+	}
+	select {
+	case storageQueue <- item:
+	default:
+		storageDropped.Add(1)
+		errorLimiter.log(LogWarning, "storage backlog",
+			"Database writes are falling behind; dropping packet records until the storage queue drains")
+	}
+}
+
+// queuePacketRecord queues a packet row, sampled and aggregated per second if configured
+func queuePacketRecord(record database.PacketRecord) {
+	if !samplePacket(&record) {
+		sampleSkipped.Add(1)
+		return
+	}
+	queueStorage(storageItem{packet: record})
+}
+
+// samplePacket reports whether a packet row is stored under SampleRate. A
+// stored row stands for the SampleRate packets it was picked from, so the
+// totals summed from packet_logs and the rollups stay close to the real
+// ones. Packets to blocklisted destinations are always stored as themselves.
+func samplePacket(record *database.PacketRecord) bool {
+	rate := flowConfig.SampleRate
+	if rate <= 1 || record.Flagged {
+		return true
+	}
+	if (sampleCounter.Add(1)-1)%rate != 0 {
+		return false
+	}
+	record.PacketCount = rate
+	record.TotalBytes = uint64(record.Length) * rate
+	return true
+}
+
+// queueFlow queues a finished flow; it must already be removed from activeFlows
+func queueFlow(flow *Flow) {
+	queueStorage(storageItem{flow: flow})
+}
+
+// runStorageWriter writes queued records until the queue is closed
+func runStorageWriter(queue <-chan storageItem, done chan<- struct{}) {
+	defer close(done)
+
+	for item := range queue {
+		writeStorageItem(item)
+	}
+}
+
+func writeStorageItem(item storageItem) {
+	switch {
+	case item.flow != nil:
+		storeFlow(item.flow)
+	case item.attribution != nil:
+		if flowConfig.AggregatePackets {
+			flushConnectionBuckets(item.attribution)
+		}
+		if _, err := store.AttributeConnection(*item.attribution); err != nil {
+			errorLimiter.log(LogError, "store attribution", "Error updating process of stored packets: %v", err)
+		}
+	case flowConfig.AggregatePackets:
+		aggregatePacket(item.packet)
+	default:
+		StorePacketRecord(item.packet)
+	}
+}
@@ -0,0 +1,114 @@
+package capture
+
+import (
+	"fmt"
+	"time"
+)
+
+// ThresholdConfig controls per-application bandwidth alerts
+type ThresholdConfig struct {
+	BytesPerInterval uint64        // Alert when an app sends/receives more than this per interval (0 disables)
+	Interval         time.Duration // Length of the measurement window
+}
+
+var (
+	thresholdConfig ThresholdConfig
+
+	thresholdDone    chan struct{}
+	thresholdStopped chan struct{}
+)
+
+// ConfigureThresholds sets the bandwidth alert options. It must be called before capture starts.
+func ConfigureThresholds(config ThresholdConfig) {
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+	thresholdConfig = config
+}
+
+// startThresholdMonitor starts the bandwidth monitor if a threshold is configured
+func startThresholdMonitor() {
+	if thresholdDone != nil || thresholdConfig.BytesPerInterval == 0 {
+		return
+	}
+	thresholdDone = make(chan struct{})
+	thresholdStopped = make(chan struct{})
+	go monitorThresholds(thresholdConfig, thresholdDone, thresholdStopped)
+}
+
+// stopThresholdMonitor stops the bandwidth monitor
+func stopThresholdMonitor() {
+	if thresholdDone != nil {
+		close(thresholdDone)
+		<-thresholdStopped
+		thresholdDone = nil
+	}
+}
+
+// monitorThresholds checks the bandwidth thresholds once per interval
+func monitorThresholds(config ThresholdConfig, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(config.Interval)
+	defer ticker.Stop()
+
+	state := newThresholdState()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		state.check(config)
+	}
+}
+
+// thresholdState holds each application's byte count at the previous check
+// and whether it was over the threshold then
+type thresholdState struct {
+	previous map[string]uint64
+	exceeded map[string]bool
+}
+
+// newThresholdState starts from the current totals, so traffic from before
+// the monitor started doesn't count towards the first interval
+func newThresholdState() *thresholdState {
+	state := &thresholdState{
+		previous: make(map[string]uint64),
+		exceeded: make(map[string]bool),
+	}
+	for app, appStats := range GetApplicationStats() {
+		state.previous[app] = appStats.TotalBytes.Load()
+	}
+	return state
+}
+
+// check compares each application's byte count with the previous check and
+// raises an alert when an application crosses the threshold. An app only
+// alerts again after it has dropped back below the threshold for an interval.
+func (s *thresholdState) check(config ThresholdConfig) {
+	for app, appStats := range GetApplicationStats() {
+		total := appStats.TotalBytes.Load()
+		delta := total - s.previous[app]
+		s.previous[app] = total
+
+		if delta < config.BytesPerInterval {
+			s.exceeded[app] = false
+			continue
+		}
+		if s.exceeded[app] {
+			continue
+		}
+		s.exceeded[app] = true
+
+		emitAlert(Alert{
+			Kind:      AlertBandwidthThreshold,
+			App:       appStats.ProcessName,
+			ProcessID: appStats.ProcessID,
+			Bytes:     delta,
+			Window:    config.Interval.String(),
+			Message: fmt.Sprintf("%s (PID %d) transferred %d bytes in %v (threshold %d)",
+				appStats.ProcessName, appStats.ProcessID, delta, config.Interval, config.BytesPerInterval),
+		})
+	}
+}
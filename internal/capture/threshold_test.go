@@ -0,0 +1,71 @@
+package capture
+
+import (
+	"testing"
+	"time"
+
+	"grip/internal/process"
+)
+
+// captureAlerts collects the alerts of kind emitted until the test ends
+func captureAlerts(t *testing.T, kind string) *[]Alert {
+	t.Helper()
+
+	alertHandlersMu.Lock()
+	previous := alertHandlers
+	alertHandlersMu.Unlock()
+	t.Cleanup(func() {
+		alertHandlersMu.Lock()
+		alertHandlers = previous
+		alertHandlersMu.Unlock()
+	})
+
+	var alerts []Alert
+	RegisterAlertHandler(func(alert Alert) {
+		if alert.Kind == kind {
+			alerts = append(alerts, alert)
+		}
+	})
+	return &alerts
+}
+
+// TestThresholdDeltas counts traffic for an application between checks and
+// checks that an alert is raised for the bytes of the interval that crosses
+// the threshold, and only again once the application has dropped below it
+func TestThresholdDeltas(t *testing.T) {
+	useTestStore(t)
+	alerts := captureAlerts(t, AlertBandwidthThreshold)
+	config := ThresholdConfig{BytesPerInterval: 1000, Interval: time.Minute}
+	info := &process.ProcessInfo{ProcessID: 100, ProcessName: "agent.exe", ExecutablePath: `C:\Apps\agent.exe`}
+
+	// Traffic from before the monitor starts doesn't count
+	updateAppStats(info, "TCP", "outgoing", 1, 5000, "192.0.2.1", "192.0.2.1", "", "443")
+	state := newThresholdState()
+
+	tests := []struct {
+		name      string
+		bytes     uint64 // Transferred during the interval
+		wantAlert uint64 // Bytes reported by the alert, 0 for none
+	}{
+		{"below", 500, 0},
+		{"crosses", 1500, 1500},
+		{"still above", 2000, 0},
+		{"drops below", 200, 0},
+		{"exactly the threshold", 1000, 1000},
+		{"idle", 0, 0},
+	}
+	for _, tt := range tests {
+		*alerts = nil
+		if tt.bytes > 0 {
+			updateAppStats(info, "TCP", "outgoing", 1, tt.bytes, "192.0.2.1", "192.0.2.1", "", "443")
+		}
+		state.check(config)
+
+		switch {
+		case tt.wantAlert == 0 && len(*alerts) > 0:
+			t.Errorf("%s: alerted %+v, want no alert", tt.name, *alerts)
+		case tt.wantAlert > 0 && (len(*alerts) != 1 || (*alerts)[0].Bytes != tt.wantAlert || (*alerts)[0].App != "agent.exe"):
+			t.Errorf("%s: alerted %+v, want one alert for agent.exe with %d bytes", tt.name, *alerts, tt.wantAlert)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package capture
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// sniHostTTL is how long a server name seen in a ClientHello stays attached to its IP
+const sniHostTTL = 10 * time.Minute
+
+var (
+	// Destination ports whose first data packet is checked for a TLS ClientHello
+	sniPorts      = map[uint16]bool{443: true}
+	sniPortsMutex sync.RWMutex
+)
+
+// SetSNIPorts replaces the set of destination ports inspected for TLS server names
+func SetSNIPorts(ports []uint16) {
+	set := make(map[uint16]bool, len(ports))
+	for _, port := range ports {
+		set[port] = true
+	}
+
+	sniPortsMutex.Lock()
+	sniPorts = set
+	sniPortsMutex.Unlock()
+}
+
+// observeSNI extracts the server name from an outgoing TLS ClientHello and
+// returns it, remembering it for later packets to the same IP
+func observeSNI(packet gopacket.Packet, dst string) string {
+	tcp, ok := packet.TransportLayer().(*layers.TCP)
+	if !ok || len(tcp.Payload) == 0 {
+		return ""
+	}
+
+	sniPortsMutex.RLock()
+	inspect := sniPorts[uint16(tcp.DstPort)]
+	sniPortsMutex.RUnlock()
+	if !inspect {
+		return ""
+	}
+
+	serverName, ok := parseClientHelloSNI(tcp.Payload)
+	if !ok {
+		return ""
+	}
+
+	rememberHost(dst, serverName, sniHostTTL)
+	return serverName
+}
+
+// parseClientHelloSNI returns the host_name from the server_name extension of a
+// TLS ClientHello. The whole ClientHello must be contained in data; anything
+// truncated or malformed is rejected rather than reassembled.
+func parseClientHelloSNI(data []byte) (string, bool) {
+	// TLS record header: type, version, length
+	if len(data) < 5 || data[0] != 0x16 {
+		return "", false
+	}
+	recordLen := int(data[3])<<8 | int(data[4])
+	if len(data) < 5+recordLen {
+		return "", false
+	}
+	record := data[5 : 5+recordLen]
+
+	// Handshake header: type, 24-bit length
+	if len(record) < 4 || record[0] != 0x01 {
+		return "", false
+	}
+	helloLen := int(record[1])<<16 | int(record[2])<<8 | int(record[3])
+	if len(record) < 4+helloLen {
+		return "", false
+	}
+	hello := record[4 : 4+helloLen]
+
+	// Skip client version and random
+	pos := 2 + 32
+	if len(hello) < pos+1 {
+		return "", false
+	}
+
+	// Session ID
+	pos += 1 + int(hello[pos])
+	if len(hello) < pos+2 {
+		return "", false
+	}
+
+	// Cipher suites
+	pos += 2 + (int(hello[pos])<<8 | int(hello[pos+1]))
+	if len(hello) < pos+1 {
+		return "", false
+	}
+
+	// Compression methods
+	pos += 1 + int(hello[pos])
+	if len(hello) < pos+2 {
+		return "", false
+	}
+
+	// Extensions
+	extensionsLen := int(hello[pos])<<8 | int(hello[pos+1])
+	pos += 2
+	if len(hello) < pos+extensionsLen {
+		return "", false
+	}
+	extensions := hello[pos : pos+extensionsLen]
+
+	for len(extensions) >= 4 {
+		extType := int(extensions[0])<<8 | int(extensions[1])
+		extLen := int(extensions[2])<<8 | int(extensions[3])
+		if len(extensions) < 4+extLen {
+			return "", false
+		}
+		ext := extensions[4 : 4+extLen]
+		extensions = extensions[4+extLen:]
+
+		if extType != 0x0000 {
+			continue
+		}
+
+		// server_name_list
+		if len(ext) < 2 {
+			return "", false
+		}
+		listLen := int(ext[0])<<8 | int(ext[1])
+		if len(ext) < 2+listLen {
+			return "", false
+		}
+		list := ext[2 : 2+listLen]
+
+		for len(list) >= 3 {
+			nameType := list[0]
+			nameLen := int(list[1])<<8 | int(list[2])
+			if len(list) < 3+nameLen {
+				return "", false
+			}
+			name := list[3 : 3+nameLen]
+			list = list[3+nameLen:]
+
+			if nameType == 0 && nameLen > 0 {
+				return strings.ToLower(strings.TrimSuffix(string(name), ".")), true
+			}
+		}
+		return "", false
+	}
+
+	return "", false
+}
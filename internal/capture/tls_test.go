@@ -0,0 +1,57 @@
+package capture
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+)
+
+// clientHello returns the first TLS record crypto/tls sends to serverName
+func clientHello(t *testing.T, serverName string) []byte {
+	t.Helper()
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		// Fails once the pipe is closed, as no server answers
+		tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true}).Handshake()
+		client.Close()
+	}()
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(server, header); err != nil {
+		t.Fatal(err)
+	}
+	record := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(server, record); err != nil {
+		t.Fatal(err)
+	}
+	return append(header, record...)
+}
+
+func TestParseClientHelloSNI(t *testing.T) {
+	hello := clientHello(t, "WWW.Example.com")
+	appData := append([]byte{0x17}, hello[1:]...)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		want   string
+		wantOK bool
+	}{
+		{"client hello", hello, "www.example.com", true},
+		{"followed by more data", append(append([]byte{}, hello...), 0x17, 0x03, 0x03), "www.example.com", true},
+		{"no server name", clientHello(t, "192.0.2.1"), "", false},
+		{"truncated", hello[:len(hello)-10], "", false},
+		{"record header only", hello[:5], "", false},
+		{"application data", appData, "", false},
+		{"empty", nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := parseClientHelloSNI(tt.data)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: parseClientHelloSNI = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
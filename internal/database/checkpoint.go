@@ -0,0 +1,64 @@
+package database
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Checkpoint copies the write-ahead log into the database file and truncates
+// it. It waits for writers up to the busy timeout; readers that are still
+// using the log leave it partly checkpointed until the next run.
+func (db *DB) Checkpoint() error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	var busy, logFrames, checkpointed int
+	err := db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed)
+	if err != nil {
+		return fmt.Errorf("failed to checkpoint the write-ahead log: %v", err)
+	}
+	if busy != 0 {
+		return fmt.Errorf("write-ahead log checkpoint incomplete: %d of %d frames copied while readers were active",
+			checkpointed, logFrames)
+	}
+	return nil
+}
+
+// startCheckpoints checkpoints the database every interval until Close
+func (db *DB) startCheckpoints(interval time.Duration) {
+	db.checkpointDone = make(chan struct{})
+	db.checkpointStopped = make(chan struct{})
+	go db.checkpointPeriodically(interval, db.checkpointDone, db.checkpointStopped)
+}
+
+func (db *DB) checkpointPeriodically(interval time.Duration, done <-chan struct{}, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := db.Checkpoint(); err != nil {
+				log.Printf("%v", err)
+			}
+		}
+	}
+}
+
+// Close stops periodic checkpoints and closes the database. SQLite
+// checkpoints the log itself when the last connection closes.
+func (db *DB) Close() error {
+	db.closeOnce.Do(func() {
+		if db.checkpointDone != nil {
+			close(db.checkpointDone)
+			<-db.checkpointStopped
+		}
+	})
+	return db.DB.Close()
+}
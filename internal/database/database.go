@@ -2,58 +2,178 @@ package database
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
+// MemoryPath opens a private in-memory database, mainly for tests
+const MemoryPath = ":memory:"
 
-type NetworkInterface struct {
-	ID          int64
-	Name        string
-	Description string
-	CreatedAt   time.Time
+// DB is an open grip database and the SQLite implementation of Store. Its
+// methods store and query captured traffic; the package-level functions of
+// the same names use the default database opened by InitDatabase.
+type DB struct {
+	*sql.DB
+	path string
+
+	// Periodic WAL checkpoints, stopped by Close
+	checkpointDone    chan struct{}
+	checkpointStopped chan struct{}
+	closeOnce         sync.Once
 }
 
-type PacketRecord struct {
-	ID          int64
-	Timestamp   time.Time
-	DeviceID    int64
-	SrcIP       string
-	SrcPort     string
-	DstIP       string
-	DstPort     string
-	Protocol    string
-	Length      int
-	ProcessID   uint32
-	ProcessName string
-	ProcessPath string
-	Direction   string // "incoming", "outgoing", "internal", or "external"
+type NetworkInterface struct {
+	ID           int64
+	Name         string
+	Description  string
+	FriendlyName string // Adapter name such as "Ethernet", if known
+	MAC          string
+	Addresses    string // Comma-separated IP addresses when last seen
+	CreatedAt    time.Time
 }
 
-// ApplicationStats represents statistics for a specific application
-type ApplicationStats struct {
+type PacketRecord struct {
 	ID           int64
+	Timestamp    time.Time
+	DeviceID     int64
+	SrcIP        string
+	SrcPort      string
+	DstIP        string
+	DstPort      string
+	SrcMAC       string // Link-layer addresses, e.g. "00:1a:2b:3c:4d:5e"; empty without an Ethernet header
+	DstMAC       string
+	VLAN         uint16 // 802.1Q VLAN ID, 0 for untagged packets
+	DstHost      string // Hostname learned from DNS, if known
+	ReverseHost  string // PTR name of the remote peer, filled in when reading
+	Protocol     string
+	Length       int
+	PacketCount  uint64 // Packets this row stands for; more than 1 when packets are aggregated per second
+	TotalBytes   uint64 // Bytes of all PacketCount packets
 	ProcessID    uint32
 	ProcessName  string
 	ProcessPath  string
-	TotalPackets uint64
-	TotalBytes   uint64
-	LastUpdated  time.Time
-	Destinations string // JSON array of destinations
-	FirstSeen    time.Time
-	LastSeen     time.Time
+	ServiceName  string // Services hosted by svchost.exe, if any
+	ProcessOwner string // DOMAIN\user account the process runs as, if known
+	Direction    string // "incoming", "outgoing", "internal", "external", "multicast" or "broadcast"
+	Scope        string // Remote peer: "local", "lan" or "internet"
+	GeoIP        string // Country and ASN of an external remote peer, e.g. "US AS15169 Google LLC"
+	Payload      []byte // Leading application payload bytes, if payload capture is enabled
+	Flagged      bool   // Destination was on the blocklist
+}
+
+// ApplicationStats represents statistics for a specific application
+type ApplicationStats struct {
+	ID           int64     `json:"id"`
+	ProcessID    uint32    `json:"process_id"`
+	ProcessName  string    `json:"process_name"`
+	ProcessPath  string    `json:"process_path,omitempty"`
+	ServiceName  string    `json:"service_name,omitempty"`
+	ProcessOwner string    `json:"process_owner,omitempty"`
+	ExeSHA256    string    `json:"exe_sha256,omitempty"`
+	ExePublisher string    `json:"exe_publisher,omitempty"` // Authenticode signer, empty if unsigned
+	ExeError     string    `json:"exe_error,omitempty"`     // Why the executable couldn't be hashed
+	CommandLine  string    `json:"command_line,omitempty"`  // Of the most recent process seen, e.g. which script python.exe ran
+	TotalPackets uint64    `json:"total_packets"`
+	TotalBytes   uint64    `json:"total_bytes"`
+	PacketsIn    uint64    `json:"packets_in"` // Incoming traffic; internal and external traffic is in neither direction
+	BytesIn      uint64    `json:"bytes_in"`
+	PacketsOut   uint64    `json:"packets_out"`
+	BytesOut     uint64    `json:"bytes_out"`
+	LastUpdated  time.Time `json:"-"`
+	Destinations string    `json:"destinations,omitempty"` // JSON array of destinations, set by StreamAppStats
+	FirstSeen    time.Time `json:"first_seen"`
+	LastSeen     time.Time `json:"last_seen"`
 }
 
 // ProtocolStat represents protocol statistics for an application
 type ProtocolStat struct {
 	Protocol    string
 	PacketCount uint64
+	ByteCount   uint64
+}
+
+// Config holds the options used to open the database
+type Config struct {
+	// Path of the database file; empty for netmonitor.db under
+	// %LOCALAPPDATA%\GripNetMonitor. Its directory is created if needed.
+	Path string
+
+	// How long a write waits for another connection's lock before failing
+	BusyTimeout time.Duration
+
+	// SQLite synchronous mode: OFF, NORMAL, FULL or EXTRA. NORMAL is safe with
+	// WAL except that a power loss can undo the last commits; FULL also
+	// survives that at the cost of an fsync per commit. OFF leaves flushing to
+	// the OS entirely, so a crash of the machine can corrupt the database.
+	Synchronous string
+
+	// SQLite journal mode: WAL, DELETE or TRUNCATE. WAL lets status, export
+	// and the dashboard read while capture writes; the rollback journals block
+	// readers during every write but keep everything in the one file.
+	JournalMode string
+
+	// Page cache per connection in KiB
+	CacheSizeKB int
+
+	// How often the write-ahead log is checkpointed and truncated, so the
+	// -wal file doesn't grow without bound during long captures; 0 leaves
+	// checkpoints to SQLite, which never shrinks the file
+	CheckpointInterval time.Duration
+}
+
+// DefaultConfig returns the configuration used when no options are given
+func DefaultConfig() Config {
+	return Config{
+		BusyTimeout:        5 * time.Second,
+		Synchronous:        "NORMAL",
+		JournalMode:        "WAL",
+		CacheSizeKB:        2000,
+		CheckpointInterval: 5 * time.Minute,
+	}
+}
+
+// Validate checks the options without touching the file system
+func (c Config) Validate() error {
+	if c.BusyTimeout < 0 {
+		return fmt.Errorf("busy timeout must not be negative, got %v", c.BusyTimeout)
+	}
+	switch strings.ToUpper(c.Synchronous) {
+	case "OFF", "NORMAL", "FULL", "EXTRA":
+	default:
+		return fmt.Errorf("synchronous mode must be OFF, NORMAL, FULL or EXTRA, got %q", c.Synchronous)
+	}
+	switch strings.ToUpper(c.JournalMode) {
+	case "WAL", "DELETE", "TRUNCATE":
+	default:
+		return fmt.Errorf("journal mode must be WAL, DELETE or TRUNCATE, got %q", c.JournalMode)
+	}
+	if c.CacheSizeKB <= 0 {
+		return fmt.Errorf("cache size must be positive, got %d KiB", c.CacheSizeKB)
+	}
+	if c.CheckpointInterval < 0 {
+		return fmt.Errorf("checkpoint interval must not be negative, got %v", c.CheckpointInterval)
+	}
+	return nil
+}
+
+// NetworkShareError is returned by InitDatabase when the database is on a
+// network share. SQLite's locking is unreliable over SMB, so concurrent
+// readers such as the dashboard can corrupt it. The database has been opened
+// when this error is returned; callers may warn and carry on.
+type NetworkShareError struct {
+	Path string
+}
+
+func (e *NetworkShareError) Error() string {
+	return fmt.Sprintf("database %s is on a network share; SQLite locking is unreliable there and the file may be corrupted", e.Path)
 }
 
 func getDefaultDBPath() (string, error) {
@@ -62,54 +182,148 @@ func getDefaultDBPath() (string, error) {
 		return "", fmt.Errorf("LOCALAPPDATA environment variable not set")
 	}
 
-	dbDir := filepath.Join(appData, "GripNetMonitor")
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create database directory: %v", err)
+	return filepath.Join(appData, "GripNetMonitor", "netmonitor.db"), nil
+}
+
+// resolvePath returns the absolute database path for a configured one
+func resolvePath(path string) (string, error) {
+	if path == "" {
+		return getDefaultDBPath()
 	}
 
-	return filepath.Join(dbDir, "netmonitor.db"), nil
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid database path: %v", err)
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return "", fmt.Errorf("database path %s is a directory", path)
+	}
+	return path, nil
 }
 
-func InitDatabase() error {
-	dbPath, err := getDefaultDBPath()
-	if err != nil {
-		return fmt.Errorf("failed to get database path: %v", err)
+// Open opens or creates the database and brings its schema up to date. A
+// *NetworkShareError is returned together with the open database when it
+// lives on a network share.
+func Open(config Config) (*DB, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 
-	db, err = sql.Open("sqlite3", dbPath+"?_journal_mode=WAL")
+	path := config.Path
+	if path != MemoryPath {
+		var err error
+		if path, err = resolvePath(path); err != nil {
+			return nil, fmt.Errorf("failed to get database path: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create database directory: %v", err)
+		}
+	}
+
+	// Connection options in the DSN apply to every pooled connection. Times are
+	// stored in UTC and read back in the local time zone.
+	dsn := fmt.Sprintf("%s?_journal_mode=%s&_busy_timeout=%d&_synchronous=%s&_cache_size=-%d&_loc=auto",
+		path, strings.ToUpper(config.JournalMode), config.BusyTimeout.Milliseconds(),
+		strings.ToUpper(config.Synchronous), config.CacheSizeKB)
+	sqlDB, err := sql.Open("sqlite3", dsn)
 	if err != nil {
-		return fmt.Errorf("error opening database: %v", err)
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	db := &DB{DB: sqlDB, path: path}
+	if path == MemoryPath {
+		// Every connection to :memory: is a separate database
+		sqlDB.SetMaxOpenConns(1)
 	}
 
-	// Set pragmas for better performance
-	if _, err := db.Exec(`PRAGMA synchronous = NORMAL`); err != nil {
-		return fmt.Errorf("error setting synchronous pragma: %v", err)
+	if err := db.initialize(); err != nil {
+		sqlDB.Close()
+		return nil, err
 	}
-	if _, err := db.Exec(`PRAGMA cache_size = -2000`); err != nil {
-		return fmt.Errorf("error setting cache size: %v", err)
+
+	log.Printf("Database initialized at: %s", path)
+	if path != MemoryPath && strings.EqualFold(config.JournalMode, "WAL") && config.CheckpointInterval > 0 {
+		db.startCheckpoints(config.CheckpointInterval)
+	}
+	if path != MemoryPath && isNetworkPath(path) {
+		return db, &NetworkShareError{Path: path}
+	}
+	return db, nil
+}
+
+// initialize creates or migrates the schema
+func (db *DB) initialize() error {
+	// Databases created before version tracking are at version 0 too, so
+	// tell them apart from a new file before creating the tables
+	existing, err := db.tableExists("packet_logs")
+	if err != nil {
+		return err
+	}
+	version, err := db.checkSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if err := db.createMigrationsTable(); err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %v", err)
 	}
 
 	// Create tables if they don't exist
-	if err := createTables(); err != nil {
+	if err := db.createTables(); err != nil {
 		return fmt.Errorf("error creating tables: %v", err)
 	}
 
 	// Perform database migrations if needed
-	if err := migrateDatabase(); err != nil {
+	if err := db.migrate(version, !existing); err != nil {
 		return fmt.Errorf("error migrating database: %v", err)
 	}
-
-	log.Printf("Database initialized at: %s", dbPath)
 	return nil
 }
 
-func createTables() error {
+// OpenReadOnly opens the existing database at path (empty for the default
+// location) without write access, so tools can read it while the service is
+// capturing. Tables are neither created nor migrated.
+func OpenReadOnly(path string) (*DB, error) {
+	path, err := resolvePath(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database path: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("database not found at %s: %v", path, err)
+	}
+
+	sqlDB, err := sql.Open("sqlite3", "file:"+filepath.ToSlash(path)+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("error opening database: %v", err)
+	}
+
+	db := &DB{DB: sqlDB, path: path}
+	if _, err := db.checkSchemaVersion(); err != nil {
+		sqlDB.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Path returns the file path of the database
+func (db *DB) Path() string {
+	return db.path
+}
+
+func (db *DB) createTables() error {
 	// Create network_interfaces table
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS network_interfaces (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			name TEXT NOT NULL,
 			description TEXT,
+			friendly_name TEXT,
+			mac TEXT,
+			addresses TEXT,
+			updated_at TIMESTAMP,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			UNIQUE(name, description)
 		)
@@ -134,6 +348,18 @@ func createTables() error {
 			process_name TEXT,
 			process_path TEXT,
 			direction TEXT,
+			dst_host TEXT,
+			service_name TEXT,
+			geoip TEXT,
+			scope TEXT,
+			payload TEXT,
+			flagged INTEGER NOT NULL DEFAULT 0,
+			process_owner TEXT,
+			packet_count INTEGER NOT NULL DEFAULT 1,
+			total_bytes INTEGER NOT NULL DEFAULT 0,
+			src_mac TEXT,
+			dst_mac TEXT,
+			vlan INTEGER,
 			FOREIGN KEY (device_id) REFERENCES network_interfaces (id)
 		)
 	`)
@@ -155,128 +381,102 @@ func createTables() error {
 		}
 	}
 
-	// Create application statistics tables
-	if err := createAppStatsTables(); err != nil {
-		return fmt.Errorf("error creating application stats tables: %v", err)
+	// Create per-interface statistics table
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS interface_stats (
+			interface_id INTEGER PRIMARY KEY,
+			total_packets INTEGER NOT NULL DEFAULT 0,
+			total_bytes INTEGER NOT NULL DEFAULT 0,
+			packets_received INTEGER NOT NULL DEFAULT 0,
+			packets_dropped INTEGER NOT NULL DEFAULT 0,
+			packets_if_dropped INTEGER NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (interface_id) REFERENCES network_interfaces (id)
+		)
+	`)
+	if err != nil {
+		return err
 	}
 
-	return nil
-}
-
-func migrateDatabase() error {
-	// Check if direction column exists
-	var count int
-	err := db.QueryRow(`
-		SELECT COUNT(*) FROM pragma_table_info('packet_logs') 
-		WHERE name = 'direction'
-	`).Scan(&count)
-
+	// Create DNS cache table so learned hostnames survive restarts
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS dns_cache (
+			ip TEXT PRIMARY KEY,
+			hostname TEXT NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		)
+	`)
 	if err != nil {
-		return fmt.Errorf("error checking for direction column: %v", err)
+		return err
 	}
 
-	// Add the direction column if it doesn't exist
-	if count == 0 {
-		log.Printf("Adding direction column to packet_logs table")
-		_, err := db.Exec(`ALTER TABLE packet_logs ADD COLUMN direction TEXT`)
-		if err != nil {
-			return fmt.Errorf("error adding direction column: %v", err)
-		}
+	// Create reverse DNS table so PTR lookups aren't repeated after restarts
+	if err := db.createReverseDNSTable(); err != nil {
+		return err
 	}
 
-	// Check if we need to migrate from device to device_id
-	err = db.QueryRow(`
-		SELECT COUNT(*) FROM pragma_table_info('packet_logs') 
-		WHERE name = 'device'
-	`).Scan(&count)
-
+	// Create flows table aggregating packets per connection
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS flows (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			device_id INTEGER NOT NULL,
+			src_ip TEXT NOT NULL,
+			src_port TEXT NOT NULL,
+			dst_ip TEXT NOT NULL,
+			dst_port TEXT NOT NULL,
+			dst_host TEXT,
+			protocol TEXT NOT NULL,
+			direction TEXT,
+			scope TEXT,
+			packet_count INTEGER NOT NULL DEFAULT 0,
+			byte_count INTEGER NOT NULL DEFAULT 0,
+			first_seen TIMESTAMP NOT NULL,
+			last_seen TIMESTAMP NOT NULL,
+			tcp_flags TEXT,
+			process_id INTEGER,
+			process_name TEXT,
+			process_path TEXT,
+			attribution TEXT,
+			FOREIGN KEY (device_id) REFERENCES network_interfaces (id)
+		)
+	`)
 	if err != nil {
-		return fmt.Errorf("error checking for device column: %v", err)
+		return err
 	}
 
-	// If device column exists, we need to migrate to device_id
-	if count > 0 {
-		log.Printf("Migrating from device to device_id in packet_logs table")
-
-		// First, add the device_id column if it doesn't exist
-		_, err = db.Exec(`ALTER TABLE packet_logs ADD COLUMN device_id INTEGER`)
-		if err != nil {
-			return fmt.Errorf("error adding device_id column: %v", err)
-		}
-
-		// Create a temporary table for migration
-		_, err = db.Exec(`
-			CREATE TABLE IF NOT EXISTS packet_logs_new (
-				id INTEGER PRIMARY KEY AUTOINCREMENT,
-				timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-				device_id INTEGER NOT NULL,
-				src_ip TEXT NOT NULL,
-				src_port TEXT NOT NULL,
-				dst_ip TEXT NOT NULL,
-				dst_port TEXT NOT NULL,
-				protocol TEXT NOT NULL,
-				length INTEGER NOT NULL,
-				process_id INTEGER,
-				process_name TEXT,
-				process_path TEXT,
-				direction TEXT,
-				FOREIGN KEY (device_id) REFERENCES network_interfaces (id)
-			)
-		`)
-		if err != nil {
-			return fmt.Errorf("error creating new packet_logs table: %v", err)
-		}
-
-		// Move data to the new table, ignoring records that can't be migrated
-		_, err = db.Exec(`
-			INSERT INTO packet_logs_new (
-				timestamp, device_id, src_ip, src_port, dst_ip, dst_port,
-				protocol, length, process_id, process_name, process_path, direction
-			)
-			SELECT 
-				p.timestamp, 
-				COALESCE(n.id, 0) AS device_id, 
-				p.src_ip, p.src_port, p.dst_ip, p.dst_port,
-				p.protocol, p.length, p.process_id, p.process_name, p.process_path, p.direction
-			FROM packet_logs p
-			LEFT JOIN network_interfaces n ON p.device = n.name
-		`)
-		if err != nil {
-			return fmt.Errorf("error migrating data to new table: %v", err)
-		}
-
-		// Replace old table with new one
-		_, err = db.Exec(`DROP TABLE packet_logs`)
-		if err != nil {
-			return fmt.Errorf("error dropping old table: %v", err)
-		}
-
-		_, err = db.Exec(`ALTER TABLE packet_logs_new RENAME TO packet_logs`)
-		if err != nil {
-			return fmt.Errorf("error renaming new table: %v", err)
+	flowIndexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_flows_first_seen ON flows(first_seen)`,
+		`CREATE INDEX IF NOT EXISTS idx_flows_process_name ON flows(process_name)`,
+		// Covering indexes for the top-N queries
+		`CREATE INDEX IF NOT EXISTS idx_flows_last_seen_process ON flows(last_seen, process_name, byte_count, packet_count)`,
+		`CREATE INDEX IF NOT EXISTS idx_flows_last_seen_dst ON flows(last_seen, direction, dst_ip, dst_host, byte_count, packet_count)`,
+		`CREATE INDEX IF NOT EXISTS idx_flows_last_seen_port ON flows(last_seen, protocol, dst_port, byte_count, packet_count)`,
+	}
+	for _, idx := range flowIndexes {
+		if _, err := db.Exec(idx); err != nil {
+			return fmt.Errorf("error creating index: %v", err)
 		}
+	}
 
-		// Recreate indexes
-		indexes := []string{
-			`CREATE INDEX IF NOT EXISTS idx_timestamp ON packet_logs(timestamp)`,
-			`CREATE INDEX IF NOT EXISTS idx_protocol ON packet_logs(protocol)`,
-			`CREATE INDEX IF NOT EXISTS idx_process_name ON packet_logs(process_name)`,
-			`CREATE INDEX IF NOT EXISTS idx_device_id ON packet_logs(device_id)`,
-		}
+	// Create application statistics tables
+	if err := db.createAppStatsTables(); err != nil {
+		return fmt.Errorf("error creating application stats tables: %v", err)
+	}
 
-		for _, idx := range indexes {
-			if _, err := db.Exec(idx); err != nil {
-				return fmt.Errorf("error recreating index: %v", err)
-			}
-		}
+	// Create hourly and daily rollups of the packets
+	if err := db.createRollupTables(); err != nil {
+		return fmt.Errorf("error creating rollup tables: %v", err)
+	}
 
-		log.Printf("Migration from device to device_id completed")
+	// Create the table of listening sockets
+	if err := db.createListenerTable(); err != nil {
+		return fmt.Errorf("error creating listeners table: %v", err)
 	}
 
 	return nil
 }
 
-func StoreInterface(iface NetworkInterface) (int64, error) {
+func (db *DB) StoreInterface(iface NetworkInterface) (int64, error) {
 	// Check if interface already exists
 	var exists bool
 	var id int64
@@ -324,14 +524,23 @@ func StoreInterface(iface NetworkInterface) (int64, error) {
 	return id, nil
 }
 
-func StorePacket(packet PacketRecord) error {
-	_, err := db.Exec(`
+// StorePacket inserts a packet row. A record without a PacketCount stands for
+// the single packet it describes.
+func (db *DB) StorePacket(packet PacketRecord) error {
+	if packet.PacketCount == 0 {
+		packet.PacketCount = 1
+		packet.TotalBytes = uint64(packet.Length)
+	}
+
+	_, err := db.execWrite(`
 		INSERT INTO packet_logs (
 			timestamp, device_id, src_ip, src_port, dst_ip, dst_port,
-			protocol, length, process_id, process_name, process_path, direction
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			protocol, length, process_id, process_name, process_path, direction,
+			dst_host, service_name, geoip, scope, payload, flagged, process_owner,
+			packet_count, total_bytes, src_mac, dst_mac, vlan
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`,
-		packet.Timestamp,
+		dbTime(packet.Timestamp),
 		packet.DeviceID,
 		packet.SrcIP,
 		packet.SrcPort,
@@ -343,6 +552,19 @@ func StorePacket(packet PacketRecord) error {
 		sql.NullString{String: packet.ProcessName, Valid: packet.ProcessName != ""},
 		sql.NullString{String: packet.ProcessPath, Valid: packet.ProcessPath != ""},
 		sql.NullString{String: packet.Direction, Valid: packet.Direction != ""},
+		sql.NullString{String: packet.DstHost, Valid: packet.DstHost != ""},
+		sql.NullString{String: packet.ServiceName, Valid: packet.ServiceName != ""},
+		sql.NullString{String: packet.GeoIP, Valid: packet.GeoIP != ""},
+		sql.NullString{String: packet.Scope, Valid: packet.Scope != ""},
+		// Base64 keeps the column readable in SQLite tools
+		sql.NullString{String: base64.StdEncoding.EncodeToString(packet.Payload), Valid: len(packet.Payload) > 0},
+		packet.Flagged,
+		sql.NullString{String: packet.ProcessOwner, Valid: packet.ProcessOwner != ""},
+		packet.PacketCount,
+		packet.TotalBytes,
+		sql.NullString{String: packet.SrcMAC, Valid: packet.SrcMAC != ""},
+		sql.NullString{String: packet.DstMAC, Valid: packet.DstMAC != ""},
+		sql.NullInt32{Int32: int32(packet.VLAN), Valid: packet.VLAN > 0},
 	)
 
 	if err != nil {
@@ -351,14 +573,196 @@ func StorePacket(packet PacketRecord) error {
 	return err
 }
 
-func CloseDatabase() {
-	if db != nil {
-		db.Close()
+// FlowRecord is an aggregated connection as stored in the flows table
+type FlowRecord struct {
+	ID          int64     `json:"id"`
+	DeviceID    int64     `json:"device_id"`
+	SrcIP       string    `json:"src_ip"`
+	SrcPort     string    `json:"src_port"`
+	DstIP       string    `json:"dst_ip"`
+	DstPort     string    `json:"dst_port"`
+	DstHost     string    `json:"dst_host,omitempty"`
+	ReverseHost string    `json:"reverse_host,omitempty"` // PTR name of the remote peer, filled in when reading
+	Protocol    string    `json:"protocol"`
+	Direction   string    `json:"direction"`
+	Scope       string    `json:"scope,omitempty"` // Remote peer: "local", "lan" or "internet"
+	PacketCount uint64    `json:"packet_count"`
+	ByteCount   uint64    `json:"byte_count"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	TCPFlags    string    `json:"tcp_flags,omitempty"` // e.g. "SYN|ACK|FIN"
+	ProcessID   uint32    `json:"process_id,omitempty"`
+	ProcessName string    `json:"process_name,omitempty"`
+	ProcessPath string    `json:"process_path,omitempty"`
+	Attribution string    `json:"attribution,omitempty"` // "attributed", "pending" or "failed"; empty when no lookup applies
+}
+
+// StoreFlow inserts a finished flow
+func (db *DB) StoreFlow(flow FlowRecord) error {
+	flow.ID = 0
+	_, err := db.UpsertFlow(flow)
+	return err
+}
+
+// UpsertFlow writes a flow and returns its row ID. A flow with an ID of zero is
+// inserted; otherwise the existing row is updated with the latest counters, so
+// long-lived connections can be checkpointed while they are still open.
+func (db *DB) UpsertFlow(flow FlowRecord) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	if flow.ID == 0 {
+		result, err := db.execWrite(`
+			INSERT INTO flows (
+				device_id, src_ip, src_port, dst_ip, dst_port, dst_host,
+				protocol, direction, scope, packet_count, byte_count, first_seen, last_seen,
+				tcp_flags, process_id, process_name, process_path, attribution
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+			flow.DeviceID,
+			flow.SrcIP,
+			flow.SrcPort,
+			flow.DstIP,
+			flow.DstPort,
+			sql.NullString{String: flow.DstHost, Valid: flow.DstHost != ""},
+			flow.Protocol,
+			sql.NullString{String: flow.Direction, Valid: flow.Direction != ""},
+			sql.NullString{String: flow.Scope, Valid: flow.Scope != ""},
+			flow.PacketCount,
+			flow.ByteCount,
+			dbTime(flow.FirstSeen),
+			dbTime(flow.LastSeen),
+			sql.NullString{String: flow.TCPFlags, Valid: flow.TCPFlags != ""},
+			sql.NullInt32{Int32: int32(flow.ProcessID), Valid: flow.ProcessID > 0},
+			sql.NullString{String: flow.ProcessName, Valid: flow.ProcessName != ""},
+			sql.NullString{String: flow.ProcessPath, Valid: flow.ProcessPath != ""},
+			sql.NullString{String: flow.Attribution, Valid: flow.Attribution != ""},
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to store flow: %v", err)
+		}
+		return result.LastInsertId()
 	}
+
+	_, err := db.execWrite(`
+		UPDATE flows SET
+			dst_host = COALESCE(?, dst_host),
+			packet_count = ?,
+			byte_count = ?,
+			last_seen = ?,
+			tcp_flags = ?,
+			process_id = COALESCE(?, process_id),
+			process_name = COALESCE(?, process_name),
+			process_path = COALESCE(?, process_path),
+			attribution = COALESCE(?, attribution)
+		WHERE id = ?
+	`,
+		sql.NullString{String: flow.DstHost, Valid: flow.DstHost != ""},
+		flow.PacketCount,
+		flow.ByteCount,
+		dbTime(flow.LastSeen),
+		sql.NullString{String: flow.TCPFlags, Valid: flow.TCPFlags != ""},
+		sql.NullInt32{Int32: int32(flow.ProcessID), Valid: flow.ProcessID > 0},
+		sql.NullString{String: flow.ProcessName, Valid: flow.ProcessName != ""},
+		sql.NullString{String: flow.ProcessPath, Valid: flow.ProcessPath != ""},
+		sql.NullString{String: flow.Attribution, Valid: flow.Attribution != ""},
+		flow.ID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update flow %d: %v", flow.ID, err)
+	}
+	return flow.ID, nil
+}
+
+// ConnectionAttribution is the process of a connection, found after some of its
+// packets were stored without one
+type ConnectionAttribution struct {
+	SrcIP        string
+	SrcPort      string
+	DstIP        string
+	DstPort      string
+	Protocol     string
+	Direction    string
+	Since        time.Time // Capture time of the connection's first packet
+	Until        time.Time // Capture time of its last packet stored without a process
+	ProcessID    uint32
+	ProcessName  string
+	ProcessPath  string
+	ServiceName  string
+	ProcessOwner string
+}
+
+// AttributeConnection fills in the process of the connection's packet rows
+// and flow rows that were stored without one, and returns how many packet
+// rows were updated. Rows are matched by the connection's addresses, ports,
+// protocol and direction within its time range.
+func (db *DB) AttributeConnection(a ConnectionAttribution) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	var packets int64
+	err := retryWrite(func() error {
+		var err error
+		packets, err = db.attributeConnection(a)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to attribute connection %s:%s -> %s:%s: %v", a.SrcIP, a.SrcPort, a.DstIP, a.DstPort, err)
+	}
+	return packets, nil
+}
+
+// attributeConnection updates packet and flow rows in one transaction. Errors
+// are returned unwrapped so retryWrite can recognize lock conflicts.
+func (db *DB) attributeConnection(a ConnectionAttribution) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE packet_logs SET
+			process_id = ?, process_name = ?, process_path = ?, service_name = ?, process_owner = ?
+		WHERE timestamp >= ? AND timestamp <= ? AND process_id IS NULL
+		  AND src_ip = ? AND src_port = ? AND dst_ip = ? AND dst_port = ? AND protocol = ? AND direction = ?
+	`,
+		a.ProcessID,
+		sql.NullString{String: a.ProcessName, Valid: a.ProcessName != ""},
+		sql.NullString{String: a.ProcessPath, Valid: a.ProcessPath != ""},
+		sql.NullString{String: a.ServiceName, Valid: a.ServiceName != ""},
+		sql.NullString{String: a.ProcessOwner, Valid: a.ProcessOwner != ""},
+		dbTime(a.Since), dbTime(a.Until), a.SrcIP, a.SrcPort, a.DstIP, a.DstPort, a.Protocol, a.Direction,
+	)
+	if err != nil {
+		return 0, err
+	}
+	packets, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE flows SET
+			process_id = ?, process_name = ?, process_path = ?, attribution = 'attributed'
+		WHERE first_seen >= ? AND first_seen <= ? AND process_id IS NULL
+		  AND src_ip = ? AND src_port = ? AND dst_ip = ? AND dst_port = ? AND protocol = ? AND direction = ?
+	`,
+		a.ProcessID,
+		sql.NullString{String: a.ProcessName, Valid: a.ProcessName != ""},
+		sql.NullString{String: a.ProcessPath, Valid: a.ProcessPath != ""},
+		dbTime(a.Since), dbTime(a.Until), a.SrcIP, a.SrcPort, a.DstIP, a.DstPort, a.Protocol, a.Direction,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return packets, tx.Commit()
 }
 
 // Initialize application statistics tables
-func createAppStatsTables() error {
+func (db *DB) createAppStatsTables() error {
 	// Create application_stats table
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS application_stats (
@@ -366,13 +770,23 @@ func createAppStatsTables() error {
 			process_id INTEGER NOT NULL,
 			process_name TEXT NOT NULL,
 			process_path TEXT,
+			service_name TEXT,
+			process_owner TEXT,
+			exe_sha256 TEXT,
+			exe_publisher TEXT,
+			exe_error TEXT,
+			command_line TEXT,
 			total_packets INTEGER NOT NULL DEFAULT 0,
 			total_bytes INTEGER NOT NULL DEFAULT 0,
+			packets_in INTEGER NOT NULL DEFAULT 0,
+			bytes_in INTEGER NOT NULL DEFAULT 0,
+			packets_out INTEGER NOT NULL DEFAULT 0,
+			bytes_out INTEGER NOT NULL DEFAULT 0,
 			last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			destinations TEXT, -- JSON array
 			first_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			UNIQUE(process_name, process_id)
+			app_key TEXT NOT NULL,
+			UNIQUE(app_key, process_id)
 		)
 	`)
 	if err != nil {
@@ -386,6 +800,7 @@ func createAppStatsTables() error {
 			app_stats_id INTEGER NOT NULL,
 			protocol TEXT NOT NULL,
 			packet_count INTEGER NOT NULL DEFAULT 0,
+			byte_count INTEGER NOT NULL DEFAULT 0,
 			UNIQUE(app_stats_id, protocol),
 			FOREIGN KEY (app_stats_id) REFERENCES application_stats(id)
 		)
@@ -394,6 +809,10 @@ func createAppStatsTables() error {
 		return err
 	}
 
+	if err := db.createAppDestinationsTable(); err != nil {
+		return err
+	}
+
 	// Create indexes
 	indexes := []string{
 		`CREATE INDEX IF NOT EXISTS idx_app_stats_process_name ON application_stats(process_name)`,
@@ -410,102 +829,170 @@ func createAppStatsTables() error {
 	return nil
 }
 
-// IsInitialized returns whether the database is initialized
-func IsInitialized() bool {
-	return db != nil
+// Summary describes what the database currently holds
+type Summary struct {
+	TotalPackets int64
+	TotalFlows   int64
+	TrackedApps  int64
+	FirstPacket  time.Time
+	LastPacket   time.Time
 }
 
-// StoreAppStats stores or updates application statistics in the database
-func StoreAppStats(stats *ApplicationStats) error {
+// GetSummary returns row counts and the time range covered by stored packets
+func (db *DB) GetSummary() (*Summary, error) {
 	if db == nil {
-		return fmt.Errorf("database not initialized")
+		return nil, fmt.Errorf("database not initialized")
 	}
 
-	// First try to update existing record
-	result, err := db.Exec(`
-		UPDATE application_stats SET
-			total_packets = ?,
-			total_bytes = ?,
-			last_updated = ?,
-			destinations = ?,
-			last_seen = ?,
-			process_path = COALESCE(?, process_path)
-		WHERE process_name = ? AND process_id = ?
-	`,
-		stats.TotalPackets,
-		stats.TotalBytes,
-		time.Now(),
-		stats.Destinations,
-		time.Now(),
-		stats.ProcessPath,
-		stats.ProcessName,
-		stats.ProcessID,
-	)
+	summary := &Summary{}
+	var first, last sql.NullString
+	err := db.QueryRow(`
+		SELECT COALESCE(SUM(packet_count), 0), MIN(timestamp), MAX(timestamp) FROM packet_logs
+	`).Scan(&summary.TotalPackets, &first, &last)
 	if err != nil {
-		return fmt.Errorf("failed to update app stats: %v", err)
+		return nil, fmt.Errorf("failed to summarize packets: %v", err)
 	}
+	summary.FirstPacket = parseTimestamp(first.String)
+	summary.LastPacket = parseTimestamp(last.String)
 
-	// Check if the update affected any rows
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("failed to check rows affected: %v", err)
+	if err := db.QueryRow(`SELECT COUNT(*) FROM flows`).Scan(&summary.TotalFlows); err != nil {
+		return nil, fmt.Errorf("failed to count flows: %v", err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM application_stats`).Scan(&summary.TrackedApps); err != nil {
+		return nil, fmt.Errorf("failed to count applications: %v", err)
 	}
 
-	// If no rows were updated, insert a new record
-	if rowsAffected == 0 {
-		result, err = db.Exec(`
-			INSERT INTO application_stats (
-				process_id, process_name, process_path, 
-				total_packets, total_bytes, 
-				last_updated, destinations, 
-				first_seen, last_seen
-			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-		`,
-			stats.ProcessID,
-			stats.ProcessName,
-			stats.ProcessPath,
-			stats.TotalPackets,
-			stats.TotalBytes,
-			time.Now(),
-			stats.Destinations,
-			time.Now(),
-			time.Now(),
-		)
-		if err != nil {
-			return fmt.Errorf("failed to insert app stats: %v", err)
+	return summary, nil
+}
+
+// parseTimestamp parses a timestamp returned by an aggregate query, where the
+// driver hands back the stored text instead of a time.Time
+func parseTimestamp(value string) time.Time {
+	for _, layout := range sqlite3TimestampFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
 		}
 	}
+	return time.Time{}
+}
+
+// sqlite3TimestampFormats mirrors the layouts go-sqlite3 reads and writes for time.Time values
+var sqlite3TimestampFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+// timestampFormat is how times are stored: in UTC and with every fractional
+// digit, so that comparing and ordering them as text, as the indexed range
+// queries do, agrees with comparing the times themselves
+const timestampFormat = "2006-01-02 15:04:05.000000000"
+
+// dbTime formats t for storing in or comparing against a timestamp column.
+// The driver would keep t's own UTC offset, which sorts wrongly as text
+// across time zones and daylight saving changes.
+func dbTime(t time.Time) string {
+	return t.UTC().Format(timestampFormat)
+}
+
+// StoreAppStats adds application statistics to the stored totals. TotalPackets
+// and TotalBytes are the counts since the previous call, not absolute values,
+// so totals stay exact across restarts.
+func (db *DB) StoreAppStats(stats *ApplicationStats) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	// A checked executable has either a hash or the reason it has none; its
+	// result replaces the previous one as a whole
+	exeChecked := stats.ExeSHA256 != "" || stats.ExeError != ""
+	exeSHA256 := sql.NullString{String: stats.ExeSHA256, Valid: stats.ExeSHA256 != ""}
+	exePublisher := sql.NullString{String: stats.ExePublisher, Valid: stats.ExePublisher != ""}
+	exeError := sql.NullString{String: stats.ExeError, Valid: stats.ExeError != ""}
+
+	// A single upsert keeps the write lock for one statement
+	now := dbTime(time.Now())
+	_, err := db.execWrite(`
+		INSERT INTO application_stats (
+			app_key, process_id, process_name, process_path, service_name, process_owner,
+			exe_sha256, exe_publisher, exe_error, command_line,
+			total_packets, total_bytes, packets_in, bytes_in, packets_out, bytes_out,
+			last_updated, first_seen, last_seen
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (app_key, process_id) DO UPDATE SET
+			total_packets = total_packets + excluded.total_packets,
+			total_bytes = total_bytes + excluded.total_bytes,
+			packets_in = packets_in + excluded.packets_in,
+			bytes_in = bytes_in + excluded.bytes_in,
+			packets_out = packets_out + excluded.packets_out,
+			bytes_out = bytes_out + excluded.bytes_out,
+			last_updated = excluded.last_updated,
+			last_seen = excluded.last_seen,
+			process_name = excluded.process_name,
+			process_path = COALESCE(excluded.process_path, process_path),
+			service_name = COALESCE(excluded.service_name, service_name),
+			process_owner = COALESCE(excluded.process_owner, process_owner),
+			command_line = COALESCE(excluded.command_line, command_line),
+			exe_sha256 = CASE WHEN ? THEN excluded.exe_sha256 ELSE exe_sha256 END,
+			exe_publisher = CASE WHEN ? THEN excluded.exe_publisher ELSE exe_publisher END,
+			exe_error = CASE WHEN ? THEN excluded.exe_error ELSE exe_error END
+	`,
+		AppKey(stats.ProcessName, stats.ProcessPath, stats.ServiceName),
+		stats.ProcessID,
+		stats.ProcessName,
+		stats.ProcessPath,
+		sql.NullString{String: stats.ServiceName, Valid: stats.ServiceName != ""},
+		sql.NullString{String: stats.ProcessOwner, Valid: stats.ProcessOwner != ""},
+		exeSHA256,
+		exePublisher,
+		exeError,
+		sql.NullString{String: stats.CommandLine, Valid: stats.CommandLine != ""},
+		stats.TotalPackets,
+		stats.TotalBytes,
+		stats.PacketsIn,
+		stats.BytesIn,
+		stats.PacketsOut,
+		stats.BytesOut,
+		now,
+		now,
+		now,
+		exeChecked,
+		exeChecked,
+		exeChecked,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store app stats: %v", err)
+	}
 
 	return nil
 }
 
-// StoreProtocolStats stores protocol statistics for an application
-func StoreProtocolStats(appName string, processID uint32, protocol string, packetCount uint64) error {
+// StoreProtocolStats adds protocol statistics for the application with the
+// given AppKey to the stored totals. packetCount and byteCount are the counts
+// since the previous call.
+func (db *DB) StoreProtocolStats(appKey string, processID uint32, protocol string, packetCount, byteCount uint64) error {
 	if db == nil {
 		return fmt.Errorf("database not initialized")
 	}
 
 	// First get the app_stats_id
-	var appStatsID int64
-	err := db.QueryRow(`
-		SELECT id FROM application_stats 
-		WHERE process_name = ? AND process_id = ?
-	`, appName, processID).Scan(&appStatsID)
-
+	appStatsID, err := db.appStatsID(appKey, processID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return fmt.Errorf("application stats not found for %s (PID %d)", appName, processID)
-		}
-		return fmt.Errorf("error getting app stats ID: %v", err)
+		return err
 	}
 
 	// Now update the protocol stats
-	_, err = db.Exec(`
-		INSERT INTO protocol_stats (app_stats_id, protocol, packet_count)
-		VALUES (?, ?, ?)
+	_, err = db.execWrite(`
+		INSERT INTO protocol_stats (app_stats_id, protocol, packet_count, byte_count)
+		VALUES (?, ?, ?, ?)
 		ON CONFLICT (app_stats_id, protocol) 
-		DO UPDATE SET packet_count = ?
-	`, appStatsID, protocol, packetCount, packetCount)
+		DO UPDATE SET packet_count = packet_count + excluded.packet_count,
+		              byte_count = byte_count + excluded.byte_count
+	`, appStatsID, protocol, packetCount, byteCount)
 
 	if err != nil {
 		return fmt.Errorf("failed to update protocol stats: %v", err)
@@ -514,24 +1001,101 @@ func StoreProtocolStats(appName string, processID uint32, protocol string, packe
 	return nil
 }
 
+// AppStatsOrder selects which applications GetAppStats returns first
+type AppStatsOrder string
+
+const (
+	AppStatsByPackets  AppStatsOrder = "packets"   // Most packets first
+	AppStatsByBytes    AppStatsOrder = "bytes"     // Most bytes first
+	AppStatsByLastSeen AppStatsOrder = "last_seen" // Most recently active first
+)
+
+// appStatsOrders maps each order to the aggregate ranking an application by
+// all of its rows and the column ordering the rows themselves
+var appStatsOrders = map[AppStatsOrder][2]string{
+	AppStatsByPackets:  {"SUM(total_packets)", "total_packets"},
+	AppStatsByBytes:    {"SUM(total_bytes)", "total_bytes"},
+	AppStatsByLastSeen: {"MAX(last_seen)", "last_seen"},
+}
+
+// AppKey identifies an application across PIDs and restarts: its executable
+// path, compared case-insensitively like Windows paths, and hosted services.
+// Two updater.exe in different folders are different applications. Rows
+// without a path fall back to the name.
+func AppKey(processName, processPath, serviceName string) string {
+	if processPath == "" {
+		return processName
+	}
+	key := strings.ToLower(filepath.Clean(processPath))
+	if serviceName != "" {
+		key = fmt.Sprintf("%s (%s)", key, serviceName)
+	}
+	return key
+}
+
+const appStatsColumns = `
+	id, process_id, process_name, process_path, COALESCE(service_name, ''),
+	COALESCE(process_owner, ''), COALESCE(exe_sha256, ''), COALESCE(exe_publisher, ''),
+	COALESCE(exe_error, ''), COALESCE(command_line, ''), total_packets, total_bytes,
+	packets_in, bytes_in, packets_out, bytes_out, first_seen, last_seen`
+
 // GetAllAppStats returns all application statistics from the database
-func GetAllAppStats() ([]*ApplicationStats, error) {
+func (db *DB) GetAllAppStats() ([]*ApplicationStats, error) {
+	return db.GetAppStats(AppStatsByPackets, 0)
+}
+
+// GetAppStats returns the rows of the first limit applications in the given
+// order, or of every application if limit is 0. All rows of an application,
+// one per PID, are returned together so its totals are complete.
+func (db *DB) GetAppStats(order AppStatsOrder, limit int) ([]*ApplicationStats, error) {
 	if db == nil {
 		return nil, fmt.Errorf("database not initialized")
 	}
+	orderBy, ok := appStatsOrders[order]
+	if !ok {
+		return nil, fmt.Errorf("unknown application stats order %q", order)
+	}
 
-	rows, err := db.Query(`
-		SELECT id, process_id, process_name, process_path, 
-		       total_packets, total_bytes, destinations,
-		       first_seen, last_seen
-		FROM application_stats
-		ORDER BY total_packets DESC
-	`)
+	query := `SELECT ` + appStatsColumns + ` FROM application_stats`
+	var args []interface{}
+	if limit > 0 {
+		query += ` WHERE app_key IN (
+			SELECT app_key FROM application_stats
+			GROUP BY 1 ORDER BY ` + orderBy[0] + ` DESC LIMIT ?)`
+		args = append(args, limit)
+	}
+	query += ` ORDER BY ` + orderBy[1] + ` DESC`
+
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query application stats: %v", err)
 	}
 	defer rows.Close()
 
+	return scanAppStats(rows)
+}
+
+// GetAppStatsForKey returns the rows stored under any PID for the
+// application with the given AppKey
+func (db *DB) GetAppStatsForKey(appKey string) ([]*ApplicationStats, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(`
+		SELECT `+appStatsColumns+` FROM application_stats
+		WHERE app_key = ?
+	`, appKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query application stats for %s: %v", appKey, err)
+	}
+	defer rows.Close()
+
+	return scanAppStats(rows)
+}
+
+// scanAppStats reads rows selected with appStatsColumns
+func scanAppStats(rows *sql.Rows) ([]*ApplicationStats, error) {
 	var appStats []*ApplicationStats
 	for rows.Next() {
 		appStat := &ApplicationStats{}
@@ -541,9 +1105,18 @@ func GetAllAppStats() ([]*ApplicationStats, error) {
 			&appStat.ProcessID,
 			&appStat.ProcessName,
 			&appStat.ProcessPath,
+			&appStat.ServiceName,
+			&appStat.ProcessOwner,
+			&appStat.ExeSHA256,
+			&appStat.ExePublisher,
+			&appStat.ExeError,
+			&appStat.CommandLine,
 			&appStat.TotalPackets,
 			&appStat.TotalBytes,
-			&appStat.Destinations,
+			&appStat.PacketsIn,
+			&appStat.BytesIn,
+			&appStat.PacketsOut,
+			&appStat.BytesOut,
 			&firstSeen,
 			&lastSeen,
 		)
@@ -555,17 +1128,17 @@ func GetAllAppStats() ([]*ApplicationStats, error) {
 		appStats = append(appStats, appStat)
 	}
 
-	return appStats, nil
+	return appStats, rows.Err()
 }
 
 // GetProtocolStatsForApp returns protocol statistics for a specific application
-func GetProtocolStatsForApp(appStatsID int64) ([]ProtocolStat, error) {
+func (db *DB) GetProtocolStatsForApp(appStatsID int64) ([]ProtocolStat, error) {
 	if db == nil {
 		return nil, fmt.Errorf("database not initialized")
 	}
 
 	rows, err := db.Query(`
-		SELECT protocol, packet_count
+		SELECT protocol, packet_count, byte_count
 		FROM protocol_stats
 		WHERE app_stats_id = ?
 	`, appStatsID)
@@ -577,7 +1150,7 @@ func GetProtocolStatsForApp(appStatsID int64) ([]ProtocolStat, error) {
 	var protocolStats []ProtocolStat
 	for rows.Next() {
 		var proto ProtocolStat
-		err := rows.Scan(&proto.Protocol, &proto.PacketCount)
+		err := rows.Scan(&proto.Protocol, &proto.PacketCount, &proto.ByteCount)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan protocol stats: %v", err)
 		}
@@ -586,3 +1159,498 @@ func GetProtocolStatsForApp(appStatsID int64) ([]ProtocolStat, error) {
 
 	return protocolStats, nil
 }
+
+// InterfaceStats holds the counters stored for a network interface
+type InterfaceStats struct {
+	InterfaceID      int64
+	TotalPackets     uint64
+	TotalBytes       uint64
+	PacketsReceived  uint64
+	PacketsDropped   uint64
+	PacketsIfDropped uint64
+	UpdatedAt        time.Time
+}
+
+// StoreInterfaceStats inserts or replaces the counters for an interface
+func (db *DB) StoreInterfaceStats(stats InterfaceStats) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := db.execWrite(`
+		INSERT INTO interface_stats (
+			interface_id, total_packets, total_bytes,
+			packets_received, packets_dropped, packets_if_dropped, updated_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (interface_id) DO UPDATE SET
+			total_packets = excluded.total_packets,
+			total_bytes = excluded.total_bytes,
+			packets_received = excluded.packets_received,
+			packets_dropped = excluded.packets_dropped,
+			packets_if_dropped = excluded.packets_if_dropped,
+			updated_at = excluded.updated_at
+	`,
+		stats.InterfaceID,
+		stats.TotalPackets,
+		stats.TotalBytes,
+		stats.PacketsReceived,
+		stats.PacketsDropped,
+		stats.PacketsIfDropped,
+		dbTime(time.Now()),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store interface stats: %v", err)
+	}
+
+	return nil
+}
+
+// DNSEntry maps an IP address to the hostname it was resolved from
+type DNSEntry struct {
+	IP        string
+	Hostname  string
+	ExpiresAt time.Time
+}
+
+// StoreDNSEntry inserts or refreshes a hostname mapping
+func (db *DB) StoreDNSEntry(entry DNSEntry) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := db.execWrite(`
+		INSERT INTO dns_cache (ip, hostname, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (ip)
+		DO UPDATE SET hostname = excluded.hostname, expires_at = excluded.expires_at
+	`, entry.IP, entry.Hostname, dbTime(entry.ExpiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to store DNS entry: %v", err)
+	}
+
+	return nil
+}
+
+// GetDNSEntries removes expired mappings and returns the remaining ones
+func (db *DB) GetDNSEntries() ([]DNSEntry, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	if _, err := db.PurgeDNSEntries(time.Now()); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT ip, hostname, expires_at FROM dns_cache`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query DNS cache: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []DNSEntry
+	for rows.Next() {
+		var entry DNSEntry
+		if err := rows.Scan(&entry.IP, &entry.Hostname, &entry.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan DNS entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+// PurgeDNSEntries deletes mappings that expired before cutoff and returns how
+// many were deleted
+func (db *DB) PurgeDNSEntries(cutoff time.Time) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	result, err := db.execWrite(`DELETE FROM dns_cache WHERE expires_at < ?`, dbTime(cutoff))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge expired DNS entries: %v", err)
+	}
+	return result.RowsAffected()
+}
+
+// UpdateInterfaceDetails records an interface's adapter name, MAC address and
+// current IP addresses
+func (db *DB) UpdateInterfaceDetails(id int64, friendlyName, mac, addresses string) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := db.execWrite(`
+		UPDATE network_interfaces
+		SET friendly_name = ?, mac = ?, addresses = ?, updated_at = ?
+		WHERE id = ?
+	`, friendlyName, mac, addresses, dbTime(time.Now()), id)
+	if err != nil {
+		return fmt.Errorf("failed to update interface details: %v", err)
+	}
+	return nil
+}
+
+// GetInterfaces returns every known network interface
+func (db *DB) GetInterfaces() ([]NetworkInterface, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(`
+		SELECT id, name, COALESCE(description, ''), COALESCE(friendly_name, ''), COALESCE(mac, ''),
+		       COALESCE(addresses, ''), created_at
+		FROM network_interfaces`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query interfaces: %v", err)
+	}
+	defer rows.Close()
+
+	var interfaces []NetworkInterface
+	for rows.Next() {
+		var iface NetworkInterface
+		if err := rows.Scan(&iface.ID, &iface.Name, &iface.Description, &iface.FriendlyName, &iface.MAC,
+			&iface.Addresses, &iface.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan interface: %v", err)
+		}
+		interfaces = append(interfaces, iface)
+	}
+
+	return interfaces, rows.Err()
+}
+
+// PacketFilter restricts which packet, flow or application rows are returned.
+// Zero values match everything.
+type PacketFilter struct {
+	From        time.Time
+	To          time.Time
+	ProcessName string
+	Protocol    string // Not applicable to application stats
+	Direction   string // Not applicable to application stats
+}
+
+// where builds the WHERE clause for the filter. Rows match the time range when
+// fromColumn >= From and toColumn <= To, so a flow overlapping the range can be
+// selected by passing its last and first timestamps.
+func (f PacketFilter) where(fromColumn, toColumn string) (string, []interface{}) {
+	clause := ` WHERE 1 = 1`
+	var args []interface{}
+
+	if !f.From.IsZero() {
+		clause += ` AND ` + fromColumn + ` >= ?`
+		args = append(args, dbTime(f.From))
+	}
+	if !f.To.IsZero() {
+		clause += ` AND ` + toColumn + ` <= ?`
+		args = append(args, dbTime(f.To))
+	}
+	if f.ProcessName != "" {
+		clause += ` AND process_name = ? COLLATE NOCASE`
+		args = append(args, f.ProcessName)
+	}
+	if f.Protocol != "" {
+		clause += ` AND protocol = ? COLLATE NOCASE`
+		args = append(args, f.Protocol)
+	}
+	if f.Direction != "" {
+		clause += ` AND direction = ? COLLATE NOCASE`
+		args = append(args, f.Direction)
+	}
+	return clause, args
+}
+
+// packetColumns are the packet_logs columns read by scanPacket
+const packetColumns = `id, timestamp, device_id, src_ip, src_port, dst_ip, dst_port, dst_host, ` + reverseHostColumn + `,
+	protocol, length, process_id, process_name, process_path, service_name, process_owner,
+	direction, geoip, scope, payload, flagged, packet_count, total_bytes, src_mac, dst_mac, vlan`
+
+// StreamPackets calls fn for each packet matching the filter in timestamp order,
+// without loading the result set into memory. Returning an error from fn stops the scan.
+func (db *DB) StreamPackets(filter PacketFilter, fn func(PacketRecord) error) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	query := `SELECT ` + packetColumns + ` FROM packet_logs`
+	where, args := filter.where("timestamp", "timestamp")
+	query += where + ` ORDER BY timestamp`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query packets: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanPacket(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// scanPacket reads a row selected with packetColumns, followed by the
+// columns scanned into extra
+func scanPacket(rows *sql.Rows, extra ...interface{}) (PacketRecord, error) {
+	var (
+		record       PacketRecord
+		dstHost      sql.NullString
+		reverseHost  sql.NullString
+		processID    sql.NullInt64
+		processName  sql.NullString
+		processPath  sql.NullString
+		serviceName  sql.NullString
+		processOwner sql.NullString
+		direction    sql.NullString
+		geoip        sql.NullString
+		scope        sql.NullString
+		payload      sql.NullString
+		srcMAC       sql.NullString
+		dstMAC       sql.NullString
+		vlan         sql.NullInt64
+	)
+	dest := []interface{}{
+		&record.ID,
+		&record.Timestamp,
+		&record.DeviceID,
+		&record.SrcIP,
+		&record.SrcPort,
+		&record.DstIP,
+		&record.DstPort,
+		&dstHost,
+		&reverseHost,
+		&record.Protocol,
+		&record.Length,
+		&processID,
+		&processName,
+		&processPath,
+		&serviceName,
+		&processOwner,
+		&direction,
+		&geoip,
+		&scope,
+		&payload,
+		&record.Flagged,
+		&record.PacketCount,
+		&record.TotalBytes,
+		&srcMAC,
+		&dstMAC,
+		&vlan,
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return record, fmt.Errorf("failed to scan packet: %v", err)
+	}
+	if payload.Valid {
+		var err error
+		if record.Payload, err = base64.StdEncoding.DecodeString(payload.String); err != nil {
+			return record, fmt.Errorf("invalid payload in packet %d: %v", record.ID, err)
+		}
+	}
+	record.DstHost = dstHost.String
+	record.ReverseHost = reverseHost.String
+	record.ProcessID = uint32(processID.Int64)
+	record.ProcessName = processName.String
+	record.ProcessPath = processPath.String
+	record.ServiceName = serviceName.String
+	record.ProcessOwner = processOwner.String
+	record.Direction = direction.String
+	record.GeoIP = geoip.String
+	record.Scope = scope.String
+	record.SrcMAC = srcMAC.String
+	record.DstMAC = dstMAC.String
+	record.VLAN = uint16(vlan.Int64)
+	return record, nil
+}
+
+// StreamFlows calls fn for each flow overlapping the filter's time range, in
+// order of first packet, without loading the result set into memory
+func (db *DB) StreamFlows(filter PacketFilter, fn func(FlowRecord) error) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	query := `
+		SELECT id, device_id, src_ip, src_port, dst_ip, dst_port, dst_host, ` + reverseHostColumn + `,
+		       protocol, direction, scope, packet_count, byte_count, first_seen, last_seen,
+		       tcp_flags, process_id, process_name, process_path, attribution
+		FROM flows`
+	where, args := filter.where("last_seen", "first_seen")
+	query += where + ` ORDER BY first_seen`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query flows: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			flow        FlowRecord
+			dstHost     sql.NullString
+			reverseHost sql.NullString
+			direction   sql.NullString
+			scope       sql.NullString
+			tcpFlags    sql.NullString
+			processID   sql.NullInt64
+			processName sql.NullString
+			processPath sql.NullString
+			attribution sql.NullString
+		)
+		err := rows.Scan(
+			&flow.ID,
+			&flow.DeviceID,
+			&flow.SrcIP,
+			&flow.SrcPort,
+			&flow.DstIP,
+			&flow.DstPort,
+			&dstHost,
+			&reverseHost,
+			&flow.Protocol,
+			&direction,
+			&scope,
+			&flow.PacketCount,
+			&flow.ByteCount,
+			&flow.FirstSeen,
+			&flow.LastSeen,
+			&tcpFlags,
+			&processID,
+			&processName,
+			&processPath,
+			&attribution,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to scan flow: %v", err)
+		}
+		flow.DstHost = dstHost.String
+		flow.ReverseHost = reverseHost.String
+		flow.Direction = direction.String
+		flow.Scope = scope.String
+		flow.TCPFlags = tcpFlags.String
+		flow.ProcessID = uint32(processID.Int64)
+		flow.ProcessName = processName.String
+		flow.ProcessPath = processPath.String
+		flow.Attribution = attribution.String
+
+		if err := fn(flow); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// StreamAppStats calls fn for each application last seen within the filter's
+// time range. Protocol and Direction filters do not apply to applications.
+func (db *DB) StreamAppStats(filter PacketFilter, fn func(ApplicationStats) error) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	filter.Protocol = ""
+	filter.Direction = ""
+	query := `
+		SELECT id, process_id, process_name, COALESCE(process_path, ''), COALESCE(service_name, ''),
+		       COALESCE(process_owner, ''), COALESCE(exe_sha256, ''), COALESCE(exe_publisher, ''),
+		       COALESCE(exe_error, ''), COALESCE(command_line, ''),
+		       total_packets, total_bytes, packets_in, bytes_in, packets_out, bytes_out,
+		       (SELECT json_group_array(CASE WHEN COALESCE(reverse_host, '') = '' THEN destination
+		                                     ELSE destination || ' [' || reverse_host || ']' END)
+		        FROM app_destinations WHERE app_stats_id = application_stats.id),
+		       first_seen, last_seen
+		FROM application_stats`
+	where, args := filter.where("last_seen", "first_seen")
+	query += where + ` ORDER BY total_bytes DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query application stats: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var app ApplicationStats
+		err := rows.Scan(
+			&app.ID,
+			&app.ProcessID,
+			&app.ProcessName,
+			&app.ProcessPath,
+			&app.ServiceName,
+			&app.ProcessOwner,
+			&app.ExeSHA256,
+			&app.ExePublisher,
+			&app.ExeError,
+			&app.CommandLine,
+			&app.TotalPackets,
+			&app.TotalBytes,
+			&app.PacketsIn,
+			&app.BytesIn,
+			&app.PacketsOut,
+			&app.BytesOut,
+			&app.Destinations,
+			&app.FirstSeen,
+			&app.LastSeen,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to scan application stats: %v", err)
+		}
+
+		if err := fn(app); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// PurgeBefore deletes packets captured and flows last active before cutoff,
+// returning how many of each were removed. Application and protocol totals
+// are kept.
+func (db *DB) PurgeBefore(cutoff time.Time) (int64, int64, error) {
+	if db == nil {
+		return 0, 0, fmt.Errorf("database not initialized")
+	}
+
+	result, err := db.Exec(`DELETE FROM packet_logs WHERE timestamp < ?`, dbTime(cutoff))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to delete old packets: %v", err)
+	}
+	packets, _ := result.RowsAffected()
+
+	result, err = db.Exec(`DELETE FROM flows WHERE last_seen < ?`, dbTime(cutoff))
+	if err != nil {
+		return packets, 0, fmt.Errorf("failed to delete old flows: %v", err)
+	}
+	flows, _ := result.RowsAffected()
+
+	return packets, flows, nil
+}
+
+// Vacuum checkpoints the write-ahead log, rebuilds the database file to
+// release space left by deleted rows and refreshes the query planner
+// statistics. It needs exclusive access, so capture must not be running.
+func (db *DB) Vacuum() error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	if _, err := db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("failed to checkpoint write-ahead log: %v", err)
+	}
+	if _, err := db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("failed to vacuum database: %v", err)
+	}
+	if _, err := db.Exec(`PRAGMA optimize`); err != nil {
+		return fmt.Errorf("failed to optimize database: %v", err)
+	}
+	// VACUUM goes through the write-ahead log too; fold it back into the file
+	if _, err := db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return fmt.Errorf("failed to checkpoint write-ahead log: %v", err)
+	}
+
+	return nil
+}
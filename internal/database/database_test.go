@@ -0,0 +1,386 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestStreamFilters stores packets, flows and applications and checks which
+// ones each export filter selects
+func TestStreamFilters(t *testing.T) {
+	db := openTestDB(t, MemoryPath)
+	deviceID, err := db.StoreInterface(NetworkInterface{Name: "eth0", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	traffic := []struct {
+		at                           time.Time
+		process, protocol, direction string
+	}{
+		{day.Add(1 * time.Hour), "chrome.exe", "TCP", "outgoing"},
+		{day.Add(2 * time.Hour), "chrome.exe", "UDP", "outgoing"},
+		{day.Add(3 * time.Hour), "svchost.exe", "UDP", "incoming"},
+		{day.Add(25 * time.Hour), "Chrome.exe", "TCP", "incoming"},
+	}
+	for i, tr := range traffic {
+		err := db.StorePacket(PacketRecord{
+			Timestamp: tr.at, DeviceID: deviceID, SrcIP: "10.0.0.2", SrcPort: "50000", DstIP: "192.0.2.1", DstPort: "443",
+			Protocol: tr.protocol, Length: 100, ProcessID: uint32(i + 1), ProcessName: tr.process, Direction: tr.direction,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = db.UpsertFlow(FlowRecord{
+			DeviceID: deviceID, SrcIP: "10.0.0.2", SrcPort: "50000", DstIP: "192.0.2.1", DstPort: "443",
+			Protocol: tr.protocol, Direction: tr.direction, PacketCount: 1, ByteCount: 100,
+			FirstSeen: tr.at, LastSeen: tr.at.Add(time.Minute), ProcessName: tr.process,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Applications are exported by most bytes first
+		err = db.StoreAppStats(&ApplicationStats{
+			ProcessID: uint32(i + 1), ProcessName: tr.process, TotalPackets: 1, TotalBytes: uint64(1000 - i),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// StoreAppStats stamps the current time
+		_, err = db.Exec(`UPDATE application_stats SET first_seen = ?, last_seen = ? WHERE process_id = ?`, tr.at, tr.at, i+1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter PacketFilter
+		want   []int // Indexes into traffic
+	}{
+		{"everything", PacketFilter{}, []int{0, 1, 2, 3}},
+		{"from", PacketFilter{From: day.Add(2 * time.Hour)}, []int{1, 2, 3}},
+		{"to", PacketFilter{To: day.Add(2 * time.Hour)}, []int{0, 1}},
+		{"one day", PacketFilter{From: day, To: day.Add(24 * time.Hour)}, []int{0, 1, 2}},
+		{"process ignores case", PacketFilter{ProcessName: "CHROME.EXE"}, []int{0, 1, 3}},
+		{"protocol", PacketFilter{Protocol: "udp"}, []int{1, 2}},
+		{"direction", PacketFilter{Direction: "incoming"}, []int{2, 3}},
+		{"combined", PacketFilter{ProcessName: "chrome.exe", Protocol: "tcp", Direction: "outgoing"}, []int{0}},
+		{"nothing", PacketFilter{ProcessName: "firefox.exe"}, nil},
+	}
+	for _, tt := range tests {
+		var packets []int
+		err := db.StreamPackets(tt.filter, func(p PacketRecord) error {
+			packets = append(packets, int(p.ProcessID)-1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(packets, tt.want) {
+			t.Errorf("%s: packets %v, want %v", tt.name, packets, tt.want)
+		}
+
+		var flows []int
+		err = db.StreamFlows(tt.filter, func(f FlowRecord) error {
+			flows = append(flows, int(f.ID)-1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(flows, tt.want) {
+			t.Errorf("%s: flows %v, want %v", tt.name, flows, tt.want)
+		}
+	}
+
+	// Applications ignore the protocol and direction
+	appTests := []struct {
+		name   string
+		filter PacketFilter
+		want   []int
+	}{
+		{"everything", PacketFilter{}, []int{0, 1, 2, 3}},
+		{"one day", PacketFilter{From: day, To: day.Add(24 * time.Hour)}, []int{0, 1, 2}},
+		{"process", PacketFilter{ProcessName: "svchost.exe", Protocol: "TCP"}, []int{2}},
+	}
+	for _, tt := range appTests {
+		var apps []int
+		err := db.StreamAppStats(tt.filter, func(a ApplicationStats) error {
+			apps = append(apps, int(a.ProcessID)-1)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(apps, tt.want) {
+			t.Errorf("%s: applications %v, want %v", tt.name, apps, tt.want)
+		}
+	}
+}
+
+// TestAttributeConnection stores packets of a connection captured before its
+// process was found, among others, and checks that only the connection's
+// unattributed rows within its time range are back-filled
+func TestAttributeConnection(t *testing.T) {
+	db := openTestDB(t, MemoryPath)
+	deviceID, err := db.StoreInterface(NetworkInterface{Name: "eth0", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	packets := []struct {
+		name        string
+		at          time.Duration // After start
+		srcPort     string
+		direction   string
+		processID   uint32
+		wantProcess string
+	}{
+		{"first packet", 0, "50000", "outgoing", 0, "client.exe"},
+		{"second packet", 20 * time.Millisecond, "50000", "outgoing", 0, "client.exe"},
+		{"already attributed", 30 * time.Millisecond, "50000", "outgoing", 7, "other.exe"},
+		{"after the range", time.Second, "50000", "outgoing", 0, ""},
+		{"earlier connection", -time.Minute, "50000", "outgoing", 0, ""},
+		{"other port", 10 * time.Millisecond, "50001", "outgoing", 0, ""},
+		{"other direction", 10 * time.Millisecond, "50000", "internal", 0, ""},
+	}
+	for _, p := range packets {
+		record := PacketRecord{
+			Timestamp: start.Add(p.at), DeviceID: deviceID, SrcIP: "10.0.0.2", SrcPort: p.srcPort, DstIP: "192.0.2.1", DstPort: "443",
+			Protocol: "TCP", Length: 60, Direction: p.direction, ProcessID: p.processID,
+		}
+		if p.processID != 0 {
+			record.ProcessName = p.wantProcess
+		}
+		if err := db.StorePacket(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flows := []struct {
+		srcPort     string
+		wantProcess string
+	}{
+		{"50000", "client.exe"},
+		{"50001", ""},
+	}
+	for _, f := range flows {
+		_, err := db.UpsertFlow(FlowRecord{
+			DeviceID: deviceID, SrcIP: "10.0.0.2", SrcPort: f.srcPort, DstIP: "192.0.2.1", DstPort: "443",
+			Protocol: "TCP", Direction: "outgoing", PacketCount: 2, ByteCount: 120,
+			FirstSeen: start, LastSeen: start.Add(20 * time.Millisecond),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated, err := db.AttributeConnection(ConnectionAttribution{
+		SrcIP: "10.0.0.2", SrcPort: "50000", DstIP: "192.0.2.1", DstPort: "443", Protocol: "TCP", Direction: "outgoing",
+		Since: start, Until: start.Add(500 * time.Millisecond),
+		ProcessID: 100, ProcessName: "client.exe", ProcessPath: `C:\Apps\client.exe`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated != 2 {
+		t.Errorf("AttributeConnection updated %d packets, want 2", updated)
+	}
+
+	for _, p := range packets {
+		var name sql.NullString
+		err := db.QueryRow(`SELECT process_name FROM packet_logs WHERE timestamp = ? AND src_port = ? AND direction = ?`,
+			dbTime(start.Add(p.at)), p.srcPort, p.direction).Scan(&name)
+		if err != nil {
+			t.Fatalf("%s: %v", p.name, err)
+		}
+		if name.String != p.wantProcess {
+			t.Errorf("%s: process %q, want %q", p.name, name.String, p.wantProcess)
+		}
+	}
+	for _, f := range flows {
+		var name sql.NullString
+		if err := db.QueryRow(`SELECT process_name FROM flows WHERE src_port = ?`, f.srcPort).Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if name.String != f.wantProcess {
+			t.Errorf("flow from %s: process %q, want %q", f.srcPort, name.String, f.wantProcess)
+		}
+	}
+}
+
+// TestTimeZones stores times captured with different UTC offsets, as after a
+// daylight saving change, and checks that range queries and ordering follow
+// the instants rather than the clock readings
+func TestTimeZones(t *testing.T) {
+	db := openTestDB(t, MemoryPath)
+	deviceID, err := db.StoreInterface(NetworkInterface{Name: "eth0", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	india := time.FixedZone("IST", 5*3600+1800)
+	pacific := time.FixedZone("PST", -8*3600)
+	noon := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	traffic := []struct {
+		port string
+		at   time.Time
+	}{
+		{"1", noon.In(india)},                         // 17:30 local
+		{"2", noon.Add(10 * time.Minute).In(pacific)}, // 04:10 local
+		{"3", noon.Add(20 * time.Minute)},
+	}
+	for _, tt := range traffic {
+		packet := PacketRecord{Timestamp: tt.at, DeviceID: deviceID, SrcIP: "10.0.0.2", SrcPort: tt.port,
+			DstIP: "192.0.2.1", DstPort: "443", Protocol: "TCP", Length: 100, Direction: "outgoing"}
+		if err := db.StorePacket(packet); err != nil {
+			t.Fatal(err)
+		}
+		flow := FlowRecord{DeviceID: deviceID, SrcIP: "10.0.0.2", SrcPort: tt.port, DstIP: "192.0.2.1", DstPort: "443",
+			Protocol: "TCP", Direction: "outgoing", PacketCount: 1, ByteCount: 100, FirstSeen: tt.at, LastSeen: tt.at}
+		if err := db.StoreFlow(flow); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		from time.Time
+		want []string // Source ports of the packets selected, in order
+	}{
+		{"all", time.Time{}, []string{"1", "2", "3"}},
+		{"from in another zone", noon.Add(5 * time.Minute).In(india), []string{"2", "3"}},
+		{"from in UTC", noon.Add(15 * time.Minute), []string{"3"}},
+		{"from a later local clock", noon.Add(25 * time.Minute).In(pacific), nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		err := db.StreamPackets(PacketFilter{From: tt.from}, func(packet PacketRecord) error {
+			got = append(got, packet.SrcPort)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: packets %v, want %v", tt.name, got, tt.want)
+		}
+
+		page, err := db.QueryPackets(PacketQuery{PacketFilter: PacketFilter{From: tt.from}, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paged []string
+		for {
+			for _, packet := range page.Packets {
+				paged = append(paged, packet.SrcPort)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			if page, err = db.QueryPackets(PacketQuery{PacketFilter: PacketFilter{From: tt.from}, Cursor: page.NextCursor, Limit: 1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !reflect.DeepEqual(paged, tt.want) {
+			t.Errorf("%s: paged packets %v, want %v", tt.name, paged, tt.want)
+		}
+
+		totals, err := db.GetTotalsSince(tt.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totals.Flows != int64(len(tt.want)) {
+			t.Errorf("%s: %d flows since, want %d", tt.name, totals.Flows, len(tt.want))
+		}
+	}
+
+	// Times come back as the same instants
+	var first time.Time
+	if err := db.QueryRow(`SELECT first_seen FROM flows WHERE src_port = '2'`).Scan(&first); err != nil {
+		t.Fatal(err)
+	}
+	if !first.Equal(traffic[1].at) {
+		t.Errorf("flow first seen %v, want %v", first, traffic[1].at)
+	}
+
+	packets, flows, err := db.PurgeBefore(noon.Add(15 * time.Minute).In(pacific))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packets != 2 || flows != 2 {
+		t.Errorf("PurgeBefore deleted %d packets, %d flows, want 2 of each", packets, flows)
+	}
+}
+
+func TestPurgeDNSEntries(t *testing.T) {
+	db := openTestDB(t, MemoryPath)
+	now := time.Now()
+
+	entries := []struct {
+		ip        string
+		expiresIn time.Duration
+		wantKept  bool
+	}{
+		{"192.0.2.1", -time.Hour, false},
+		{"192.0.2.2", -time.Second, false},
+		{"192.0.2.3", time.Minute, true},
+		{"2001:db8::1", time.Hour, true},
+	}
+	for _, e := range entries {
+		if err := db.StoreDNSEntry(DNSEntry{IP: e.ip, Hostname: "example.com", ExpiresAt: now.Add(e.expiresIn)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	purged, err := db.PurgeDNSEntries(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 2 {
+		t.Errorf("PurgeDNSEntries deleted %d entries, want 2", purged)
+	}
+
+	kept, err := db.GetDNSEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	remaining := make(map[string]bool)
+	for _, entry := range kept {
+		remaining[entry.IP] = true
+	}
+	for _, e := range entries {
+		if remaining[e.ip] != e.wantKept {
+			t.Errorf("%s kept = %v, want %v", e.ip, remaining[e.ip], e.wantKept)
+		}
+	}
+}
+
+// TestOpenCreatesDirectory checks that only Open creates the directory of a
+// database path, and that OpenReadOnly refuses a missing database without
+// leaving a directory behind
+func TestOpenCreatesDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data", "grip")
+	path := filepath.Join(dir, "netmonitor.db")
+
+	if db, err := OpenReadOnly(path); err == nil {
+		db.Close()
+		t.Fatal("OpenReadOnly opened a missing database")
+	}
+	if _, err := os.Stat(filepath.Dir(dir)); !os.IsNotExist(err) {
+		t.Errorf("OpenReadOnly created %s: %v", filepath.Dir(dir), err)
+	}
+
+	openTestDB(t, path).Close()
+	db, err := OpenReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+}
@@ -0,0 +1,245 @@
+package database
+
+import (
+	"time"
+)
+
+// The functions in this file use the default database, which InitDatabase or
+// InitReadOnlyDatabase opens, so callers that only ever need one database
+// don't have to pass a *DB around.
+
+var defaultDB *DB
+
+// InitDatabase opens the default database with Open
+func InitDatabase(config Config) error {
+	db, err := Open(config)
+	if db != nil {
+		defaultDB = db
+	}
+	return err
+}
+
+// InitReadOnlyDatabase opens the default database with OpenReadOnly
+func InitReadOnlyDatabase(path string) error {
+	db, err := OpenReadOnly(path)
+	if err != nil {
+		return err
+	}
+	defaultDB = db
+	return nil
+}
+
+// Default returns the default database, or nil if it hasn't been opened
+func Default() *DB {
+	return defaultDB
+}
+
+// IsInitialized returns whether the default database has been opened
+func IsInitialized() bool {
+	return defaultDB != nil
+}
+
+// CloseDatabase closes the default database
+func CloseDatabase() {
+	if defaultDB != nil {
+		defaultDB.Close()
+	}
+}
+
+// Path returns the file path of the default database
+func Path() string {
+	if defaultDB == nil {
+		return ""
+	}
+	return defaultDB.Path()
+}
+
+// StoreInterface calls DB.StoreInterface on the default database
+func StoreInterface(iface NetworkInterface) (int64, error) {
+	return defaultDB.StoreInterface(iface)
+}
+
+// StorePacket calls DB.StorePacket on the default database
+func StorePacket(packet PacketRecord) error {
+	return defaultDB.StorePacket(packet)
+}
+
+// StoreFlow calls DB.StoreFlow on the default database
+func StoreFlow(flow FlowRecord) error {
+	return defaultDB.StoreFlow(flow)
+}
+
+// UpsertFlow calls DB.UpsertFlow on the default database
+func UpsertFlow(flow FlowRecord) (int64, error) {
+	return defaultDB.UpsertFlow(flow)
+}
+
+// GetSummary calls DB.GetSummary on the default database
+func GetSummary() (*Summary, error) {
+	return defaultDB.GetSummary()
+}
+
+// RollupAppStats calls DB.RollupAppStats on the default database
+func RollupAppStats(batch int, before time.Time) (int64, error) {
+	return defaultDB.RollupAppStats(batch, before)
+}
+
+// GetAppTimeSeries calls DB.GetAppTimeSeries on the default database
+func GetAppTimeSeries(interval RollupInterval, processName string, from, to time.Time) ([]AppStatsPoint, error) {
+	return defaultDB.GetAppTimeSeries(interval, processName, from, to)
+}
+
+// SchemaVersion calls DB.SchemaVersion on the default database
+func SchemaVersion() (int, error) {
+	return defaultDB.SchemaVersion()
+}
+
+// StoreAppStats calls DB.StoreAppStats on the default database
+func StoreAppStats(stats *ApplicationStats) error {
+	return defaultDB.StoreAppStats(stats)
+}
+
+// StoreProtocolStats calls DB.StoreProtocolStats on the default database
+func StoreProtocolStats(appKey string, processID uint32, protocol string, packetCount, byteCount uint64) error {
+	return defaultDB.StoreProtocolStats(appKey, processID, protocol, packetCount, byteCount)
+}
+
+// GetAllAppStats calls DB.GetAllAppStats on the default database
+func GetAllAppStats() ([]*ApplicationStats, error) {
+	return defaultDB.GetAllAppStats()
+}
+
+// GetAppStats calls DB.GetAppStats on the default database
+func GetAppStats(order AppStatsOrder, limit int) ([]*ApplicationStats, error) {
+	return defaultDB.GetAppStats(order, limit)
+}
+
+// GetAppStatsForKey calls DB.GetAppStatsForKey on the default database
+func GetAppStatsForKey(appKey string) ([]*ApplicationStats, error) {
+	return defaultDB.GetAppStatsForKey(appKey)
+}
+
+// GetProtocolStatsForApp calls DB.GetProtocolStatsForApp on the default database
+func GetProtocolStatsForApp(appStatsID int64) ([]ProtocolStat, error) {
+	return defaultDB.GetProtocolStatsForApp(appStatsID)
+}
+
+// StoreInterfaceStats calls DB.StoreInterfaceStats on the default database
+func StoreInterfaceStats(stats InterfaceStats) error {
+	return defaultDB.StoreInterfaceStats(stats)
+}
+
+// StoreDNSEntry calls DB.StoreDNSEntry on the default database
+func StoreDNSEntry(entry DNSEntry) error {
+	return defaultDB.StoreDNSEntry(entry)
+}
+
+// GetDNSEntries calls DB.GetDNSEntries on the default database
+func GetDNSEntries() ([]DNSEntry, error) {
+	return defaultDB.GetDNSEntries()
+}
+
+// PurgeDNSEntries calls DB.PurgeDNSEntries on the default database
+func PurgeDNSEntries(cutoff time.Time) (int64, error) {
+	return defaultDB.PurgeDNSEntries(cutoff)
+}
+
+// UpdateInterfaceDetails calls DB.UpdateInterfaceDetails on the default database
+func UpdateInterfaceDetails(id int64, friendlyName, mac, addresses string) error {
+	return defaultDB.UpdateInterfaceDetails(id, friendlyName, mac, addresses)
+}
+
+// GetInterfaces calls DB.GetInterfaces on the default database
+func GetInterfaces() ([]NetworkInterface, error) {
+	return defaultDB.GetInterfaces()
+}
+
+// StreamPackets calls DB.StreamPackets on the default database
+func StreamPackets(filter PacketFilter, fn func(PacketRecord) error) error {
+	return defaultDB.StreamPackets(filter, fn)
+}
+
+// StreamFlows calls DB.StreamFlows on the default database
+func StreamFlows(filter PacketFilter, fn func(FlowRecord) error) error {
+	return defaultDB.StreamFlows(filter, fn)
+}
+
+// QueryPackets calls DB.QueryPackets on the default database
+func QueryPackets(q PacketQuery) (PacketPage, error) {
+	return defaultDB.QueryPackets(q)
+}
+
+// QueryAppStats calls DB.QueryAppStats on the default database
+func QueryAppStats(q AppStatsQuery) (AppStatsPage, error) {
+	return defaultDB.QueryAppStats(q)
+}
+
+// StreamAppStats calls DB.StreamAppStats on the default database
+func StreamAppStats(filter PacketFilter, fn func(ApplicationStats) error) error {
+	return defaultDB.StreamAppStats(filter, fn)
+}
+
+// PurgeBefore calls DB.PurgeBefore on the default database
+func PurgeBefore(cutoff time.Time) (int64, int64, error) {
+	return defaultDB.PurgeBefore(cutoff)
+}
+
+// Vacuum calls DB.Vacuum on the default database
+func Vacuum() error {
+	return defaultDB.Vacuum()
+}
+
+// StoreAppDestinations calls DB.StoreAppDestinations on the default database
+func StoreAppDestinations(appKey string, processID uint32, destinations []AppDestination) error {
+	return defaultDB.StoreAppDestinations(appKey, processID, destinations)
+}
+
+// GetAppDestinations calls DB.GetAppDestinations on the default database
+func GetAppDestinations(appStatsID int64, limit int) ([]AppDestination, error) {
+	return defaultDB.GetAppDestinations(appStatsID, limit)
+}
+
+// GetDestinationsForApp calls DB.GetDestinationsForApp on the default database
+func GetDestinationsForApp(appKey string) ([]AppDestination, error) {
+	return defaultDB.GetDestinationsForApp(appKey)
+}
+
+// HasAppDestination calls DB.HasAppDestination on the default database
+func HasAppDestination(appKey, destination string) (bool, error) {
+	return defaultDB.HasAppDestination(appKey, destination)
+}
+
+// StoreReverseDNSEntry calls DB.StoreReverseDNSEntry on the default database
+func StoreReverseDNSEntry(entry ReverseDNSEntry) error {
+	return defaultDB.StoreReverseDNSEntry(entry)
+}
+
+// GetReverseDNSEntries calls DB.GetReverseDNSEntries on the default database
+func GetReverseDNSEntries() ([]ReverseDNSEntry, error) {
+	return defaultDB.GetReverseDNSEntries()
+}
+
+// GetTopAppsByBytes calls DB.GetTopAppsByBytes on the default database
+func GetTopAppsByBytes(since time.Time, limit int) ([]TopApp, error) {
+	return defaultDB.GetTopAppsByBytes(since, limit)
+}
+
+// GetTopDestinations calls DB.GetTopDestinations on the default database
+func GetTopDestinations(since time.Time, limit int) ([]TopDestination, error) {
+	return defaultDB.GetTopDestinations(since, limit)
+}
+
+// GetTopPorts calls DB.GetTopPorts on the default database
+func GetTopPorts(since time.Time, limit int) ([]TopPort, error) {
+	return defaultDB.GetTopPorts(since, limit)
+}
+
+// GetOpenListeners calls DB.GetOpenListeners on the default database
+func GetOpenListeners() ([]Listener, error) {
+	return defaultDB.GetOpenListeners()
+}
+
+// GetTotalsSince calls DB.GetTotalsSince on the default database
+func GetTotalsSince(since time.Time) (TrafficTotals, error) {
+	return defaultDB.GetTotalsSince(since)
+}
@@ -0,0 +1,224 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// AppDestination holds the traffic an application exchanged with one destination.
+// PacketCount and ByteCount are deltas when storing and totals when reading.
+type AppDestination struct {
+	Destination string
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	PacketCount uint64
+	ByteCount   uint64
+	ReverseHost string // PTR name of an IP destination, if resolved
+	Country     string // ISO country code of the destination's IP, if known
+}
+
+// createAppDestinationsTable creates the per-application destination table
+func (db *DB) createAppDestinationsTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS app_destinations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			app_stats_id INTEGER NOT NULL,
+			destination TEXT NOT NULL,
+			first_seen TIMESTAMP NOT NULL,
+			last_seen TIMESTAMP NOT NULL,
+			packet_count INTEGER NOT NULL DEFAULT 0,
+			byte_count INTEGER NOT NULL DEFAULT 0,
+			reverse_host TEXT,
+			country TEXT,
+			UNIQUE(app_stats_id, destination),
+			FOREIGN KEY (app_stats_id) REFERENCES application_stats(id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// The unique constraint covers lookups by application; this one answers
+	// "which applications talked to this address"
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_app_destinations_destination ON app_destinations(destination)`)
+	if err != nil {
+		return fmt.Errorf("error creating index: %v", err)
+	}
+
+	return nil
+}
+
+// StoreAppDestinations adds traffic per destination to the stored totals of
+// the application with the given AppKey, creating destinations seen for the
+// first time. PacketCount and ByteCount are the counts since the previous call.
+func (db *DB) StoreAppDestinations(appKey string, processID uint32, destinations []AppDestination) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if len(destinations) == 0 {
+		return nil
+	}
+
+	appStatsID, err := db.appStatsID(appKey, processID)
+	if err != nil {
+		return err
+	}
+
+	if err := retryWrite(func() error { return db.storeAppDestinations(appStatsID, destinations) }); err != nil {
+		return fmt.Errorf("failed to store destinations: %v", err)
+	}
+	return nil
+}
+
+// storeAppDestinations upserts destinations in one transaction. Errors are
+// returned unwrapped so retryWrite can recognize lock conflicts.
+func (db *DB) storeAppDestinations(appStatsID int64, destinations []AppDestination) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO app_destinations (app_stats_id, destination, first_seen, last_seen, packet_count, byte_count, reverse_host, country)
+		VALUES (?, ?, ?, ?, ?, ?, NULLIF(?, ''), NULLIF(?, ''))
+		ON CONFLICT (app_stats_id, destination)
+		DO UPDATE SET last_seen = excluded.last_seen,
+		              packet_count = packet_count + excluded.packet_count,
+		              byte_count = byte_count + excluded.byte_count,
+		              reverse_host = COALESCE(excluded.reverse_host, reverse_host),
+		              country = COALESCE(excluded.country, country)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, d := range destinations {
+		if _, err := stmt.Exec(appStatsID, d.Destination, dbTime(d.FirstSeen), dbTime(d.LastSeen), d.PacketCount, d.ByteCount, d.ReverseHost, d.Country); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetAppDestinations returns up to limit destinations of an application row,
+// most recently used first. A non-positive limit returns every destination.
+func (db *DB) GetAppDestinations(appStatsID int64, limit int) ([]AppDestination, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	if limit <= 0 {
+		limit = -1 // SQLite treats a negative LIMIT as no limit
+	}
+
+	rows, err := db.Query(`
+		SELECT destination, first_seen, last_seen, packet_count, byte_count, COALESCE(reverse_host, ''), COALESCE(country, '')
+		FROM app_destinations
+		WHERE app_stats_id = ?
+		ORDER BY last_seen DESC
+		LIMIT ?
+	`, appStatsID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query destinations: %v", err)
+	}
+	defer rows.Close()
+
+	return scanAppDestinations(rows)
+}
+
+// GetDestinationsForApp returns the destinations stored for every row of the
+// application with the given AppKey, merging rows of earlier PIDs, most
+// recently used first
+func (db *DB) GetDestinationsForApp(appKey string) ([]AppDestination, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(`
+		SELECT d.destination, MIN(d.first_seen), MAX(d.last_seen), SUM(d.packet_count), SUM(d.byte_count),
+		       COALESCE(MAX(d.reverse_host), ''), COALESCE(MAX(d.country), '')
+		FROM app_destinations d
+		JOIN application_stats a ON a.id = d.app_stats_id
+		WHERE a.app_key = ?
+		GROUP BY d.destination
+		ORDER BY MAX(d.last_seen) DESC
+	`, appKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query destinations for %s: %v", appKey, err)
+	}
+	defer rows.Close()
+
+	return scanAppDestinations(rows)
+}
+
+// HasAppDestination reports whether the application with the given AppKey has
+// ever been recorded talking to a destination, under any PID
+func (db *DB) HasAppDestination(appKey, destination string) (bool, error) {
+	if db == nil {
+		return false, fmt.Errorf("database not initialized")
+	}
+
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM app_destinations d
+			JOIN application_stats a ON a.id = d.app_stats_id
+			WHERE a.app_key = ? AND d.destination = ?
+		)
+	`, appKey, destination).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up destination %s: %v", destination, err)
+	}
+	return exists, nil
+}
+
+// scanAppDestinations reads destination rows. Aggregated timestamps come back
+// as text, so both forms are accepted.
+func scanAppDestinations(rows *sql.Rows) ([]AppDestination, error) {
+	destinations := []AppDestination{}
+	for rows.Next() {
+		var (
+			d                   AppDestination
+			firstSeen, lastSeen interface{}
+		)
+		if err := rows.Scan(&d.Destination, &firstSeen, &lastSeen, &d.PacketCount, &d.ByteCount, &d.ReverseHost, &d.Country); err != nil {
+			return nil, fmt.Errorf("failed to scan destination: %v", err)
+		}
+		d.FirstSeen = scannedTime(firstSeen)
+		d.LastSeen = scannedTime(lastSeen)
+		destinations = append(destinations, d)
+	}
+	return destinations, rows.Err()
+}
+
+// scannedTime converts a timestamp column scanned into an interface{}
+func scannedTime(value interface{}) time.Time {
+	switch v := value.(type) {
+	case time.Time:
+		return v
+	case string:
+		return parseTimestamp(v)
+	case []byte:
+		return parseTimestamp(string(v))
+	}
+	return time.Time{}
+}
+
+// appStatsID looks up the row of an application, by AppKey, and PID
+func (db *DB) appStatsID(appKey string, processID uint32) (int64, error) {
+	var id int64
+	err := db.QueryRow(`
+		SELECT id FROM application_stats
+		WHERE app_key = ? AND process_id = ?
+	`, appKey, processID).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("application stats not found for %s (PID %d)", appKey, processID)
+		}
+		return 0, fmt.Errorf("error getting app stats ID: %v", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDestinationsBySameNamedApps stores two executables of the same name and
+// PID in different folders and checks that neither sees the other's
+// destinations
+func TestDestinationsBySameNamedApps(t *testing.T) {
+	db := openTestDB(t, MemoryPath)
+	now := time.Now()
+
+	apps := []struct {
+		path        string
+		destination string
+	}{
+		{`C:\Program Files\Vendor\updater.exe`, "updates.vendor.example"},
+		{`C:\Users\Public\updater.exe`, "203.0.113.7"},
+	}
+	for _, app := range apps {
+		err := db.StoreAppStats(&ApplicationStats{
+			ProcessID:    42,
+			ProcessName:  "updater.exe",
+			ProcessPath:  app.path,
+			TotalPackets: 1,
+			FirstSeen:    now,
+			LastSeen:     now,
+		})
+		if err != nil {
+			t.Fatalf("storing %s: %v", app.path, err)
+		}
+		key := AppKey("updater.exe", app.path, "")
+		err = db.StoreAppDestinations(key, 42, []AppDestination{
+			{Destination: app.destination, FirstSeen: now, LastSeen: now, PacketCount: 1, ByteCount: 100},
+		})
+		if err != nil {
+			t.Fatalf("storing destinations of %s: %v", app.path, err)
+		}
+	}
+
+	for i, app := range apps {
+		other := apps[1-i]
+		// Keys fold case, as Windows paths do
+		key := AppKey("updater.exe", strings.ToUpper(app.path), "")
+
+		destinations, err := db.GetDestinationsForApp(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(destinations) != 1 || destinations[0].Destination != app.destination {
+			t.Errorf("%s: destinations = %+v, want only %s", app.path, destinations, app.destination)
+		}
+
+		tests := []struct {
+			destination string
+			want        bool
+		}{
+			{app.destination, true},
+			{other.destination, false},
+		}
+		for _, tt := range tests {
+			got, err := db.HasAppDestination(key, tt.destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s: HasAppDestination(%s) = %v, want %v", app.path, tt.destination, got, tt.want)
+			}
+		}
+
+		stats, err := db.GetAppStatsForKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 1 || stats[0].ProcessPath != app.path {
+			t.Errorf("%s: GetAppStatsForKey = %d rows, want its own", app.path, len(stats))
+		}
+	}
+}
+
+func TestAppKey(t *testing.T) {
+	tests := []struct {
+		name, path, service string
+		want                string
+	}{
+		{"System", "", "", "System"},
+		{"updater.exe", `C:\Tools\Updater.exe`, "", `c:\tools\updater.exe`},
+		{"svchost.exe", `C:\Windows\System32\svchost.exe`, "Dnscache", `c:\windows\system32\svchost.exe (Dnscache)`},
+	}
+	for _, tt := range tests {
+		if got := AppKey(tt.name, tt.path, tt.service); got != tt.want {
+			t.Errorf("AppKey(%q, %q, %q) = %q, want %q", tt.name, tt.path, tt.service, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Page sizes of QueryPackets and QueryAppStats
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// ErrInvalidCursor is returned by QueryPackets and QueryAppStats for a cursor
+// they did not make
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PacketQuery selects one page of stored packets, oldest first
+type PacketQuery struct {
+	PacketFilter
+	Cursor string // NextCursor of the previous page; empty for the first page
+	Limit  int    // Packets per page: DefaultPageSize if 0, at most MaxPageSize
+}
+
+// PacketPage is one page of packets. NextCursor continues after its last
+// packet and is empty once there are no more.
+type PacketPage struct {
+	Packets    []PacketRecord
+	NextCursor string
+}
+
+// AppStatsQuery selects one page of application rows last seen within the
+// filter's time range, in the order they were first stored. Protocol and
+// Direction filters do not apply to applications.
+type AppStatsQuery struct {
+	PacketFilter
+	Cursor string
+	Limit  int
+}
+
+// AppStatsPage is one page of application rows
+type AppStatsPage struct {
+	Apps       []*ApplicationStats
+	NextCursor string
+}
+
+// QueryPackets returns a page of the packets matching the query. Pages are
+// keyed on the timestamp index rather than an offset, so a deep page costs
+// no more than the first and packets stored meanwhile don't shift them.
+func (db *DB) QueryPackets(q PacketQuery) (PacketPage, error) {
+	var page PacketPage
+	if db == nil {
+		return page, fmt.Errorf("database not initialized")
+	}
+	limit := pageSize(q.Limit)
+
+	query := `SELECT ` + packetColumns + `, CAST(timestamp AS TEXT) FROM packet_logs`
+	where, args := q.where("timestamp", "timestamp")
+	if q.Cursor != "" {
+		// The cursor holds the last timestamp as stored, so it compares exactly
+		parts, err := decodeCursor(q.Cursor, 2)
+		if err != nil {
+			return page, err
+		}
+		where += ` AND (timestamp > ? OR (timestamp = ? AND id > ?))`
+		args = append(args, parts[0], parts[0], parts[1])
+	}
+	query += where + ` ORDER BY timestamp, id LIMIT ?`
+	args = append(args, limit+1) // One more tells whether there is another page
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return page, fmt.Errorf("failed to query packets: %v", err)
+	}
+	defer rows.Close()
+
+	page.Packets = []PacketRecord{}
+	var lastTimestamp string
+	for rows.Next() {
+		if len(page.Packets) == limit {
+			last := page.Packets[limit-1]
+			page.NextCursor = encodeCursor(lastTimestamp, strconv.FormatInt(last.ID, 10))
+			break
+		}
+		record, err := scanPacket(rows, &lastTimestamp)
+		if err != nil {
+			return page, err
+		}
+		page.Packets = append(page.Packets, record)
+	}
+	return page, rows.Err()
+}
+
+// QueryAppStats returns a page of the application rows matching the query
+func (db *DB) QueryAppStats(q AppStatsQuery) (AppStatsPage, error) {
+	var page AppStatsPage
+	if db == nil {
+		return page, fmt.Errorf("database not initialized")
+	}
+	limit := pageSize(q.Limit)
+
+	q.Protocol = ""
+	q.Direction = ""
+	query := `SELECT ` + appStatsColumns + ` FROM application_stats`
+	where, args := q.where("last_seen", "first_seen")
+	if q.Cursor != "" {
+		parts, err := decodeCursor(q.Cursor, 1)
+		if err != nil {
+			return page, err
+		}
+		where += ` AND id > ?`
+		args = append(args, parts[0])
+	}
+	query += where + ` ORDER BY id LIMIT ?`
+	args = append(args, limit+1)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return page, fmt.Errorf("failed to query application stats: %v", err)
+	}
+	defer rows.Close()
+
+	apps, err := scanAppStats(rows)
+	if err != nil {
+		return page, err
+	}
+	page.Apps = []*ApplicationStats{}
+	if len(apps) > limit {
+		apps = apps[:limit]
+		page.NextCursor = encodeCursor(strconv.FormatInt(apps[limit-1].ID, 10))
+	}
+	page.Apps = append(page.Apps, apps...)
+	return page, nil
+}
+
+// pageSize applies the default and maximum page size to a requested limit
+func pageSize(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	return min(limit, MaxPageSize)
+}
+
+// encodeCursor joins the values a page ends on into an opaque cursor
+func encodeCursor(values ...string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(values, "\n")))
+}
+
+// decodeCursor splits a cursor made by encodeCursor into its n values
+func decodeCursor(cursor string, n int) ([]string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	values := strings.Split(string(data), "\n")
+	if len(values) != n {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := strconv.ParseInt(values[n-1], 10, 64); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return values, nil
+}
@@ -0,0 +1,160 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Listener is a listening socket and the process that owns it. A socket is
+// identified by its protocol, address, port and executable, so a program that
+// restarts keeps its row while another program on the same port gets a new one.
+type Listener struct {
+	Protocol    string    `json:"protocol"`
+	Address     string    `json:"address"`
+	Port        uint16    `json:"port"`
+	ProcessID   uint32    `json:"process_id"`
+	ProcessName string    `json:"process_name,omitempty"`
+	ProcessPath string    `json:"process_path,omitempty"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+	Open        bool      `json:"open"`
+}
+
+// listenerTableStatements create the listeners table; shared by createTables
+// and the migration that introduced it
+var listenerTableStatements = []string{
+	`CREATE TABLE IF NOT EXISTS listeners (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		protocol TEXT NOT NULL,
+		address TEXT NOT NULL,
+		port INTEGER NOT NULL,
+		process_path TEXT NOT NULL DEFAULT '',
+		process_id INTEGER,
+		process_name TEXT,
+		first_seen TIMESTAMP NOT NULL,
+		last_seen TIMESTAMP NOT NULL,
+		open INTEGER NOT NULL DEFAULT 1,
+		UNIQUE (protocol, address, port, process_path)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_listeners_open ON listeners(open, port)`,
+}
+
+func (db *DB) createListenerTable() error {
+	for _, statement := range listenerTableStatements {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateListenerTable adds the listeners table
+func migrateListenerTable(tx *sql.Tx) error {
+	for _, statement := range listenerTableStatements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordListeners stores the listening sockets found by a scan at seen and
+// marks the ones missing from it as closed. It returns the listeners that
+// weren't in the table before, and whether the table was empty, in which case
+// the scan is the baseline rather than a change.
+func (db *DB) RecordListeners(listeners []Listener, seen time.Time) ([]Listener, bool, error) {
+	if db == nil {
+		return nil, false, fmt.Errorf("database not initialized")
+	}
+
+	var added []Listener
+	var baseline bool
+	err := retryWrite(func() error {
+		var err error
+		added, baseline, err = db.recordListeners(listeners, seen)
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to record listeners: %v", err)
+	}
+	return added, baseline, nil
+}
+
+// recordListeners updates the listeners in one transaction. Errors are
+// returned unwrapped so retryWrite can recognize lock conflicts.
+func (db *DB) recordListeners(listeners []Listener, seen time.Time) ([]Listener, bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var recorded bool
+	if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM listeners)`).Scan(&recorded); err != nil {
+		return nil, false, err
+	}
+
+	var added []Listener
+	for _, l := range listeners {
+		result, err := tx.Exec(`
+			UPDATE listeners SET process_id = ?, process_name = ?, last_seen = ?, open = 1
+			WHERE protocol = ? AND address = ? AND port = ? AND process_path = ?
+		`, l.ProcessID, sql.NullString{String: l.ProcessName, Valid: l.ProcessName != ""}, dbTime(seen),
+			l.Protocol, l.Address, l.Port, l.ProcessPath)
+		if err != nil {
+			return nil, false, err
+		}
+		if updated, err := result.RowsAffected(); err != nil {
+			return nil, false, err
+		} else if updated > 0 {
+			continue
+		}
+
+		if _, err := tx.Exec(`
+			INSERT INTO listeners (protocol, address, port, process_path, process_id, process_name, first_seen, last_seen)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, l.Protocol, l.Address, l.Port, l.ProcessPath, l.ProcessID,
+			sql.NullString{String: l.ProcessName, Valid: l.ProcessName != ""}, dbTime(seen), dbTime(seen)); err != nil {
+			return nil, false, err
+		}
+		l.FirstSeen, l.LastSeen, l.Open = seen, seen, true
+		added = append(added, l)
+	}
+
+	if _, err := tx.Exec(`UPDATE listeners SET open = 0 WHERE open = 1 AND last_seen < ?`, dbTime(seen)); err != nil {
+		return nil, false, err
+	}
+	return added, !recorded, tx.Commit()
+}
+
+// GetOpenListeners returns the listeners that were open in the most recent
+// scan, ordered by protocol and port
+func (db *DB) GetOpenListeners() ([]Listener, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(`
+		SELECT protocol, address, port, COALESCE(process_id, 0), COALESCE(process_name, ''),
+		       process_path, first_seen, last_seen
+		FROM listeners
+		WHERE open = 1
+		ORDER BY protocol, port, address
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listeners: %v", err)
+	}
+	defer rows.Close()
+
+	listeners := []Listener{}
+	for rows.Next() {
+		l := Listener{Open: true}
+		if err := rows.Scan(&l.Protocol, &l.Address, &l.Port, &l.ProcessID, &l.ProcessName,
+			&l.ProcessPath, &l.FirstSeen, &l.LastSeen); err != nil {
+			return nil, fmt.Errorf("failed to scan listener: %v", err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, rows.Err()
+}
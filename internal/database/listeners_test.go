@@ -0,0 +1,89 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestRecordListeners records a series of scans and checks which listeners
+// each one adds, that only a scan of an empty table is the baseline, and
+// which rows are open afterwards
+func TestRecordListeners(t *testing.T) {
+	db := openTestDB(t, MemoryPath)
+
+	const server, other = `C:\Apps\server.exe`, `C:\Tools\server.exe`
+	web := Listener{Protocol: "TCP", Address: "0.0.0.0", Port: 8080, ProcessID: 100, ProcessName: "server.exe", ProcessPath: server}
+	dns := Listener{Protocol: "UDP", Address: "127.0.0.1", Port: 53, ProcessID: 200, ProcessName: "dns.exe", ProcessPath: `C:\Apps\dns.exe`}
+	restarted := web
+	restarted.ProcessID = 300
+	moved := web
+	moved.ProcessPath = other
+
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	steps := []struct {
+		name      string
+		scan      []Listener
+		wantAdded []Listener // Compared by identity only
+		baseline  bool
+		wantOpen  []string // Protocol, port and PID of the open listeners
+	}{
+		{"empty scan of an empty table", nil, nil, true, nil},
+		{"first listeners", []Listener{web, dns}, []Listener{web, dns}, true, []string{"TCP 8080 100", "UDP 53 200"}},
+		{"same listeners", []Listener{web, dns}, nil, false, []string{"TCP 8080 100", "UDP 53 200"}},
+		{"dns closed", []Listener{web}, nil, false, []string{"TCP 8080 100"}},
+		{"dns reopened", []Listener{web, dns}, nil, false, []string{"TCP 8080 100", "UDP 53 200"}},
+		{"server restarted", []Listener{restarted, dns}, nil, false, []string{"TCP 8080 300", "UDP 53 200"}},
+		// Every listener of this scan is new, but the table isn't empty
+		{"another executable", []Listener{moved}, []Listener{moved}, false, []string{"TCP 8080 100"}},
+		{"nothing listening", nil, nil, false, nil},
+	}
+	for i, step := range steps {
+		seen := base.Add(time.Duration(i) * time.Minute)
+		added, baseline, err := db.RecordListeners(step.scan, seen)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if baseline != step.baseline {
+			t.Errorf("%s: baseline %v, want %v", step.name, baseline, step.baseline)
+		}
+		if len(added) != len(step.wantAdded) {
+			t.Errorf("%s: added %+v, want %+v", step.name, added, step.wantAdded)
+		}
+		for j := range added {
+			if j < len(step.wantAdded) && (added[j].Port != step.wantAdded[j].Port || added[j].ProcessPath != step.wantAdded[j].ProcessPath ||
+				!added[j].FirstSeen.Equal(seen) || !added[j].Open) {
+				t.Errorf("%s: added %+v, want %+v first seen at %v", step.name, added[j], step.wantAdded[j], seen)
+			}
+		}
+
+		open, err := db.GetOpenListeners()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range open {
+			got = append(got, fmt.Sprintf("%s %d %d", l.Protocol, l.Port, l.ProcessID))
+			if !l.LastSeen.Equal(seen) {
+				t.Errorf("%s: %s:%d last seen %v, want %v", step.name, l.Protocol, l.Port, l.LastSeen, seen)
+			}
+		}
+		if !reflect.DeepEqual(got, step.wantOpen) {
+			t.Errorf("%s: open %v, want %v", step.name, got, step.wantOpen)
+		}
+	}
+
+	// A restart keeps the row and when it was first seen
+	var rows int
+	var firstSeen time.Time
+	if err := db.QueryRow(`SELECT COUNT(*) FROM listeners`).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT first_seen FROM listeners WHERE process_path = ?`, server).Scan(&firstSeen); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 3 || !firstSeen.Equal(base.Add(time.Minute)) {
+		t.Errorf("%d rows with the server first seen at %v, want 3 rows and %v", rows, firstSeen, base.Add(time.Minute))
+	}
+}
@@ -0,0 +1,627 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// migration upgrades the schema by one version. It runs in a transaction that
+// also records the new version, so it is applied completely or not at all.
+type migration struct {
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations are applied in order; migration i is schema version i+1. Each
+// applied version is recorded in schema_migrations. Append new ones to the end
+// and mirror them in createTables, which builds new databases at the latest
+// version; never edit or reorder a migration that has shipped.
+//
+// The first four predate version tracking and check what they change, as
+// databases created before it start at version 0 in any state.
+var migrations = []migration{
+	{"add packet_logs.direction", migrateDirectionColumn},
+	{"replace packet_logs.device with device_id", migrateDeviceID},
+	{"add columns introduced before version tracking", migrateUntrackedColumns},
+	{"move destination blobs to app_destinations", migrateDestinationBlobs},
+	{"add packet_logs.packet_count and total_bytes", migratePacketCounts},
+	{"add hourly and daily application rollups", migrateRollupTables},
+	{"add application_stats.command_line", migrateCommandLine},
+	{"key application_stats on the executable path", migrateAppKey},
+	{"drop application_stats.destinations", migrateDropDestinationBlobs},
+	{"add flows.attribution", migrateFlowAttribution},
+	{"add packet_logs.src_mac and dst_mac", migratePacketMACs},
+	{"add listeners table", migrateListenerTable},
+	{"add packet_logs.vlan", migratePacketVLAN},
+	{"store timestamps in UTC", migrateUTCTimestamps},
+	{"move destination countries to app_destinations.country", migrateDestinationCountry},
+	{"key hourly and daily rollups on the application key", migrateRollupAppKey},
+}
+
+// SchemaTooNewError is returned when the database was migrated by a newer
+// build than this one
+type SchemaTooNewError struct {
+	Version   int
+	Supported int
+}
+
+func (e *SchemaTooNewError) Error() string {
+	return fmt.Sprintf("database schema version %d is newer than this build supports (%d); upgrade grip to use this database",
+		e.Version, e.Supported)
+}
+
+// LatestSchemaVersion is the schema version this build creates and migrates to
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// SchemaVersion returns the highest migration applied to the database, or 0
+// for a database created before version tracking
+func (db *DB) SchemaVersion() (int, error) {
+	exists, err := db.tableExists("schema_migrations")
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	var version int
+	if err := db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("error reading schema version: %v", err)
+	}
+	return version, nil
+}
+
+// checkSchemaVersion refuses databases written by a newer build, whose
+// schema this one may misread or damage
+func (db *DB) checkSchemaVersion() (int, error) {
+	version, err := db.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if version > len(migrations) {
+		return 0, &SchemaTooNewError{Version: version, Supported: len(migrations)}
+	}
+	return version, nil
+}
+
+// tableExists reports whether the database has the named table
+func (db *DB) tableExists(name string) (bool, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, name).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking for %s table: %v", name, err)
+	}
+	return count > 0, nil
+}
+
+// createMigrationsTable creates the table recording applied schema versions
+func (db *DB) createMigrationsTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)
+	`)
+	return err
+}
+
+// migrate applies the migrations after version. A new database already has the
+// latest schema from createTables, so its versions are only recorded.
+func (db *DB) migrate(version int, created bool) error {
+	for i := version; i < len(migrations); i++ {
+		if created {
+			if err := db.applyMigration(i+1, migration{description: migrations[i].description}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		log.Printf("Applying database migration %d: %s", i+1, migrations[i].description)
+		if err := db.applyMigration(i+1, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", i+1, migrations[i].description, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs one migration and records version in the same transaction
+func (db *DB) applyMigration(version int, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.apply != nil {
+		if err := m.apply(tx); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)`,
+		version, m.description, time.Now())
+	if err != nil {
+		return fmt.Errorf("error recording schema version: %v", err)
+	}
+	return tx.Commit()
+}
+
+// columnExists reports whether table has the named column
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info(?)
+		WHERE name = ?
+	`, table, column).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("error checking for %s column: %v", column, err)
+	}
+	return count > 0, nil
+}
+
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	exists, err := columnExists(tx, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	log.Printf("Adding %s column to %s table", column, table)
+	if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+		return fmt.Errorf("error adding %s column: %v", column, err)
+	}
+	return nil
+}
+
+// migrateDirectionColumn adds the direction column to packet_logs
+func migrateDirectionColumn(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "packet_logs", "direction", "TEXT")
+}
+
+// migrateDeviceID replaces the device name column of packet_logs with a
+// reference to network_interfaces
+func migrateDeviceID(tx *sql.Tx) error {
+	exists, err := columnExists(tx, "packet_logs", "device")
+	if err != nil || !exists {
+		return err
+	}
+
+	log.Printf("Migrating from device to device_id in packet_logs table")
+
+	// Create a temporary table for migration
+	_, err = tx.Exec(`
+		CREATE TABLE IF NOT EXISTS packet_logs_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			device_id INTEGER NOT NULL,
+			src_ip TEXT NOT NULL,
+			src_port TEXT NOT NULL,
+			dst_ip TEXT NOT NULL,
+			dst_port TEXT NOT NULL,
+			protocol TEXT NOT NULL,
+			length INTEGER NOT NULL,
+			process_id INTEGER,
+			process_name TEXT,
+			process_path TEXT,
+			direction TEXT,
+			FOREIGN KEY (device_id) REFERENCES network_interfaces (id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating new packet_logs table: %v", err)
+	}
+
+	// Move data to the new table, ignoring records that can't be migrated
+	_, err = tx.Exec(`
+		INSERT INTO packet_logs_new (
+			timestamp, device_id, src_ip, src_port, dst_ip, dst_port,
+			protocol, length, process_id, process_name, process_path, direction
+		)
+		SELECT
+			p.timestamp,
+			COALESCE(n.id, 0) AS device_id,
+			p.src_ip, p.src_port, p.dst_ip, p.dst_port,
+			p.protocol, p.length, p.process_id, p.process_name, p.process_path, p.direction
+		FROM packet_logs p
+		LEFT JOIN network_interfaces n ON p.device = n.name
+	`)
+	if err != nil {
+		return fmt.Errorf("error migrating data to new table: %v", err)
+	}
+
+	// Replace old table with new one
+	if _, err := tx.Exec(`DROP TABLE packet_logs`); err != nil {
+		return fmt.Errorf("error dropping old table: %v", err)
+	}
+	if _, err := tx.Exec(`ALTER TABLE packet_logs_new RENAME TO packet_logs`); err != nil {
+		return fmt.Errorf("error renaming new table: %v", err)
+	}
+
+	// Recreate indexes
+	indexes := []string{
+		`CREATE INDEX IF NOT EXISTS idx_timestamp ON packet_logs(timestamp)`,
+		`CREATE INDEX IF NOT EXISTS idx_protocol ON packet_logs(protocol)`,
+		`CREATE INDEX IF NOT EXISTS idx_process_name ON packet_logs(process_name)`,
+		`CREATE INDEX IF NOT EXISTS idx_device_id ON packet_logs(device_id)`,
+	}
+	for _, idx := range indexes {
+		if _, err := tx.Exec(idx); err != nil {
+			return fmt.Errorf("error recreating index: %v", err)
+		}
+	}
+	return nil
+}
+
+// migrateUntrackedColumns adds the columns introduced after the initial schema
+// but before version tracking. It runs after the device_id rebuild so the
+// rebuilt table picks them up too.
+func migrateUntrackedColumns(tx *sql.Tx) error {
+	columns := []struct{ table, column, definition string }{
+		{"network_interfaces", "friendly_name", "TEXT"},
+		{"network_interfaces", "mac", "TEXT"},
+		{"network_interfaces", "addresses", "TEXT"},
+		{"network_interfaces", "updated_at", "TIMESTAMP"},
+		{"protocol_stats", "byte_count", "INTEGER NOT NULL DEFAULT 0"},
+		{"packet_logs", "dst_host", "TEXT"},
+		{"packet_logs", "service_name", "TEXT"},
+		{"packet_logs", "geoip", "TEXT"},
+		{"packet_logs", "scope", "TEXT"},
+		{"packet_logs", "payload", "TEXT"},
+		{"packet_logs", "flagged", "INTEGER NOT NULL DEFAULT 0"},
+		{"packet_logs", "process_owner", "TEXT"},
+		{"flows", "scope", "TEXT"},
+		{"application_stats", "service_name", "TEXT"},
+		{"application_stats", "process_owner", "TEXT"},
+		{"application_stats", "exe_sha256", "TEXT"},
+		{"application_stats", "exe_publisher", "TEXT"},
+		{"application_stats", "exe_error", "TEXT"},
+		{"application_stats", "packets_in", "INTEGER NOT NULL DEFAULT 0"},
+		{"application_stats", "bytes_in", "INTEGER NOT NULL DEFAULT 0"},
+		{"application_stats", "packets_out", "INTEGER NOT NULL DEFAULT 0"},
+		{"application_stats", "bytes_out", "INTEGER NOT NULL DEFAULT 0"},
+		{"app_destinations", "reverse_host", "TEXT"},
+	}
+	for _, c := range columns {
+		if err := addColumnIfMissing(tx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateDestinationBlobs moves destinations stored as a JSON array in
+// application_stats.destinations into app_destinations and clears the column
+func migrateDestinationBlobs(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT id, destinations, first_seen, last_seen
+		FROM application_stats
+		WHERE destinations IS NOT NULL AND destinations != ''
+	`)
+	if err != nil {
+		return fmt.Errorf("error reading destination blobs: %v", err)
+	}
+
+	type blob struct {
+		appStatsID          int64
+		destinations        string
+		firstSeen, lastSeen time.Time
+	}
+	var blobs []blob
+	for rows.Next() {
+		var b blob
+		if err := rows.Scan(&b.appStatsID, &b.destinations, &b.firstSeen, &b.lastSeen); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning destination blob: %v", err)
+		}
+		blobs = append(blobs, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading destination blobs: %v", err)
+	}
+
+	if len(blobs) == 0 {
+		return nil
+	}
+
+	log.Printf("Migrating destinations of %d applications to app_destinations table", len(blobs))
+
+	for _, b := range blobs {
+		var destinations []string
+		if err := json.Unmarshal([]byte(b.destinations), &destinations); err != nil {
+			// Keep going; an unreadable blob only loses its destination list
+			log.Printf("Skipping unreadable destinations for application %d: %v", b.appStatsID, err)
+			destinations = nil
+		}
+
+		// Per-destination traffic was never recorded, so counts start at zero
+		for _, destination := range destinations {
+			_, err := tx.Exec(`
+				INSERT INTO app_destinations (app_stats_id, destination, first_seen, last_seen)
+				VALUES (?, ?, ?, ?)
+				ON CONFLICT (app_stats_id, destination) DO NOTHING
+			`, b.appStatsID, destination, b.firstSeen, b.lastSeen)
+			if err != nil {
+				return fmt.Errorf("error migrating destination %s: %v", destination, err)
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE application_stats SET destinations = NULL WHERE id = ?`, b.appStatsID); err != nil {
+			return fmt.Errorf("error clearing destination blob: %v", err)
+		}
+	}
+	return nil
+}
+
+// migratePacketCounts adds the columns of packets aggregated per second. Every
+// existing row is a single packet.
+func migratePacketCounts(tx *sql.Tx) error {
+	statements := []string{
+		`ALTER TABLE packet_logs ADD COLUMN packet_count INTEGER NOT NULL DEFAULT 1`,
+		`ALTER TABLE packet_logs ADD COLUMN total_bytes INTEGER NOT NULL DEFAULT 0`,
+		`UPDATE packet_logs SET total_bytes = length`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateCommandLine adds the command line of the process behind each application
+func migrateCommandLine(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE application_stats ADD COLUMN command_line TEXT`)
+	return err
+}
+
+// migrateAppKey rebuilds application_stats unique on AppKey and PID instead
+// of name and PID, so same-named executables in different folders get rows
+// of their own. Keys are computed here rather than in SQL, whose LOWER only
+// folds ASCII. Rows whose paths differ only in case share a key; they are
+// merged into the oldest, together with their protocols and destinations.
+func migrateAppKey(tx *sql.Tx) error {
+	_, err := tx.Exec(`
+		CREATE TABLE application_stats_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			process_id INTEGER NOT NULL,
+			process_name TEXT NOT NULL,
+			process_path TEXT,
+			service_name TEXT,
+			process_owner TEXT,
+			exe_sha256 TEXT,
+			exe_publisher TEXT,
+			exe_error TEXT,
+			command_line TEXT,
+			total_packets INTEGER NOT NULL DEFAULT 0,
+			total_bytes INTEGER NOT NULL DEFAULT 0,
+			packets_in INTEGER NOT NULL DEFAULT 0,
+			bytes_in INTEGER NOT NULL DEFAULT 0,
+			packets_out INTEGER NOT NULL DEFAULT 0,
+			bytes_out INTEGER NOT NULL DEFAULT 0,
+			last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			destinations TEXT,
+			first_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			app_key TEXT NOT NULL,
+			UNIQUE(app_key, process_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating new application_stats table: %v", err)
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, process_id, process_name, COALESCE(process_path, ''), COALESCE(service_name, '')
+		FROM application_stats ORDER BY id
+	`)
+	if err != nil {
+		return fmt.Errorf("error reading application stats: %v", err)
+	}
+	type row struct {
+		id        int64
+		processID uint32
+		key       string
+	}
+	var apps []row
+	for rows.Next() {
+		var (
+			r                   row
+			name, path, service string
+		)
+		if err := rows.Scan(&r.id, &r.processID, &name, &path, &service); err != nil {
+			rows.Close()
+			return fmt.Errorf("error scanning application stats: %v", err)
+		}
+		r.key = AppKey(name, path, service)
+		apps = append(apps, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error reading application stats: %v", err)
+	}
+
+	type identity struct {
+		key       string
+		processID uint32
+	}
+	kept := make(map[identity]int64)
+	for _, r := range apps {
+		keptID, duplicate := kept[identity{r.key, r.processID}]
+		if !duplicate {
+			kept[identity{r.key, r.processID}] = r.id
+			_, err := tx.Exec(`
+				INSERT INTO application_stats_new
+				SELECT id, process_id, process_name, process_path, service_name, process_owner,
+				       exe_sha256, exe_publisher, exe_error, command_line,
+				       total_packets, total_bytes, packets_in, bytes_in, packets_out, bytes_out,
+				       last_updated, destinations, first_seen, last_seen, ?
+				FROM application_stats WHERE id = ?
+			`, r.key, r.id)
+			if err != nil {
+				return fmt.Errorf("error copying application stats: %v", err)
+			}
+			continue
+		}
+
+		log.Printf("Merging application stats row %d into row %d of %s", r.id, keptID, r.key)
+		if err := mergeAppStatsRow(tx, r.id, keptID); err != nil {
+			return err
+		}
+	}
+
+	statements := []string{
+		`DROP TABLE application_stats`,
+		`ALTER TABLE application_stats_new RENAME TO application_stats`,
+		`CREATE INDEX IF NOT EXISTS idx_app_stats_process_name ON application_stats(process_name)`,
+		`CREATE INDEX IF NOT EXISTS idx_app_stats_process_id ON application_stats(process_id)`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("error replacing application_stats table: %v", err)
+		}
+	}
+	return nil
+}
+
+// mergeAppStatsRow adds the totals, protocols and destinations of the
+// application_stats row from to the copied row into, for migrateAppKey
+func mergeAppStatsRow(tx *sql.Tx, from, into int64) error {
+	statements := []string{
+		`UPDATE application_stats_new AS n SET
+			total_packets = n.total_packets + o.total_packets,
+			total_bytes = n.total_bytes + o.total_bytes,
+			packets_in = n.packets_in + o.packets_in,
+			bytes_in = n.bytes_in + o.bytes_in,
+			packets_out = n.packets_out + o.packets_out,
+			bytes_out = n.bytes_out + o.bytes_out,
+			first_seen = MIN(n.first_seen, o.first_seen),
+			last_seen = MAX(n.last_seen, o.last_seen)
+		FROM (SELECT * FROM application_stats WHERE id = ?1) AS o
+		WHERE n.id = ?2`,
+		`INSERT INTO protocol_stats (app_stats_id, protocol, packet_count, byte_count)
+		SELECT ?2, protocol, packet_count, byte_count FROM protocol_stats WHERE app_stats_id = ?1
+		ON CONFLICT (app_stats_id, protocol) DO UPDATE SET
+			packet_count = packet_count + excluded.packet_count,
+			byte_count = byte_count + excluded.byte_count`,
+		`DELETE FROM protocol_stats WHERE app_stats_id = ?1`,
+		`INSERT INTO app_destinations (app_stats_id, destination, first_seen, last_seen, packet_count, byte_count, reverse_host)
+		SELECT ?2, destination, first_seen, last_seen, packet_count, byte_count, reverse_host
+		FROM app_destinations WHERE app_stats_id = ?1
+		ON CONFLICT (app_stats_id, destination) DO UPDATE SET
+			first_seen = MIN(first_seen, excluded.first_seen),
+			last_seen = MAX(last_seen, excluded.last_seen),
+			packet_count = packet_count + excluded.packet_count,
+			byte_count = byte_count + excluded.byte_count,
+			reverse_host = COALESCE(reverse_host, excluded.reverse_host)`,
+		`DELETE FROM app_destinations WHERE app_stats_id = ?1`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement, from, into); err != nil {
+			return fmt.Errorf("error merging application stats row %d: %v", from, err)
+		}
+	}
+	return nil
+}
+
+// migrateDropDestinationBlobs drops the destinations column, emptied by
+// migrateDestinationBlobs, so no code can write JSON blobs into it again
+func migrateDropDestinationBlobs(tx *sql.Tx) error {
+	exists, err := columnExists(tx, "application_stats", "destinations")
+	if err != nil || !exists {
+		return err
+	}
+	_, err = tx.Exec(`ALTER TABLE application_stats DROP COLUMN destinations`)
+	return err
+}
+
+// migrateFlowAttribution adds whether the process of each flow was found.
+// Databases from before the flows table get it from createTables, column
+// included, so the column may already be there.
+func migrateFlowAttribution(tx *sql.Tx) error {
+	return addColumnIfMissing(tx, "flows", "attribution", "TEXT")
+}
+
+// migratePacketMACs adds the link-layer addresses of each packet
+func migratePacketMACs(tx *sql.Tx) error {
+	statements := []string{
+		`ALTER TABLE packet_logs ADD COLUMN src_mac TEXT`,
+		`ALTER TABLE packet_logs ADD COLUMN dst_mac TEXT`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migratePacketVLAN adds the 802.1Q VLAN ID of packet rows, NULL for untagged
+// packets and rows stored before it
+func migratePacketVLAN(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE packet_logs ADD COLUMN vlan INTEGER`)
+	return err
+}
+
+// utcTimestampColumns are the time columns queried by range, which rows written
+// before migrateUTCTimestamps hold with the local UTC offset of the time
+var utcTimestampColumns = []struct{ table, column string }{
+	{"packet_logs", "timestamp"},
+	{"flows", "first_seen"},
+	{"flows", "last_seen"},
+	{"application_stats", "first_seen"},
+	{"application_stats", "last_seen"},
+	{"app_destinations", "first_seen"},
+	{"app_destinations", "last_seen"},
+	{"listeners", "last_seen"},
+	{"dns_cache", "expires_at"},
+	{"reverse_dns", "expires_at"},
+}
+
+// migrateUTCTimestamps rewrites stored times in timestampFormat. SQLite keeps
+// milliseconds, which is as precise as the range queries need to be.
+func migrateUTCTimestamps(tx *sql.Tx) error {
+	for _, c := range utcTimestampColumns {
+		utc := `strftime('%Y-%m-%d %H:%M:%f', ` + c.column + `)`
+		_, err := tx.Exec(`UPDATE ` + c.table + ` SET ` + c.column + ` = ` + utc + ` || '000000' WHERE ` + utc + ` IS NOT NULL`)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", c.table, c.column, err)
+		}
+	}
+	return nil
+}
+
+// migrateDestinationCountry adds the country of each destination, which was
+// stored as a suffix of the destination such as "example.com (US)". Suffixed
+// rows are renamed, or merged into the row of the same destination without a
+// country where there is one. Databases from before app_destinations get the
+// column from createTables.
+func migrateDestinationCountry(tx *sql.Tx) error {
+	if err := addColumnIfMissing(tx, "app_destinations", "country", "TEXT"); err != nil {
+		return err
+	}
+
+	const suffixed = `s.destination GLOB '* ([A-Z][A-Z])'`
+	const plain = `substr(s.destination, 1, length(s.destination) - 5)`
+	statements := []string{
+		`UPDATE app_destinations AS d SET
+			first_seen = MIN(d.first_seen, s.first_seen),
+			last_seen = MAX(d.last_seen, s.last_seen),
+			packet_count = d.packet_count + s.packet_count,
+			byte_count = d.byte_count + s.byte_count,
+			country = substr(s.destination, -3, 2)
+		FROM app_destinations AS s
+		WHERE s.app_stats_id = d.app_stats_id AND ` + suffixed + ` AND d.destination = ` + plain,
+		`DELETE FROM app_destinations AS s WHERE ` + suffixed + ` AND EXISTS (
+			SELECT 1 FROM app_destinations d WHERE d.app_stats_id = s.app_stats_id AND d.destination = ` + plain + `)`,
+		`UPDATE app_destinations AS s SET country = substr(s.destination, -3, 2), destination = ` + plain + `
+		WHERE ` + suffixed,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,428 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// baselineSchema is the schema written by the first release, before version
+// tracking: packet_logs already has direction and device_id, and
+// application_stats keeps destinations as a JSON array
+var baselineSchema = []string{
+	`CREATE TABLE network_interfaces (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		description TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(name, description)
+	)`,
+	`CREATE TABLE packet_logs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		device_id INTEGER NOT NULL,
+		src_ip TEXT NOT NULL,
+		src_port TEXT NOT NULL,
+		dst_ip TEXT NOT NULL,
+		dst_port TEXT NOT NULL,
+		protocol TEXT NOT NULL,
+		length INTEGER NOT NULL,
+		process_id INTEGER,
+		process_name TEXT,
+		process_path TEXT,
+		direction TEXT,
+		FOREIGN KEY (device_id) REFERENCES network_interfaces (id)
+	)`,
+	`CREATE INDEX idx_timestamp ON packet_logs(timestamp)`,
+	`CREATE INDEX idx_protocol ON packet_logs(protocol)`,
+	`CREATE INDEX idx_process_name ON packet_logs(process_name)`,
+	`CREATE INDEX idx_device_id ON packet_logs(device_id)`,
+	`CREATE TABLE application_stats (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		process_id INTEGER NOT NULL,
+		process_name TEXT NOT NULL,
+		process_path TEXT,
+		total_packets INTEGER NOT NULL DEFAULT 0,
+		total_bytes INTEGER NOT NULL DEFAULT 0,
+		last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		destinations TEXT,
+		first_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE(process_name, process_id)
+	)`,
+	`CREATE TABLE protocol_stats (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		app_stats_id INTEGER NOT NULL,
+		protocol TEXT NOT NULL,
+		packet_count INTEGER NOT NULL DEFAULT 0,
+		UNIQUE(app_stats_id, protocol),
+		FOREIGN KEY (app_stats_id) REFERENCES application_stats(id)
+	)`,
+	`CREATE INDEX idx_app_stats_process_name ON application_stats(process_name)`,
+	`CREATE INDEX idx_app_stats_process_id ON application_stats(process_id)`,
+
+	`INSERT INTO network_interfaces (name, description) VALUES ('\Device\NPF_{1}', 'Ethernet adapter')`,
+	`INSERT INTO packet_logs (timestamp, device_id, src_ip, src_port, dst_ip, dst_port, protocol, length, process_name, direction)
+		VALUES ('2024-05-01 10:00:00', 1, '10.0.0.2', '50000', '1.1.1.1', '443', 'TCP', 1500, 'chrome.exe', 'outgoing')`,
+	`INSERT INTO application_stats (process_id, process_name, total_packets, total_bytes, destinations, first_seen, last_seen)
+		VALUES (42, 'chrome.exe', 10, 15000, '["1.1.1.1","example.com"]', '2024-05-01 10:00:00', '2024-05-01 11:00:00')`,
+	`INSERT INTO protocol_stats (app_stats_id, protocol, packet_count) VALUES (1, 'TCP', 10)`,
+}
+
+// openBaseline writes the baseline schema to a new file and returns its path
+func openBaseline(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "baseline.db")
+	raw, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	for _, statement := range baselineSchema {
+		if _, err := raw.Exec(statement); err != nil {
+			t.Fatalf("baseline schema: %v\n%s", err, statement)
+		}
+	}
+	return path
+}
+
+func openTestDB(t *testing.T, path string) *DB {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.Path = path
+	config.CheckpointInterval = 0
+	db, err := Open(config)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", path, err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// tableColumns returns the column names of every table, sorted
+func tableColumns(t *testing.T, db *DB) map[string][]string {
+	t.Helper()
+
+	rows, err := db.Query(`
+		SELECT m.name, p.name FROM sqlite_master m, pragma_table_info(m.name) p
+		WHERE m.type = 'table' AND m.name NOT LIKE 'sqlite_%'
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	columns := make(map[string][]string)
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			t.Fatal(err)
+		}
+		columns[table] = append(columns[table], column)
+	}
+	for _, list := range columns {
+		sort.Strings(list)
+	}
+	return columns
+}
+
+func TestUpgradeFromBaseline(t *testing.T) {
+	db := openTestDB(t, openBaseline(t))
+
+	version, err := db.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("schema version = %d, want %d", version, LatestSchemaVersion())
+	}
+
+	// An upgraded database must end up with the same tables and columns as a new one
+	fresh := openTestDB(t, MemoryPath)
+	want, got := tableColumns(t, fresh), tableColumns(t, db)
+	for table, columns := range want {
+		if !equalStrings(got[table], columns) {
+			t.Errorf("%s columns = %v, want %v", table, got[table], columns)
+		}
+	}
+	for table := range got {
+		if _, ok := want[table]; !ok {
+			t.Errorf("upgraded database has extra table %s", table)
+		}
+	}
+
+	// Existing rows survive and pick up the defaults of new columns
+	var packetCount, totalBytes int
+	if err := db.QueryRow(`SELECT packet_count, total_bytes FROM packet_logs`).Scan(&packetCount, &totalBytes); err != nil {
+		t.Fatal(err)
+	}
+	if packetCount != 1 || totalBytes != 1500 {
+		t.Errorf("packet row counts = %d packets, %d bytes; want 1, 1500", packetCount, totalBytes)
+	}
+
+	destinations, err := db.GetDestinationsForApp("chrome.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(destinations) != 2 {
+		t.Errorf("migrated destinations = %v, want 1.1.1.1 and example.com", destinations)
+	}
+
+	// Reopening an upgraded database applies nothing more
+	db.Close()
+	db = openTestDB(t, db.path)
+	if version, err := db.SchemaVersion(); err != nil || version != LatestSchemaVersion() {
+		t.Errorf("after reopening: schema version = %d, %v", version, err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestMigrations rebuilds the schema each migration starts from on top of a
+// new database and checks what the migration leaves behind
+func TestMigrations(t *testing.T) {
+	tests := []struct {
+		version int
+		name    string
+		before  []string // Turns the latest schema back into the one the migration upgrades
+		want    string   // Query returning a single true value once the migration has run
+	}{
+		{1, "direction", []string{
+			`ALTER TABLE packet_logs DROP COLUMN direction`,
+		}, `SELECT COUNT(*) = 1 FROM pragma_table_info('packet_logs') WHERE name = 'direction'`},
+		{2, "device_id", []string{
+			`DROP TABLE packet_logs`,
+			`CREATE TABLE packet_logs (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				device TEXT NOT NULL,
+				src_ip TEXT NOT NULL,
+				src_port TEXT NOT NULL,
+				dst_ip TEXT NOT NULL,
+				dst_port TEXT NOT NULL,
+				protocol TEXT NOT NULL,
+				length INTEGER NOT NULL,
+				process_id INTEGER,
+				process_name TEXT,
+				process_path TEXT,
+				direction TEXT
+			)`,
+			`INSERT INTO network_interfaces (id, name, description) VALUES (7, 'eth0', '')`,
+			`INSERT INTO packet_logs (device, src_ip, src_port, dst_ip, dst_port, protocol, length)
+				VALUES ('eth0', '10.0.0.2', '1', '1.1.1.1', '53', 'UDP', 80)`,
+		}, `SELECT (SELECT device_id FROM packet_logs) = 7
+			AND NOT EXISTS (SELECT 1 FROM pragma_table_info('packet_logs') WHERE name = 'device')
+			AND EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'idx_device_id')`},
+		{3, "untracked columns", []string{
+			`ALTER TABLE network_interfaces DROP COLUMN friendly_name`,
+			`ALTER TABLE packet_logs DROP COLUMN scope`,
+			`ALTER TABLE application_stats DROP COLUMN exe_sha256`,
+			`ALTER TABLE app_destinations DROP COLUMN reverse_host`,
+		}, `SELECT COUNT(*) = 4 FROM (
+				SELECT name FROM pragma_table_info('network_interfaces') WHERE name = 'friendly_name'
+				UNION ALL SELECT name FROM pragma_table_info('packet_logs') WHERE name = 'scope'
+				UNION ALL SELECT name FROM pragma_table_info('application_stats') WHERE name = 'exe_sha256'
+				UNION ALL SELECT name FROM pragma_table_info('app_destinations') WHERE name = 'reverse_host'
+			)`},
+		{4, "destination blobs", []string{
+			`ALTER TABLE application_stats ADD COLUMN destinations TEXT`,
+			`INSERT INTO application_stats (id, process_id, process_name, app_key, destinations) VALUES (1, 1, 'a.exe', 'a.exe', '["1.1.1.1","example.com"]')`,
+			`INSERT INTO application_stats (id, process_id, process_name, app_key, destinations) VALUES (2, 2, 'b.exe', 'b.exe', 'not json')`,
+		}, `SELECT (SELECT COUNT(*) FROM app_destinations WHERE app_stats_id = 1) = 2
+			AND (SELECT COUNT(*) FROM application_stats WHERE destinations IS NOT NULL) = 0`},
+		{5, "packet counts", []string{
+			`ALTER TABLE packet_logs DROP COLUMN packet_count`,
+			`ALTER TABLE packet_logs DROP COLUMN total_bytes`,
+			`INSERT INTO packet_logs (device_id, src_ip, src_port, dst_ip, dst_port, protocol, length)
+				VALUES (1, '10.0.0.2', '1', '1.1.1.1', '53', 'UDP', 80)`,
+		}, `SELECT packet_count = 1 AND total_bytes = 80 FROM packet_logs`},
+		{6, "rollup tables", []string{
+			`DROP TABLE hourly_app_stats`,
+			`DROP TABLE daily_app_stats`,
+			`DROP TABLE rollup_state`,
+		}, `SELECT COUNT(*) = 3 FROM sqlite_master
+			WHERE type = 'table' AND name IN ('hourly_app_stats', 'daily_app_stats', 'rollup_state')`},
+		{7, "command_line", []string{
+			`ALTER TABLE application_stats DROP COLUMN command_line`,
+		}, `SELECT COUNT(*) = 1 FROM pragma_table_info('application_stats') WHERE name = 'command_line'`},
+		{8, "app key", []string{
+			`DROP TABLE application_stats`,
+			`CREATE TABLE application_stats (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				process_id INTEGER NOT NULL,
+				process_name TEXT NOT NULL,
+				process_path TEXT,
+				service_name TEXT,
+				process_owner TEXT,
+				exe_sha256 TEXT,
+				exe_publisher TEXT,
+				exe_error TEXT,
+				command_line TEXT,
+				total_packets INTEGER NOT NULL DEFAULT 0,
+				total_bytes INTEGER NOT NULL DEFAULT 0,
+				packets_in INTEGER NOT NULL DEFAULT 0,
+				bytes_in INTEGER NOT NULL DEFAULT 0,
+				packets_out INTEGER NOT NULL DEFAULT 0,
+				bytes_out INTEGER NOT NULL DEFAULT 0,
+				last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				destinations TEXT,
+				first_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				last_seen TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+				UNIQUE(process_name, process_id)
+			)`,
+			// The last two rows are the same executable, named in different case
+			`INSERT INTO application_stats (id, process_id, process_name, process_path, total_packets) VALUES
+				(1, 4, 'System', '', 1),
+				(2, 9, 'svchost.exe', 'C:\Windows\System32\svchost.exe', 2),
+				(3, 7, 'Updater.exe', 'C:\Tools\Updater.exe', 3),
+				(4, 7, 'updater.exe', 'c:\tools\updater.exe', 4)`,
+			`UPDATE application_stats SET service_name = 'Dnscache' WHERE id = 2`,
+			`INSERT INTO protocol_stats (app_stats_id, protocol, packet_count, byte_count) VALUES
+				(3, 'TCP', 3, 300), (4, 'TCP', 4, 400), (4, 'UDP', 1, 100)`,
+			`INSERT INTO app_destinations (app_stats_id, destination, first_seen, last_seen, packet_count, byte_count) VALUES
+				(3, '1.1.1.1', '2024-01-02', '2024-01-03', 1, 10), (4, '1.1.1.1', '2024-01-01', '2024-01-02', 2, 20)`,
+		}, `SELECT (SELECT group_concat(app_key, '|') FROM (SELECT app_key FROM application_stats ORDER BY id))
+				= 'System|c:\windows\system32\svchost.exe (Dnscache)|c:\tools\updater.exe'
+			AND (SELECT total_packets FROM application_stats WHERE id = 3) = 7
+			AND (SELECT SUM(packet_count) FROM protocol_stats WHERE app_stats_id = 3 AND protocol = 'TCP') = 7
+			AND (SELECT COUNT(*) FROM protocol_stats) = 2
+			AND (SELECT packet_count = 3 AND first_seen = '2024-01-01' AND last_seen = '2024-01-03'
+				FROM app_destinations WHERE app_stats_id = 3)
+			AND (SELECT COUNT(*) FROM app_destinations) = 1
+			AND EXISTS (SELECT 1 FROM sqlite_master WHERE name = 'idx_app_stats_process_id')`},
+		{9, "drop destination blobs", []string{
+			`ALTER TABLE application_stats ADD COLUMN destinations TEXT`,
+		}, `SELECT COUNT(*) = 0 FROM pragma_table_info('application_stats') WHERE name = 'destinations'`},
+		{10, "flow attribution", []string{
+			`ALTER TABLE flows DROP COLUMN attribution`,
+		}, `SELECT COUNT(*) = 1 FROM pragma_table_info('flows') WHERE name = 'attribution'`},
+		// flows created by createTables during the upgrade already has the column
+		{10, "flow attribution present", nil,
+			`SELECT COUNT(*) = 1 FROM pragma_table_info('flows') WHERE name = 'attribution'`},
+		{11, "packet MACs", []string{
+			`ALTER TABLE packet_logs DROP COLUMN src_mac`,
+			`ALTER TABLE packet_logs DROP COLUMN dst_mac`,
+		}, `SELECT COUNT(*) = 2 FROM pragma_table_info('packet_logs') WHERE name IN ('src_mac', 'dst_mac')`},
+		{12, "listeners", []string{
+			`DROP TABLE listeners`,
+		}, `SELECT COUNT(*) = 1 FROM sqlite_master WHERE type = 'table' AND name = 'listeners'`},
+		{13, "packet VLAN", []string{
+			`ALTER TABLE packet_logs DROP COLUMN vlan`,
+		}, `SELECT COUNT(*) = 1 FROM pragma_table_info('packet_logs') WHERE name = 'vlan'`},
+		// Times written with the offset of the zone they were captured in
+		{14, "UTC timestamps", []string{
+			`INSERT INTO packet_logs (timestamp, device_id, src_ip, src_port, dst_ip, dst_port, protocol, length) VALUES
+				('2024-05-01 12:30:00.123456789+02:00', 1, '10.0.0.2', '1', '1.1.1.1', '53', 'UDP', 80),
+				('2024-05-01 10:45:00-05:00', 1, '10.0.0.2', '1', '1.1.1.1', '53', 'UDP', 80),
+				('2024-05-01 11:00:00', 1, '10.0.0.2', '1', '1.1.1.1', '53', 'UDP', 80)`,
+			`INSERT INTO dns_cache (ip, hostname, expires_at) VALUES ('1.1.1.1', 'one.one.one.one', '2024-05-02 00:00:00+01:00')`,
+		}, `SELECT (SELECT group_concat(timestamp, '|') FROM (SELECT timestamp FROM packet_logs ORDER BY timestamp))
+				= '2024-05-01 10:30:00.123000000|2024-05-01 11:00:00.000000000|2024-05-01 15:45:00.000000000'
+			AND (SELECT expires_at FROM dns_cache) = '2024-05-01 23:00:00.000000000'`},
+		{15, "destination country", []string{
+			`ALTER TABLE app_destinations DROP COLUMN country`,
+			`INSERT INTO application_stats (id, process_id, process_name, app_key) VALUES (1, 1, 'a.exe', 'a.exe')`,
+			// The same destination was stored with and without its country
+			`INSERT INTO app_destinations (app_stats_id, destination, first_seen, last_seen, packet_count, byte_count) VALUES
+				(1, 'example.com (US)', '2024-01-02', '2024-01-04', 1, 10),
+				(1, 'example.com', '2024-01-01', '2024-01-03', 2, 20),
+				(1, '192.0.2.1 (DE)', '2024-01-01', '2024-01-01', 3, 30),
+				(1, 'printer', '2024-01-01', '2024-01-01', 4, 40)`,
+		}, `SELECT (SELECT group_concat(destination || ':' || COALESCE(country, '') || ':' || packet_count || ':' || first_seen || ':' || last_seen, '|')
+				FROM (SELECT * FROM app_destinations ORDER BY destination))
+				= '192.0.2.1:DE:3:2024-01-01:2024-01-01|example.com:US:3:2024-01-01:2024-01-04|printer::4:2024-01-01:2024-01-01'`},
+		{16, "rollup app key", []string{
+			`DROP TABLE hourly_app_stats`,
+			`DROP TABLE daily_app_stats`,
+			`CREATE TABLE hourly_app_stats (
+				bucket_start TIMESTAMP NOT NULL,
+				process_name TEXT NOT NULL,
+				packet_count INTEGER NOT NULL DEFAULT 0,
+				byte_count INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (bucket_start, process_name)
+			)`,
+			`CREATE TABLE daily_app_stats (
+				bucket_start TIMESTAMP NOT NULL,
+				process_name TEXT NOT NULL,
+				packet_count INTEGER NOT NULL DEFAULT 0,
+				byte_count INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (bucket_start, process_name)
+			)`,
+			// One agent.exe is known, but two different updater.exe
+			`INSERT INTO application_stats (process_id, process_name, process_path, app_key) VALUES
+				(1, 'agent.exe', 'C:\Apps\agent.exe', 'c:\apps\agent.exe'),
+				(2, 'agent.exe', 'C:\Apps\agent.exe', 'c:\apps\agent.exe'),
+				(3, 'updater.exe', 'C:\Tools\updater.exe', 'c:\tools\updater.exe'),
+				(4, 'updater.exe', 'C:\Games\updater.exe', 'c:\games\updater.exe')`,
+			`INSERT INTO hourly_app_stats (bucket_start, process_name, packet_count, byte_count) VALUES
+				('2024-05-01 10:00:00', 'agent.exe', 1, 10),
+				('2024-05-01 10:00:00', 'updater.exe', 2, 20),
+				('2024-05-01 11:00:00', '(unknown)', 3, 30)`,
+			`INSERT INTO daily_app_stats (bucket_start, process_name, packet_count, byte_count) VALUES
+				('2024-05-01 00:00:00', 'agent.exe', 4, 40)`,
+		}, `SELECT (SELECT group_concat(app_key || ':' || process_name || ':' || packet_count, '|')
+				FROM (SELECT * FROM hourly_app_stats ORDER BY bucket_start, process_name))
+				= 'c:\apps\agent.exe:agent.exe:1|updater.exe:updater.exe:2|(unknown):(unknown):3'
+			AND (SELECT app_key = 'c:\apps\agent.exe' AND byte_count = 40 FROM daily_app_stats)
+			AND (SELECT COUNT(*) = 2 FROM sqlite_master WHERE type = 'index'
+				AND name IN ('idx_hourly_app_stats_process', 'idx_daily_app_stats_process'))`},
+		// Rollups created by createTables during the upgrade already have the key
+		{16, "rollup app key present", nil,
+			`SELECT COUNT(*) = 1 FROM pragma_table_info('daily_app_stats') WHERE name = 'app_key'`},
+	}
+
+	// Every migration needs a case here
+	covered := make(map[int]bool)
+	for _, tt := range tests {
+		covered[tt.version] = true
+	}
+	for version := 1; version <= len(migrations); version++ {
+		if !covered[version] {
+			t.Errorf("migration %d (%s) has no test", version, migrations[version-1].description)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t, MemoryPath)
+			for _, statement := range tt.before {
+				if _, err := db.Exec(statement); err != nil {
+					t.Fatalf("old schema: %v\n%s", err, statement)
+				}
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := migrations[tt.version-1].apply(tx); err != nil {
+				tx.Rollback()
+				t.Fatalf("migration %d: %v", tt.version, err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+
+			var ok bool
+			if err := db.QueryRow(tt.want).Scan(&ok); err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Errorf("migration %d left an unexpected schema or data: %s", tt.version, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package database
+
+// isNetworkPath is only implemented on Windows, where SMB shares are common
+func isNetworkPath(path string) bool {
+	return false
+}
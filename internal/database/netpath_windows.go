@@ -0,0 +1,32 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// isNetworkPath reports whether an absolute path is a UNC share or on a
+// mapped network drive
+func isNetworkPath(path string) bool {
+	// \\?\ and \\.\ prefixes name local devices unless followed by UNC\
+	if strings.HasPrefix(path, `\\?\UNC\`) {
+		return true
+	}
+	if strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\\.\`) {
+		path = path[4:]
+	} else if strings.HasPrefix(path, `\\`) {
+		return true
+	}
+
+	volume := filepath.VolumeName(path)
+	if volume == "" {
+		return false
+	}
+	root, err := windows.UTF16PtrFromString(volume + `\`)
+	if err != nil {
+		return false
+	}
+	return windows.GetDriveType(root) == windows.DRIVE_REMOTE
+}
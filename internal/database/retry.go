@@ -0,0 +1,68 @@
+package database
+
+import (
+	"database/sql"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Retries of a write that failed because another connection, such as the
+// sqlite3 CLI or the dashboard, held a lock beyond the busy timeout. The delay
+// doubles from writeRetryDelay with up to 100% jitter so competing writers
+// don't retry in lockstep.
+const (
+	writeAttempts   = 4
+	writeRetryDelay = 50 * time.Millisecond
+)
+
+// droppedWrites counts writes that failed for good, losing their data
+var droppedWrites atomic.Uint64
+
+// DroppedWrites returns how many writes have failed since the process started,
+// after any retries
+func DroppedWrites() uint64 {
+	return droppedWrites.Load()
+}
+
+// isBusy reports whether err means the database was locked by another
+// connection. SQLITE_BUSY reads "database is locked" and SQLITE_LOCKED
+// "database table is locked"; the messages are matched because the driver's
+// error type only exists in cgo builds.
+func isBusy(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "database is locked") || strings.Contains(message, "table is locked")
+}
+
+// retryWrite runs write until it succeeds, fails with an error other than a
+// lock conflict, or runs out of attempts. write must be safe to repeat, such
+// as a single statement or a transaction that rolls back on failure. A final
+// failure is counted in DroppedWrites.
+func retryWrite(write func() error) error {
+	var err error
+	for attempt := 0; attempt < writeAttempts; attempt++ {
+		if attempt > 0 {
+			delay := writeRetryDelay << (attempt - 1)
+			time.Sleep(delay + time.Duration(rand.Int63n(int64(delay))))
+		}
+		if err = write(); err == nil || !isBusy(err) {
+			break
+		}
+	}
+	if err != nil {
+		droppedWrites.Add(1)
+	}
+	return err
+}
+
+// execWrite runs a write statement with retryWrite
+func (db *DB) execWrite(query string, args ...interface{}) (sql.Result, error) {
+	var result sql.Result
+	err := retryWrite(func() error {
+		var err error
+		result, err = db.Exec(query, args...)
+		return err
+	})
+	return result, err
+}
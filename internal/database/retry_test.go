@@ -0,0 +1,48 @@
+package database
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetryWrite(t *testing.T) {
+	busy := errors.New("database is locked")
+	tableLocked := errors.New("database table is locked: flows")
+	other := errors.New("UNIQUE constraint failed")
+
+	tests := []struct {
+		name         string
+		errs         []error // Returned by successive attempts, then nil
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", nil, 1, nil},
+		{"busy then success", []error{busy}, 2, nil},
+		{"table locked then success", []error{tableLocked, busy}, 3, nil},
+		{"other error", []error{other}, 1, other},
+		{"busy then other error", []error{busy, other}, 2, other},
+		{"always busy", []error{busy, busy, busy, busy, busy}, writeAttempts, busy},
+	}
+	for _, tt := range tests {
+		attempts := 0
+		dropped := DroppedWrites()
+		err := retryWrite(func() error {
+			attempts++
+			if attempts <= len(tt.errs) {
+				return tt.errs[attempts-1]
+			}
+			return nil
+		})
+
+		if err != tt.wantErr || attempts != tt.wantAttempts {
+			t.Errorf("%s: %d attempts returning %v, want %d returning %v", tt.name, attempts, err, tt.wantAttempts, tt.wantErr)
+		}
+		wantDropped := dropped
+		if tt.wantErr != nil {
+			wantDropped++
+		}
+		if got := DroppedWrites(); got != wantDropped {
+			t.Errorf("%s: DroppedWrites() = %d, want %d", tt.name, got, wantDropped)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// reverseHostColumn selects the PTR name of a row's remote peer, src_ip for
+// incoming traffic and dst_ip otherwise, in packet and flow queries
+const reverseHostColumn = `(SELECT NULLIF(hostname, '') FROM reverse_dns
+	WHERE reverse_dns.ip = CASE WHEN direction = 'incoming' THEN src_ip ELSE dst_ip END)`
+
+// ReverseDNSEntry is the result of a PTR lookup. An empty Hostname records a
+// failed lookup so it isn't retried before ExpiresAt.
+type ReverseDNSEntry struct {
+	IP         string
+	Hostname   string
+	ResolvedAt time.Time
+	ExpiresAt  time.Time
+}
+
+// createReverseDNSTable creates the table of PTR lookup results
+func (db *DB) createReverseDNSTable() error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS reverse_dns (
+			ip TEXT PRIMARY KEY,
+			hostname TEXT NOT NULL DEFAULT '',
+			resolved_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		)
+	`)
+	return err
+}
+
+// StoreReverseDNSEntry inserts or replaces the lookup result for an IP
+func (db *DB) StoreReverseDNSEntry(entry ReverseDNSEntry) error {
+	if db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	_, err := db.execWrite(`
+		INSERT INTO reverse_dns (ip, hostname, resolved_at, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (ip)
+		DO UPDATE SET hostname = excluded.hostname,
+		              resolved_at = excluded.resolved_at,
+		              expires_at = excluded.expires_at
+	`, entry.IP, entry.Hostname, dbTime(entry.ResolvedAt), dbTime(entry.ExpiresAt))
+	if err != nil {
+		return fmt.Errorf("failed to store reverse DNS entry: %v", err)
+	}
+
+	return nil
+}
+
+// GetReverseDNSEntries returns every unexpired lookup result. Expired rows are
+// kept so exports of older traffic still show the name.
+func (db *DB) GetReverseDNSEntries() ([]ReverseDNSEntry, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(`
+		SELECT ip, hostname, resolved_at, expires_at
+		FROM reverse_dns
+		WHERE expires_at >= ?
+	`, dbTime(time.Now()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reverse DNS cache: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []ReverseDNSEntry
+	for rows.Next() {
+		var entry ReverseDNSEntry
+		if err := rows.Scan(&entry.IP, &entry.Hostname, &entry.ResolvedAt, &entry.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("failed to scan reverse DNS entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,327 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RollupInterval selects the rollup table of a time series query
+type RollupInterval string
+
+const (
+	RollupHourly RollupInterval = "hourly"
+	RollupDaily  RollupInterval = "daily"
+)
+
+// rollupTimeFormat is how bucket starts are stored, in UTC, so they sort as text
+const rollupTimeFormat = "2006-01-02 15:04:05"
+
+// AppStatsPoint is the traffic of one application in one hour or day
+type AppStatsPoint struct {
+	Start       time.Time `json:"start"`
+	AppKey      string    `json:"app_key"`
+	ProcessName string    `json:"process_name"`
+	Packets     uint64    `json:"packets"`
+	Bytes       uint64    `json:"bytes"`
+}
+
+// rollupTableStatements create the rollup tables for createTables. Rows are
+// keyed on AppKey like application_stats; process_name is for display.
+var rollupTableStatements = []string{
+	`CREATE TABLE IF NOT EXISTS hourly_app_stats (
+		bucket_start TIMESTAMP NOT NULL,
+		app_key TEXT NOT NULL,
+		process_name TEXT NOT NULL,
+		packet_count INTEGER NOT NULL DEFAULT 0,
+		byte_count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (bucket_start, app_key)
+	)`,
+	`CREATE TABLE IF NOT EXISTS daily_app_stats (
+		bucket_start TIMESTAMP NOT NULL,
+		app_key TEXT NOT NULL,
+		process_name TEXT NOT NULL,
+		packet_count INTEGER NOT NULL DEFAULT 0,
+		byte_count INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (bucket_start, app_key)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_hourly_app_stats_process ON hourly_app_stats(process_name, bucket_start)`,
+	`CREATE INDEX IF NOT EXISTS idx_daily_app_stats_process ON daily_app_stats(process_name, bucket_start)`,
+	// Highest packet_logs id already counted in the rollups
+	`CREATE TABLE IF NOT EXISTS rollup_state (
+		name TEXT PRIMARY KEY,
+		last_packet_id INTEGER NOT NULL
+	)`,
+}
+
+// rollupBuckets maps each rollup table to the SQL expression of a packet's
+// bucket start. Days follow the local time zone, like the "today" reports.
+var rollupBuckets = []struct{ table, bucket string }{
+	{"hourly_app_stats", `strftime('%Y-%m-%d %H:00:00', timestamp)`},
+	{"daily_app_stats", `strftime('%Y-%m-%d %H:%M:%S', timestamp, 'localtime', 'start of day', 'utc')`},
+}
+
+func (db *DB) createRollupTables() error {
+	for _, statement := range rollupTableStatements {
+		if _, err := db.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRollupTables adds the hourly and daily rollup tables, keyed on the
+// process name as they were at this version; migrateRollupAppKey rekeys them
+func migrateRollupTables(tx *sql.Tx) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS hourly_app_stats (
+			bucket_start TIMESTAMP NOT NULL,
+			process_name TEXT NOT NULL,
+			packet_count INTEGER NOT NULL DEFAULT 0,
+			byte_count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (bucket_start, process_name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS daily_app_stats (
+			bucket_start TIMESTAMP NOT NULL,
+			process_name TEXT NOT NULL,
+			packet_count INTEGER NOT NULL DEFAULT 0,
+			byte_count INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (bucket_start, process_name)
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_hourly_app_stats_process ON hourly_app_stats(process_name, bucket_start)`,
+		`CREATE INDEX IF NOT EXISTS idx_daily_app_stats_process ON daily_app_stats(process_name, bucket_start)`,
+		`CREATE TABLE IF NOT EXISTS rollup_state (
+			name TEXT PRIMARY KEY,
+			last_packet_id INTEGER NOT NULL
+		)`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateRollupAppKey rebuilds the hourly and daily rollups keyed on AppKey
+// instead of the process name, so same-named executables in different
+// folders get series of their own. Rows rolled up before only know the name:
+// they take the key of the one application of that name, if there is just
+// one, and otherwise keep the name as key. Databases that got the rollups
+// from createTables already have the key.
+func migrateRollupAppKey(tx *sql.Tx) error {
+	exists, err := columnExists(tx, "hourly_app_stats", "app_key")
+	if err != nil || exists {
+		return err
+	}
+
+	for _, table := range []string{"hourly_app_stats", "daily_app_stats"} {
+		statements := []string{
+			`CREATE TABLE ` + table + `_new (
+				bucket_start TIMESTAMP NOT NULL,
+				app_key TEXT NOT NULL,
+				process_name TEXT NOT NULL,
+				packet_count INTEGER NOT NULL DEFAULT 0,
+				byte_count INTEGER NOT NULL DEFAULT 0,
+				PRIMARY KEY (bucket_start, app_key)
+			)`,
+			`INSERT INTO ` + table + `_new (bucket_start, app_key, process_name, packet_count, byte_count)
+			SELECT r.bucket_start, COALESCE(k.app_key, r.process_name), r.process_name, r.packet_count, r.byte_count
+			FROM ` + table + ` AS r LEFT JOIN (
+				SELECT process_name, MIN(app_key) AS app_key FROM application_stats
+				GROUP BY process_name HAVING COUNT(DISTINCT app_key) = 1
+			) AS k ON k.process_name = r.process_name
+			WHERE true
+			ON CONFLICT (bucket_start, app_key) DO UPDATE SET
+				packet_count = packet_count + excluded.packet_count,
+				byte_count = byte_count + excluded.byte_count`,
+			`DROP TABLE ` + table,
+			`ALTER TABLE ` + table + `_new RENAME TO ` + table,
+			`CREATE INDEX idx_` + table + `_process ON ` + table + `(process_name, bucket_start)`,
+		}
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return fmt.Errorf("error rekeying %s: %v", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// RollupAppStats adds up to batch packet rows not yet counted to the hourly
+// and daily rollups and returns how many it added. It stops at the first row
+// captured at or after before, leaving it and the rows stored after it for a
+// later call, as their process may still be filled in. Call it until it
+// returns less than batch to catch up.
+func (db *DB) RollupAppStats(batch int, before time.Time) (int64, error) {
+	if db == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
+
+	var added int64
+	err := retryWrite(func() error {
+		var err error
+		added, err = db.rollupAppStats(batch, before)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to roll up application statistics: %v", err)
+	}
+	return added, nil
+}
+
+// rollupAppStats counts one batch in a transaction together with the new
+// watermark, so no packet is counted twice. Errors are returned unwrapped so
+// retryWrite can recognize lock conflicts.
+func (db *DB) rollupAppStats(batch int, before time.Time) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var last int64
+	err = tx.QueryRow(`SELECT last_packet_id FROM rollup_state WHERE name = 'app_stats'`).Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+
+	var upper sql.NullInt64
+	var count int64
+	err = tx.QueryRow(`
+		WITH batch AS (
+			SELECT id, timestamp FROM packet_logs WHERE id > ? ORDER BY id LIMIT ?
+		)
+		SELECT MAX(id), COUNT(*) FROM batch
+		WHERE id < COALESCE((SELECT MIN(id) FROM batch WHERE timestamp >= ?), id + 1)
+	`, last, batch, dbTime(before)).Scan(&upper, &count)
+	if err != nil || !upper.Valid {
+		return 0, err
+	}
+
+	for _, rollup := range rollupBuckets {
+		if err := rollupBucket(tx, rollup.table, rollup.bucket, last, upper.Int64); err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO rollup_state (name, last_packet_id) VALUES ('app_stats', ?)
+		ON CONFLICT (name) DO UPDATE SET last_packet_id = excluded.last_packet_id
+	`, upper.Int64)
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// rollupBucket adds the packet rows with ids in (from, to] to one rollup
+// table. Keys are computed here rather than in SQL, whose LOWER only folds
+// ASCII, so rows of one application under differently cased paths are
+// counted together.
+func rollupBucket(tx *sql.Tx, table, bucket string, from, to int64) error {
+	rows, err := tx.Query(`
+		SELECT `+bucket+` AS bucket, COALESCE(process_name, '(unknown)'), COALESCE(process_path, ''),
+		       COALESCE(service_name, ''), SUM(packet_count), SUM(total_bytes)
+		FROM packet_logs
+		WHERE id > ? AND id <= ?
+		GROUP BY bucket, process_name, process_path, service_name
+	`, from, to)
+	if err != nil {
+		return err
+	}
+
+	type key struct{ bucket, app string }
+	type total struct {
+		name           string
+		packets, bytes uint64
+	}
+	totals := make(map[key]*total)
+	var order []key
+	for rows.Next() {
+		var (
+			bucket, name, path, service string
+			packets, bytes              uint64
+		)
+		if err := rows.Scan(&bucket, &name, &path, &service, &packets, &bytes); err != nil {
+			rows.Close()
+			return err
+		}
+		k := key{bucket, AppKey(name, path, service)}
+		t, ok := totals[k]
+		if !ok {
+			t = &total{}
+			totals[k] = t
+			order = append(order, k)
+		}
+		t.name = name
+		t.packets += packets
+		t.bytes += bytes
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, k := range order {
+		t := totals[k]
+		_, err := tx.Exec(`
+			INSERT INTO `+table+` (bucket_start, app_key, process_name, packet_count, byte_count)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (bucket_start, app_key) DO UPDATE SET
+				process_name = excluded.process_name,
+				packet_count = packet_count + excluded.packet_count,
+				byte_count = byte_count + excluded.byte_count
+		`, k.bucket, k.app, t.name, t.packets, t.bytes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAppTimeSeries returns the hourly or daily traffic of the applications
+// named processName (all applications if it is empty) in buckets starting
+// within [from, to), ordered by time. Same-named executables in different
+// folders have points of their own, told apart by AppKey. Unattributed
+// traffic is named "(unknown)".
+func (db *DB) GetAppTimeSeries(interval RollupInterval, processName string, from, to time.Time) ([]AppStatsPoint, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	var table string
+	switch interval {
+	case RollupHourly:
+		table = "hourly_app_stats"
+	case RollupDaily:
+		table = "daily_app_stats"
+	default:
+		return nil, fmt.Errorf("unknown rollup interval %q, expected hourly or daily", interval)
+	}
+
+	query := `SELECT bucket_start, app_key, process_name, packet_count, byte_count FROM ` + table + `
+		WHERE bucket_start >= ? AND bucket_start < ?`
+	args := []interface{}{from.UTC().Format(rollupTimeFormat), to.UTC().Format(rollupTimeFormat)}
+	if processName != "" {
+		query += ` AND process_name = ? COLLATE NOCASE`
+		args = append(args, processName)
+	}
+	query += ` ORDER BY bucket_start, byte_count DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s application statistics: %v", interval, err)
+	}
+	defer rows.Close()
+
+	points := []AppStatsPoint{}
+	for rows.Next() {
+		var point AppStatsPoint
+		if err := rows.Scan(&point.Start, &point.AppKey, &point.ProcessName, &point.Packets, &point.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan application statistics: %v", err)
+		}
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
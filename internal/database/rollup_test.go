@@ -0,0 +1,141 @@
+package database
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// TestRollupAppStats rolls packets up in small batches and checks the
+// batches, the watermark, the hourly and local daily buckets per application
+// key, that rows newer than the cutoff wait, and that running again adds
+// nothing
+func TestRollupAppStats(t *testing.T) {
+	db := openTestDB(t, MemoryPath)
+	deviceID, err := db.StoreInterface(NetworkInterface{Name: "eth0", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	const agent, otherAgent = `C:\Apps\agent.exe`, `C:\Tools\agent.exe`
+	traffic := []struct {
+		at            time.Duration // After base
+		process, path string
+		bytes         int
+	}{
+		{10 * time.Minute, "agent.exe", agent, 100},
+		{50 * time.Minute, "AGENT.EXE", `C:\APPS\AGENT.EXE`, 200},
+		{80 * time.Minute, "agent.exe", agent, 300},
+		{90 * time.Minute, "", "", 50},
+		{150 * time.Minute, "agent.exe", otherAgent, 400},
+		{16 * time.Hour, "agent.exe", agent, 500},
+	}
+	for _, tr := range traffic {
+		err := db.StorePacket(PacketRecord{
+			Timestamp: base.Add(tr.at), DeviceID: deviceID, SrcIP: "10.0.0.2", SrcPort: "50000", DstIP: "192.0.2.1",
+			DstPort: "443", Protocol: "TCP", Length: tr.bytes, ProcessName: tr.process, ProcessPath: tr.path, Direction: "outgoing",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watermark := func() int64 {
+		var last int64
+		if err := db.QueryRow(`SELECT last_packet_id FROM rollup_state WHERE name = 'app_stats'`).Scan(&last); err != nil {
+			t.Fatal(err)
+		}
+		return last
+	}
+	runs := []struct {
+		name          string
+		before        time.Duration // Cutoff after base
+		wantAdded     int64
+		wantWatermark int64
+	}{
+		{"first batch", 140 * time.Minute, 2, 2},
+		{"second batch", 140 * time.Minute, 2, 4},
+		{"newer rows wait", 140 * time.Minute, 0, 4},
+		{"cutoff in the batch", 3 * time.Hour, 1, 5},
+		{"last row", 24 * time.Hour, 1, 6},
+		{"nothing left", 24 * time.Hour, 0, 6},
+	}
+	for _, run := range runs {
+		added, err := db.RollupAppStats(2, base.Add(run.before))
+		if err != nil {
+			t.Fatalf("%s: %v", run.name, err)
+		}
+		if added != run.wantAdded {
+			t.Errorf("%s: added %d rows, want %d", run.name, added, run.wantAdded)
+		}
+		if last := watermark(); last != run.wantWatermark {
+			t.Errorf("%s: watermark %d, want %d", run.name, last, run.wantWatermark)
+		}
+	}
+
+	hourly, err := db.GetAppTimeSeries(RollupHourly, "", base, base.Add(24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentKey, otherAgentKey := AppKey("agent.exe", agent, ""), AppKey("agent.exe", otherAgent, "")
+	wantHourly := []AppStatsPoint{
+		{Start: base, AppKey: agentKey, Packets: 2, Bytes: 300},
+		{Start: base.Add(time.Hour), AppKey: agentKey, Packets: 1, Bytes: 300},
+		{Start: base.Add(time.Hour), AppKey: "(unknown)", Packets: 1, Bytes: 50},
+		{Start: base.Add(2 * time.Hour), AppKey: otherAgentKey, Packets: 1, Bytes: 400},
+		{Start: base.Add(16 * time.Hour), AppKey: agentKey, Packets: 1, Bytes: 500},
+	}
+	for _, p := range hourly {
+		if p.AppKey != "(unknown)" && p.ProcessName != "agent.exe" && p.ProcessName != "AGENT.EXE" {
+			t.Errorf("hourly point of %s named %q", p.AppKey, p.ProcessName)
+		}
+	}
+	if !reflect.DeepEqual(points(hourly), points(wantHourly)) {
+		t.Errorf("hourly rollup\n got %v\nwant %v", points(hourly), points(wantHourly))
+	}
+
+	// Days start at local midnight, wherever the test runs
+	var wantDaily []AppStatsPoint
+	for _, tr := range traffic {
+		local := base.Add(tr.at).Local()
+		start := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+		key := AppKey(tr.process, tr.path, "")
+		if key == "" {
+			key = "(unknown)"
+		}
+		i := len(wantDaily) - 1
+		for ; i >= 0; i-- {
+			if wantDaily[i].Start.Equal(start) && wantDaily[i].AppKey == key {
+				break
+			}
+		}
+		if i < 0 {
+			wantDaily = append(wantDaily, AppStatsPoint{Start: start, AppKey: key})
+			i = len(wantDaily) - 1
+		}
+		wantDaily[i].Packets++
+		wantDaily[i].Bytes += uint64(tr.bytes)
+	}
+	daily, err := db.GetAppTimeSeries(RollupDaily, "", base.Add(-24*time.Hour), base.Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := points(daily), points(wantDaily)
+	sort.Strings(got)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("daily rollup\n got %v\nwant %v", got, want)
+	}
+}
+
+// points formats time series points for comparison, with times in UTC
+func points(series []AppStatsPoint) []string {
+	formatted := make([]string, 0, len(series))
+	for _, p := range series {
+		formatted = append(formatted, fmt.Sprintf("%s %s %d/%d", p.Start.UTC().Format(time.RFC3339), p.AppKey, p.Packets, p.Bytes))
+	}
+	return formatted
+}
@@ -0,0 +1,51 @@
+package database
+
+import "time"
+
+// Store is where captured traffic, statistics and caches are written and read
+// back by the capture package. *DB is the SQLite implementation; another
+// backend, or a store that discards everything for tests, only has to
+// implement these methods.
+type Store interface {
+	// Packets and flows
+	StorePacket(packet PacketRecord) error
+	UpsertFlow(flow FlowRecord) (int64, error)
+	AttributeConnection(a ConnectionAttribution) (int64, error)
+	StreamPackets(filter PacketFilter, fn func(PacketRecord) error) error
+
+	// Application and protocol statistics
+	StoreAppStats(stats *ApplicationStats) error
+	// Applications are identified by AppKey, so same-named executables in
+	// different folders are kept apart
+	StoreProtocolStats(appKey string, processID uint32, protocol string, packetCount, byteCount uint64) error
+	GetAppStats(order AppStatsOrder, limit int) ([]*ApplicationStats, error)
+	GetAppStatsForKey(appKey string) ([]*ApplicationStats, error)
+	GetProtocolStatsForApp(appStatsID int64) ([]ProtocolStat, error)
+	StoreAppDestinations(appKey string, processID uint32, destinations []AppDestination) error
+	GetAppDestinations(appStatsID int64, limit int) ([]AppDestination, error)
+	GetDestinationsForApp(appKey string) ([]AppDestination, error)
+	HasAppDestination(appKey, destination string) (bool, error)
+
+	// Interfaces
+	StoreInterface(iface NetworkInterface) (int64, error)
+	UpdateInterfaceDetails(id int64, friendlyName, mac, addresses string) error
+	StoreInterfaceStats(stats InterfaceStats) error
+
+	// Host name caches
+	StoreDNSEntry(entry DNSEntry) error
+	GetDNSEntries() ([]DNSEntry, error)
+	PurgeDNSEntries(cutoff time.Time) (int64, error)
+	StoreReverseDNSEntry(entry ReverseDNSEntry) error
+	GetReverseDNSEntries() ([]ReverseDNSEntry, error)
+
+	// Listening sockets
+	RecordListeners(listeners []Listener, seen time.Time) ([]Listener, bool, error)
+
+	// Retention and rollups
+	PurgeBefore(cutoff time.Time) (int64, int64, error)
+	RollupAppStats(batch int, before time.Time) (int64, error)
+
+	Close() error
+}
+
+var _ Store = (*DB)(nil)
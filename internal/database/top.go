@@ -0,0 +1,156 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// TopApp is an application ranked by the traffic of its flows
+type TopApp struct {
+	ProcessName string `json:"process_name"`
+	Flows       int64  `json:"flows"`
+	Packets     uint64 `json:"packets"`
+	Bytes       uint64 `json:"bytes"`
+}
+
+// TopDestination is a remote address ranked by the traffic sent to it
+type TopDestination struct {
+	IP      string `json:"ip"`
+	Host    string `json:"host,omitempty"`
+	Flows   int64  `json:"flows"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// TopPort is a destination port ranked by traffic
+type TopPort struct {
+	Protocol string `json:"protocol"`
+	Port     string `json:"port"`
+	Flows    int64  `json:"flows"`
+	Packets  uint64 `json:"packets"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+// GetTopAppsByBytes returns the applications that moved the most bytes in
+// flows active since the given time. Unattributed flows are grouped as "(unknown)".
+func (db *DB) GetTopAppsByBytes(since time.Time, limit int) ([]TopApp, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(`
+		SELECT COALESCE(process_name, '(unknown)') AS name,
+		       COUNT(*), SUM(packet_count), SUM(byte_count)
+		FROM flows
+		WHERE last_seen >= ?
+		GROUP BY name
+		ORDER BY SUM(byte_count) DESC
+		LIMIT ?
+	`, dbTime(since), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top applications: %v", err)
+	}
+	defer rows.Close()
+
+	apps := []TopApp{}
+	for rows.Next() {
+		var app TopApp
+		if err := rows.Scan(&app.ProcessName, &app.Flows, &app.Packets, &app.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan top application: %v", err)
+		}
+		apps = append(apps, app)
+	}
+	return apps, rows.Err()
+}
+
+// GetTopDestinations returns the remote addresses that received the most
+// bytes in flows active since the given time. Incoming flows are excluded
+// because their destination is this machine, and multicast and broadcast
+// flows because their destination is a group rather than a host.
+func (db *DB) GetTopDestinations(since time.Time, limit int) ([]TopDestination, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(`
+		SELECT dst_ip, COALESCE(MAX(dst_host), ''),
+		       COUNT(*), SUM(packet_count), SUM(byte_count)
+		FROM flows
+		WHERE last_seen >= ? AND COALESCE(direction, '') NOT IN ('incoming', 'multicast', 'broadcast')
+		GROUP BY dst_ip
+		ORDER BY SUM(byte_count) DESC
+		LIMIT ?
+	`, dbTime(since), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top destinations: %v", err)
+	}
+	defer rows.Close()
+
+	destinations := []TopDestination{}
+	for rows.Next() {
+		var dest TopDestination
+		if err := rows.Scan(&dest.IP, &dest.Host, &dest.Flows, &dest.Packets, &dest.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan top destination: %v", err)
+		}
+		destinations = append(destinations, dest)
+	}
+	return destinations, rows.Err()
+}
+
+// GetTopPorts returns the destination ports that carried the most bytes in
+// flows active since the given time
+func (db *DB) GetTopPorts(since time.Time, limit int) ([]TopPort, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	rows, err := db.Query(`
+		SELECT protocol, dst_port,
+		       COUNT(*), SUM(packet_count), SUM(byte_count)
+		FROM flows
+		WHERE last_seen >= ?
+		GROUP BY protocol, dst_port
+		ORDER BY SUM(byte_count) DESC
+		LIMIT ?
+	`, dbTime(since), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query top ports: %v", err)
+	}
+	defer rows.Close()
+
+	ports := []TopPort{}
+	for rows.Next() {
+		var port TopPort
+		if err := rows.Scan(&port.Protocol, &port.Port, &port.Flows, &port.Packets, &port.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan top port: %v", err)
+		}
+		ports = append(ports, port)
+	}
+	return ports, rows.Err()
+}
+
+// TrafficTotals sums the flows active in a time range
+type TrafficTotals struct {
+	Flows   int64  `json:"flows"`
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+// GetTotalsSince returns the number of flows active since the given time and
+// the packets and bytes they carried
+func (db *DB) GetTotalsSince(since time.Time) (TrafficTotals, error) {
+	var totals TrafficTotals
+	if db == nil {
+		return totals, fmt.Errorf("database not initialized")
+	}
+
+	err := db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(packet_count), 0), COALESCE(SUM(byte_count), 0)
+		FROM flows
+		WHERE last_seen >= ?
+	`, dbTime(since)).Scan(&totals.Flows, &totals.Packets, &totals.Bytes)
+	if err != nil {
+		return totals, fmt.Errorf("failed to sum flows: %v", err)
+	}
+	return totals, nil
+}
@@ -0,0 +1,120 @@
+// Package firewall blocks applications with Windows Firewall rules so alerts
+// raised while monitoring can be acted on.
+package firewall
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	util "grip/internal"
+)
+
+// rulePrefix starts the name of every rule created here, so they are easy to
+// find in the Windows Defender Firewall console
+const rulePrefix = "Grip block "
+
+// RuleName returns the name of the rule that blocks an executable. Each
+// executable has one rule, which makes blocking and unblocking idempotent.
+// Paths are compared case-insensitively like Windows does, so the path is
+// lowercased.
+func RuleName(processPath string) string {
+	return rulePrefix + strings.ToLower(filepath.Clean(processPath))
+}
+
+// BlockApplication adds an outbound block rule for the executable at
+// processPath on every firewall profile. Nothing is changed if the rule
+// already exists.
+func BlockApplication(processPath string) error {
+	if err := checkPath(processPath); err != nil {
+		return err
+	}
+	name := RuleName(processPath)
+
+	exists, err := ruleExists(name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	return netsh("add", "rule",
+		"name="+name,
+		"dir=out",
+		"action=block",
+		"program="+filepath.Clean(processPath),
+		"profile=any",
+		"enable=yes",
+		"description=Blocked by grip network monitor")
+}
+
+// UnblockApplication removes the rule added by BlockApplication. Nothing is
+// changed if there is no such rule.
+func UnblockApplication(processPath string) error {
+	if err := checkPath(processPath); err != nil {
+		return err
+	}
+	name := RuleName(processPath)
+
+	exists, err := ruleExists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+
+	return netsh("delete", "rule", "name="+name)
+}
+
+// IsBlocked reports whether BlockApplication's rule exists for processPath
+func IsBlocked(processPath string) (bool, error) {
+	if err := checkPath(processPath); err != nil {
+		return false, err
+	}
+	return ruleExists(RuleName(processPath))
+}
+
+// checkPath rejects paths that can't name an executable and callers without
+// the administrator rights netsh needs to change rules
+func checkPath(processPath string) error {
+	if !filepath.IsAbs(processPath) {
+		return fmt.Errorf("executable path must be absolute, got %q", processPath)
+	}
+
+	admin, err := util.IsRunningAsAdmin()
+	if err != nil {
+		return fmt.Errorf("could not check for administrator privileges: %v", err)
+	}
+	if !admin {
+		return fmt.Errorf("changing firewall rules requires administrator privileges")
+	}
+	return nil
+}
+
+// ruleExists looks a rule up by name. netsh exits with status 1 when no rule
+// matches; its message is localized, so only the status is checked.
+func ruleExists(name string) (bool, error) {
+	err := exec.Command("netsh", "advfirewall", "firewall", "show", "rule", "name="+name).Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up firewall rule %q: %v", name, err)
+	}
+	return true, nil
+}
+
+// netsh runs an "advfirewall firewall" command, returning its output on failure
+func netsh(args ...string) error {
+	args = append([]string{"advfirewall", "firewall"}, args...)
+	output, err := exec.Command("netsh", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("netsh %s failed: %v: %s", strings.Join(args[:4], " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
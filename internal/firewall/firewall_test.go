@@ -0,0 +1,20 @@
+package firewall
+
+import "testing"
+
+func TestRuleName(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`C:\Tools\updater.exe`, `Grip block c:\tools\updater.exe`},
+		{`C:\TOOLS\Updater.EXE`, `Grip block c:\tools\updater.exe`},
+		{`c:\tools\updater.exe`, `Grip block c:\tools\updater.exe`},
+		{`C:\Tools\..\Tools\.\updater.exe`, `Grip block c:\tools\updater.exe`},
+	}
+	for _, tt := range tests {
+		if got := RuleName(tt.path); got != tt.want {
+			t.Errorf("RuleName(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
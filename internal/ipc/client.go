@@ -0,0 +1,122 @@
+package ipc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// ErrNotRunning is returned by Dial when nothing serves the pipe
+var ErrNotRunning = errors.New("the service is not running")
+
+// dialBusyWait is how long Dial keeps retrying while every instance of the
+// pipe is taken by the server handing the previous one to a client
+const dialBusyWait = 2 * time.Second
+
+// Client is a connection to the service's pipe. It is not safe for
+// concurrent use.
+type Client struct {
+	conn *pipeConn
+	path string
+}
+
+// Dial connects to the pipe called name
+func Dial(name string) (*Client, error) {
+	path := pipePath(name)
+	path16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(dialBusyWait)
+	for {
+		// The server only learns who we are, it can't act as us
+		handle, err := windows.CreateFile(path16, windows.GENERIC_READ|windows.GENERIC_WRITE, 0, nil, windows.OPEN_EXISTING,
+			windows.FILE_FLAG_OVERLAPPED|windows.SECURITY_SQOS_PRESENT|windows.SECURITY_IDENTIFICATION, 0)
+		switch {
+		case err == nil:
+			timeout, err := windows.CreateEvent(nil, 1, 0, nil)
+			if err != nil {
+				windows.CloseHandle(handle)
+				return nil, err
+			}
+			return &Client{conn: &pipeConn{handle: handle, abort: []windows.Handle{timeout}}, path: path}, nil
+		case err == windows.ERROR_FILE_NOT_FOUND:
+			return nil, ErrNotRunning
+		case err == windows.ERROR_ACCESS_DENIED:
+			return nil, fmt.Errorf("access to %s denied; run as administrator", path)
+		case err == windows.ERROR_PIPE_BUSY && time.Now().Before(deadline):
+			time.Sleep(20 * time.Millisecond)
+		default:
+			return nil, fmt.Errorf("failed to open %s: %v", path, err)
+		}
+	}
+}
+
+// Path returns the path of the pipe, e.g. \\.\pipe\GripNetMonitor
+func (c *Client) Path() string {
+	return c.path
+}
+
+// Call sends a request and decodes its result into result unless that is
+// nil. If the service doesn't answer within timeout the call fails and the
+// client can't be used any more.
+func (c *Client) Call(method string, params map[string]string, result interface{}, timeout time.Duration) error {
+	timer := time.AfterFunc(timeout, func() { windows.SetEvent(c.conn.abort[0]) })
+	response, err := c.roundTrip(Request{Method: method, Params: params})
+	if !timer.Stop() || err == errAborted {
+		return fmt.Errorf("the service did not answer within %s", timeout)
+	}
+	if err != nil {
+		return err
+	}
+	if response.Error != "" {
+		return errors.New(response.Error)
+	}
+	if result != nil && len(response.Result) > 0 {
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("invalid %s result: %v", method, err)
+		}
+	}
+	return nil
+}
+
+// Subscribe asks for the captured packets matching params; read them with Next
+func (c *Client) Subscribe(params map[string]string, timeout time.Duration) error {
+	return c.Call(MethodSubscribe, params, nil, timeout)
+}
+
+// Next waits for the next packet of a subscription and returns it as the
+// JSON form of capture.PacketLog. It returns io.EOF when the service stops.
+func (c *Client) Next() (json.RawMessage, error) {
+	var response Response
+	if err := readMessage(c.conn, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response.Result, nil
+}
+
+func (c *Client) roundTrip(request Request) (Response, error) {
+	var response Response
+	if err := writeMessage(c.conn, request); err != nil {
+		return response, err
+	}
+	err := readMessage(c.conn, &response)
+	if err == io.EOF {
+		err = fmt.Errorf("the service closed the connection")
+	}
+	return response, err
+}
+
+// Close disconnects from the pipe
+func (c *Client) Close() error {
+	windows.CloseHandle(c.conn.abort[0])
+	return c.conn.Close()
+}
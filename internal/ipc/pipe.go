@@ -0,0 +1,110 @@
+package ipc
+
+import (
+	"errors"
+	"io"
+
+	"golang.org/x/sys/windows"
+)
+
+// pipeSecurity lets only Administrators and LocalSystem open the pipe. The
+// service runs as LocalSystem; anyone else must run elevated, as for the
+// service controls.
+const pipeSecurity = "D:P(A;;GA;;;BA)(A;;GA;;;SY)"
+
+// errAborted is returned by I/O abandoned because one of the connection's
+// abort events was signalled
+var errAborted = errors.New("pipe I/O aborted")
+
+// pipePath returns the path of the named pipe called name
+func pipePath(name string) string {
+	return `\\.\pipe\` + name
+}
+
+// pipeConn is one end of a named pipe opened for overlapped I/O, so that a
+// blocked read or write can be abandoned from another goroutine
+type pipeConn struct {
+	handle windows.Handle
+
+	// Manual-reset events; signalling any of them aborts pending and later I/O
+	abort []windows.Handle
+}
+
+func (c *pipeConn) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := c.wait(func(o *windows.Overlapped) error {
+		var started uint32 // Not nil: the race-enabled wrapper reads it
+		return windows.ReadFile(c.handle, p, &started, o)
+	})
+	if err == windows.ERROR_BROKEN_PIPE || err == windows.ERROR_PIPE_NOT_CONNECTED {
+		return n, io.EOF
+	}
+	return n, err
+}
+
+func (c *pipeConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		n, err := c.wait(func(o *windows.Overlapped) error {
+			var started uint32
+			return windows.WriteFile(c.handle, p[written:], &started, o)
+		})
+		written += n
+		if err != nil {
+			if err == windows.ERROR_NO_DATA || err == windows.ERROR_BROKEN_PIPE {
+				return written, io.ErrClosedPipe
+			}
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// accept waits for a client to connect to a server end of the pipe
+func (c *pipeConn) accept() error {
+	_, err := c.wait(func(o *windows.Overlapped) error {
+		err := windows.ConnectNamedPipe(c.handle, o)
+		if err == windows.ERROR_PIPE_CONNECTED {
+			return nil // The client connected before we started waiting
+		}
+		return err
+	})
+	return err
+}
+
+// wait starts an overlapped operation and waits for it to complete or for an
+// abort event, in which case the operation is cancelled and errAborted returned
+func (c *pipeConn) wait(start func(*windows.Overlapped) error) (int, error) {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer windows.CloseHandle(event)
+
+	o := &windows.Overlapped{HEvent: event}
+	var done uint32
+	if err := start(o); err == windows.ERROR_IO_PENDING {
+		result, err := windows.WaitForMultipleObjects(append([]windows.Handle{event}, c.abort...), false, windows.INFINITE)
+		if err != nil {
+			return 0, err
+		}
+		if result != windows.WAIT_OBJECT_0 {
+			// The kernel may still write to o until the cancellation completes
+			windows.CancelIoEx(c.handle, o)
+			windows.GetOverlappedResult(c.handle, o, &done, true)
+			return 0, errAborted
+		}
+	} else if err != nil {
+		return 0, err
+	}
+
+	err = windows.GetOverlappedResult(c.handle, o, &done, true)
+	return int(done), err
+}
+
+// Close releases the handle. No I/O may be pending on the connection.
+func (c *pipeConn) Close() error {
+	return windows.CloseHandle(c.handle)
+}
@@ -0,0 +1,88 @@
+// Package ipc connects the command line to the running service over a Windows
+// named pipe, so status, tail and top need no HTTP port. Every message is a
+// JSON document preceded by its length as a 4-byte little-endian integer.
+// A client sends a Request and reads one Response, and may send further
+// requests on the same connection; after Subscribe the server sends a
+// Response for every captured packet until either side closes the pipe.
+package ipc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// DefaultPipeName is the pipe the service listens on, \\.\pipe\GripNetMonitor
+const DefaultPipeName = "GripNetMonitor"
+
+// maxMessageSize bounds the length read from the pipe, so a corrupt prefix
+// can't make the reader allocate gigabytes
+const maxMessageSize = 16 << 20
+
+// Request methods
+const (
+	// MethodGetStats returns an api.Status; params apps and sort as for /status
+	MethodGetStats = "GetStats"
+	// MethodGetApps returns every application's []api.StatusApp; param sort as for /status
+	MethodGetApps = "GetApps"
+	// MethodSubscribe streams capture.PacketLog results; params app, protocol,
+	// direction and dst as for /packets
+	MethodSubscribe = "Subscribe"
+	// MethodSetLogLevel changes the service's log level; param level
+	MethodSetLogLevel = "SetLogLevel"
+	// MethodPause stops counting packets until MethodResume
+	MethodPause  = "Pause"
+	MethodResume = "Resume"
+)
+
+// Request is a call from a client. Params take the same names and values as
+// the query parameters of the HTTP endpoints.
+type Request struct {
+	Method string            `json:"method"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// Response answers a Request with either an error or the method's result
+type Response struct {
+	Error  string          `json:"error,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+// writeMessage sends v as one length-prefixed JSON message
+func writeMessage(w io.Writer, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	message := make([]byte, 4+len(body))
+	binary.LittleEndian.PutUint32(message, uint32(len(body)))
+	copy(message[4:], body)
+	_, err = w.Write(message)
+	return err
+}
+
+// readMessage reads one length-prefixed JSON message into v. It returns
+// io.EOF if the other end closed the pipe between messages.
+func readMessage(r io.Reader, v interface{}) error {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return err
+	}
+	size := binary.LittleEndian.Uint32(prefix[:])
+	if size > maxMessageSize {
+		return fmt.Errorf("message of %d bytes exceeds the limit of %d", size, maxMessageSize)
+	}
+
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("invalid message: %v", err)
+	}
+	return nil
+}
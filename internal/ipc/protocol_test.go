@@ -0,0 +1,81 @@
+package ipc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMessageRoundTrip writes several messages into one buffer and reads them
+// back in order, then checks that the end of the stream reads as io.EOF
+func TestMessageRoundTrip(t *testing.T) {
+	messages := []Request{
+		{Method: MethodGetStats, Params: map[string]string{"apps": "5", "sort": "bytes"}},
+		{Method: MethodPause},
+		{Method: MethodSubscribe, Params: map[string]string{"dst": "10.0.0.0/8,192.0.2.1", "app": "Bob's app.exe"}},
+	}
+	var buf bytes.Buffer
+	for _, message := range messages {
+		if err := writeMessage(&buf, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, want := range messages {
+		var got Request
+		if err := readMessage(&buf, &got); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("message %d: got %+v, want %+v", i, got, want)
+		}
+	}
+	var extra Request
+	if err := readMessage(&buf, &extra); err != io.EOF {
+		t.Errorf("reading past the last message: %v, want io.EOF", err)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	// frame returns body preceded by size as its length prefix
+	frame := func(size uint32, body string) []byte {
+		data := binary.LittleEndian.AppendUint32(nil, size)
+		return append(data, body...)
+	}
+	var whole bytes.Buffer
+	if err := writeMessage(&whole, Response{Result: json.RawMessage(`{"packets":12}`)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error  // Exact error, or nil to check wantMsg
+		wantMsg string // Text the error must contain
+	}{
+		{"truncated prefix", whole.Bytes()[:3], io.ErrUnexpectedEOF, ""},
+		{"truncated body", whole.Bytes()[:whole.Len()-1], io.ErrUnexpectedEOF, ""},
+		{"missing body", frame(10, ""), io.ErrUnexpectedEOF, ""},
+		{"at the size limit", frame(maxMessageSize, "{}"), io.ErrUnexpectedEOF, ""},
+		{"over the size limit", frame(maxMessageSize+1, "{}"), nil, "exceeds the limit"},
+		{"largest prefix", frame(1<<32-1, ""), nil, "exceeds the limit"},
+		{"not JSON", frame(5, "hello"), nil, "invalid message"},
+	}
+	for _, tt := range tests {
+		var response Response
+		err := readMessage(bytes.NewReader(tt.data), &response)
+		if tt.wantErr != nil {
+			if err != tt.wantErr {
+				t.Errorf("%s: error %v, want %v", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantMsg) {
+			t.Errorf("%s: error %v, want one containing %q", tt.name, err, tt.wantMsg)
+		}
+	}
+}
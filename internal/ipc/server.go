@@ -0,0 +1,268 @@
+package ipc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"grip/internal/api"
+	"grip/internal/capture"
+	"grip/internal/logger"
+)
+
+// pipeBufferSize is the size of the pipe's input and output buffers
+const pipeBufferSize = 64 * 1024
+
+// Config controls the named pipe server
+type Config struct {
+	// Name is the pipe name, e.g. "GripNetMonitor" for \\.\pipe\GripNetMonitor.
+	// Empty disables the server.
+	Name string
+	// SetPaused pauses or resumes capture for Pause and Resume requests
+	SetPaused func(pause bool) error
+}
+
+var server *pipeServer
+
+type pipeServer struct {
+	config   Config
+	path     string
+	security *windows.SecurityAttributes
+
+	stop    windows.Handle  // Manual-reset event signalled by Stop, aborting pipe I/O
+	ctx     context.Context // Cancelled by Stop, ending subscriptions
+	cancel  context.CancelFunc
+	running sync.WaitGroup // The accept loop and every client
+	clients atomic.Uint64  // Numbers clients in the log
+}
+
+// Start creates the pipe and serves clients in the background. It fails if
+// the pipe already exists, which means another instance is running.
+func Start(cfg Config) error {
+	if cfg.Name == "" {
+		return nil
+	}
+
+	descriptor, err := windows.SecurityDescriptorFromString(pipeSecurity)
+	if err != nil {
+		return fmt.Errorf("failed to create the pipe security descriptor: %v", err)
+	}
+	stop, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create the pipe stop event: %v", err)
+	}
+
+	s := &pipeServer{
+		config: cfg,
+		path:   pipePath(cfg.Name),
+		security: &windows.SecurityAttributes{
+			Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+			SecurityDescriptor: descriptor,
+		},
+		stop: stop,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	conn, err := s.listen(true)
+	if err != nil {
+		s.cancel()
+		windows.CloseHandle(stop)
+		return fmt.Errorf("failed to create pipe %s: %v", s.path, err)
+	}
+
+	server = s
+	s.running.Add(1)
+	go s.serve(conn)
+
+	logger.Info("Serving the command line on %s", s.path)
+	return nil
+}
+
+// Stop closes the pipe, disconnecting every client, and waits for them
+func Stop() {
+	if server == nil {
+		return
+	}
+	server.cancel()
+	windows.SetEvent(server.stop)
+	server.running.Wait()
+	windows.CloseHandle(server.stop)
+	server = nil
+}
+
+// listen creates an instance of the pipe for the next client. Only the first
+// instance may create the pipe, so a second service can't serve it too.
+func (s *pipeServer) listen(first bool) (*pipeConn, error) {
+	path, err := windows.UTF16PtrFromString(s.path)
+	if err != nil {
+		return nil, err
+	}
+	flags := uint32(windows.PIPE_ACCESS_DUPLEX | windows.FILE_FLAG_OVERLAPPED)
+	if first {
+		flags |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	mode := uint32(windows.PIPE_TYPE_BYTE | windows.PIPE_READMODE_BYTE | windows.PIPE_WAIT | windows.PIPE_REJECT_REMOTE_CLIENTS)
+
+	handle, err := windows.CreateNamedPipe(path, flags, mode, windows.PIPE_UNLIMITED_INSTANCES,
+		pipeBufferSize, pipeBufferSize, 0, s.security)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeConn{handle: handle, abort: []windows.Handle{s.stop}}, nil
+}
+
+// serve hands each connected instance to its own goroutine and creates the
+// next, so any number of clients can be connected at once
+func (s *pipeServer) serve(conn *pipeConn) {
+	defer s.running.Done()
+
+	for {
+		if err := conn.accept(); err != nil {
+			conn.Close()
+			if err != errAborted {
+				logger.Error("Pipe server stopped: %v", err)
+			}
+			return
+		}
+
+		s.running.Add(1)
+		go s.serveClient(conn)
+
+		next, err := s.listen(false)
+		if err != nil {
+			logger.Error("Pipe server stopped: %v", err)
+			return
+		}
+		conn = next
+	}
+}
+
+// serveClient answers requests until the client disconnects, or streams
+// packets to it once it subscribes
+func (s *pipeServer) serveClient(conn *pipeConn) {
+	defer s.running.Done()
+	defer conn.Close()
+
+	client := fmt.Sprintf("#%d", s.clients.Add(1))
+	logger.Debug("Pipe client %s connected", client)
+	defer logger.Debug("Pipe client %s disconnected", client)
+
+	for {
+		var request Request
+		if err := readMessage(conn, &request); err != nil {
+			if err != io.EOF && err != errAborted {
+				logger.Warning("Pipe client %s: %v", client, err)
+			}
+			return
+		}
+
+		var response Response
+		if request.Method == MethodSubscribe {
+			filter, err := api.PacketFilterFromQuery(queryValues(request.Params))
+			if err == nil {
+				s.subscribe(conn, client, filter)
+				return
+			}
+			response.Error = err.Error()
+		} else {
+			response = s.call(request)
+		}
+		if err := writeMessage(conn, response); err != nil {
+			return
+		}
+	}
+}
+
+// call runs a request other than Subscribe
+func (s *pipeServer) call(request Request) Response {
+	result, err := s.handle(request)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	body, err := json.Marshal(result)
+	if err != nil {
+		return Response{Error: fmt.Sprintf("failed to encode result: %v", err)}
+	}
+	return Response{Result: body}
+}
+
+func (s *pipeServer) handle(request Request) (interface{}, error) {
+	query := queryValues(request.Params)
+	switch request.Method {
+	case MethodGetStats:
+		return api.CurrentStatus(query)
+	case MethodGetApps:
+		return api.CurrentApps(query.Get("sort"))
+	case MethodSetLogLevel:
+		level, err := logger.ParseLevel(query.Get("level"))
+		if err != nil {
+			return nil, err
+		}
+		logger.SetLevel(level)
+		logger.Warning("Log level changed to %s", level)
+		return nil, nil
+	case MethodPause, MethodResume:
+		if s.config.SetPaused == nil {
+			return nil, fmt.Errorf("this instance can't be paused")
+		}
+		return nil, s.config.SetPaused(request.Method == MethodPause)
+	default:
+		return nil, fmt.Errorf("unknown method %q", request.Method)
+	}
+}
+
+// subscribe acknowledges a subscription and streams matching packets until
+// the client goes away or the server stops
+func (s *pipeServer) subscribe(conn *pipeConn, client string, filter capture.PacketFilter) {
+	// Lets this goroutine abort the read below once it stops streaming
+	closing, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		writeMessage(conn, Response{Error: fmt.Sprintf("failed to subscribe: %v", err)})
+		return
+	}
+	defer windows.CloseHandle(closing)
+	conn.abort = append(conn.abort, closing)
+
+	if err := writeMessage(conn, Response{}); err != nil {
+		return
+	}
+	logger.Info("Pipe client %s subscribed to packets", client)
+
+	// The client sends nothing more, so a read only ends when it disconnects
+	ctx, cancel := context.WithCancel(s.ctx)
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		defer cancel()
+		io.Copy(io.Discard, conn)
+	}()
+	defer func() {
+		windows.SetEvent(closing)
+		<-gone
+	}()
+
+	dropped, _ := capture.StreamPackets(ctx, filter, func(packet capture.PacketLog, _ uint64) error {
+		body, err := json.Marshal(packet)
+		if err != nil {
+			return nil
+		}
+		return writeMessage(conn, Response{Result: body})
+	})
+	logger.Info("Pipe client %s unsubscribed, %d packets dropped", client, dropped)
+}
+
+// queryValues turns request parameters into the query values the HTTP
+// endpoints parse
+func queryValues(params map[string]string) url.Values {
+	query := url.Values{}
+	for name, value := range params {
+		query.Set(name, value)
+	}
+	return query
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"fmt"
+	"net"
+)
+
+// ListenLoopback listens for TCP connections on addr, which must be localhost
+// or a loopback IP with a port, e.g. 127.0.0.1:6060. For endpoints that must
+// never be reachable from other machines.
+func ListenLoopback(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %s: %v", addr, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("%s is not a loopback address, e.g. 127.0.0.1", addr)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	// localhost is resolved by Listen, so check what it actually bound
+	if !IsLoopback(listener.Addr()) {
+		listener.Close()
+		return nil, fmt.Errorf("%s resolved to %s, which is not a loopback address", addr, listener.Addr())
+	}
+	return listener, nil
+}
+
+// IsLoopback reports whether a listener is bound to a loopback address.
+// Hostnames like localhost are resolved by Listen, so this is what decides.
+func IsLoopback(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	return ok && tcpAddr.IP.IsLoopback()
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package logger
+
+import "fmt"
+
+// openEventLog always fails outside Windows, leaving only the other sinks
+func openEventLog(source string) error {
+	return fmt.Errorf("the Windows Event Log is not available on this platform")
+}
+
+func writeEventLog(level LogLevel, message string) {}
+
+func closeEventLog() {}
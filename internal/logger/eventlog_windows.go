@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Event IDs written to the Windows Event Log. The EventCreate message file
+// registered by eventlog.InstallAsEventCreate accepts IDs 1 to 1000.
+const (
+	eventIDError   = 1
+	eventIDWarning = 2
+	eventIDInfo    = 3
+)
+
+var (
+	eventLog      *eventlog.Log
+	eventLogMutex sync.Mutex
+)
+
+// openEventLog opens the event source, or returns an error when it has not
+// been registered (e.g. the service was never installed)
+func openEventLog(source string) error {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE,
+		`SYSTEM\CurrentControlSet\Services\EventLog\Application\`+source, registry.QUERY_VALUE)
+	if err != nil {
+		return fmt.Errorf("event source %s is not registered: %v", source, err)
+	}
+	key.Close()
+
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to open event source %s: %v", source, err)
+	}
+
+	eventLogMutex.Lock()
+	if eventLog != nil {
+		eventLog.Close()
+	}
+	eventLog = l
+	eventLogMutex.Unlock()
+	return nil
+}
+
+// writeEventLog reports a message at the given level; failures are ignored so
+// the Event Log can never break logging to the other sinks
+func writeEventLog(level LogLevel, message string) {
+	eventLogMutex.Lock()
+	defer eventLogMutex.Unlock()
+	if eventLog == nil {
+		return
+	}
+
+	switch level {
+	case LevelError:
+		eventLog.Error(eventIDError, message)
+	case LevelWarning:
+		eventLog.Warning(eventIDWarning, message)
+	default:
+		eventLog.Info(eventIDInfo, message)
+	}
+}
+
+// closeEventLog closes the event source if it is open
+func closeEventLog() {
+	eventLogMutex.Lock()
+	defer eventLogMutex.Unlock()
+	if eventLog != nil {
+		eventLog.Close()
+		eventLog = nil
+	}
+}
@@ -2,8 +2,9 @@ package logger
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -42,15 +43,28 @@ var (
 	useColors      = true
 	consoleEnabled atomic.Bool
 
-	// File output settings
-	logFile     *os.File
-	logFilePath string
+	// Timestamp settings
+	timeFormat = DefaultTimeFormat
+	useUTC     bool
+
+	// Prefix debug and trace messages with the caller's file and line
+	includeCaller atomic.Bool
+
+	// File output settings. sinksMutex guards replacing the sinks; each sink
+	// guards its own writes.
+	mainSink    *logSink
+	levelSinks  map[LogLevel]*logSink // Extra files that receive one level each
+	sinksMutex  sync.RWMutex
 	fileEnabled atomic.Bool
 
-	// Thread safety
-	fileMutex sync.Mutex
+	// Windows Event Log output settings
+	eventLogEnabled     atomic.Bool
+	eventLogInfoEnabled atomic.Bool
 )
 
+// DefaultTimeFormat is the timestamp layout used when LoggerConfig.TimeFormat is empty
+const DefaultTimeFormat = "2006-01-02 15:04:05.000"
+
 // ANSI color codes
 const (
 	colorReset  = "\033[0m"
@@ -72,6 +86,38 @@ type LoggerConfig struct {
 	EnableFile    bool
 	LogFilePath   string
 	UseColors     bool
+
+	// Timestamps
+	TimeFormat string // Go time layout, DefaultTimeFormat if empty
+	UseUTC     bool   // Use UTC rather than local time
+
+	// Prefix debug and trace messages with the file and line they were logged from
+	IncludeCaller bool
+
+	// Log file rotation, applied to each file
+	MaxSizeMB   int  // Rotate once the file exceeds this size (0 for no limit)
+	MaxBackups  int  // Number of rotated files kept (0 to keep all)
+	RotateDaily bool // Rotate when the date changes
+
+	// Per-level files, written in addition to LogFilePath. SeparateLevels
+	// gives every level its own file named after LogFilePath, such as
+	// netmonitor.error.log; ErrorLogPath sets the error file's path and
+	// enables it on its own.
+	SeparateLevels bool
+	ErrorLogPath   string
+
+	// Windows Event Log output. Errors and warnings are written to the named
+	// event source, which must already be registered (the service installer
+	// does this); otherwise the sink is skipped and the others are used.
+	EnableEventLog bool
+	EventLogSource string
+	EventLogInfo   bool // Also write info messages
+
+	// Remote syslog output (RFC 5424). SyslogAddr is "udp://host:port",
+	// "tcp://host:port" or "host:port" for UDP; messages are queued and sent
+	// in the background so an unreachable server never blocks logging.
+	SyslogAddr    string
+	SyslogAppName string // APP-NAME field, "-" if empty
 }
 
 // Initialize sets up the logger with the given configuration
@@ -86,38 +132,113 @@ func Initialize(config LoggerConfig) error {
 	// Configure outputs
 	consoleEnabled.Store(config.EnableConsole)
 	useColors = config.UseColors
+	timeFormat = config.TimeFormat
+	if timeFormat == "" {
+		timeFormat = DefaultTimeFormat
+	}
+	useUTC = config.UseUTC
+	includeCaller.Store(config.IncludeCaller)
 
 	// Configure file logging if enabled
 	if config.EnableFile {
+		main, levels, err := openSinks(config)
+		if err != nil {
+			return err
+		}
+
+		// Initialize may be called more than once; don't leak the previous handles
+		replaceSinks(main, levels)
 		fileEnabled.Store(true)
-		logFilePath = config.LogFilePath
+	}
 
-		// Create log directory if it doesn't exist
-		dir := filepath.Dir(logFilePath)
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("failed to create log directory: %v", err)
+	// Configure the Event Log; an unregistered source is expected outside the service
+	eventLogEnabled.Store(false)
+	closeEventLog()
+	var eventLogErr error
+	if config.EnableEventLog {
+		if eventLogErr = openEventLog(config.EventLogSource); eventLogErr == nil {
+			eventLogInfoEnabled.Store(config.EventLogInfo)
+			eventLogEnabled.Store(true)
 		}
+	}
 
-		// Open log file
-		file, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-		if err != nil {
-			return fmt.Errorf("failed to open log file: %v", err)
+	// Configure syslog forwarding; connection failures are retried in the background
+	closeSyslog()
+	if config.SyslogAddr != "" {
+		if err := openSyslog(config.SyslogAddr, config.SyslogAppName); err != nil {
+			return fmt.Errorf("invalid syslog address: %v", err)
 		}
-		logFile = file
 	}
 
 	// Log initialization
 	Info("Logger initialized")
+	if eventLogErr != nil {
+		Debug("Not writing to the Windows Event Log: %v", eventLogErr)
+	}
 	return nil
 }
 
 // Close properly closes the logger and any open files
 func Close() {
-	if logFile != nil {
-		fileMutex.Lock()
-		defer fileMutex.Unlock()
-		logFile.Close()
-		logFile = nil
+	eventLogEnabled.Store(false)
+	closeEventLog()
+	closeSyslog()
+
+	replaceSinks(nil, nil)
+}
+
+// levelLogPath returns the file a level is routed to with SeparateLevels,
+// e.g. logs/netmonitor.warn.log. The level goes before the extension rather
+// than after a dash so rotated backups of the main file never match it.
+func levelLogPath(path string, level LogLevel) string {
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.%s%s", strings.TrimSuffix(path, ext), strings.ToLower(levelStrings[level]), ext)
+}
+
+// openSinks opens the main log file and any per-level files
+func openSinks(config LoggerConfig) (*logSink, map[LogLevel]*logSink, error) {
+	main, err := newLogSink(config.LogFilePath, config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paths := make(map[LogLevel]string)
+	if config.SeparateLevels {
+		for level := range levelStrings {
+			paths[level] = levelLogPath(config.LogFilePath, level)
+		}
+	}
+	if config.ErrorLogPath != "" {
+		paths[LevelError] = config.ErrorLogPath
+	}
+
+	levels := make(map[LogLevel]*logSink)
+	for level, path := range paths {
+		sink, err := newLogSink(path, config)
+		if err != nil {
+			main.close()
+			for _, opened := range levels {
+				opened.close()
+			}
+			return nil, nil, err
+		}
+		levels[level] = sink
+	}
+	return main, levels, nil
+}
+
+// replaceSinks installs new log files and closes the previous ones
+func replaceSinks(main *logSink, levels map[LogLevel]*logSink) {
+	sinksMutex.Lock()
+	previous, previousLevels := mainSink, levelSinks
+	mainSink, levelSinks = main, levels
+	sinksMutex.Unlock()
+
+	if previous != nil {
+		previous.close()
+	}
+	for _, sink := range previousLevels {
+		sink.close()
 	}
 }
 
@@ -163,7 +284,11 @@ func getColorCode(level LogLevel) string {
 
 // formatMessage formats a log message with timestamp, level and message
 func formatMessage(level LogLevel, format string, args ...interface{}) string {
-	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
+	now := time.Now()
+	if useUTC {
+		now = now.UTC()
+	}
+	timestamp := now.Format(timeFormat)
 	levelStr := levelStrings[level]
 	message := fmt.Sprintf(format, args...)
 
@@ -182,51 +307,129 @@ func logToConsole(message string) {
 	}
 }
 
-// logToFile logs a message to the log file if file logging is enabled
-func logToFile(message string) {
-	if fileEnabled.Load() && logFile != nil {
-		fileMutex.Lock()
-		defer fileMutex.Unlock()
-		fmt.Fprintln(logFile, message)
+// logToFile logs a message to the main log file, and to the level's own file
+// if it has one, if file logging is enabled
+func logToFile(level LogLevel, message string) {
+	if !fileEnabled.Load() {
+		return
+	}
+
+	sinksMutex.RLock()
+	defer sinksMutex.RUnlock()
+
+	if mainSink != nil {
+		mainSink.write(message)
+	}
+	if sink, ok := levelSinks[level]; ok {
+		sink.write(message)
 	}
 }
 
-// log logs a message at the specified level
-func log(level LogLevel, format string, args ...interface{}) {
+// logToEventLog writes errors, warnings and, if configured, info messages to
+// the Windows Event Log. The Event Log records its own timestamp and level, so
+// the message is passed without them.
+func logToEventLog(level LogLevel, force bool, format string, args ...interface{}) {
+	if !eventLogEnabled.Load() {
+		return
+	}
+	if !force && level > LevelWarning && !(level == LevelInfo && eventLogInfoEnabled.Load()) {
+		return
+	}
+
+	writeEventLog(level, fmt.Sprintf(format, args...))
+}
+
+// logToSyslog forwards a message to the syslog server, which records the
+// timestamp and severity in the message header
+func logToSyslog(level LogLevel, format string, args ...interface{}) {
+	if activeSyslog.Load() == nil {
+		return
+	}
+	writeSyslog(level, fmt.Sprintf(format, args...))
+}
+
+// callerFrames is the number of frames between the caller of a public logging
+// function and the runtime.Caller call: caller, log and the public function
+const callerFrames = 3
+
+// caller returns "dir/file.go:line" for the code that called a public logging
+// function. depth skips further frames for wrappers such as capture.LogDebug.
+func caller(depth int) string {
+	_, file, line, ok := runtime.Caller(callerFrames + depth)
+	if !ok {
+		return "???:0"
+	}
+	return fmt.Sprintf("%s/%s:%d", filepath.Base(filepath.Dir(file)), filepath.Base(file), line)
+}
+
+// log logs a message at the specified level. Public logging functions must
+// call it directly so caller's frame count holds; depth counts any wrappers
+// above them.
+func log(level LogLevel, depth int, format string, args ...interface{}) {
 	if !isLevelEnabled(level) {
 		return
 	}
 
+	if level >= LevelDebug && includeCaller.Load() {
+		format = "%s " + format
+		args = append([]interface{}{caller(depth)}, args...)
+	}
+
 	message := formatMessage(level, format, args...)
 	logToConsole(message)
-	logToFile(message)
+	logToFile(level, message)
+	logToEventLog(level, false, format, args...)
+	logToSyslog(level, format, args...)
 }
 
 // Public logging functions
 
 // Error logs an error message
 func Error(format string, args ...interface{}) {
-	log(LevelError, format, args...)
+	log(LevelError, 0, format, args...)
 }
 
 // Warning logs a warning message
 func Warning(format string, args ...interface{}) {
-	log(LevelWarning, format, args...)
+	log(LevelWarning, 0, format, args...)
 }
 
 // Info logs an informational message
 func Info(format string, args ...interface{}) {
-	log(LevelInfo, format, args...)
+	log(LevelInfo, 0, format, args...)
 }
 
 // Debug logs a debug message
 func Debug(format string, args ...interface{}) {
-	log(LevelDebug, format, args...)
+	log(LevelDebug, 0, format, args...)
+}
+
+// DebugDepth logs a debug message from a logging wrapper. depth is the number
+// of wrapper functions between the logged call site and DebugDepth, so the
+// caller shown with IncludeCaller is the call site rather than the wrapper.
+func DebugDepth(depth int, format string, args ...interface{}) {
+	log(LevelDebug, depth, format, args...)
 }
 
 // Trace logs a trace message (very detailed debugging)
 func Trace(format string, args ...interface{}) {
-	log(LevelTrace, format, args...)
+	log(LevelTrace, 0, format, args...)
+}
+
+// TraceDepth is Trace for logging wrappers, see DebugDepth
+func TraceDepth(depth int, format string, args ...interface{}) {
+	log(LevelTrace, depth, format, args...)
+}
+
+// Event logs a service lifecycle message at info level. Unlike Info it is
+// always written to the Event Log when that sink is enabled, and to the other
+// sinks even when info logging is turned off.
+func Event(format string, args ...interface{}) {
+	message := formatMessage(LevelInfo, format, args...)
+	logToConsole(message)
+	logToFile(LevelInfo, message)
+	logToEventLog(LevelInfo, true, format, args...)
+	logToSyslog(LevelInfo, format, args...)
 }
 
 // IsErrorEnabled returns whether error logging is enabled
@@ -253,3 +456,39 @@ func IsDebugEnabled() bool {
 func IsTraceEnabled() bool {
 	return traceEnabled.Load()
 }
+
+// ParseLevel converts a level name (error, warn, info, debug or trace) to a LogLevel
+func ParseLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "error":
+		return LevelError, nil
+	case "warn", "warning":
+		return LevelWarning, nil
+	case "info":
+		return LevelInfo, nil
+	case "debug":
+		return LevelDebug, nil
+	case "trace":
+		return LevelTrace, nil
+	default:
+		return 0, fmt.Errorf("unknown log level %q (expected error, warn, info, debug or trace)", name)
+	}
+}
+
+// String returns the name of a log level as accepted by ParseLevel
+func (l LogLevel) String() string {
+	if s, ok := levelStrings[l]; ok {
+		return strings.ToLower(s)
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// SetLevel enables every level up to and including the given threshold and
+// disables the more verbose ones. It is safe to call while logging is in use.
+func SetLevel(level LogLevel) {
+	errorEnabled.Store(level >= LevelError)
+	warningEnabled.Store(level >= LevelWarning)
+	infoEnabled.Store(level >= LevelInfo)
+	debugEnabled.Store(level >= LevelDebug)
+	traceEnabled.Store(level >= LevelTrace)
+}
@@ -0,0 +1,247 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    LogLevel
+		wantErr bool
+	}{
+		{"error", LevelError, false},
+		{"warn", LevelWarning, false},
+		{"warning", LevelWarning, false},
+		{"info", LevelInfo, false},
+		{" Debug ", LevelDebug, false},
+		{"TRACE", LevelTrace, false},
+		{"verbose", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLevel(tt.name)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v (error %v)", tt.name, got, err, tt.want, tt.wantErr)
+		}
+		// Every level's name parses back to it
+		if err == nil {
+			if back, err := ParseLevel(got.String()); err != nil || back != got {
+				t.Errorf("ParseLevel(%q) = %v, %v, want %v", got.String(), back, err, got)
+			}
+		}
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	t.Cleanup(func() { SetLevel(LevelInfo) })
+
+	tests := []struct {
+		level                              LogLevel
+		error, warning, info, debug, trace bool
+	}{
+		{LevelError, true, false, false, false, false},
+		{LevelWarning, true, true, false, false, false},
+		{LevelInfo, true, true, true, false, false},
+		{LevelDebug, true, true, true, true, false},
+		{LevelTrace, true, true, true, true, true},
+	}
+	for _, tt := range tests {
+		SetLevel(tt.level)
+		got := []bool{IsErrorEnabled(), IsWarningEnabled(), IsInfoEnabled(), IsDebugEnabled(), IsTraceEnabled()}
+		want := []bool{tt.error, tt.warning, tt.info, tt.debug, tt.trace}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("SetLevel(%v): %v enabled = %v, want %v", tt.level, LogLevel(i), got[i], want[i])
+			}
+		}
+	}
+}
+
+// callerLine returns the line it was called from
+func callerLine() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+// debugWrapper logs like capture.LogDebug, one wrapper above DebugDepth
+func debugWrapper(format string, args ...interface{}) {
+	DebugDepth(1, format, args...)
+}
+
+func TestIncludeCaller(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netmonitor.log")
+	t.Cleanup(func() {
+		Close()
+		SetLevel(LevelInfo)
+		includeCaller.Store(false)
+	})
+
+	tests := []struct {
+		name          string
+		includeCaller bool
+		log           func() int // Logs "message" and returns the line it did so
+		wantCaller    bool
+	}{
+		{"debug", true, func() int { Debug("message"); return callerLine() }, true},
+		{"trace", true, func() int { Trace("message"); return callerLine() }, true},
+		{"wrapper", true, func() int { debugWrapper("message"); return callerLine() }, true},
+		{"info", true, func() int { Info("message"); return callerLine() }, false},
+		{"warning", true, func() int { Warning("message"); return callerLine() }, false},
+		{"debug without caller", false, func() int { Debug("message"); return callerLine() }, false},
+	}
+	for _, tt := range tests {
+		err := Initialize(LoggerConfig{
+			EnableError:   true,
+			EnableWarning: true,
+			EnableInfo:    true,
+			EnableDebug:   true,
+			EnableTrace:   true,
+			EnableFile:    true,
+			LogFilePath:   path,
+			IncludeCaller: tt.includeCaller,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		line := tt.log()
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		last := lines[len(lines)-1]
+
+		want := "] message"
+		if tt.wantCaller {
+			want = fmt.Sprintf("] logger/logger_test.go:%d message", line)
+		}
+		if !strings.HasSuffix(last, want) {
+			t.Errorf("%s: logged %q, want it to end with %q", tt.name, last, want)
+		}
+	}
+}
+
+// TestSeparateLevels logs at every level with per-level files and checks that
+// each file gets its own level, that the main log still gets everything, and
+// that Close closes every file
+func TestSeparateLevels(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "netmonitor.log")
+	errorPath := filepath.Join(dir, "errors", "grip-errors.log")
+	t.Cleanup(func() {
+		Close()
+		SetLevel(LevelInfo)
+	})
+
+	tests := []struct {
+		name           string
+		separateLevels bool
+		errorLogPath   string
+		want           map[string][]string // Files and the levels they hold
+		wantMissing    []string            // Files that must not be created
+	}{
+		{"separate levels", true, "", map[string][]string{
+			path: {"ERROR", "WARN", "INFO", "DEBUG", "TRACE"},
+			filepath.Join(dir, "netmonitor.error.log"): {"ERROR"},
+			filepath.Join(dir, "netmonitor.warn.log"):  {"WARN"},
+			filepath.Join(dir, "netmonitor.info.log"):  {"INFO"},
+			filepath.Join(dir, "netmonitor.debug.log"): {"DEBUG"},
+			filepath.Join(dir, "netmonitor.trace.log"): {"TRACE"},
+		}, []string{errorPath}},
+		{"error file only", false, errorPath, map[string][]string{
+			path:      {"ERROR", "WARN", "INFO", "DEBUG", "TRACE"},
+			errorPath: {"ERROR"},
+		}, []string{filepath.Join(dir, "netmonitor.error.log"), filepath.Join(dir, "netmonitor.warn.log")}},
+		{"error file with separate levels", true, errorPath, map[string][]string{
+			path:      {"ERROR", "WARN", "INFO", "DEBUG", "TRACE"},
+			errorPath: {"ERROR"},
+			filepath.Join(dir, "netmonitor.warn.log"):  {"WARN"},
+			filepath.Join(dir, "netmonitor.info.log"):  {"INFO"},
+			filepath.Join(dir, "netmonitor.debug.log"): {"DEBUG"},
+			filepath.Join(dir, "netmonitor.trace.log"): {"TRACE"},
+		}, []string{filepath.Join(dir, "netmonitor.error.log")}},
+	}
+	for _, tt := range tests {
+		files, err := filepath.Glob(filepath.Join(dir, "*.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range append(files, errorPath) {
+			os.Remove(file)
+		}
+
+		err = Initialize(LoggerConfig{
+			EnableError:    true,
+			EnableWarning:  true,
+			EnableInfo:     true,
+			EnableDebug:    true,
+			EnableTrace:    true,
+			EnableFile:     true,
+			LogFilePath:    path,
+			SeparateLevels: tt.separateLevels,
+			ErrorLogPath:   tt.errorLogPath,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		Error("routed %s", "error")
+		Warning("routed warn")
+		Info("routed info")
+		Debug("routed debug")
+		Trace("routed trace")
+
+		sinksMutex.RLock()
+		sinks := []*logSink{mainSink}
+		for _, sink := range levelSinks {
+			sinks = append(sinks, sink)
+		}
+		sinksMutex.RUnlock()
+		if len(sinks) != len(tt.want) {
+			t.Errorf("%s: %d files open, want %d", tt.name, len(sinks), len(tt.want))
+		}
+
+		Close()
+		for _, sink := range sinks {
+			if sink.file != nil {
+				t.Errorf("%s: %s still open after Close", tt.name, sink.path)
+			}
+		}
+		Error("after close")
+
+		for file, levels := range tt.want {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			var got []string
+			for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+				if strings.Contains(line, "after close") {
+					t.Errorf("%s: %s written after Close", tt.name, file)
+				}
+				if _, message, ok := strings.Cut(line, "] routed "); ok {
+					level := line[strings.Index(line, "[")+1 : strings.Index(line, "]")]
+					if !strings.EqualFold(level, message) {
+						t.Errorf("%s: %s logged as %s", tt.name, message, level)
+					}
+					got = append(got, level)
+				}
+			}
+			if strings.Join(got, " ") != strings.Join(levels, " ") {
+				t.Errorf("%s: %s holds %v, want %v", tt.name, filepath.Base(file), got, levels)
+			}
+		}
+		for _, file := range tt.wantMissing {
+			if _, err := os.Stat(file); !os.IsNotExist(err) {
+				t.Errorf("%s: %s created", tt.name, filepath.Base(file))
+			}
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat is appended to the base name of rotated log files
+const rotatedTimeFormat = "20060102-150405.000"
+
+// rotatedGlob matches the suffix rotatedTimeFormat produces, so backups of one
+// file never match files that merely share its prefix
+const rotatedGlob = "-????????-??????.???"
+
+// logSink is one log file with its own rotation state. Every sink has its own
+// mutex so writes to different files don't wait on each other.
+type logSink struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	openedOn string // date the active file was opened, "2006-01-02"
+
+	// Rotation settings, fixed when the sink is created
+	maxSize     int64 // bytes, 0 for no limit
+	maxBackups  int   // rotated files kept, 0 to keep all
+	rotateDaily bool
+}
+
+// newLogSink creates the file's directory and opens it for appending
+func newLogSink(path string, config LoggerConfig) (*logSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %v", err)
+	}
+
+	sink := &logSink{
+		path:        path,
+		maxSize:     int64(config.MaxSizeMB) * 1024 * 1024,
+		maxBackups:  config.MaxBackups,
+		rotateDaily: config.RotateDaily,
+	}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+// open opens the active log file and records its current size.
+// Must be called with mu held.
+func (s *logSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %v", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %v", err)
+	}
+
+	s.file = file
+	s.size = info.Size()
+	s.openedOn = time.Now().Format("2006-01-02")
+	return nil
+}
+
+// write appends a line to the file, rotating it first if needed
+func (s *logSink) write(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.needsRotation(len(message) + 1) {
+		if err := s.rotate(); err != nil && consoleEnabled.Load() {
+			fmt.Println(formatMessage(LevelError, "Log rotation failed: %v", err))
+		}
+	}
+	if s.file == nil {
+		return
+	}
+
+	n, _ := fmt.Fprintln(s.file, message)
+	s.size += int64(n)
+}
+
+// close closes the file; later writes are dropped
+func (s *logSink) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
+
+// needsRotation reports whether writing n more bytes should start a new file.
+// Must be called with mu held.
+func (s *logSink) needsRotation(n int) bool {
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(n) > s.maxSize {
+		return true
+	}
+	return s.rotateDaily && time.Now().Format("2006-01-02") != s.openedOn
+}
+
+// rotate closes the active file, renames it with a timestamp suffix, opens a
+// fresh one and removes old backups. Must be called with mu held so no
+// concurrent writes are lost during the swap.
+func (s *logSink) rotate() error {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+
+	ext := filepath.Ext(s.path)
+	base := strings.TrimSuffix(s.path, ext)
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().Format(rotatedTimeFormat), ext)
+	if err := os.Rename(s.path, rotated); err != nil && !os.IsNotExist(err) {
+		// Keep logging to the existing file rather than losing messages
+		openErr := s.open()
+		if openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("failed to rename log file: %v", err)
+	}
+
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	s.removeOldBackups(base, ext)
+	return nil
+}
+
+// removeOldBackups deletes the oldest rotated files beyond maxBackups
+func (s *logSink) removeOldBackups(base, ext string) {
+	if s.maxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(base + rotatedGlob + ext)
+	if err != nil || len(matches) <= s.maxBackups {
+		return
+	}
+
+	// The timestamp suffix sorts chronologically
+	sort.Strings(matches)
+	for _, path := range matches[:len(matches)-s.maxBackups] {
+		os.Remove(path)
+	}
+}
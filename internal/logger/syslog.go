@@ -0,0 +1,217 @@
+package logger
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// syslogQueueSize bounds the messages held while the server is unreachable;
+	// newer messages are dropped once it is full
+	syslogQueueSize = 1000
+
+	syslogFacility    = 3 // daemon
+	syslogDialTimeout = 5 * time.Second
+	syslogMaxBackoff  = 30 * time.Second
+
+	// syslogFlushTimeout bounds how long Close spends sending queued messages
+	syslogFlushTimeout = 2 * time.Second
+)
+
+// syslogSeverity maps log levels to RFC 5424 severities
+var syslogSeverity = map[LogLevel]int{
+	LevelError:   3, // err
+	LevelWarning: 4, // warning
+	LevelInfo:    6, // informational
+	LevelDebug:   7, // debug
+	LevelTrace:   7,
+}
+
+// syslogEntry is a queued message
+type syslogEntry struct {
+	level   LogLevel
+	time    time.Time
+	message string
+}
+
+// syslogSink forwards messages to a syslog server from its own goroutine, so
+// a slow or dead server never blocks the caller
+type syslogSink struct {
+	network  string // "udp" or "tcp"
+	address  string
+	appName  string
+	hostname string
+
+	queue   chan syslogEntry
+	done    chan struct{}
+	stopped chan struct{}
+	dropped atomic.Uint64 // Messages lost to a full queue since the last report
+}
+
+var activeSyslog atomic.Pointer[syslogSink]
+
+// parseSyslogAddr splits "udp://host:port", "tcp://host:port" or "host:port"
+// (UDP) into a network and address, defaulting the port to 514
+func parseSyslogAddr(addr string) (string, string, error) {
+	network := "udp"
+	if scheme, rest, ok := strings.Cut(addr, "://"); ok {
+		network, addr = strings.ToLower(scheme), rest
+	}
+	if network != "udp" && network != "tcp" {
+		return "", "", fmt.Errorf("unsupported syslog protocol %q, expected udp or tcp", network)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "514")
+	}
+	return network, addr, nil
+}
+
+// openSyslog starts forwarding to the server at addr
+func openSyslog(addr, appName string) error {
+	network, address, err := parseSyslogAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	if appName == "" {
+		appName = "-"
+	}
+
+	sink := &syslogSink{
+		network:  network,
+		address:  address,
+		appName:  strings.ReplaceAll(appName, " ", "_"),
+		hostname: hostname,
+		queue:    make(chan syslogEntry, syslogQueueSize),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go sink.run()
+	activeSyslog.Store(sink)
+	return nil
+}
+
+// closeSyslog stops forwarding, sending what is queued if the server is connected
+func closeSyslog() {
+	sink := activeSyslog.Swap(nil)
+	if sink == nil {
+		return
+	}
+	close(sink.done)
+	<-sink.stopped
+}
+
+// writeSyslog queues a message without blocking; it is dropped if the queue is full
+func writeSyslog(level LogLevel, message string) {
+	sink := activeSyslog.Load()
+	if sink == nil {
+		return
+	}
+
+	select {
+	case sink.queue <- syslogEntry{level: level, time: time.Now(), message: message}:
+	default:
+		sink.dropped.Add(1)
+	}
+}
+
+// run sends queued messages, reconnecting with backoff after a failure. The
+// message being sent when the connection fails is retried on the new one.
+func (s *syslogSink) run() {
+	defer close(s.stopped)
+
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	backoff := time.Second
+
+	for {
+		var entry syslogEntry
+		select {
+		case <-s.done:
+			s.flush(conn)
+			return
+		case entry = <-s.queue:
+		}
+
+		for {
+			if conn == nil {
+				var err error
+				conn, err = net.DialTimeout(s.network, s.address, syslogDialTimeout)
+				if err != nil {
+					conn = nil
+					select {
+					case <-s.done:
+						return
+					case <-time.After(backoff):
+					}
+					backoff = min(backoff*2, syslogMaxBackoff)
+					continue
+				}
+				backoff = time.Second
+				s.reportDropped(conn)
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(syslogDialTimeout))
+			if err := s.send(conn, entry); err != nil {
+				conn.Close()
+				conn = nil
+				continue
+			}
+			break
+		}
+	}
+}
+
+// flush sends the queued messages on an open connection within syslogFlushTimeout
+func (s *syslogSink) flush(conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(syslogFlushTimeout))
+	for {
+		select {
+		case entry := <-s.queue:
+			if s.send(conn, entry) != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// reportDropped tells the server how many messages were lost while it was unreachable
+func (s *syslogSink) reportDropped(conn net.Conn) {
+	if dropped := s.dropped.Swap(0); dropped > 0 {
+		s.send(conn, syslogEntry{
+			level:   LevelWarning,
+			time:    time.Now(),
+			message: fmt.Sprintf("Dropped %d log messages while the syslog server was unreachable", dropped),
+		})
+	}
+}
+
+// send writes one RFC 5424 message. TCP uses octet-counting framing (RFC 6587).
+func (s *syslogSink) send(conn net.Conn, entry syslogEntry) error {
+	message := fmt.Sprintf("<%d>1 %s %s %s %d - - %s",
+		syslogFacility*8+syslogSeverity[entry.level],
+		entry.time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname, s.appName, os.Getpid(), entry.message)
+	if s.network == "tcp" {
+		message = fmt.Sprintf("%d %s", len(message), message)
+	}
+	_, err := conn.Write([]byte(message))
+	return err
+}
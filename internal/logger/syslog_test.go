@@ -0,0 +1,158 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSyslogAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		network string
+		address string
+		wantErr bool
+	}{
+		{"localhost", "udp", "localhost:514", false},
+		{"localhost:1514", "udp", "localhost:1514", false},
+		{"udp://10.0.0.5", "udp", "10.0.0.5:514", false},
+		{"tcp://logs.example.com:6514", "tcp", "logs.example.com:6514", false},
+		{"TCP://logs.example.com", "tcp", "logs.example.com:514", false},
+		{"[::1]:1514", "udp", "[::1]:1514", false},
+		{"tcp://::1", "tcp", "[::1]:514", false},
+		{"http://logs.example.com", "", "", true},
+		{"tls://logs.example.com:6514", "", "", true},
+	}
+	for _, tt := range tests {
+		network, address, err := parseSyslogAddr(tt.addr)
+		if (err != nil) != tt.wantErr || network != tt.network || address != tt.address {
+			t.Errorf("parseSyslogAddr(%q) = %q, %q, %v, want %q, %q (error %v)",
+				tt.addr, network, address, err, tt.network, tt.address, tt.wantErr)
+		}
+	}
+}
+
+// sent returns what send writes for entry on a sink using network
+func sent(t *testing.T, network string, entry syslogEntry) string {
+	t.Helper()
+	s := &syslogSink{network: network, appName: "grip", hostname: "host"}
+	client, server := net.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.send(client, entry)
+		client.Close()
+	}()
+	data, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestSyslogSend(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 30, 0, 250000000, time.UTC)
+	tests := []struct {
+		level    LogLevel
+		priority int
+	}{
+		{LevelError, 27},
+		{LevelWarning, 28},
+		{LevelInfo, 30},
+		{LevelDebug, 31},
+		{LevelTrace, 31},
+	}
+	for _, tt := range tests {
+		want := fmt.Sprintf("<%d>1 2024-05-01T12:30:00.250000Z host grip %d - - capture started", tt.priority, os.Getpid())
+
+		got := sent(t, "udp", syslogEntry{level: tt.level, time: at, message: "capture started"})
+		if got != want {
+			t.Errorf("%v over udp: got %q, want %q", tt.level, got, want)
+		}
+
+		// TCP prefixes each message with its length in octets
+		got = sent(t, "tcp", syslogEntry{level: tt.level, time: at, message: "capture started"})
+		if want := fmt.Sprintf("%d %s", len(want), want); got != want {
+			t.Errorf("%v over tcp: got %q, want %q", tt.level, got, want)
+		}
+	}
+
+	// The offset is kept for local times and the length counts bytes, not runes
+	local := time.Date(2024, 5, 1, 14, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	got := sent(t, "tcp", syslogEntry{level: LevelInfo, time: local, message: "café"})
+	want := fmt.Sprintf("<30>1 2024-05-01T14:30:00.000000+02:00 host grip %d - - café", os.Getpid())
+	if want = fmt.Sprintf("%d %s", len(want), want); got != want {
+		t.Errorf("local time over tcp: got %q, want %q", got, want)
+	}
+}
+
+// TestSyslogDropsWhenUnreachable fills the queue while nothing listens and
+// checks that writes never block, the overflow is counted and reported, and
+// closing does not wait out the reconnect backoff
+func TestSyslogDropsWhenUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	if err := openSyslog("tcp://"+addr, "grip test"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(closeSyslog)
+	sink := activeSyslog.Load()
+	if sink.appName != "grip_test" {
+		t.Errorf("app name %q, want spaces replaced", sink.appName)
+	}
+
+	const extra = 100
+	start := time.Now()
+	for i := 0; i < syslogQueueSize+extra; i++ {
+		writeSyslog(LevelInfo, fmt.Sprintf("message %d", i))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("writing took %v with nothing listening", elapsed)
+	}
+	if len(sink.queue) > syslogQueueSize {
+		t.Errorf("queue holds %d messages, want at most %d", len(sink.queue), syslogQueueSize)
+	}
+	// run may have taken one message off the queue before it filled
+	dropped := sink.dropped.Load()
+	if dropped < extra-1 || dropped > extra {
+		t.Errorf("dropped %d messages, want %d", dropped, extra)
+	}
+
+	// The count is reported once the server is reachable again
+	client, server := net.Pipe()
+	go func() {
+		sink.reportDropped(client)
+		client.Close()
+	}()
+	data, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("Dropped %d log messages", dropped); !strings.Contains(string(data), want) {
+		t.Errorf("report %q does not contain %q", data, want)
+	}
+	if n := sink.dropped.Load(); n != 0 {
+		t.Errorf("dropped count %d after reporting, want 0", n)
+	}
+
+	start = time.Now()
+	closeSyslog()
+	if elapsed := time.Since(start); elapsed > syslogFlushTimeout {
+		t.Errorf("closing took %v while reconnecting", elapsed)
+	}
+	if activeSyslog.Load() != nil {
+		t.Error("sink still active after closing")
+	}
+	writeSyslog(LevelInfo, "after close")
+}
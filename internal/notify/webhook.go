@@ -0,0 +1,141 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"grip/internal/logger"
+)
+
+// WebhookConfig controls how events are delivered to a webhook
+type WebhookConfig struct {
+	URL        string        // Endpoint that receives JSON POST requests
+	Timeout    time.Duration // Per-request timeout
+	MaxRetries int           // Retries after the first failed attempt
+	QueueSize  int           // Events buffered while the endpoint is slow
+	Headers    http.Header   // Sent with every request, e.g. Authorization
+}
+
+// retryBackoff is the wait before the first retry; it doubles for each one after
+var retryBackoff = time.Second
+
+// Webhook posts JSON events to a URL from a background goroutine so a slow
+// or unreachable endpoint never blocks the caller
+type Webhook struct {
+	config  WebhookConfig
+	client  *http.Client
+	queue   chan interface{}
+	done    chan struct{}
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+// NewWebhook validates the configuration and starts the delivery goroutine
+func NewWebhook(config WebhookConfig) (*Webhook, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("webhook URL must not be empty")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+
+	w := &Webhook{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		queue:  make(chan interface{}, config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Notify queues an event for delivery. It never blocks; events are dropped
+// and counted when the queue is full. It must not be called after Close.
+func (w *Webhook) Notify(event interface{}) {
+	select {
+	case w.queue <- event:
+	default:
+		dropped := w.dropped.Add(1)
+		logger.Warning("Webhook queue full, dropped event (%d dropped so far)", dropped)
+	}
+}
+
+// Close stops accepting events and waits for queued ones to be delivered,
+// giving up after one request timeout so shutdown can't hang on a dead endpoint
+func (w *Webhook) Close() {
+	w.once.Do(func() {
+		close(w.queue)
+		select {
+		case <-w.done:
+		case <-time.After(w.config.Timeout):
+			logger.Warning("Webhook did not finish delivering, %d queued events discarded", len(w.queue))
+		}
+	})
+}
+
+// run delivers queued events one at a time
+func (w *Webhook) run() {
+	defer close(w.done)
+	for event := range w.queue {
+		if err := w.Post(event); err != nil {
+			logger.Error("Webhook delivery failed: %v", err)
+		}
+	}
+}
+
+// Post sends a single event, retrying with exponential backoff on failure
+func (w *Webhook) Post(event interface{}) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %v", err)
+	}
+
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		err = w.post(body)
+		if err == nil {
+			return nil
+		}
+		if attempt >= w.config.MaxRetries {
+			return fmt.Errorf("giving up after %d attempts: %v", attempt+1, err)
+		}
+
+		logger.Debug("Webhook attempt %d failed, retrying in %v: %v", attempt+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes one HTTP request
+func (w *Webhook) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range w.config.Headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestWebhookRetry posts to a server that fails a number of times before
+// accepting the event and checks how many attempts are made and whether the
+// event is delivered
+func TestWebhookRetry(t *testing.T) {
+	previousBackoff := retryBackoff
+	retryBackoff = time.Millisecond
+	t.Cleanup(func() { retryBackoff = previousBackoff })
+
+	tests := []struct {
+		name         string
+		failures     int32 // Requests answered with 500 before one succeeds
+		maxRetries   int
+		wantAttempts int32
+		wantErr      bool
+	}{
+		{"first attempt", 0, 2, 1, false},
+		{"after retries", 2, 2, 3, false},
+		{"retries exhausted", 3, 2, 3, true},
+		{"no retries", 1, 0, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var event map[string]string
+				if err := json.NewDecoder(r.Body).Decode(&event); err != nil || event["kind"] != "test" {
+					t.Errorf("received event %v (%v)", event, err)
+				}
+				if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "Bearer token" {
+					t.Errorf("received headers %v", r.Header)
+				}
+				if attempts.Add(1) <= tt.failures {
+					w.WriteHeader(http.StatusInternalServerError)
+				}
+			}))
+			defer server.Close()
+
+			webhook, err := NewWebhook(WebhookConfig{URL: server.URL, MaxRetries: tt.maxRetries,
+				Headers: http.Header{"Authorization": {"Bearer token"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer webhook.Close()
+
+			err = webhook.Post(map[string]string{"kind": "test"})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Post() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got := attempts.Load(); got != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+// TestWebhookTimeout checks that a request to an endpoint that doesn't answer
+// is abandoned after the timeout, each time it is retried
+func TestWebhookTimeout(t *testing.T) {
+	previousBackoff := retryBackoff
+	retryBackoff = time.Millisecond
+	t.Cleanup(func() { retryBackoff = previousBackoff })
+
+	release := make(chan struct{})
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release) // Before Close, which waits for the handlers
+
+	webhook, err := NewWebhook(WebhookConfig{URL: server.URL, Timeout: 50 * time.Millisecond, MaxRetries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer webhook.Close()
+
+	start := time.Now()
+	if err := webhook.Post(map[string]string{"kind": "test"}); err == nil {
+		t.Error("Post() succeeded against an endpoint that never answers")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Post() took %v with a 50ms timeout", elapsed)
+	}
+	if got := attempts.Load(); got != 2 {
+		t.Errorf("%d attempts, want 2", got)
+	}
+}
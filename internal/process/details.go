@@ -0,0 +1,169 @@
+package process
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// maxCachedProcesses bounds the details cache; it is cleared when full
+const maxCachedProcesses = 4096
+
+// serviceRetryInterval is how long a svchost.exe process found hosting no
+// services keeps that answer before its services are listed again, in case
+// they were still starting
+var serviceRetryInterval = time.Minute
+
+// cachedDetails holds the parts of ProcessInfo that are slow to look up. The
+// creation time tells a cached entry apart from a later process reusing the PID.
+type cachedDetails struct {
+	created     windows.Filetime
+	owner       string
+	serviceName string
+	parentPID   uint32
+	parentName  string
+	commandLine string
+	expires     time.Time // When to look the details up again; zero if never
+}
+
+var (
+	detailsCache      = make(map[uint32]cachedDetails)
+	detailsCacheMutex sync.Mutex
+)
+
+// processCreationTime returns when the process behind handle started
+func processCreationTime(handle windows.Handle) (windows.Filetime, error) {
+	var created, exited, kernel, user windows.Filetime
+	err := windows.GetProcessTimes(handle, &created, &exited, &kernel, &user)
+	return created, err
+}
+
+// cachedProcessDetails returns the cached owner and services for pid if the
+// entry belongs to the same process and hasn't expired
+func cachedProcessDetails(pid uint32, created windows.Filetime) (cachedDetails, bool) {
+	detailsCacheMutex.Lock()
+	defer detailsCacheMutex.Unlock()
+
+	details, ok := detailsCache[pid]
+	if !ok || details.created != created || (!details.expires.IsZero() && time.Now().After(details.expires)) {
+		return cachedDetails{}, false
+	}
+	return details, true
+}
+
+// cacheProcessDetails stores the owner and services looked up for pid
+func cacheProcessDetails(pid uint32, details cachedDetails) {
+	detailsCacheMutex.Lock()
+	defer detailsCacheMutex.Unlock()
+
+	if len(detailsCache) >= maxCachedProcesses {
+		detailsCache = make(map[uint32]cachedDetails)
+	}
+	detailsCache[pid] = details
+}
+
+// forgetProcessDetails drops the cached entry for pid
+func forgetProcessDetails(pid uint32) {
+	detailsCacheMutex.Lock()
+	defer detailsCacheMutex.Unlock()
+
+	delete(detailsCache, pid)
+}
+
+// processOwner returns the DOMAIN\user account that owns the process token.
+// Protected processes deny access to their token; they get an empty owner.
+func processOwner(handle windows.Handle) (string, error) {
+	var token windows.Token
+	if err := windows.OpenProcessToken(handle, windows.TOKEN_QUERY, &token); err != nil {
+		return "", err
+	}
+	defer token.Close()
+
+	user, err := token.GetTokenUser()
+	if err != nil {
+		return "", err
+	}
+	account, domain, _, err := user.User.Sid.LookupAccount("")
+	if err != nil {
+		return "", err
+	}
+	if domain == "" {
+		return account, nil
+	}
+	return domain + `\` + account, nil
+}
+
+// processParentID returns the PID of the process that started the process
+// behind handle. The parent may have exited and its PID been reused since.
+func processParentID(handle windows.Handle) (uint32, error) {
+	var info windows.PROCESS_BASIC_INFORMATION
+	err := windows.NtQueryInformationProcess(handle, windows.ProcessBasicInformation,
+		unsafe.Pointer(&info), uint32(unsafe.Sizeof(info)), nil)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(info.InheritedFromUniqueProcessId), nil
+}
+
+// parentProcessName returns the executable name of parentPID, or "" if it
+// can't be opened or started after the child created at childCreated, which
+// means the parent has exited and its PID belongs to another process now
+func parentProcessName(parentPID uint32, childCreated windows.Filetime) string {
+	switch parentPID {
+	case idlePID:
+		return ""
+	case systemPID:
+		return "System"
+	}
+
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, parentPID)
+	if err != nil {
+		return ""
+	}
+	defer windows.CloseHandle(handle)
+
+	created, err := processCreationTime(handle)
+	if err != nil || created.Nanoseconds() > childCreated.Nanoseconds() {
+		return ""
+	}
+
+	var path [windows.MAX_PATH]uint16
+	length := uint32(len(path))
+	if err := windows.QueryFullProcessImageName(handle, 0, &path[0], &length); err != nil {
+		return ""
+	}
+	return filepath.Base(windows.UTF16ToString(path[:length]))
+}
+
+// processCommandLine returns the command line of the process behind handle.
+// Only limited query access is needed, but protected processes still deny it.
+func processCommandLine(handle windows.Handle) (string, error) {
+	// The result is a UNICODE_STRING followed by the characters it points to
+	size := uint32(512)
+	for {
+		buffer := make([]byte, size)
+		err := windows.NtQueryInformationProcess(handle, windows.ProcessCommandLineInformation,
+			unsafe.Pointer(&buffer[0]), size, &size)
+		tooSmall := err == windows.STATUS_INFO_LENGTH_MISMATCH || err == windows.STATUS_BUFFER_TOO_SMALL ||
+			err == windows.STATUS_BUFFER_OVERFLOW
+		if tooSmall && size > uint32(len(buffer)) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		commandLine := (*windows.NTUnicodeString)(unsafe.Pointer(&buffer[0]))
+		if commandLine.Length == 0 || commandLine.Buffer == nil {
+			return "", nil
+		}
+		if int(commandLine.Length) > len(buffer) {
+			return "", fmt.Errorf("command line of %d bytes exceeds the %d byte result", commandLine.Length, len(buffer))
+		}
+		return windows.UTF16ToString(unsafe.Slice(commandLine.Buffer, commandLine.Length/2)), nil
+	}
+}
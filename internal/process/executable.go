@@ -0,0 +1,201 @@
+package process
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modCrypt32           = windows.NewLazySystemDLL("crypt32.dll")
+	procCryptMsgGetParam = modCrypt32.NewProc("CryptMsgGetParam")
+	procCryptMsgClose    = modCrypt32.NewProc("CryptMsgClose")
+)
+
+// CMSG_SIGNER_INFO_PARAM selects the signer information in CryptMsgGetParam
+const CMSG_SIGNER_INFO_PARAM = 6
+
+// cmsgSignerInfo is the leading part of CMSG_SIGNER_INFO; only the fields that
+// identify the signer's certificate are read
+type cmsgSignerInfo struct {
+	Version      uint32
+	Issuer       windows.CertNameBlob
+	SerialNumber windows.CryptIntegerBlob
+}
+
+// ExecutableInfo identifies an executable file
+type ExecutableInfo struct {
+	SHA256    string // Hex digest, empty if the file couldn't be hashed
+	Publisher string // Subject of the Authenticode signer, empty if unsigned
+	Error     string // Why the file couldn't be hashed, e.g. "file locked"
+}
+
+// IdentifyExecutable hashes the file at path and reads the publisher from its
+// embedded Authenticode signature. Files larger than maxBytes aren't hashed.
+// Files signed only through a catalog, like many Windows components, have no
+// embedded signature and get an empty publisher.
+func IdentifyExecutable(path string, maxBytes int64) ExecutableInfo {
+	var info ExecutableInfo
+
+	digest, err := hashFile(path, maxBytes)
+	if err != nil {
+		info.Error = describeFileError(err)
+	} else {
+		info.SHA256 = digest
+	}
+
+	publisher, err := signaturePublisher(path)
+	if err != nil {
+		logf("Signature check failed for %s: %v", path, err)
+	} else {
+		info.Publisher = publisher
+	}
+
+	return info
+}
+
+// hashFile returns the hex SHA-256 of a file no larger than maxBytes
+func hashFile(path string, maxBytes int64) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if maxBytes > 0 && stat.Size() > maxBytes {
+		return "", fmt.Errorf("larger than %d MB", maxBytes/(1024*1024))
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// describeFileError turns a hashing error into the short reason that is stored
+func describeFileError(err error) string {
+	switch {
+	case errors.Is(err, windows.ERROR_SHARING_VIOLATION), errors.Is(err, windows.ERROR_LOCK_VIOLATION):
+		return "file locked"
+	case errors.Is(err, os.ErrNotExist):
+		return "file not found"
+	case errors.Is(err, os.ErrPermission):
+		return "access denied"
+	default:
+		return err.Error()
+	}
+}
+
+// signaturePublisher returns the signer of a file's embedded Authenticode
+// signature, marked "(signature not valid)" if WinVerifyTrust rejects it.
+// Unsigned files return an empty publisher and no error.
+func signaturePublisher(path string) (string, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return "", err
+	}
+
+	verifyErr := verifySignature(pathPtr)
+	if errors.Is(verifyErr, windows.Errno(windows.TRUST_E_NOSIGNATURE)) {
+		return "", nil
+	}
+
+	subject, err := signerSubject(pathPtr)
+	if err != nil {
+		return "", err
+	}
+	if verifyErr != nil {
+		return subject + " (signature not valid)", nil
+	}
+	return subject, nil
+}
+
+// verifySignature checks the embedded signature without network access
+func verifySignature(path *uint16) error {
+	fileInfo := windows.WinTrustFileInfo{
+		Size:     uint32(unsafe.Sizeof(windows.WinTrustFileInfo{})),
+		FilePath: path,
+	}
+	data := windows.WinTrustData{
+		Size:                            uint32(unsafe.Sizeof(windows.WinTrustData{})),
+		UIChoice:                        windows.WTD_UI_NONE,
+		RevocationChecks:                windows.WTD_REVOKE_NONE,
+		UnionChoice:                     windows.WTD_CHOICE_FILE,
+		FileOrCatalogOrBlobOrSgnrOrCert: unsafe.Pointer(&fileInfo),
+		StateAction:                     windows.WTD_STATEACTION_VERIFY,
+		ProvFlags:                       windows.WTD_CACHE_ONLY_URL_RETRIEVAL,
+	}
+	err := windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, &data)
+
+	// Release the state data allocated by the verify action
+	data.StateAction = windows.WTD_STATEACTION_CLOSE
+	windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, &data)
+	return err
+}
+
+// signerSubject reads the display name of the certificate that signed a file
+func signerSubject(path *uint16) (string, error) {
+	var (
+		encoding, contentType, formatType uint32
+		store, msg                        windows.Handle
+	)
+	err := windows.CryptQueryObject(
+		windows.CERT_QUERY_OBJECT_FILE,
+		unsafe.Pointer(path),
+		windows.CERT_QUERY_CONTENT_FLAG_PKCS7_SIGNED_EMBED,
+		windows.CERT_QUERY_FORMAT_FLAG_BINARY,
+		0,
+		&encoding,
+		&contentType,
+		&formatType,
+		&store,
+		&msg,
+		nil,
+	)
+	if err != nil {
+		return "", fmt.Errorf("CryptQueryObject failed: %v", err)
+	}
+	defer windows.CertCloseStore(store, 0)
+	defer procCryptMsgClose.Call(uintptr(msg))
+
+	// The signer info names the signing certificate by issuer and serial number
+	var size uint32
+	if ret, _, err := procCryptMsgGetParam.Call(uintptr(msg), CMSG_SIGNER_INFO_PARAM, 0, 0, uintptr(unsafe.Pointer(&size))); ret == 0 {
+		return "", fmt.Errorf("CryptMsgGetParam failed: %v", err)
+	}
+	buf := make([]byte, size)
+	if ret, _, err := procCryptMsgGetParam.Call(uintptr(msg), CMSG_SIGNER_INFO_PARAM, 0,
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size))); ret == 0 {
+		return "", fmt.Errorf("CryptMsgGetParam failed: %v", err)
+	}
+	signer := (*cmsgSignerInfo)(unsafe.Pointer(&buf[0]))
+
+	certInfo := windows.CertInfo{
+		Issuer:       signer.Issuer,
+		SerialNumber: signer.SerialNumber,
+	}
+	cert, err := windows.CertFindCertificateInStore(store, encoding, 0,
+		windows.CERT_FIND_SUBJECT_CERT, unsafe.Pointer(&certInfo), nil)
+	if err != nil {
+		return "", fmt.Errorf("signer certificate not found: %v", err)
+	}
+	defer windows.CertFreeCertificateContext(cert)
+
+	var name [256]uint16
+	n := windows.CertGetNameString(cert, windows.CERT_NAME_SIMPLE_DISPLAY_TYPE, 0, nil, &name[0], uint32(len(name)))
+	if n <= 1 {
+		return "", fmt.Errorf("signer certificate has no name")
+	}
+	return windows.UTF16ToString(name[:n]), nil
+}
@@ -2,6 +2,10 @@ package process
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -15,16 +19,56 @@ var (
 
 // Windows API constants for TCP/UDP table operations
 const (
-	AF_INET                 = 2
-	TCP_TABLE_OWNER_PID_ALL = 5
-	UDP_TABLE_OWNER_PID     = 1
-	SORT_BY_PID             = 1
+	AF_INET                      = 2
+	TCP_TABLE_OWNER_PID_LISTENER = 3
+	TCP_TABLE_OWNER_PID_ALL      = 5
+	UDP_TABLE_OWNER_PID          = 1
+	SORT_BY_PID                  = 1
+)
+
+// Logger receives diagnostic messages from the process package. It defaults to
+// a no-op so the package never writes to stdout on its own.
+type Logger func(format string, args ...interface{})
+
+// logger is read by lookups on the capture goroutines, so SetLogger may be
+// called at any time
+var logger atomic.Pointer[Logger]
+
+// SetLogger installs the function used for diagnostic output. Passing nil
+// restores the default no-op logger.
+func SetLogger(l Logger) {
+	if l == nil {
+		logger.Store(nil)
+		return
+	}
+	logger.Store(&l)
+}
+
+// logf writes a diagnostic message to the installed logger, if any
+func logf(format string, args ...interface{}) {
+	if l := logger.Load(); l != nil {
+		(*l)(format, args...)
+	}
+}
+
+// Pseudo-processes that have no image file and can't be opened
+const (
+	idlePID   = 0
+	systemPID = 4
 )
 
 type ProcessInfo struct {
 	ProcessID      uint32
 	ProcessName    string
 	ExecutablePath string
+	ServiceName    string // Services hosted by a svchost.exe process, comma separated
+	Owner          string // DOMAIN\user account the process runs as, empty if access was denied
+
+	// The process that started this one, if it is still running, and the
+	// command line, e.g. the script run by python.exe. Empty if access was denied.
+	ParentProcessID   uint32
+	ParentProcessName string
+	CommandLine       string
 }
 
 type TCPRow struct {
@@ -42,43 +86,32 @@ type UDPRow struct {
 	ProcessID uint32
 }
 
-func GetProcessDetails(pid uint32) (*ProcessInfo, error) {
-	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_INFORMATION|windows.PROCESS_VM_READ, false, pid)
-	if err != nil {
-		return nil, fmt.Errorf("OpenProcess failed: %v", err)
-	}
-	defer windows.CloseHandle(handle)
-
-	var path [windows.MAX_PATH]uint16
-	length := uint32(len(path))
-	if err := windows.QueryFullProcessImageName(handle, 0, &path[0], &length); err != nil {
-		return nil, fmt.Errorf("QueryFullProcessImageName failed: %v", err)
-	}
-
-	info := &ProcessInfo{
-		ProcessID:      pid,
-		ExecutablePath: windows.UTF16ToString(path[:length]),
-		ProcessName:    windows.UTF16ToString(path[windows.MAX_PATH-length:]),
-	}
-
-	return info, nil
+// Listener is a socket waiting for connections: a TCP socket in the LISTEN
+// state or a bound UDP socket
+type Listener struct {
+	Protocol  string // "TCP" or "UDP"
+	Address   string // Local IPv4 address, 0.0.0.0 for all interfaces
+	Port      uint16
+	ProcessID uint32
 }
 
-func FindTCPProcess(localPort uint16, remotePort uint16, localAddr, remoteAddr uint32) (*ProcessInfo, error) {
+// extendedTable reads the IPv4 rows of GetExtendedTcpTable or
+// GetExtendedUdpTable for a table class, growing the buffer until the table
+// fits. Row must match the layout of the class's rows.
+func extendedTable[Row any](proc *windows.LazyProc, class uintptr) ([]Row, error) {
 	var size uint32 = 8192 // Start with a reasonable buffer size
-	var table []byte
 	var lastErr error
 
 	// Try multiple times with increasing buffer sizes
 	for attempts := 0; attempts < 3; attempts++ {
-		table = make([]byte, size)
+		table := make([]byte, size)
 
-		ret, _, errCall := procGetExtendedTcpTable.Call(
+		ret, _, errCall := proc.Call(
 			uintptr(unsafe.Pointer(&table[0])),
 			uintptr(unsafe.Pointer(&size)),
 			SORT_BY_PID,
 			AF_INET,
-			TCP_TABLE_OWNER_PID_ALL,
+			class,
 			0,
 		)
 
@@ -88,119 +121,201 @@ func FindTCPProcess(localPort uint16, remotePort uint16, localAddr, remoteAddr u
 			size *= 2
 			continue
 		} else if ret != 0 {
-			lastErr = fmt.Errorf("GetExtendedTcpTable failed with code %d: %v", ret, errCall)
+			lastErr = fmt.Errorf("%s failed with code %d: %v", proc.Name, ret, errCall)
+			logf("%v (attempt %d)", lastErr, attempts+1)
 			continue
 		}
 
-		// Success - process the data
 		// Check if we have enough data for at least the count
 		if len(table) < 4 {
-			return nil, fmt.Errorf("TCP table data too small")
+			return nil, fmt.Errorf("%s returned too little data", proc.Name)
 		}
-
 		count := *(*uint32)(unsafe.Pointer(&table[0]))
 		if count == 0 {
-			return nil, fmt.Errorf("no TCP connections found")
+			return nil, nil
 		}
 
-		rowSize := unsafe.Sizeof(TCPRow{})
 		// Make sure we have enough data for the rows
-		expectedSize := 4 + (uint32(rowSize) * count)
-		if uint32(len(table)) < expectedSize {
-			return nil, fmt.Errorf("TCP table data incomplete")
+		var row Row
+		if uint64(len(table)) < 4+uint64(unsafe.Sizeof(row))*uint64(count) {
+			return nil, fmt.Errorf("%s returned an incomplete table", proc.Name)
 		}
+		return unsafe.Slice((*Row)(unsafe.Pointer(&table[4])), count), nil
+	}
 
-		// Convert ports from host to network byte order for comparison
-		localPortN := (localPort << 8) | (localPort >> 8)
-		remotePortN := (remotePort << 8) | (remotePort >> 8)
-
-		// Process the table data
-		rows := (*[1024]TCPRow)(unsafe.Pointer(&table[4]))[:count:count]
-
-		for i := uint32(0); i < count; i++ {
-			row := rows[i]
+	// If we get here, all attempts failed
+	return nil, lastErr
+}
 
-			if row.LocalPort == uint32(localPortN) &&
-				(remotePort == 0 || row.RemotePort == uint32(remotePortN)) &&
-				(localAddr == 0 || row.LocalAddr == localAddr) &&
-				(remoteAddr == 0 || row.RemoteAddr == remoteAddr) {
-				return GetProcessDetails(row.ProcessID)
-			}
-		}
+// GetListeners returns the IPv4 TCP sockets in the LISTEN state and the bound
+// UDP sockets, with the processes that own them
+func GetListeners() ([]Listener, error) {
+	tcpRows, err := extendedTable[TCPRow](procGetExtendedTcpTable, TCP_TABLE_OWNER_PID_LISTENER)
+	if err != nil {
+		return nil, err
+	}
+	udpRows, err := extendedTable[UDPRow](procGetExtendedUdpTable, UDP_TABLE_OWNER_PID)
+	if err != nil {
+		return nil, err
+	}
 
-		// If we get here, we processed the table but found no match
-		return nil, fmt.Errorf("matching process not found for ports %d->%d", localPort, remotePort)
+	listeners := make([]Listener, 0, len(tcpRows)+len(udpRows))
+	for _, row := range tcpRows {
+		listeners = append(listeners, Listener{"TCP", ipv4String(row.LocalAddr), networkPort(row.LocalPort), row.ProcessID})
+	}
+	for _, row := range udpRows {
+		listeners = append(listeners, Listener{"UDP", ipv4String(row.LocalAddr), networkPort(row.LocalPort), row.ProcessID})
 	}
+	return listeners, nil
+}
 
-	// If we get here, all attempts failed
-	return nil, lastErr
+// ipv4String formats an address stored in network byte order
+func ipv4String(addr uint32) string {
+	return fmt.Sprintf("%d.%d.%d.%d", byte(addr), byte(addr>>8), byte(addr>>16), byte(addr>>24))
 }
 
-func FindUDPProcess(localPort uint16, localAddr uint32) (*ProcessInfo, error) {
-	var size uint32 = 8192 // Start with a reasonable buffer size
-	var table []byte
-	var lastErr error
+// networkPort converts a port stored in network byte order to host order
+func networkPort(port uint32) uint16 {
+	return uint16(port>>8&0xff | port<<8&0xff00)
+}
 
-	// Try multiple times with increasing buffer sizes
-	for attempts := 0; attempts < 3; attempts++ {
-		table = make([]byte, size)
+func GetProcessDetails(pid uint32) (*ProcessInfo, error) {
+	// The kernel owns traffic such as SMB and http.sys; use the names Task
+	// Manager shows, as the path too so the traffic is still counted per app
+	switch pid {
+	case idlePID:
+		return &ProcessInfo{ProcessID: pid, ProcessName: "Idle", ExecutablePath: "Idle"}, nil
+	case systemPID:
+		return &ProcessInfo{ProcessID: pid, ProcessName: "System", ExecutablePath: "System"}, nil
+	}
 
-		ret, _, errCall := procGetExtendedUdpTable.Call(
-			uintptr(unsafe.Pointer(&table[0])),
-			uintptr(unsafe.Pointer(&size)),
-			SORT_BY_PID,
-			AF_INET,
-			UDP_TABLE_OWNER_PID,
-			0,
-		)
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_INFORMATION|windows.PROCESS_VM_READ, false, pid)
+	if err == windows.ERROR_ACCESS_DENIED {
+		// Protected processes still allow limited queries, enough for the path
+		handle, err = windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, pid)
+	}
+	if err != nil {
+		// The process has most likely exited
+		forgetProcessDetails(pid)
+		logf("OpenProcess failed for PID %d: %v", pid, err)
+		return nil, fmt.Errorf("OpenProcess failed: %v", err)
+	}
+	defer windows.CloseHandle(handle)
 
-		// Windows ERROR_INSUFFICIENT_BUFFER is 122
-		if ret == 122 {
-			// Double the buffer size and try again
-			size *= 2
-			continue
-		} else if ret != 0 {
-			lastErr = fmt.Errorf("GetExtendedUdpTable failed with code %d: %v", ret, errCall)
-			continue
-		}
+	var path [windows.MAX_PATH]uint16
+	length := uint32(len(path))
+	if err := windows.QueryFullProcessImageName(handle, 0, &path[0], &length); err != nil {
+		return nil, fmt.Errorf("QueryFullProcessImageName failed: %v", err)
+	}
 
-		// Success - process the data
-		// Check if we have enough data for at least the count
-		if len(table) < 4 {
-			return nil, fmt.Errorf("UDP table data too small")
-		}
+	executablePath := windows.UTF16ToString(path[:length])
+	info := &ProcessInfo{
+		ProcessID:      pid,
+		ExecutablePath: executablePath,
+		ProcessName:    filepath.Base(executablePath),
+	}
 
-		count := *(*uint32)(unsafe.Pointer(&table[0]))
-		if count == 0 {
-			return nil, fmt.Errorf("no UDP connections found")
+	// The owner and services don't change for the life of a process, so look
+	// them up once per PID and creation time
+	created, err := processCreationTime(handle)
+	cacheable := err == nil
+	if cacheable {
+		if details, ok := cachedProcessDetails(pid, created); ok {
+			info.Owner = details.owner
+			info.ServiceName = details.serviceName
+			info.ParentProcessID = details.parentPID
+			info.ParentProcessName = details.parentName
+			info.CommandLine = details.commandLine
+			return info, nil
 		}
+	}
 
-		rowSize := unsafe.Sizeof(UDPRow{})
-		// Make sure we have enough data for the rows
-		expectedSize := 4 + (uint32(rowSize) * count)
-		if uint32(len(table)) < expectedSize {
-			return nil, fmt.Errorf("UDP table data incomplete")
+	if info.Owner, err = processOwner(handle); err != nil {
+		logf("Owner lookup failed for PID %d: %v", pid, err)
+	}
+	if info.ParentProcessID, err = processParentID(handle); err != nil {
+		logf("Parent lookup failed for PID %d: %v", pid, err)
+	} else if cacheable {
+		info.ParentProcessName = parentProcessName(info.ParentProcessID, created)
+	}
+	if info.CommandLine, err = processCommandLine(handle); err != nil {
+		logf("Command line lookup failed for PID %d: %v", pid, err)
+	}
+
+	// svchost.exe hosts many unrelated services, so name the ones in this process
+	var expires time.Time
+	if strings.EqualFold(info.ProcessName, "svchost.exe") {
+		services, err := servicesForPID(pid)
+		if err != nil {
+			logf("Service lookup failed for PID %d: %v", pid, err)
+		} else {
+			info.ServiceName = strings.Join(services, ",")
+		}
+		// Services may still be starting, but listing them for every packet
+		// until they have is too slow; keep the empty answer for a while
+		if info.ServiceName == "" {
+			expires = time.Now().Add(serviceRetryInterval)
 		}
+	}
 
-		// Convert port from host to network byte order for comparison
-		localPortN := (localPort << 8) | (localPort >> 8)
+	if cacheable {
+		cacheProcessDetails(pid, cachedDetails{
+			expires:     expires,
+			created:     created,
+			owner:       info.Owner,
+			serviceName: info.ServiceName,
+			parentPID:   info.ParentProcessID,
+			parentName:  info.ParentProcessName,
+			commandLine: info.CommandLine,
+		})
+	}
 
-		// Process the table data
-		rows := (*[1024]UDPRow)(unsafe.Pointer(&table[4]))[:count:count]
+	return info, nil
+}
 
-		for i := uint32(0); i < count; i++ {
-			row := rows[i]
+func FindTCPProcess(localPort uint16, remotePort uint16, localAddr, remoteAddr uint32) (*ProcessInfo, error) {
+	rows, err := extendedTable[TCPRow](procGetExtendedTcpTable, TCP_TABLE_OWNER_PID_ALL)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no TCP connections found")
+	}
+
+	// Convert ports from host to network byte order for comparison
+	localPortN := (localPort << 8) | (localPort >> 8)
+	remotePortN := (remotePort << 8) | (remotePort >> 8)
 
-			if row.LocalPort == uint32(localPortN) &&
-				(localAddr == 0 || row.LocalAddr == localAddr) {
-				return GetProcessDetails(row.ProcessID)
-			}
+	for _, row := range rows {
+		if row.LocalPort == uint32(localPortN) &&
+			(remotePort == 0 || row.RemotePort == uint32(remotePortN)) &&
+			(localAddr == 0 || row.LocalAddr == localAddr) &&
+			(remoteAddr == 0 || row.RemoteAddr == remoteAddr) {
+			return GetProcessDetails(row.ProcessID)
 		}
+	}
+
+	return nil, fmt.Errorf("matching process not found for ports %d->%d", localPort, remotePort)
+}
+
+func FindUDPProcess(localPort uint16, localAddr uint32) (*ProcessInfo, error) {
+	rows, err := extendedTable[UDPRow](procGetExtendedUdpTable, UDP_TABLE_OWNER_PID)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("no UDP connections found")
+	}
+
+	// Convert port from host to network byte order for comparison
+	localPortN := (localPort << 8) | (localPort >> 8)
 
-		// If we get here, we processed the table but found no match
-		return nil, fmt.Errorf("matching process not found for port %d", localPort)
+	for _, row := range rows {
+		if row.LocalPort == uint32(localPortN) &&
+			(localAddr == 0 || row.LocalAddr == localAddr) {
+			return GetProcessDetails(row.ProcessID)
+		}
 	}
 
-	// If we get here, all attempts failed
-	return nil, lastErr
+	return nil, fmt.Errorf("matching process not found for port %d", localPort)
 }
@@ -0,0 +1,84 @@
+package process
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestSetLogger(t *testing.T) {
+	var got []string
+	record := func(format string, args ...interface{}) {
+		got = append(got, fmt.Sprintf(format, args...))
+	}
+	t.Cleanup(func() { SetLogger(nil) })
+
+	tests := []struct {
+		name   string
+		logger Logger
+		want   []string
+	}{
+		{"installed", record, []string{"OpenProcess failed for PID 4: access denied"}},
+		{"nil restores the no-op logger", nil, nil},
+		{"installed again", record, []string{"OpenProcess failed for PID 4: access denied"}},
+	}
+	for _, tt := range tests {
+		got = nil
+		SetLogger(tt.logger)
+		logf("OpenProcess failed for PID %d: %v", 4, "access denied")
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: logged %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestSetLoggerWhileLogging replaces the logger while lookups log, which the
+// race detector reports if the logger isn't swapped atomically
+func TestSetLoggerWhileLogging(t *testing.T) {
+	t.Cleanup(func() { SetLogger(nil) })
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				logf("lookup %d", j)
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		SetLogger(func(string, ...interface{}) {})
+		SetLogger(nil)
+	}
+	wg.Wait()
+}
+
+// TestCachedProcessDetails checks that an entry is only returned for the
+// process it was looked up for, and until it expires
+func TestCachedProcessDetails(t *testing.T) {
+	t.Cleanup(func() { forgetProcessDetails(100) })
+	created := windows.Filetime{LowDateTime: 1}
+
+	tests := []struct {
+		name    string
+		expires time.Time
+		lookup  windows.Filetime
+		want    bool
+	}{
+		{"never expires", time.Time{}, created, true},
+		{"not yet expired", time.Now().Add(time.Minute), created, true},
+		{"expired", time.Now().Add(-time.Second), created, false},
+		{"PID reused", time.Time{}, windows.Filetime{LowDateTime: 2}, false},
+	}
+	for _, tt := range tests {
+		cacheProcessDetails(100, cachedDetails{created: created, expires: tt.expires})
+		if _, ok := cachedProcessDetails(100, tt.lookup); ok != tt.want {
+			t.Errorf("%s: cached = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+}
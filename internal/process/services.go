@@ -0,0 +1,69 @@
+package process
+
+import (
+	"sort"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// servicesForPID returns the names of the running services hosted in a process
+func servicesForPID(pid uint32) ([]string, error) {
+	scm, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_ENUMERATE_SERVICE)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseServiceHandle(scm)
+
+	var (
+		buf          []byte
+		bytesNeeded  uint32
+		servicesRead uint32
+		resume       uint32
+		names        []string
+	)
+
+	for {
+		var bufPtr *byte
+		if len(buf) > 0 {
+			bufPtr = &buf[0]
+		}
+
+		err := windows.EnumServicesStatusEx(
+			scm,
+			windows.SC_ENUM_PROCESS_INFO,
+			windows.SERVICE_WIN32,
+			windows.SERVICE_ACTIVE,
+			bufPtr,
+			uint32(len(buf)),
+			&bytesNeeded,
+			&servicesRead,
+			&resume,
+			nil,
+		)
+		if err != nil && err != windows.ERROR_MORE_DATA {
+			return nil, err
+		}
+
+		if servicesRead > 0 {
+			entries := unsafe.Slice((*windows.ENUM_SERVICE_STATUS_PROCESS)(unsafe.Pointer(&buf[0])), servicesRead)
+			for _, entry := range entries {
+				if entry.ServiceStatusProcess.ProcessId == pid {
+					names = append(names, windows.UTF16PtrToString(entry.ServiceName))
+				}
+			}
+		}
+
+		if err == nil {
+			break
+		}
+
+		// More entries remain; grow the buffer if the call asked for more space
+		if bytesNeeded > uint32(len(buf)) {
+			buf = make([]byte, bytesNeeded)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,110 @@
+// Package stream serves captured packets to gRPC clients as they are seen.
+// The service is defined in streampb/stream.proto.
+package stream
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	util "grip/internal"
+	"grip/internal/capture"
+	"grip/internal/logger"
+	"grip/internal/stream/streampb"
+)
+
+// Config controls the gRPC endpoint
+type Config struct {
+	// Addr is the listen address, e.g. "127.0.0.1:9184". Packets carry
+	// payloads and process details and clients aren't authenticated, so only
+	// loopback addresses are accepted. Empty disables the server.
+	Addr string
+}
+
+var server *grpc.Server
+
+// Start begins serving the gRPC endpoint in the background. An address that
+// is not a loopback address is rejected.
+func Start(cfg Config) error {
+	if cfg.Addr == "" {
+		return nil
+	}
+	listener, err := util.ListenLoopback(cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("gRPC server: %v", err)
+	}
+
+	server = grpc.NewServer()
+	streampb.RegisterPacketStreamServer(server, &packetStream{})
+
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logger.Error("gRPC server stopped: %v", err)
+		}
+	}()
+
+	logger.Info("Streaming packets over gRPC on %s", listener.Addr())
+	return nil
+}
+
+// Stop shuts the gRPC endpoint down, ending any open streams
+func Stop() {
+	if server == nil {
+		return
+	}
+	server.Stop()
+	server = nil
+}
+
+type packetStream struct {
+	streampb.UnimplementedPacketStreamServer
+}
+
+// Subscribe streams matching packets until the client goes away or capture stops
+func (s *packetStream) Subscribe(req *streampb.SubscribeRequest, stream streampb.PacketStream_SubscribeServer) error {
+	client := "unknown"
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		client = p.Addr.String()
+	}
+
+	logger.Info("Packet stream client %s connected", client)
+	filter := capture.PacketFilter{
+		ProcessNames: req.GetProcessNames(),
+		Protocols:    req.GetProtocols(),
+	}
+	dropped, err := capture.StreamPackets(stream.Context(), filter, func(packet capture.PacketLog, dropped uint64) error {
+		event := packetEvent(packet)
+		event.Dropped = dropped
+		return stream.Send(event)
+	})
+	logger.Info("Packet stream client %s disconnected, %d packets dropped", client, dropped)
+	return err
+}
+
+// packetEvent converts a captured packet to its wire form
+func packetEvent(packet capture.PacketLog) *streampb.PacketEvent {
+	return &streampb.PacketEvent{
+		Timestamp:    timestamppb.New(packet.Timestamp),
+		Device:       packet.Device,
+		SrcIp:        packet.SrcIP,
+		SrcPort:      packet.SrcPort,
+		DstIp:        packet.DstIP,
+		DstPort:      packet.DstPort,
+		DstHost:      packet.DstHost,
+		ReverseHost:  packet.ReverseHost,
+		Geoip:        packet.GeoIP,
+		Protocol:     packet.Protocol,
+		Length:       int32(packet.Length),
+		Direction:    packet.Direction,
+		Scope:        packet.Scope,
+		ProcessId:    packet.ProcessID,
+		ProcessName:  packet.ProcessName,
+		ProcessPath:  packet.ProcessPath,
+		ServiceName:  packet.ServiceName,
+		ProcessOwner: packet.ProcessOwner,
+		Payload:      packet.Payload,
+		Flagged:      packet.Flagged,
+	}
+}